@@ -29,6 +29,14 @@ var (
 	// ErrBlobInvalidLength returned when the blob has an expected length on
 	// commit, meaning mismatched with the descriptor or an invalid value.
 	ErrBlobInvalidLength = errors.New("blob invalid length")
+
+	// ErrBlobUploadLimitExceeded returned when a repository already has the
+	// maximum number of uploads in progress.
+	ErrBlobUploadLimitExceeded = errors.New("too many blob uploads in progress")
+
+	// ErrBlobUploadQuotaExceeded returned when the uploads in progress in a
+	// repository already hold the maximum number of bytes.
+	ErrBlobUploadQuotaExceeded = errors.New("blob upload quota exceeded")
 )
 
 // ErrBlobInvalidDigest returned when digest check fails.
@@ -81,6 +89,15 @@ type BlobEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error
 }
 
+// BlobPageEnumerator enables iterating over blobs from storage in bounded
+// batches, so that a long enumeration can be resumed after an interruption.
+type BlobPageEnumerator interface {
+	// EnumerateFrom fills dgsts with the digests ordered after last and
+	// returns the number of entries filled. An empty last starts from the
+	// beginning. io.EOF is returned once the enumeration is complete.
+	EnumerateFrom(ctx context.Context, dgsts []digest.Digest, last digest.Digest) (int, error)
+}
+
 // BlobDescriptorService manages metadata about a blob by digest. Most
 // implementations will not expose such an interface explicitly. Such mappings
 // should be maintained by interacting with the BlobIngester. Hence, this is
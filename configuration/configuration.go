@@ -61,17 +61,141 @@ type Configuration struct {
 	// Proxy defines the configuration options for using the registry as a pull-through cache.
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
+	// Federation configures peer registries serving the content missing
+	// from this registry.
+	Federation Federation `yaml:"federation,omitempty"`
+
+	// Cluster configures the registry as a member of a cluster sharing the
+	// blobs it serves.
+	Cluster Cluster `yaml:"cluster,omitempty"`
+
+	// Deltas configures serving layers as deltas against layers clients
+	// already have.
+	Deltas Deltas `yaml:"deltas,omitempty"`
+
+	// Jobs configures the scheduling of maintenance jobs.
+	Jobs Jobs `yaml:"jobs,omitempty"`
+
 	// Validation configures validation options for the registry.
 	Validation Validation `yaml:"validation,omitempty"`
 
 	// Policy configures registry policy options.
 	Policy Policy `yaml:"policy,omitempty"`
+
+	// Compatibility configures how content is served to legacy clients.
+	Compatibility Compatibility `yaml:"compatibility,omitempty"`
+
+	// IDs configures the IDs generated for uploads and requests.
+	IDs IDs `yaml:"ids,omitempty"`
+}
+
+// IDs defines configuration options for the IDs generated by the registry.
+type IDs struct {
+	// Format is the format of generated IDs: "uuid", for V7 UUIDs, or
+	// "ulid". Defaults to "uuid".
+	Format string `yaml:"format,omitempty"`
+}
+
+// Compatibility defines configuration options for serving content to
+// clients that do not understand the media types stored in the registry.
+type Compatibility struct {
+	// MediaTypes is a list of manifest media type mappings. The first
+	// mapping matching both the repository and the stored media type is
+	// applied.
+	MediaTypes []MediaTypeMapping `yaml:"mediatypes,omitempty"`
+}
+
+// MediaTypeMapping converts manifests stored with one media type into a
+// view with another media type when they are fetched by tag.
+type MediaTypeMapping struct {
+	// Repositories is a list of regular expressions matched against the
+	// repository name. An empty list matches every repository.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// From is the media type of the stored manifest.
+	From string `yaml:"from"`
+
+	// To is the media type the manifest is converted to.
+	To string `yaml:"to"`
 }
 
 // Policy defines configuration options for managing registry policies.
 type Policy struct {
 	// Repository configures policies for repositories
 	Repository Repository `yaml:"repository,omitempty"`
+
+	// Uploads configures limits on the blob uploads in progress in each
+	// repository.
+	Uploads UploadPolicy `yaml:"uploads,omitempty"`
+
+	// Manifests configures limits on the manifests pushed to each
+	// repository.
+	Manifests ManifestPolicy `yaml:"manifests,omitempty"`
+
+	// Mounts restricts cross-repository blob mounts.
+	Mounts MountPolicy `yaml:"mounts,omitempty"`
+}
+
+// MountPolicy restricts the repositories blobs can be mounted from. A mount
+// which is not allowed falls back to a regular upload.
+type MountPolicy struct {
+	// Scope is the set of repositories blobs can be mounted from: "any",
+	// "namespace" for repositories sharing the first component of the
+	// name, or "none" to disable mounts. Defaults to "any".
+	Scope string `yaml:"scope,omitempty"`
+
+	// VerifySource checks that the blob is linked in the source repository
+	// for every mount, even when the caller already knows its descriptor.
+	VerifySource bool `yaml:"verifysource,omitempty"`
+
+	// Pools are repositories searched in order for the blob to mount when
+	// it is not found in the source repository, unless mounts are disabled.
+	// Blobs in the pools can be mounted by any client allowed to push, so
+	// they should only hold public content.
+	Pools []string `yaml:"pools,omitempty"`
+}
+
+// UploadPolicy defines limits on the blob uploads in progress in each
+// repository, checked when an upload session is started.
+type UploadPolicy struct {
+	// MaxConcurrent is the maximum number of upload sessions in progress in
+	// a repository. Zero means no limit.
+	MaxConcurrent int `yaml:"maxconcurrent,omitempty"`
+
+	// MaxBytes is the maximum total size in bytes of the uploads in progress
+	// in a repository. Zero means no limit.
+	MaxBytes int64 `yaml:"maxbytes,omitempty"`
+}
+
+// ManifestPolicy defines limits on the manifests pushed to the registry,
+// checked when a manifest is put. Zero means no limit.
+type ManifestPolicy struct {
+	// MaxBytes is the maximum size in bytes of the manifest itself.
+	MaxBytes int64 `yaml:"maxbytes,omitempty"`
+
+	// MaxLayers is the maximum number of layers in an image manifest.
+	MaxLayers int `yaml:"maxlayers,omitempty"`
+
+	// MaxImageSize is the maximum total size in bytes of the config and
+	// layers referenced by an image manifest.
+	MaxImageSize int64 `yaml:"maximagesize,omitempty"`
+
+	// Repositories overrides the limits for some repositories. The first
+	// override matching the repository name is applied.
+	Repositories []ManifestPolicyOverride `yaml:"repositories,omitempty"`
+}
+
+// ManifestPolicyOverride overrides the manifest limits for the repositories
+// matching Names. A zero limit inherits the limit applied to every
+// repository and a negative limit disables it.
+type ManifestPolicyOverride struct {
+	// Names is a list of regular expressions matched against the repository
+	// name.
+	Names []string `yaml:"names"`
+
+	MaxBytes     int64 `yaml:"maxbytes,omitempty"`
+	MaxLayers    int   `yaml:"maxlayers,omitempty"`
+	MaxImageSize int64 `yaml:"maximagesize,omitempty"`
 }
 
 // Repository defines configuration options related to repository policies in the registry.
@@ -89,6 +213,23 @@ type Catalog struct {
 	// to the catalog endpoint will return at most MaxEntries entries.
 	// An empty or a negative value will set a default of 1000 maximum entries by default.
 	MaxEntries int `yaml:"maxentries,omitempty"`
+
+	// Changes configures the change log, read through the /v2/_changes
+	// endpoint to list the repositories changed since a cursor.
+	Changes CatalogChanges `yaml:"changes,omitempty"`
+}
+
+// CatalogChanges configures the change log of the repositories of the
+// registry.
+type CatalogChanges struct {
+	// Enabled records the changes to the tags, manifests and repositories
+	// of the registry, and serves the /v2/_changes endpoint.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Retention is how long changes are kept in the change log. Clients
+	// which have not read the changes for longer must list the catalog
+	// again. Defaults to a week.
+	Retention time.Duration `yaml:"retention,omitempty"`
 }
 
 // Log represents the configuration for logging within the application.
@@ -113,6 +254,10 @@ type Log struct {
 
 	// ReportCaller allows user to configure the log to report the caller
 	ReportCaller bool `yaml:"reportcaller,omitempty"`
+
+	// TagPulls logs each manifest pull by tag with the digest the tag
+	// resolved to.
+	TagPulls bool `yaml:"tagpulls,omitempty"`
 }
 
 // AccessLog configures options for access logging.
@@ -149,6 +294,14 @@ type HTTP struct {
 	// receives a stop signal
 	DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
 
+	// Timeouts configures the timeouts of the HTTP server, and overrides
+	// them for the requests to given routes.
+	Timeouts HTTPTimeouts `yaml:"timeouts,omitempty"`
+
+	// MaxHeaderBytes is the maximum size of the request headers read by the
+	// HTTP server. Defaults to 1MB.
+	MaxHeaderBytes int `yaml:"maxheaderbytes,omitempty"`
+
 	// TLS instructs the http server to listen with a TLS configuration.
 	// This only support simple tls configuration with a cert and key.
 	// Mostly, this is useful for testing situations or simple deployments
@@ -162,6 +315,11 @@ type HTTP struct {
 	// the values are the associated header payloads.
 	Headers http.Header `yaml:"headers,omitempty"`
 
+	// ProvenanceHeaders adds headers to manifest responses naming the
+	// digest the manifest was resolved to and the registry instance which
+	// served it.
+	ProvenanceHeaders bool `yaml:"provenanceheaders,omitempty"`
+
 	// Debug configures the http debug interface, if specified. This can
 	// include services such as pprof, expvar and other data that should
 	// not be exposed externally. Left disabled by default.
@@ -172,6 +330,12 @@ type HTTP struct {
 
 	// H2C configures support for HTTP/2 without requiring TLS (HTTP/2 Cleartext).
 	H2C H2C `yaml:"h2c,omitempty"`
+
+	// ReplayProtection configures one-time nonces on upload URLs.
+	ReplayProtection ReplayProtection `yaml:"replayprotection,omitempty"`
+
+	// Admin configures the administrative API served under /v2/_admin.
+	Admin Admin `yaml:"admin,omitempty"`
 }
 
 // Debug defines the configuration options for the registry's debug interface.
@@ -195,6 +359,68 @@ type Prometheus struct {
 	// Path specifies the URL path where the Prometheus metrics are exposed.
 	// The default is "/metrics", but it can be customized here.
 	Path string `yaml:"path,omitempty"`
+
+	// Repositories labels the HTTP route metrics with the namespace of the
+	// repository and the action of the request.
+	Repositories PrometheusRepositories `yaml:"repositories,omitempty"`
+}
+
+// PrometheusRepositories configures the labelling of the HTTP route metrics
+// by repository namespace, the first component of the repository name, and
+// by action, pull, push or delete. Namespaces beyond the cardinality limits
+// are aggregated under a single label value.
+type PrometheusRepositories struct {
+	// Enabled labels the metrics by namespace and action.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxNamespaces is the number of distinct namespaces labelled, in the
+	// order they are first requested. Requests to the namespaces which
+	// follow are aggregated. Defaults to 100.
+	MaxNamespaces int `yaml:"maxnamespaces,omitempty"`
+
+	// Namespaces restricts the namespaces labelled to these. Requests to
+	// other namespaces are aggregated.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+}
+
+// HTTPTimeouts configures the timeouts of the HTTP server. A zero timeout
+// means no timeout.
+type HTTPTimeouts struct {
+	// ReadHeader is how long the server waits to read the headers of a
+	// request. Defaults to the Read timeout.
+	ReadHeader time.Duration `yaml:"readheader,omitempty"`
+
+	// Read is how long the server waits to read a request, body included.
+	Read time.Duration `yaml:"read,omitempty"`
+
+	// Write is how long the server waits to write a response, from the end
+	// of the headers of the request.
+	Write time.Duration `yaml:"write,omitempty"`
+
+	// Idle is how long the server keeps an idle connection open. Defaults
+	// to the Read timeout.
+	Idle time.Duration `yaml:"idle,omitempty"`
+
+	// Routes overrides the read and write timeouts for the requests to
+	// given routes, such as long timeouts for blob uploads or short ones for
+	// listing tags. The first timeout matching a request applies.
+	Routes []RouteTimeout `yaml:"routes,omitempty"`
+}
+
+// RouteTimeout overrides the timeouts of the server for the requests to a
+// route of the API.
+type RouteTimeout struct {
+	// Route is the name of the route, such as "blob-upload-chunk" or "tags".
+	Route string `yaml:"route"`
+
+	// Methods restricts the timeout to the requests with these methods. The
+	// timeout applies to all the methods of the route if none are given.
+	Methods []string `yaml:"methods,omitempty"`
+
+	// Timeout is how long the server waits to read the request and write
+	// the response, from the end of the headers of the request. The request
+	// is canceled once it expires. A zero timeout means no timeout.
+	Timeout time.Duration `yaml:"timeout"`
 }
 
 // HTTP2 configures options.
@@ -202,6 +428,18 @@ type HTTP2 struct {
 	// Specifies whether the registry should disallow clients attempting
 	// to connect via HTTP/2. If set to true, only HTTP/1.1 is supported.
 	Disabled bool `yaml:"disabled,omitempty"`
+
+	// MaxConcurrentStreams is the number of streams a client may have open
+	// on a connection at a time. Defaults to 250.
+	MaxConcurrentStreams uint32 `yaml:"maxconcurrentstreams,omitempty"`
+
+	// MaxReadFrameSize is the largest frame the server reads. Defaults to
+	// 1MB.
+	MaxReadFrameSize uint32 `yaml:"maxreadframesize,omitempty"`
+
+	// IdleTimeout is how long the server keeps an idle HTTP/2 connection
+	// open. Defaults to the idle timeout of the server.
+	IdleTimeout time.Duration `yaml:"idletimeout,omitempty"`
 }
 
 // H2C configures support for HTTP/2 Cleartext.
@@ -211,6 +449,29 @@ type H2C struct {
 	Enabled bool `yaml:"enabled,omitempty"`
 }
 
+// Admin configures the administrative API of the registry.
+type Admin struct {
+	// Enabled serves the administrative API. An auth section is required,
+	// and requests must be granted access to the registry:admin resource.
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// ReplayProtection configures one-time nonces on the upload URLs returned in
+// Location headers, so that a captured upload request cannot be replayed.
+type ReplayProtection struct {
+	// Enabled requires every PATCH and PUT to an upload URL to carry a nonce
+	// which has not been used before.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Cache selects where used nonces are recorded, either "inmemory" or
+	// "redis". Registries behind a load balancer must use redis.
+	Cache string `yaml:"cache,omitempty"`
+
+	// TTL is how long an upload URL remains valid after it is issued, and
+	// so how long its nonce is remembered.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
 // TLS defines the configuration options for enabling and configuring TLS (Transport Layer Security)
 // for secure communication between the registry and clients. It allows the registry to listen for
 // HTTPS connections with a specified certificate, key, and optional client authentication settings.
@@ -480,6 +741,10 @@ func (storage Storage) Type() string {
 			// allow configuration of redirect
 		case "tag":
 			// allow configuration of tag
+		case "chunking":
+			// allow configuration of chunking
+		case "integrity":
+			// allow configuration of integrity checks
 		default:
 			storageType = append(storageType, k)
 		}
@@ -506,6 +771,74 @@ func (storage Storage) setTagParameter(key string, value interface{}) {
 	storage["tag"][key] = value
 }
 
+// WORM returns true if the maintenance section enables write-once (WORM)
+// mode, in which the registry never deletes blob data from the backend.
+func (storage Storage) WORM() bool {
+	var enabled interface{}
+	switch worm := storage["maintenance"]["worm"].(type) {
+	case map[interface{}]interface{}:
+		enabled = worm["enabled"]
+	case map[string]interface{}:
+		// set from environment variables
+		enabled = worm["enabled"]
+	}
+	b, _ := enabled.(bool)
+	return b
+}
+
+// TagIndex returns true if the tag section enables the tag index, and the
+// number of tag changes logged after which the index is compacted, or zero
+// for the default.
+func (storage Storage) TagIndex() (enabled bool, compactAfter int) {
+	var index map[string]interface{}
+	switch v := storage.TagParameters()["index"].(type) {
+	case map[interface{}]interface{}:
+		index = make(map[string]interface{}, len(v))
+		for k, v := range v {
+			if k, ok := k.(string); ok {
+				index[k] = v
+			}
+		}
+	case map[string]interface{}:
+		// set from environment variables
+		index = v
+	}
+	enabled, _ = index["enabled"].(bool)
+	compactAfter, _ = index["compactafter"].(int)
+	return enabled, compactAfter
+}
+
+// Chunking returns true if the chunking section enables storing large blobs
+// as content-defined chunks, the size of the smallest blob chunked and the
+// average size of the chunks, or zero for the defaults.
+func (storage Storage) Chunking() (enabled bool, minSize int64, averageSize int) {
+	chunking := storage["chunking"]
+	enabled, _ = chunking["enabled"].(bool)
+	switch v := chunking["minsize"].(type) {
+	case int:
+		minSize = int64(v)
+	case int64:
+		minSize = v
+	}
+	averageSize, _ = chunking["averagesize"].(int)
+	return enabled, minSize, averageSize
+}
+
+// VerifyManifests returns true if the integrity section enables verifying
+// that the content of manifests read from the storage backend matches their
+// digest.
+func (storage Storage) VerifyManifests() bool {
+	enabled, _ := storage["integrity"]["verifymanifests"].(bool)
+	return enabled
+}
+
+// LinkChecksums returns true if the integrity section enables writing link
+// files with a checksum.
+func (storage Storage) LinkChecksums() bool {
+	enabled, _ := storage["integrity"]["linkchecksums"].(bool)
+	return enabled
+}
+
 // Parameters returns the Parameters map for a Storage configuration
 func (storage Storage) Parameters() Parameters {
 	return storage[storage.Type()]
@@ -536,6 +869,10 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of redirect
 				case "tag":
 					// allow configuration of tag
+				case "chunking":
+					// allow configuration of chunking
+				case "integrity":
+					// allow configuration of integrity checks
 				default:
 					types = append(types, k)
 				}
@@ -650,11 +987,27 @@ type Endpoint struct {
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	SPIFFE            SPIFFE        `yaml:"spiffe,omitempty"`  // authenticate with the SVID of the registry
 }
 
 // Events configures notification events.
 type Events struct {
 	IncludeReferences bool `yaml:"includereferences"` // include reference data in manifest events
+	TagResolves       bool `yaml:"tagresolves"`       // send resolve events for manifest pulls by tag
+
+	// Usage configures periodic usage events for billing.
+	Usage UsageEvents `yaml:"usage,omitempty"`
+}
+
+// UsageEvents configures the periodic events reporting the bytes pulled from
+// and pushed to each repository.
+type UsageEvents struct {
+	// Enabled sends usage events.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Interval is the interval over which usage is aggregated before it is
+	// sent. Defaults to 5 minutes.
+	Interval time.Duration `yaml:"interval,omitempty"`
 }
 
 // Ignore configures mediaTypes and actions of the event, that it won't be propagated
@@ -692,6 +1045,177 @@ type Proxy struct {
 	// if not set, defaults to 7 * 24 hours
 	// If set to zero, will never expire cache
 	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// ResumeAttempts is the number of times a blob fetch interrupted by an
+	// upstream error is resumed with a range request before the request
+	// fails. Defaults to 3 if zero, and a negative value disables it.
+	ResumeAttempts int `yaml:"resumeattempts,omitempty"`
+
+	// Scheduler configures where the expiry times of cached content are
+	// persisted.
+	Scheduler ProxyScheduler `yaml:"scheduler,omitempty"`
+
+	// Transport configures the HTTP client used for requests to the remote
+	// registry.
+	Transport ProxyTransport `yaml:"transport,omitempty"`
+}
+
+// ProxyTransport configures the HTTP client the proxy uses to fetch content
+// from the remote registry.
+type ProxyTransport struct {
+	// UserAgent is the User-Agent header sent with requests to the remote.
+	UserAgent string `yaml:"useragent,omitempty"`
+
+	// HTTPProxy and HTTPSProxy are the URLs of the outbound proxies for
+	// plain HTTP and HTTPS requests to the remote. If neither is set, the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+	HTTPProxy  string `yaml:"httpproxy,omitempty"`
+	HTTPSProxy string `yaml:"httpsproxy,omitempty"`
+
+	// NoProxy lists the hosts, or domains when prefixed with a dot, which
+	// are connected to directly rather than through the outbound proxies.
+	NoProxy []string `yaml:"noproxy,omitempty"`
+
+	// CAs lists files of PEM encoded certificate authorities which are
+	// trusted in addition to the system roots to verify the remote.
+	CAs []string `yaml:"cas,omitempty"`
+
+	// MaxIdleConns limits the idle connections kept open to the remote and
+	// its authentication servers. MaxIdleConnsPerHost limits them per host.
+	MaxIdleConns        int `yaml:"maxidleconns,omitempty"`
+	MaxIdleConnsPerHost int `yaml:"maxidleconnsperhost,omitempty"`
+
+	// MaxConnsPerHost limits the connections open to each host, including
+	// those in use. Zero means no limit.
+	MaxConnsPerHost int `yaml:"maxconnsperhost,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration `yaml:"idleconntimeout,omitempty"`
+
+	// SPIFFE authenticates the registry to the remote with its X.509 SVID,
+	// and the remote by its own.
+	SPIFFE SPIFFE `yaml:"spiffe,omitempty"`
+}
+
+// SPIFFE configures TLS clients to authenticate with the X.509 SVID of the
+// registry, fetched from the SPIFFE Workload API and rotated as it pushes
+// new ones, rather than with static credentials. Servers are authenticated
+// by their SVIDs, verified against the trust bundles of the Workload API.
+type SPIFFE struct {
+	// Enabled authenticates with SVIDs.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// EndpointSocket is the address of the Workload API, such as
+	// unix:///run/spire/agent.sock. Defaults to the SPIFFE_ENDPOINT_SOCKET
+	// environment variable.
+	EndpointSocket string `yaml:"endpointsocket,omitempty"`
+
+	// ServerIDs lists the SPIFFE IDs the server may present. Any SVID of a
+	// trusted trust domain is accepted if empty.
+	ServerIDs []string `yaml:"serverids,omitempty"`
+}
+
+// Federation configures the peer registries which serve the manifests, tags
+// and blobs missing from the registry.
+type Federation struct {
+	// Peers are the peer registries, in the order they are tried.
+	Peers []FederationPeer `yaml:"peers,omitempty"`
+
+	// Cache stores the manifests and blobs fetched from a peer in the
+	// registry. Tags are never cached.
+	Cache bool `yaml:"cache,omitempty"`
+}
+
+// Cluster configures the members of a cluster of registries, between which
+// blobs are assigned by consistent hashing of their digests.
+type Cluster struct {
+	// Self is the URL clients reach this registry at.
+	Self string `yaml:"self,omitempty"`
+
+	// Peers are the URLs of the other members of the cluster.
+	Peers []string `yaml:"peers,omitempty"`
+
+	// Replicas is the number of points of each member on the hash ring.
+	// Defaults to 100.
+	Replicas int `yaml:"replicas,omitempty"`
+
+	// Redirect redirects blob pulls to the member owning the blob, rather
+	// than only hinting at the owner in a response header.
+	Redirect bool `yaml:"redirect,omitempty"`
+}
+
+// Deltas configures serving a layer as a zstd delta against another layer of
+// the repository, which the client already has.
+type Deltas struct {
+	// Enabled enables the delta extension endpoint.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// MaxSize is the size in bytes of the largest layers, both the one
+	// requested and the one the client has, served as deltas. Defaults to
+	// 32 MiB.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+
+	// MaxConcurrent is the number of deltas computed at once. Defaults to 4.
+	MaxConcurrent int `yaml:"maxconcurrent,omitempty"`
+}
+
+// Jobs configures the scheduling of maintenance jobs, and the election of the
+// replica which runs them.
+type Jobs struct {
+	// Lock is the lock service used to elect the replica running jobs,
+	// either "inmemory", for a single replica, or "redis". Defaults to
+	// "inmemory".
+	Lock string `yaml:"lock,omitempty"`
+
+	// LockTTL is the time for which the leader holds the lock without
+	// refreshing it. Defaults to 1 minute.
+	LockTTL time.Duration `yaml:"lockttl,omitempty"`
+
+	// Schedules overrides the schedules of jobs, by job name, with cron
+	// expressions or "@every <duration>".
+	Schedules map[string]string `yaml:"schedules,omitempty"`
+
+	// GC configures a scheduled garbage collection job.
+	GC GCJob `yaml:"gc,omitempty"`
+}
+
+// GCJob configures garbage collection run by the registry on a schedule.
+type GCJob struct {
+	// Enabled schedules garbage collection.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// RemoveUntagged deletes manifests which are not currently referenced
+	// by a tag.
+	RemoveUntagged bool `yaml:"removeuntagged,omitempty"`
+
+	// DryRun reports what would be deleted without deleting it.
+	DryRun bool `yaml:"dryrun,omitempty"`
+}
+
+// FederationPeer is a peer registry of a federated registry.
+type FederationPeer struct {
+	// URL is the URL of the peer registry.
+	URL string `yaml:"url"`
+
+	// Username and Password authenticate with the peer registry.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// Transport configures the HTTP client used for requests to the peer.
+	Transport ProxyTransport `yaml:"transport,omitempty"`
+}
+
+// ProxyScheduler configures the persistence of the proxy cache expiry
+// scheduler.
+type ProxyScheduler struct {
+	// Store is where the scheduler state is persisted: "storage" for a JSON
+	// file in the storage backend, or "redis" to share it between registry
+	// instances. Defaults to "storage".
+	Store string `yaml:"store,omitempty"`
+
+	// SaveInterval is how often the changes to the scheduler state are
+	// persisted. Defaults to 5 seconds.
+	SaveInterval time.Duration `yaml:"saveinterval,omitempty"`
 }
 
 // ExecConfig defines the configuration for executing a command as a credential helper.
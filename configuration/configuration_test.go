@@ -409,6 +409,213 @@ func (suite *ConfigSuite) TestParseEnvVarImplicitMaps() {
 	suite.Require().Equal(suite.expectedConfig, config)
 }
 
+// TestParseWORM validates that WORM mode can be enabled from the
+// configuration file and from environment variables.
+func (suite *ConfigSuite) TestParseWORM() {
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	suite.Require().False(config.Storage.WORM())
+
+	yml := strings.Replace(configYamlV0_1, "  tag:\n", "  maintenance:\n    worm:\n      enabled: true\n  tag:\n", 1)
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().True(config.Storage.WORM())
+
+	suite.T().Setenv("REGISTRY_STORAGE_MAINTENANCE_WORM_ENABLED", "true")
+	config, err = Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	suite.Require().True(config.Storage.WORM())
+}
+
+// TestParseTagIndex validates that the tag index can be enabled from the
+// configuration file and from environment variables.
+func (suite *ConfigSuite) TestParseTagIndex() {
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	enabled, compactAfter := config.Storage.TagIndex()
+	suite.Require().False(enabled)
+	suite.Require().Zero(compactAfter)
+
+	yml := strings.Replace(configYamlV0_1, "  tag:\n", "  tag:\n    index:\n      enabled: true\n      compactafter: 16\n", 1)
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	enabled, compactAfter = config.Storage.TagIndex()
+	suite.Require().True(enabled)
+	suite.Require().Equal(16, compactAfter)
+
+	suite.T().Setenv("REGISTRY_STORAGE_TAG_INDEX_ENABLED", "true")
+	config, err = Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	enabled, _ = config.Storage.TagIndex()
+	suite.Require().True(enabled)
+}
+
+// TestParseChunking validates that chunking can be enabled from the
+// configuration file and from environment variables.
+func (suite *ConfigSuite) TestParseChunking() {
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	enabled, _, _ := config.Storage.Chunking()
+	suite.Require().False(enabled)
+	suite.Require().Equal("somedriver", config.Storage.Type())
+
+	yml := strings.Replace(configYamlV0_1, "  tag:\n", "  chunking:\n    enabled: true\n    minsize: 1073741824\n    averagesize: 65536\n  tag:\n", 1)
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	enabled, minSize, averageSize := config.Storage.Chunking()
+	suite.Require().True(enabled)
+	suite.Require().Equal(int64(1<<30), minSize)
+	suite.Require().Equal(65536, averageSize)
+	suite.Require().Equal("somedriver", config.Storage.Type())
+
+	suite.T().Setenv("REGISTRY_STORAGE_CHUNKING_ENABLED", "true")
+	config, err = Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	enabled, _, _ = config.Storage.Chunking()
+	suite.Require().True(enabled)
+}
+
+// TestParseIntegrity validates that manifest verification and link checksums
+// can be enabled from the configuration file and from environment variables.
+func (suite *ConfigSuite) TestParseIntegrity() {
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	suite.Require().False(config.Storage.VerifyManifests())
+	suite.Require().False(config.Storage.LinkChecksums())
+
+	yml := strings.Replace(configYamlV0_1, "  tag:\n", "  integrity:\n    verifymanifests: true\n    linkchecksums: true\n  tag:\n", 1)
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().True(config.Storage.VerifyManifests())
+	suite.Require().True(config.Storage.LinkChecksums())
+	suite.Require().Equal("somedriver", config.Storage.Type())
+
+	suite.T().Setenv("REGISTRY_STORAGE_INTEGRITY_VERIFYMANIFESTS", "true")
+	suite.T().Setenv("REGISTRY_STORAGE_INTEGRITY_LINKCHECKSUMS", "true")
+	config, err = Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	suite.Require().NoError(err)
+	suite.Require().True(config.Storage.VerifyManifests())
+	suite.Require().True(config.Storage.LinkChecksums())
+}
+
+// TestParseCatalogChanges validates that the change log can be enabled from
+// the configuration file and from environment variables.
+func (suite *ConfigSuite) TestParseCatalogChanges() {
+	yml := configYamlV0_1 + `catalog:
+  changes:
+    enabled: true
+    retention: 72h
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().True(config.Catalog.Changes.Enabled)
+	suite.Require().Equal(72*time.Hour, config.Catalog.Changes.Retention)
+	suite.Require().Equal(1000, config.Catalog.MaxEntries)
+
+	suite.T().Setenv("REGISTRY_CATALOG_CHANGES_RETENTION", "24h")
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(24*time.Hour, config.Catalog.Changes.Retention)
+}
+
+// TestParseHTTPTimeouts validates that the timeouts of the HTTP server and
+// their per-route overrides can be set from the configuration file and from
+// environment variables.
+func (suite *ConfigSuite) TestParseHTTPTimeouts() {
+	yml := configYamlV0_1 + `http:
+  timeouts:
+    readheader: 10s
+    write: 5m
+    idle: 2m
+    routes:
+      - route: blob-upload-chunk
+        methods: [PUT, PATCH]
+        timeout: 1h
+      - route: tags
+        timeout: 10s
+  maxheaderbytes: 65536
+  http2:
+    maxconcurrentstreams: 100
+    idletimeout: 1m
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(HTTPTimeouts{
+		ReadHeader: 10 * time.Second,
+		Write:      5 * time.Minute,
+		Idle:       2 * time.Minute,
+		Routes: []RouteTimeout{
+			{Route: "blob-upload-chunk", Methods: []string{"PUT", "PATCH"}, Timeout: time.Hour},
+			{Route: "tags", Timeout: 10 * time.Second},
+		},
+	}, config.HTTP.Timeouts)
+	suite.Require().Equal(65536, config.HTTP.MaxHeaderBytes)
+	suite.Require().Equal(uint32(100), config.HTTP.HTTP2.MaxConcurrentStreams)
+	suite.Require().Equal(time.Minute, config.HTTP.HTTP2.IdleTimeout)
+
+	suite.T().Setenv("REGISTRY_HTTP_TIMEOUTS_WRITE", "0s")
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Zero(config.HTTP.Timeouts.Write)
+}
+
+// TestParsePrometheusRepositories validates that the labelling of the route
+// metrics by repository namespace can be configured.
+func (suite *ConfigSuite) TestParsePrometheusRepositories() {
+	yml := configYamlV0_1 + `http:
+  debug:
+    addr: localhost:5001
+    prometheus:
+      enabled: true
+      repositories:
+        enabled: true
+        maxnamespaces: 20
+        namespaces: [library, team]
+`
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(PrometheusRepositories{
+		Enabled:       true,
+		MaxNamespaces: 20,
+		Namespaces:    []string{"library", "team"},
+	}, config.HTTP.Debug.Prometheus.Repositories)
+
+	suite.T().Setenv("REGISTRY_HTTP_DEBUG_PROMETHEUS_REPOSITORIES_MAXNAMESPACES", "50")
+	config, err = Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(50, config.HTTP.Debug.Prometheus.Repositories.MaxNamespaces)
+}
+
+// TestParseManifestPolicy validates that manifest limits and their
+// per-repository overrides can be set from the configuration file and from
+// environment variables.
+func (suite *ConfigSuite) TestParseManifestPolicy() {
+	yml := configYamlV0_1 + `policy:
+  manifests:
+    maxbytes: 1048576
+    maxlayers: 100
+    repositories:
+      - names: ["ci/.*"]
+        maxlayers: 200
+        maximagesize: -1
+`
+	suite.T().Setenv("REGISTRY_POLICY_MANIFESTS_MAXIMAGESIZE", "10737418240")
+	config, err := Parse(bytes.NewReader([]byte(yml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(ManifestPolicy{
+		MaxBytes:     1048576,
+		MaxLayers:    100,
+		MaxImageSize: 10737418240,
+		Repositories: []ManifestPolicyOverride{
+			{
+				Names:        []string{"ci/.*"},
+				MaxLayers:    200,
+				MaxImageSize: -1,
+			},
+		},
+	}, config.Policy.Manifests)
+}
+
 // TestParseEnvWrongTypeMap validates that incorrectly attempting to unmarshal a
 // string over existing map fails.
 func (suite *ConfigSuite) TestParseEnvWrongTypeMap() {
@@ -21,7 +21,8 @@ var ErrManifestNotModified = errors.New("manifest not modified")
 var ErrUnsupported = errors.New("operation unsupported")
 
 // ErrSchemaV1Unsupported is returned when a client tries to upload a schema v1
-// manifest but the registry is configured to reject it
+// manifest but the registry is configured to reject it, or when a schema v1
+// manifest is read from storage.
 var ErrSchemaV1Unsupported = errors.New("manifest schema v1 unsupported")
 
 // ErrTagUnknown is returned if the given tag is not known by the tag service
@@ -76,6 +77,19 @@ func (err ErrManifestUnknownRevision) Error() string {
 	return fmt.Sprintf("unknown manifest name=%s revision=%s", err.Name, err.Revision)
 }
 
+// ErrManifestDigestMismatch is returned when the content of a manifest read
+// from storage does not match its digest, for example because the storage
+// backend was modified.
+type ErrManifestDigestMismatch struct {
+	Name     string
+	Revision digest.Digest
+	Actual   digest.Digest
+}
+
+func (err ErrManifestDigestMismatch) Error() string {
+	return fmt.Sprintf("manifest name=%s revision=%s does not match its content digest %s", err.Name, err.Revision, err.Actual)
+}
+
 // ErrManifestUnverified is returned when the registry is unable to verify
 // the manifest.
 type ErrManifestUnverified struct{}
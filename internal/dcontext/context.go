@@ -16,7 +16,7 @@ type instanceContext struct {
 }
 
 func (ic *instanceContext) Value(key interface{}) interface{} {
-	if key == "instance.id" {
+	if resolveKey(key) == InstanceIDKey {
 		ic.once.Do(func() {
 			// We want to lazy initialize the UUID such that we don't
 			// call a random generator from the package initialization
@@ -49,7 +49,7 @@ type stringMapContext struct {
 }
 
 // WithValues returns a context that proxies lookups through a map. Only
-// supports string keys.
+// supports string keys, which may also be looked up with the equivalent Key.
 func WithValues(ctx context.Context, m map[string]interface{}) context.Context {
 	mo := make(map[string]interface{}, len(m)) // make our own copy.
 	for k, v := range m {
@@ -63,10 +63,15 @@ func WithValues(ctx context.Context, m map[string]interface{}) context.Context {
 }
 
 func (smc stringMapContext) Value(key interface{}) interface{} {
-	if ks, ok := key.(string); ok {
-		if v, ok := smc.m[ks]; ok {
-			return v
-		}
+	var ks string
+	switch k := key.(type) {
+	case string:
+		ks = k
+	case Key:
+		ks = k.String()
+	}
+	if v, ok := smc.m[ks]; ok && ks != "" {
+		return v
 	}
 
 	return smc.Context.Value(key)
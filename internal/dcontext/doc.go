@@ -85,4 +85,15 @@
 // can be traced in log messages. Using the fields like "http.request.id", one
 // can analyze call flow for a particular request with a simple grep of the
 // logs.
+//
+// # Typed Keys
+//
+// The values provided by this package can be looked up with either their
+// string key or the equivalent Key, such as RequestIDKey for
+// "http.request.id". Typed keys are cheaper to look up, and log with the same
+// field names as the string keys. GetRequest and GetVars return the request
+// and the gorilla/mux variables directly, and WithValueLogger pushes a logger
+// with values from the context:
+//
+//	ctx = WithValueLogger(ctx, RequestIDKey)
 package dcontext
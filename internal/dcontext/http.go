@@ -1,8 +1,12 @@
 package dcontext
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -25,7 +29,7 @@ var (
 // the prefix "http.request.". If a request is already present on the context,
 // this method will panic.
 func WithRequest(ctx context.Context, r *http.Request) context.Context {
-	if ctx.Value("http.request") != nil {
+	if ctx.Value(RequestKey) != nil {
 		// NOTE(stevvooe): This needs to be considered a programming error. It
 		// is unlikely that we'd want to have more than one request in
 		// context.
@@ -43,7 +47,7 @@ func WithRequest(ctx context.Context, r *http.Request) context.Context {
 // GetRequestID attempts to resolve the current request id, if possible. An
 // error is return if it is not available on the context.
 func GetRequestID(ctx context.Context) string {
-	return GetStringValue(ctx, "http.request.id")
+	return GetStringValue(ctx, RequestIDKey)
 }
 
 // WithResponseWriter returns a new context and response writer that makes
@@ -60,7 +64,7 @@ func WithResponseWriter(ctx context.Context, w http.ResponseWriter) (context.Con
 // context. If not present, ErrNoResponseWriterContext is returned. The
 // returned instance provides instrumentation in the context.
 func GetResponseWriter(ctx context.Context) (http.ResponseWriter, error) {
-	v := ctx.Value("http.response")
+	v := ctx.Value(ResponseKey)
 
 	rw, ok := v.(http.ResponseWriter)
 	if !ok || rw == nil {
@@ -91,14 +95,14 @@ func WithVars(ctx context.Context, r *http.Request) context.Context {
 // fields will display. Request loggers can safely be pushed onto the context.
 func GetRequestLogger(ctx context.Context) Logger {
 	return GetLogger(ctx,
-		"http.request.id",
-		"http.request.method",
-		"http.request.host",
-		"http.request.uri",
-		"http.request.referer",
-		"http.request.useragent",
-		"http.request.remoteaddr",
-		"http.request.contenttype")
+		RequestIDKey,
+		RequestMethodKey,
+		RequestHostKey,
+		RequestURIKey,
+		RequestRefererKey,
+		RequestUserAgentKey,
+		RequestRemoteAddrKey,
+		RequestContentTypeKey)
 }
 
 // GetResponseLogger reads the current response stats and builds a logger.
@@ -107,11 +111,11 @@ func GetRequestLogger(ctx context.Context) Logger {
 // call this at the end of a request, after the response has been written.
 func GetResponseLogger(ctx context.Context) Logger {
 	l := getLogrusLogger(ctx,
-		"http.response.written",
-		"http.response.status",
-		"http.response.contenttype")
+		ResponseWrittenKey,
+		ResponseStatusKey,
+		ResponseContentTypeKey)
 
-	duration := Since(ctx, "http.request.startedat")
+	duration := Since(ctx, RequestStartedAtKey)
 
 	if duration > 0 {
 		l = l.WithField("http.response.duration", duration.String())
@@ -133,36 +137,34 @@ type httpRequestContext struct {
 // the request itself, query "request". For other components, access them as
 // "request.<component>". For example, r.RequestURI
 func (ctx *httpRequestContext) Value(key interface{}) interface{} {
-	if keyStr, ok := key.(string); ok {
-		switch keyStr {
-		case "http.request":
-			return ctx.r
-		case "http.request.uri":
-			return ctx.r.RequestURI
-		case "http.request.remoteaddr":
-			return requestutil.RemoteAddr(ctx.r)
-		case "http.request.method":
-			return ctx.r.Method
-		case "http.request.host":
-			return ctx.r.Host
-		case "http.request.referer":
-			referer := ctx.r.Referer()
-			if referer != "" {
-				return referer
-			}
-		case "http.request.useragent":
-			return ctx.r.UserAgent()
-		case "http.request.id":
-			return ctx.id
-		case "http.request.startedat":
-			return ctx.startedAt
-		case "http.request.contenttype":
-			if ct := ctx.r.Header.Get("Content-Type"); ct != "" {
-				return ct
-			}
-		default:
-			// no match; fall back to standard behavior below
+	switch resolveKey(key) {
+	case RequestKey:
+		return ctx.r
+	case RequestURIKey:
+		return ctx.r.RequestURI
+	case RequestRemoteAddrKey:
+		return requestutil.RemoteAddr(ctx.r)
+	case RequestMethodKey:
+		return ctx.r.Method
+	case RequestHostKey:
+		return ctx.r.Host
+	case RequestRefererKey:
+		referer := ctx.r.Referer()
+		if referer != "" {
+			return referer
+		}
+	case RequestUserAgentKey:
+		return ctx.r.UserAgent()
+	case RequestIDKey:
+		return ctx.id
+	case RequestStartedAtKey:
+		return ctx.startedAt
+	case RequestContentTypeKey:
+		if ct := ctx.r.Header.Get("Content-Type"); ct != "" {
+			return ct
 		}
+	default:
+		// no match; fall back to standard behavior below
 	}
 
 	return ctx.Context.Value(key)
@@ -174,10 +176,10 @@ type muxVarsContext struct {
 }
 
 func (ctx *muxVarsContext) Value(key interface{}) interface{} {
+	if resolveKey(key) == VarsKey {
+		return ctx.vars
+	}
 	if keyStr, ok := key.(string); ok {
-		if keyStr == "vars" {
-			return ctx.vars
-		}
 		// TODO(thaJeztah): this considers "vars.FOO" and "FOO" to be equal.
 		// We need to check if that's intentional (could be a bug).
 		if v, ok := ctx.vars[strings.TrimPrefix(keyStr, "vars.")]; ok {
@@ -189,8 +191,8 @@ func (ctx *muxVarsContext) Value(key interface{}) interface{} {
 }
 
 // instrumentedResponseWriter provides response writer information in a
-// context. This variant is only used in the case where CloseNotifier is not
-// implemented by the parent ResponseWriter.
+// context. Flush, ReadFrom and Hijack are passed through to the parent
+// ResponseWriter where it supports them.
 type instrumentedResponseWriter struct {
 	http.ResponseWriter
 	context.Context
@@ -230,26 +232,73 @@ func (irw *instrumentedResponseWriter) Flush() {
 	}
 }
 
+// ReadFrom copies from r using the ReadFrom method of the parent
+// ResponseWriter when it has one, allowing the server to send files without
+// copying them through user space.
+func (irw *instrumentedResponseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if rf, ok := irw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// Hide ReadFrom from io.Copy so that it does not call back here.
+		n, err = io.Copy(struct{ io.Writer }{irw.ResponseWriter}, r)
+	}
+
+	irw.mu.Lock()
+	irw.written += n
+	if irw.status == 0 && n > 0 {
+		irw.status = http.StatusOK
+	}
+	irw.mu.Unlock()
+
+	return n, err
+}
+
+// Hijack lets the handler take over the connection, if the parent
+// ResponseWriter supports it. The response status is recorded as 101
+// Switching Protocols.
+func (irw *instrumentedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := irw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer %T does not support hijacking", irw.ResponseWriter)
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	irw.mu.Lock()
+	if irw.status == 0 {
+		irw.status = http.StatusSwitchingProtocols
+	}
+	irw.mu.Unlock()
+
+	return conn, rw, nil
+}
+
+// Unwrap returns the parent ResponseWriter, for http.ResponseController to
+// reach the connection, to set its deadlines for instance.
+func (irw *instrumentedResponseWriter) Unwrap() http.ResponseWriter {
+	return irw.ResponseWriter
+}
+
 func (irw *instrumentedResponseWriter) Value(key interface{}) interface{} {
-	if keyStr, ok := key.(string); ok {
-		switch keyStr {
-		case "http.response":
-			return irw
-		case "http.response.written":
-			irw.mu.Lock()
-			defer irw.mu.Unlock()
-			return irw.written
-		case "http.response.status":
-			irw.mu.Lock()
-			defer irw.mu.Unlock()
-			return irw.status
-		case "http.response.contenttype":
-			if ct := irw.Header().Get("Content-Type"); ct != "" {
-				return ct
-			}
-		default:
-			// no match; fall back to standard behavior below
+	switch resolveKey(key) {
+	case ResponseKey:
+		return irw
+	case ResponseWrittenKey:
+		irw.mu.Lock()
+		defer irw.mu.Unlock()
+		return irw.written
+	case ResponseStatusKey:
+		irw.mu.Lock()
+		defer irw.mu.Unlock()
+		return irw.status
+	case ResponseContentTypeKey:
+		if ct := irw.Header().Get("Content-Type"); ct != "" {
+			return ct
 		}
+	default:
+		// no match; fall back to standard behavior below
 	}
 
 	return irw.Context.Value(key)
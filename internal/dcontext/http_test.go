@@ -1,8 +1,11 @@
 package dcontext
 
 import (
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -216,3 +219,60 @@ func TestWithVars(t *testing.T) {
 		}
 	}
 }
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	trw := testResponseWriter{}
+	ctx, rw := WithResponseWriter(Background(), &trw)
+
+	rf, ok := rw.(io.ReaderFrom)
+	if !ok {
+		t.Fatal("instrumented response writer does not implement io.ReaderFrom")
+	}
+	n, err := rf.ReadFrom(strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if n != 10 || trw.written != 10 {
+		t.Fatalf("unexpected bytes written: %d, %d", n, trw.written)
+	}
+	if ctx.Value("http.response.written") != int64(10) {
+		t.Fatalf("unexpected written bytes in context: %v", ctx.Value("http.response.written"))
+	}
+	if ctx.Value("http.response.status") != http.StatusOK {
+		t.Fatalf("unexpected status in context: %v", ctx.Value("http.response.status"))
+	}
+}
+
+func TestResponseWriterHijack(t *testing.T) {
+	var status interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, rw := WithResponseWriter(Background(), w)
+		conn, buf, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unexpected error hijacking connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		status = ctx.Value("http.response.status")
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: close\r\n\r\n")
+		buf.Flush()
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected response status: %d", resp.StatusCode)
+	}
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status in context: %v", status)
+	}
+
+	_, rw := WithResponseWriter(Background(), &testResponseWriter{})
+	if _, _, err := rw.(http.Hijacker).Hijack(); err == nil {
+		t.Fatal("expected error hijacking a response writer without support")
+	}
+}
@@ -0,0 +1,141 @@
+package dcontext
+
+import (
+	"context"
+	"net/http"
+)
+
+// Key is a typed key for a value provided by the contexts of this package.
+// Looking up a Key compares integers where the equivalent string key is
+// compared against every string key a context provides. The string keys
+// remain supported: String returns the equivalent string key, which is also
+// the field name used when the key is passed to GetLogger.
+type Key int
+
+// Keys for the values provided by the contexts of this package. Each is
+// equivalent to the string key in its comment.
+const (
+	InstanceIDKey          Key = iota + 1 // "instance.id"
+	RequestKey                            // "http.request"
+	RequestIDKey                          // "http.request.id"
+	RequestMethodKey                      // "http.request.method"
+	RequestHostKey                        // "http.request.host"
+	RequestURIKey                         // "http.request.uri"
+	RequestRefererKey                     // "http.request.referer"
+	RequestUserAgentKey                   // "http.request.useragent"
+	RequestRemoteAddrKey                  // "http.request.remoteaddr"
+	RequestContentTypeKey                 // "http.request.contenttype"
+	RequestStartedAtKey                   // "http.request.startedat"
+	ResponseKey                           // "http.response"
+	ResponseWrittenKey                    // "http.response.written"
+	ResponseStatusKey                     // "http.response.status"
+	ResponseContentTypeKey                // "http.response.contenttype"
+	VarsKey                               // "vars"
+	UserKey                               // "auth.user"
+	UserNameKey                           // "auth.user.name"
+)
+
+var keyNames = [...]string{
+	InstanceIDKey:          "instance.id",
+	RequestKey:             "http.request",
+	RequestIDKey:           "http.request.id",
+	RequestMethodKey:       "http.request.method",
+	RequestHostKey:         "http.request.host",
+	RequestURIKey:          "http.request.uri",
+	RequestRefererKey:      "http.request.referer",
+	RequestUserAgentKey:    "http.request.useragent",
+	RequestRemoteAddrKey:   "http.request.remoteaddr",
+	RequestContentTypeKey:  "http.request.contenttype",
+	RequestStartedAtKey:    "http.request.startedat",
+	ResponseKey:            "http.response",
+	ResponseWrittenKey:     "http.response.written",
+	ResponseStatusKey:      "http.response.status",
+	ResponseContentTypeKey: "http.response.contenttype",
+	VarsKey:                "vars",
+	UserKey:                "auth.user",
+	UserNameKey:            "auth.user.name",
+}
+
+var keysByName = func() map[string]Key {
+	m := make(map[string]Key, len(keyNames))
+	for k, name := range keyNames {
+		if name != "" {
+			m[name] = Key(k)
+		}
+	}
+	return m
+}()
+
+// String returns the string key equivalent to k.
+func (k Key) String() string {
+	if k <= 0 || int(k) >= len(keyNames) {
+		return ""
+	}
+	return keyNames[k]
+}
+
+// resolveKey returns the Key for a typed or string key, or zero if key is
+// neither.
+func resolveKey(key interface{}) Key {
+	switch k := key.(type) {
+	case Key:
+		return k
+	case string:
+		return keysByName[k]
+	}
+	return 0
+}
+
+// GetRequest returns the http request placed on the context by WithRequest.
+func GetRequest(ctx context.Context) (*http.Request, error) {
+	if r, ok := ctx.Value(RequestKey).(*http.Request); ok && r != nil {
+		return r, nil
+	}
+	return nil, ErrNoRequestContext
+}
+
+// GetVars returns the gorilla/mux variables placed on the context by
+// WithVars, or nil if there are none.
+func GetVars(ctx context.Context) map[string]string {
+	vars, _ := ctx.Value(VarsKey).(map[string]string)
+	return vars
+}
+
+// WithUser returns a context providing the authenticated user, and its name,
+// under UserKey and UserNameKey.
+func WithUser(ctx context.Context, user interface{}, name string) context.Context {
+	return &userContext{
+		Context: ctx,
+		user:    user,
+		name:    name,
+	}
+}
+
+// GetUserName returns the name of the user placed on the context by
+// WithUser, or the empty string if there is none.
+func GetUserName(ctx context.Context) string {
+	return GetStringValue(ctx, UserNameKey)
+}
+
+type userContext struct {
+	context.Context
+	user interface{}
+	name string
+}
+
+func (ctx *userContext) Value(key interface{}) interface{} {
+	switch resolveKey(key) {
+	case UserKey:
+		return ctx.user
+	case UserNameKey:
+		return ctx.name
+	}
+	return ctx.Context.Value(key)
+}
+
+// WithValueLogger returns a context with a logger which includes the values
+// of keys resolved from ctx. It is shorthand for
+// WithLogger(ctx, GetLogger(ctx, keys...)).
+func WithValueLogger(ctx context.Context, keys ...interface{}) context.Context {
+	return WithLogger(ctx, GetLogger(ctx, keys...))
+}
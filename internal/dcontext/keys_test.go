@@ -0,0 +1,154 @@
+package dcontext
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestKeyStringCompatibility(t *testing.T) {
+	req := &http.Request{
+		Method:     http.MethodPut,
+		Host:       "example.com",
+		RequestURI: "/v2/",
+		RemoteAddr: "10.0.0.1:1234",
+		Header:     http.Header{"User-Agent": []string{"test/0.1"}},
+	}
+	ctx := WithRequest(Background(), req)
+	ctx, rw := WithResponseWriter(ctx, &testResponseWriter{})
+	rw.WriteHeader(http.StatusAccepted)
+
+	for k := range keyNames {
+		key := Key(k)
+		if key.String() == "" {
+			continue
+		}
+		typed, str := ctx.Value(key), ctx.Value(key.String())
+		if !reflect.DeepEqual(typed, str) {
+			t.Fatalf("%s: typed value %v != string value %v", key, typed, str)
+		}
+	}
+
+	if ctx.Value(RequestMethodKey) != http.MethodPut {
+		t.Fatalf("unexpected method: %v", ctx.Value(RequestMethodKey))
+	}
+	if ctx.Value(ResponseStatusKey) != http.StatusAccepted {
+		t.Fatalf("unexpected status: %v", ctx.Value(ResponseStatusKey))
+	}
+
+	// Values set with string keys can be looked up with typed keys.
+	ctx = WithValues(context.Background(), map[string]interface{}{"http.request.useragent": "agent"})
+	if GetStringValue(ctx, RequestUserAgentKey) != "agent" {
+		t.Fatalf("expected string keyed value to be found with typed key")
+	}
+}
+
+func TestGetRequest(t *testing.T) {
+	if _, err := GetRequest(Background()); err != ErrNoRequestContext {
+		t.Fatalf("expected ErrNoRequestContext, got %v", err)
+	}
+
+	req := &http.Request{Header: http.Header{}}
+	r, err := GetRequest(WithRequest(Background(), req))
+	if err != nil {
+		t.Fatalf("unexpected error getting request: %v", err)
+	}
+	if r != req {
+		t.Fatalf("unexpected request: %v != %v", r, req)
+	}
+}
+
+func TestWithUser(t *testing.T) {
+	if name := GetUserName(Background()); name != "" {
+		t.Fatalf("unexpected user name without user: %q", name)
+	}
+
+	user := struct{ Name string }{Name: "ci"}
+	ctx := WithUser(Background(), user, user.Name)
+	if name := GetUserName(ctx); name != "ci" {
+		t.Fatalf("unexpected user name: %q", name)
+	}
+	if ctx.Value(UserKey) != user || ctx.Value("auth.user") != user {
+		t.Fatalf("unexpected user: %v", ctx.Value(UserKey))
+	}
+	if ctx.Value("auth.user.name") != "ci" {
+		t.Fatalf("unexpected user name for string key: %v", ctx.Value("auth.user.name"))
+	}
+}
+
+func TestGetVars(t *testing.T) {
+	if vars := GetVars(Background()); vars != nil {
+		t.Fatalf("expected no vars, got %v", vars)
+	}
+
+	var req http.Request
+	vars := map[string]string{"name": "foo/bar"}
+	getVarsFromRequest = func(r *http.Request) map[string]string {
+		return vars
+	}
+
+	ctx := WithVars(Background(), &req)
+	if !reflect.DeepEqual(GetVars(ctx), vars) {
+		t.Fatalf("unexpected vars: %v != %v", GetVars(ctx), vars)
+	}
+}
+
+func TestWithValueLogger(t *testing.T) {
+	ctx := WithValues(Background(), map[string]interface{}{"test.field": "value"})
+	ctx = WithValueLogger(ctx, "test.field")
+
+	entry, ok := GetLogger(ctx).(*logrus.Entry)
+	if !ok {
+		t.Fatalf("unexpected logger type %T", GetLogger(ctx))
+	}
+	if entry.Data["test.field"] != "value" {
+		t.Fatalf("expected field on logger, got %v", entry.Data)
+	}
+}
+
+// benchmarkContext returns a context resembling that of a registry request,
+// with request, vars, response writer and logger contexts stacked on the
+// background context.
+func benchmarkContext() context.Context {
+	req := &http.Request{
+		Method:     http.MethodGet,
+		RequestURI: "/v2/foo/bar/manifests/latest",
+		RemoteAddr: "10.0.0.1:1234",
+		Header:     http.Header{"User-Agent": []string{"test/0.1"}},
+	}
+	ctx := WithRequest(Background(), req)
+	ctx, _ = WithResponseWriter(ctx, &testResponseWriter{})
+	ctx = WithVars(ctx, req)
+	ctx = WithValueLogger(ctx, "vars.name")
+	return WithValues(ctx, map[string]interface{}{"auth.user.name": "user"})
+}
+
+func BenchmarkValueStringKey(b *testing.B) {
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GetStringValue(ctx, "http.request.method")
+	}
+}
+
+func BenchmarkValueTypedKey(b *testing.B) {
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GetStringValue(ctx, RequestMethodKey)
+	}
+}
+
+func BenchmarkGetRequestLogger(b *testing.B) {
+	ctx := benchmarkContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GetRequestLogger(ctx)
+	}
+}
@@ -117,7 +117,7 @@ func getLogrusLogger(ctx context.Context, keys ...interface{}) *logrus.Entry {
 		fields := logrus.Fields{}
 
 		// Fill in the instance id, if we have it.
-		instanceID := ctx.Value("instance.id")
+		instanceID := ctx.Value(InstanceIDKey)
 		if instanceID != nil {
 			fields["instance.id"] = instanceID
 		}
@@ -12,7 +12,7 @@ func (versionKey) String() string { return "version" }
 func WithVersion(ctx context.Context, version string) context.Context {
 	ctx = context.WithValue(ctx, versionKey{}, version)
 	// push a new logger onto the stack
-	return WithLogger(ctx, GetLogger(ctx, versionKey{}))
+	return WithValueLogger(ctx, versionKey{})
 }
 
 // GetVersion returns the application version from the context. An empty
@@ -0,0 +1,176 @@
+// Package spiffe fetches the X.509 SVIDs identifying the registry from the
+// SPIFFE Workload API, and configures TLS clients with them.
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// EndpointSocketEnv is the environment variable giving the address of the
+// Workload API when none is configured.
+const EndpointSocketEnv = workloadapi.SocketEnv
+
+// errNotFetched is returned until the first SVID was fetched.
+var errNotFetched = errors.New("spiffe: no svid fetched from workload api yet")
+
+var (
+	sourcesMu sync.Mutex
+	sources   = make(map[string]*Source)
+)
+
+// SourceFor returns the source of the SVIDs fetched from the Workload API at
+// addr, or at the address given by SPIFFE_ENDPOINT_SOCKET if addr is empty.
+// Sources are shared by all the clients of the process.
+func SourceFor(addr string) (*Source, error) {
+	if addr == "" {
+		addr = os.Getenv(EndpointSocketEnv)
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("spiffe: no workload api address, set %s or configure the endpoint socket", EndpointSocketEnv)
+	}
+
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if source, ok := sources[addr]; ok {
+		return source, nil
+	}
+	source, err := NewSource(addr)
+	if err != nil {
+		return nil, err
+	}
+	sources[addr] = source
+	return source, nil
+}
+
+// Source keeps the latest X.509 SVID of the workload and the bundles of the
+// trust domains it trusts, watched from the Workload API. The Workload API
+// pushes new SVIDs before the current ones expire, so that the SVIDs of the
+// source rotate without the clients using it noticing.
+//
+// Unlike the X509Source it wraps, a Source is usable before the Workload API
+// answered, so that the registry starts while the agent is unavailable.
+// Connections fail until the first SVID is fetched.
+type Source struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// ready is closed once the first SVID was received, or the source
+	// failed.
+	ready  chan struct{}
+	source *workloadapi.X509Source
+	err    error
+}
+
+// NewSource returns a source of the SVIDs fetched from the Workload API at
+// addr, a unix:// or tcp:// URL. SVIDs are fetched in the background until
+// the source is closed.
+func NewSource(addr string) (*Source, error) {
+	if err := workloadapi.ValidateAddress(addr); err != nil {
+		return nil, fmt.Errorf("spiffe: invalid workload api address %q: %w", addr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		ready:  make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		// NewX509Source retries with backoff until the first SVID is
+		// received or ctx is done.
+		s.source, s.err = workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(
+			workloadapi.WithAddr(addr),
+			workloadapi.WithLogger(dcontext.GetLogger(ctx)),
+		))
+		close(s.ready)
+	}()
+	return s, nil
+}
+
+// Close stops fetching SVIDs.
+func (s *Source) Close() error {
+	s.cancel()
+	<-s.done
+	if s.source == nil {
+		return nil
+	}
+	return s.source.Close()
+}
+
+// fetched returns the X509Source once the first SVID was received, or
+// errNotFetched.
+func (s *Source) fetched() (*workloadapi.X509Source, error) {
+	select {
+	case <-s.ready:
+	default:
+		return nil, errNotFetched
+	}
+	if s.err != nil {
+		return nil, fmt.Errorf("spiffe: error fetching svids from workload api: %w", s.err)
+	}
+	return s.source, nil
+}
+
+// GetX509SVID returns the current SVID of the workload. It implements the
+// x509svid.Source interface.
+func (s *Source) GetX509SVID() (*x509svid.SVID, error) {
+	source, err := s.fetched()
+	if err != nil {
+		return nil, err
+	}
+	return source.GetX509SVID()
+}
+
+// GetX509BundleForTrustDomain returns the bundle of a trust domain trusted
+// by the workload. It implements the x509bundle.Source interface.
+func (s *Source) GetX509BundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	source, err := s.fetched()
+	if err != nil {
+		return nil, err
+	}
+	return source.GetX509BundleForTrustDomain(trustDomain)
+}
+
+// ID returns the SPIFFE ID of the current SVID of the workload, or an empty
+// string if none was fetched yet.
+func (s *Source) ID() string {
+	svid, err := s.GetX509SVID()
+	if err != nil {
+		return ""
+	}
+	return svid.ID.String()
+}
+
+// TLSConfig returns the configuration of TLS clients presenting the SVID of
+// the workload, and authenticating the server by its SVID rather than by its
+// host name. The server must present an SVID of a trust domain trusted by the
+// workload and, if serverIDs are given, with one of these SPIFFE IDs.
+func (s *Source) TLSConfig(serverIDs []string) (*tls.Config, error) {
+	authorizer := tlsconfig.AuthorizeAny()
+	if len(serverIDs) > 0 {
+		ids := make([]spiffeid.ID, 0, len(serverIDs))
+		for _, serverID := range serverIDs {
+			id, err := spiffeid.FromString(serverID)
+			if err != nil {
+				return nil, fmt.Errorf("spiffe: invalid server id %q: %w", serverID, err)
+			}
+			ids = append(ids, id)
+		}
+		authorizer = tlsconfig.AuthorizeOneOf(ids...)
+	}
+
+	return tlsconfig.MTLSClientConfig(s, s, authorizer), nil
+}
@@ -0,0 +1,268 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"google.golang.org/grpc"
+)
+
+// testCA issues the SVIDs of a trust domain.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, trustDomain string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: trustDomain},
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: trustDomain}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns the certificate and PKCS#8 key of an SVID with id.
+func (ca *testCA) issue(t *testing.T, id string) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		URIs:         []*url.URL{u},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pkcs8
+}
+
+// svidResponse returns a Workload API response with the SVID id issued by ca.
+func (ca *testCA) svidResponse(t *testing.T, id string) *workload.X509SVIDResponse {
+	cert, _, key := ca.issue(t, id)
+	return &workload.X509SVIDResponse{Svids: []*workload.X509SVID{{
+		SpiffeId:    id,
+		X509Svid:    cert.Raw,
+		X509SvidKey: key,
+		Bundle:      ca.cert.Raw,
+	}}}
+}
+
+// workloadAPI streams the responses sent on its channel to every client.
+type workloadAPI struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	responses chan *workload.X509SVIDResponse
+}
+
+func (api *workloadAPI) FetchX509SVID(_ *workload.X509SVIDRequest, stream workload.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case resp := <-api.responses:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startWorkloadAPI serves a Workload API on a unix socket, streaming the
+// responses sent on the returned channel to every client.
+func startWorkloadAPI(t *testing.T) (string, chan<- *workload.X509SVIDResponse) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &workloadAPI{responses: make(chan *workload.X509SVIDResponse)}
+
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, api)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	return "unix://" + socket, api.responses
+}
+
+func TestSource(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	addr, responses := startWorkloadAPI(t)
+
+	source, err := NewSource(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	// Servers require clients to present an SVID of the trust domain, and
+	// respond with its ID.
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	startServer := func(ca *testCA, id string) *httptest.Server {
+		cert, key, _ := ca.issue(t, id)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.TLS.PeerCertificates[0].URIs[0])
+		}))
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    roots,
+		}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		return server
+	}
+	server := startServer(ca, "spiffe://example.org/upstream")
+
+	get := func(server *httptest.Server, serverIDs ...string) (string, error) {
+		config, err := source.TLSConfig(serverIDs)
+		if err != nil {
+			return "", err
+		}
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   config,
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		p, err := io.ReadAll(resp.Body)
+		return string(p), err
+	}
+
+	// Connections fail until the first SVID is fetched.
+	if _, err := get(server); err == nil {
+		t.Fatal("expected connection without svid to fail")
+	}
+	if _, err := get(server, "https://example.org/upstream"); err == nil {
+		t.Fatal("expected error for invalid server id")
+	}
+
+	responses <- ca.svidResponse(t, "spiffe://example.org/registry")
+	deadline := time.Now().Add(10 * time.Second)
+	for source.ID() != "spiffe://example.org/registry" {
+		if time.Now().After(deadline) {
+			t.Fatal("no svid fetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if id, err := get(server, "spiffe://example.org/upstream"); err != nil || id != "spiffe://example.org/registry" {
+		t.Fatalf("expected to authenticate as spiffe://example.org/registry, got %q, %v", id, err)
+	}
+	if _, err := get(server, "spiffe://example.org/other"); err == nil {
+		t.Fatal("expected server with unexpected id to be rejected")
+	}
+
+	// Rotated SVIDs are used by the following connections.
+	responses <- ca.svidResponse(t, "spiffe://example.org/registry-rotated")
+	deadline = time.Now().Add(10 * time.Second)
+	for source.ID() != "spiffe://example.org/registry-rotated" {
+		if time.Now().After(deadline) {
+			t.Fatalf("svid not rotated, got %s", source.ID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if id, err := get(server); err != nil || id != "spiffe://example.org/registry-rotated" {
+		t.Fatalf("expected to authenticate as spiffe://example.org/registry-rotated, got %q, %v", id, err)
+	}
+
+	// Servers of untrusted trust domains are rejected.
+	other := newTestCA(t, "other.org")
+	otherServer := startServer(other, "spiffe://other.org/upstream")
+	if _, err := get(otherServer); err == nil {
+		t.Fatal("expected server of untrusted trust domain to be rejected")
+	}
+
+	// Unless its bundle is federated.
+	resp := ca.svidResponse(t, "spiffe://example.org/registry")
+	resp.FederatedBundles = map[string][]byte{"spiffe://other.org": other.cert.Raw}
+	responses <- resp
+	deadline = time.Now().Add(10 * time.Second)
+	for source.ID() != "spiffe://example.org/registry" {
+		if time.Now().After(deadline) {
+			t.Fatalf("svid not rotated, got %s", source.ID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := get(otherServer, "spiffe://other.org/upstream"); err != nil {
+		t.Fatalf("unexpected error connecting to federated server: %v", err)
+	}
+}
+
+func TestNewSourceAddress(t *testing.T) {
+	for _, tc := range []struct {
+		addr  string
+		valid bool
+	}{
+		{"unix:///run/spire/agent.sock", true},
+		{"tcp://127.0.0.1:8081", true},
+		{"tcp://127.0.0.1", false},
+		{"tcp://localhost:8081", false},
+		{"/run/spire/agent.sock", false},
+	} {
+		source, err := NewSource(tc.addr)
+		if err != nil {
+			if tc.valid {
+				t.Errorf("%s: unexpected error: %v", tc.addr, err)
+			}
+			continue
+		}
+		source.Close()
+		if !tc.valid {
+			t.Errorf("%s: expected error", tc.addr)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package uuid
+
+import (
+	"crypto/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ulidAlphabet is the Crockford base32 alphabet used to encode ULIDs.
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLength is the length of an encoded ULID.
+const ulidLength = 26
+
+var ulidState struct {
+	sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+// NewULID returns a new ULID: a 48 bit millisecond timestamp followed by 80
+// random bits, encoded as 26 characters of Crockford base32. ULIDs sort
+// lexicographically in the order they were generated: within a millisecond,
+// the random bits of each ULID are those of the previous one incremented.
+// Panics if the random source fails or the random bits overflow within a
+// millisecond.
+func NewULID() string {
+	ms := uint64(time.Now().UnixMilli())
+
+	ulidState.Lock()
+	if ms <= ulidState.ms {
+		// Keep the ULIDs of a millisecond, or of a clock step backwards,
+		// ordered.
+		ms = ulidState.ms
+		if !incrementEntropy(&ulidState.entropy) {
+			ulidState.Unlock()
+			panic("uuid: ULID entropy overflow")
+		}
+	} else {
+		if _, err := rand.Read(ulidState.entropy[:]); err != nil {
+			ulidState.Unlock()
+			panic(err)
+		}
+		ulidState.ms = ms
+	}
+	var id [16]byte
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+	copy(id[6:], ulidState.entropy[:])
+	ulidState.Unlock()
+
+	return encodeULID(id)
+}
+
+// incrementEntropy adds one to the big-endian entropy, returning false if
+// it overflows.
+func incrementEntropy(entropy *[10]byte) bool {
+	for i := len(entropy) - 1; i >= 0; i-- {
+		entropy[i]++
+		if entropy[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID encodes the 128 bits of id as 26 base32 characters, the first
+// of which holds only the 3 most significant bits.
+func encodeULID(id [16]byte) string {
+	hi := uint64(id[0])<<56 | uint64(id[1])<<48 | uint64(id[2])<<40 | uint64(id[3])<<32 |
+		uint64(id[4])<<24 | uint64(id[5])<<16 | uint64(id[6])<<8 | uint64(id[7])
+	lo := uint64(id[8])<<56 | uint64(id[9])<<48 | uint64(id[10])<<40 | uint64(id[11])<<32 |
+		uint64(id[12])<<24 | uint64(id[13])<<16 | uint64(id[14])<<8 | uint64(id[15])
+
+	var b [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		b[i] = ulidAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
+
+// isULID returns whether s is a ULID in canonical, upper case form.
+func isULID(s string) bool {
+	if len(s) != ulidLength || s[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'A' <= c && c <= 'Z') || c == 'I' || c == 'L' || c == 'O' || c == 'U' {
+			return false
+		}
+	}
+	return true
+}
+
+// ULIDTime returns the time encoded in the ULID s, to the millisecond. It
+// returns false if s is not a ULID.
+func ULIDTime(s string) (time.Time, bool) {
+	if !isULID(s) {
+		return time.Time{}, false
+	}
+	var ms uint64
+	for i := 0; i < 10; i++ {
+		ms = ms<<5 | uint64(strings.IndexByte(ulidAlphabet, s[i]))
+	}
+	return time.UnixMilli(int64(ms)), true
+}
+
+// ULIDAt returns the lowest ULID of the millisecond of t, which sorts before
+// every ULID generated at or after t.
+func ULIDAt(t time.Time) string {
+	ms := uint64(t.UnixMilli())
+	var id [16]byte
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+	return encodeULID(id)
+}
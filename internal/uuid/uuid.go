@@ -1,11 +1,72 @@
 package uuid
 
 import (
+	"fmt"
+	"sync/atomic"
+
 	"github.com/google/uuid"
 )
 
-// Returns a new V7 UUID string. V7 UUIDs are time-ordered for better database performance.
+// Format names an ID format.
+type Format string
+
+const (
+	// FormatUUID generates V7 UUIDs. It is the default.
+	FormatUUID Format = "uuid"
+
+	// FormatULID generates ULIDs.
+	FormatULID Format = "ulid"
+)
+
+// Generator returns a new unique ID each time it is called.
+type Generator func() string
+
+var generator atomic.Value // Generator
+
+func init() {
+	generator.Store(Generator(newV7))
+}
+
+// NewString returns a new ID from the current generator, a V7 UUID string
+// unless SetGenerator or SetFormat was called. Both V7 UUIDs and ULIDs are
+// time-ordered for better database performance.
 // Panics on error to maintain compatibility with google/uuid's NewString() method.
 func NewString() string {
+	return generator.Load().(Generator)()
+}
+
+// SetGenerator replaces the generator used by NewString. The IDs it returns
+// are used as upload IDs, so they must be valid path components and be
+// accepted by IsValid to be found by upload purging.
+func SetGenerator(g Generator) {
+	if g == nil {
+		g = newV7
+	}
+	generator.Store(g)
+}
+
+// SetFormat sets the generator used by NewString to one of the built-in
+// formats. An empty format selects the default.
+func SetFormat(format Format) error {
+	switch format {
+	case "", FormatUUID:
+		SetGenerator(newV7)
+	case FormatULID:
+		SetGenerator(NewULID)
+	default:
+		return fmt.Errorf("unknown id format %q", format)
+	}
+	return nil
+}
+
+// IsValid returns whether s is a UUID or a ULID.
+func IsValid(s string) bool {
+	if _, err := uuid.Parse(s); err == nil {
+		return true
+	}
+	return isULID(s)
+}
+
+func newV7() string {
 	return uuid.Must(uuid.NewV7()).String()
 }
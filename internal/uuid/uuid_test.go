@@ -0,0 +1,110 @@
+package uuid
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEncodeULID(t *testing.T) {
+	var zero, max [16]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	if s := encodeULID(zero); s != "00000000000000000000000000" {
+		t.Errorf("unexpected encoding of zero ULID: %s", s)
+	}
+	if s := encodeULID(max); s != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("unexpected encoding of max ULID: %s", s)
+	}
+}
+
+func TestNewULIDOrdering(t *testing.T) {
+	ids := make([]string, 0, 1000)
+	for i := 0; i < cap(ids); i++ {
+		if i == cap(ids)/2 {
+			time.Sleep(2 * time.Millisecond)
+		}
+		ids = append(ids, NewULID())
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatalf("ULIDs are not sorted in generation order")
+	}
+	for i, id := range ids {
+		if !isULID(id) {
+			t.Fatalf("invalid ULID %q", id)
+		}
+		if i > 0 && id == ids[i-1] {
+			t.Fatalf("duplicate ULID %q", id)
+		}
+	}
+}
+
+func TestULIDTime(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	id := ULIDAt(now)
+	if id >= NewULID() {
+		t.Fatalf("ULID %s of the current time sorts after a new ULID", id)
+	}
+	for _, s := range []string{id, NewULID()} {
+		ts, ok := ULIDTime(s)
+		if !ok {
+			t.Fatalf("invalid ULID %q", s)
+		}
+		if ts.Before(now) || ts.Sub(now) > time.Second {
+			t.Errorf("unexpected time of ULID %s: %v, expected about %v", s, ts, now)
+		}
+	}
+	if ts, _ := ULIDTime("01ARZ3NDEKTSV4RRFFQ69G5FAV"); ts.UnixMilli() != 1469922850259 {
+		t.Errorf("unexpected time of ULID: %d", ts.UnixMilli())
+	}
+	if _, ok := ULIDTime("startedat"); ok {
+		t.Error("expected invalid ULID to have no time")
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	for s, valid := range map[string]bool{
+		newV7():                                true,
+		NewULID():                              true,
+		"01ARZ3NDEKTSV4RRFFQ69G5FAV":           true,
+		"01arz3ndektsv4rrffq69g5fav":           false,
+		"81ARZ3NDEKTSV4RRFFQ69G5FAV":           false,
+		"01ARZ3NDEKTSV4RRFFQ69G5FAU":           false,
+		"01ARZ3NDEKTSV4RRFFQ69G5FA":            false,
+		"startedat":                            false,
+		"":                                     false,
+		"c5c3c6a5-0d2f-4b2a-9c5d-5f3ce4a0e5ff": true,
+	} {
+		if IsValid(s) != valid {
+			t.Errorf("IsValid(%q) != %t", s, valid)
+		}
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	defer SetGenerator(nil)
+
+	if err := SetFormat(FormatULID); err != nil {
+		t.Fatalf("unexpected error setting format: %v", err)
+	}
+	if id := NewString(); !isULID(id) {
+		t.Fatalf("expected a ULID, got %q", id)
+	}
+
+	if err := SetFormat("snowflake"); err == nil {
+		t.Fatalf("expected error setting unknown format")
+	}
+
+	SetGenerator(func() string { return "fixed" })
+	if id := NewString(); id != "fixed" {
+		t.Fatalf("expected id from custom generator, got %q", id)
+	}
+
+	if err := SetFormat(""); err != nil {
+		t.Fatalf("unexpected error setting default format: %v", err)
+	}
+	if id := NewString(); isULID(id) || !IsValid(id) {
+		t.Fatalf("expected a UUID, got %q", id)
+	}
+}
@@ -49,6 +49,16 @@ type ManifestEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(digest.Digest) error) error
 }
 
+// ManifestPageEnumerator enables iterating over manifests in bounded batches,
+// so that a long enumeration can be resumed after an interruption.
+type ManifestPageEnumerator interface {
+	// EnumerateFrom fills dgsts with the manifest digests ordered after
+	// last and returns the number of entries filled. An empty last starts
+	// from the beginning. io.EOF is returned once the enumeration is
+	// complete.
+	EnumerateFrom(ctx context.Context, dgsts []digest.Digest, last digest.Digest) (int, error)
+}
+
 // Describable is an interface for descriptors.
 //
 // Implementations of Describable are generally objects which can be
@@ -16,4 +16,10 @@ var (
 
 	// ProxyNamespace is the prometheus namespace of proxy related metrics
 	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// HTTPNamespace is the prometheus namespace of http request metrics
+	HTTPNamespace = metrics.NewNamespace(NamespacePrefix, "http", nil)
+
+	// JobsNamespace is the prometheus namespace of maintenance job metrics
+	JobsNamespace = metrics.NewNamespace(NamespacePrefix, "jobs", nil)
 )
@@ -120,6 +120,29 @@ func (b *bridge) TagDeleted(repo reference.Named, tag string) error {
 	return b.sink.Write(*event)
 }
 
+// TagResolved sends a resolve event for a tag resolved to the manifest
+// described by desc.
+func (b *bridge) TagResolved(repo reference.Named, tag string, desc v1.Descriptor) error {
+	event := b.createEvent(EventActionResolve)
+	event.Target.Repository = repo.Name()
+	event.Target.Tag = tag
+	event.Target.MediaType = desc.MediaType
+	event.Target.Digest = desc.Digest
+	event.Target.Size = desc.Size
+	event.Target.Length = desc.Size
+
+	ref, err := reference.WithDigest(repo, desc.Digest)
+	if err != nil {
+		return err
+	}
+	event.Target.URL, err = b.ub.BuildManifestURL(ref)
+	if err != nil {
+		return err
+	}
+
+	return b.sink.Write(*event)
+}
+
 func (b *bridge) RepoDeleted(repo reference.Named) error {
 	event := b.createEvent(EventActionDelete)
 	event.Target.Repository = repo.Name()
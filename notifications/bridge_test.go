@@ -99,6 +99,26 @@ func TestEventBridgeManifestPulledWithTag(t *testing.T) {
 	}
 }
 
+func TestEventBridgeTagResolved(t *testing.T) {
+	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+		checkCommonManifest(t, EventActionResolve, event)
+		if event.(Event).Target.Tag != tag {
+			t.Fatalf("missing or unexpected tag: %#v", event.(Event).Target)
+		}
+		if event.(Event).Target.MediaType != v1.MediaTypeImageManifest {
+			t.Fatalf("unexpected media type: %q", event.(Event).Target.MediaType)
+		}
+
+		return nil
+	}))
+
+	repoRef, _ := reference.WithName(repo)
+	desc := v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
+	if err := l.(ResolveListener).TagResolved(repoRef, tag, desc); err != nil {
+		t.Fatalf("unexpected error notifying tag resolve: %v", err)
+	}
+}
+
 func TestEventBridgeManifestDeleted(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
 		checkDeleted(t, EventActionDelete, event)
@@ -14,6 +14,14 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// EventActionResolve is sent when a tag is resolved to a manifest for
+	// a pull, if enabled.
+	EventActionResolve = "resolve"
+
+	// EventActionUsage is sent periodically with the bandwidth used by each
+	// repository, if enabled. Usage events are UsageEvent rather than Event.
+	EventActionUsage = "usage"
 )
 
 const (
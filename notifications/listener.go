@@ -34,6 +34,12 @@ type RepoListener interface {
 	RepoDeleted(repo reference.Named) error
 }
 
+// ResolveListener describes a listener that can respond to tags being
+// resolved to manifests. Implementing it is optional for a Listener.
+type ResolveListener interface {
+	TagResolved(repo reference.Named, tag string, desc v1.Descriptor) error
+}
+
 // Listener combines all repository events into a single interface.
 type Listener interface {
 	ManifestListener
@@ -246,11 +252,23 @@ type tagServiceListener struct {
 	parent *repositoryListener
 }
 
+// tagListerListener is a tagServiceListener for tag services which also
+// implement distribution.TagLister.
+type tagListerListener struct {
+	*tagServiceListener
+	distribution.TagLister
+}
+
 func (rl *repositoryListener) Tags(ctx context.Context) distribution.TagService {
-	return &tagServiceListener{
-		TagService: rl.Repository.Tags(ctx),
+	tags := rl.Repository.Tags(ctx)
+	tagSL := &tagServiceListener{
+		TagService: tags,
 		parent:     rl,
 	}
+	if lister, ok := tags.(distribution.TagLister); ok {
+		return &tagListerListener{tagServiceListener: tagSL, TagLister: lister}
+	}
+	return tagSL
 }
 
 func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
@@ -152,8 +152,15 @@ func newIgnoredSink(sink events.Sink, ignored []string, ignoreActions []string)
 // Write discards events with ignored target media types and passes the rest
 // along.
 func (imts *ignoredSink) Write(event events.Event) error {
-	if imts.ignoreMediaTypes[event.(Event).Target.MediaType] || imts.ignoreActions[event.(Event).Action] {
-		return nil
+	switch event := event.(type) {
+	case Event:
+		if imts.ignoreMediaTypes[event.Target.MediaType] || imts.ignoreActions[event.Action] {
+			return nil
+		}
+	case UsageEvent:
+		if imts.ignoreActions[event.Action] {
+			return nil
+		}
 	}
 
 	return imts.Sink.Write(event)
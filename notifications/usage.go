@@ -0,0 +1,215 @@
+package notifications
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/uuid"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// UsageEvent reports the usage of a repository or namespace over an interval.
+// It is sent alongside the other events, with the usage action, for billing
+// systems to consume.
+type UsageEvent struct {
+	// ID provides a unique identifier for the event.
+	ID string `json:"id,omitempty"`
+
+	// Timestamp is the time at which the event was generated.
+	Timestamp time.Time `json:"timestamp,omitempty"`
+
+	// Action is always "usage".
+	Action string `json:"action,omitempty"`
+
+	// Usage is the usage reported by the event.
+	Usage UsageRecord `json:"usage"`
+
+	// Source identifies the registry node that generated the event. Usage
+	// is aggregated by each node, so the usage of a repository is the sum
+	// of the usage reported by every node.
+	Source SourceRecord `json:"source,omitempty"`
+}
+
+// UsageRecord is the bandwidth used by a repository, or the storage used by a
+// namespace, over an interval.
+type UsageRecord struct {
+	// Repository is the repository the bandwidth was used by.
+	Repository string `json:"repository,omitempty"`
+
+	// Namespace is the namespace, the first component of repository names,
+	// the storage was used by.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Start and End delimit the interval over which the bandwidth was used.
+	// Storage usage is computed at End.
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end"`
+
+	// BytesPulled is the number of bytes of blobs and manifests served.
+	BytesPulled int64 `json:"bytesPulled,omitempty"`
+
+	// BytesPushed is the number of bytes of blobs and manifests received.
+	BytesPushed int64 `json:"bytesPushed,omitempty"`
+
+	// UniqueBytes is the size of the blobs referenced only by the namespace.
+	UniqueBytes int64 `json:"uniqueBytes,omitempty"`
+
+	// SharedBytes is the size of the blobs the namespace references which
+	// are also referenced by other namespaces.
+	SharedBytes int64 `json:"sharedBytes,omitempty"`
+}
+
+// repositoryUsage is the bandwidth used by a repository so far in the
+// current interval.
+type repositoryUsage struct {
+	pulled int64
+	pushed int64
+}
+
+// UsageAggregator aggregates the bytes pulled from and pushed to each
+// repository, writing a usage event to the sink for each repository with
+// traffic at the end of every interval.
+type UsageAggregator struct {
+	sink     events.Sink
+	source   SourceRecord
+	interval time.Duration
+
+	mu    sync.Mutex
+	start time.Time
+	usage map[string]*repositoryUsage
+
+	done   chan struct{}
+	closed chan struct{}
+}
+
+// NewUsageAggregator returns a running aggregator writing usage events to
+// sink every interval.
+func NewUsageAggregator(sink events.Sink, source SourceRecord, interval time.Duration) *UsageAggregator {
+	ua := &UsageAggregator{
+		sink:     sink,
+		source:   source,
+		interval: interval,
+		start:    time.Now(),
+		usage:    make(map[string]*repositoryUsage),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go ua.run()
+	return ua
+}
+
+// Pulled records n bytes served from repository.
+func (ua *UsageAggregator) Pulled(repository string, n int64) {
+	if n <= 0 {
+		return
+	}
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.repository(repository).pulled += n
+}
+
+// Pushed records n bytes received for repository.
+func (ua *UsageAggregator) Pushed(repository string, n int64) {
+	if n <= 0 {
+		return
+	}
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.repository(repository).pushed += n
+}
+
+// repository returns the usage of repository in the current interval. The
+// lock must be held.
+func (ua *UsageAggregator) repository(repository string) *repositoryUsage {
+	usage, ok := ua.usage[repository]
+	if !ok {
+		usage = &repositoryUsage{}
+		ua.usage[repository] = usage
+	}
+	return usage
+}
+
+// Stored writes a usage event for the storage used by namespace, as computed
+// at the given time.
+func (ua *UsageAggregator) Stored(namespace string, uniqueBytes, sharedBytes int64, at time.Time) error {
+	event := ua.createEvent()
+	event.Usage = UsageRecord{
+		Namespace:   namespace,
+		End:         at,
+		UniqueBytes: uniqueBytes,
+		SharedBytes: sharedBytes,
+	}
+	return ua.sink.Write(event)
+}
+
+// Flush writes the usage events of the current interval and starts a new
+// one.
+func (ua *UsageAggregator) Flush() {
+	ua.mu.Lock()
+	start, end := ua.start, time.Now()
+	usage := ua.usage
+	ua.start = end
+	ua.usage = make(map[string]*repositoryUsage)
+	ua.mu.Unlock()
+
+	repositories := make([]string, 0, len(usage))
+	for repository := range usage {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+
+	for _, repository := range repositories {
+		event := ua.createEvent()
+		event.Usage = UsageRecord{
+			Repository:  repository,
+			Start:       start,
+			End:         end,
+			BytesPulled: usage[repository].pulled,
+			BytesPushed: usage[repository].pushed,
+		}
+		if err := ua.sink.Write(event); err != nil {
+			logrus.Warnf("usage: error writing usage event for %s, the usage will be lost: %v", repository, err)
+		}
+	}
+}
+
+// Close stops the aggregator, writing the usage events of the current
+// interval.
+func (ua *UsageAggregator) Close() error {
+	select {
+	case <-ua.done:
+	default:
+		close(ua.done)
+	}
+	<-ua.closed
+	return nil
+}
+
+func (ua *UsageAggregator) run() {
+	defer close(ua.closed)
+
+	ticker := time.NewTicker(ua.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ua.Flush()
+		case <-ua.done:
+			ua.Flush()
+			return
+		}
+	}
+}
+
+// createEvent returns a new usage event, timestamped, with the source
+// populated.
+func (ua *UsageAggregator) createEvent() UsageEvent {
+	return UsageEvent{
+		ID:        uuid.NewString(),
+		Timestamp: time.Now(),
+		Action:    EventActionUsage,
+		Source:    ua.source,
+	}
+}
@@ -0,0 +1,76 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+func TestUsageAggregator(t *testing.T) {
+	var written []UsageEvent
+	source := SourceRecord{Addr: "registry:5000", InstanceID: "instance"}
+	ua := NewUsageAggregator(testSinkFn(func(event events.Event) error {
+		written = append(written, event.(UsageEvent))
+		return nil
+	}), source, time.Hour)
+
+	ua.Pulled("library/b", 100)
+	ua.Pushed("library/a", 10)
+	ua.Pulled("library/a", 20)
+	ua.Pushed("library/a", 30)
+	ua.Pulled("library/c", 0)
+	if err := ua.Close(); err != nil {
+		t.Fatalf("unexpected error closing aggregator: %v", err)
+	}
+
+	expected := []UsageRecord{
+		{Repository: "library/a", BytesPulled: 20, BytesPushed: 40},
+		{Repository: "library/b", BytesPulled: 100},
+	}
+	if len(written) != len(expected) {
+		t.Fatalf("unexpected number of usage events: %d != %d", len(written), len(expected))
+	}
+	for i, event := range written {
+		if event.Action != EventActionUsage || event.ID == "" || event.Source != source {
+			t.Fatalf("unexpected usage event: %#v", event)
+		}
+		if event.Usage.Start.IsZero() || event.Usage.End.Before(event.Usage.Start) {
+			t.Fatalf("unexpected usage interval: %v to %v", event.Usage.Start, event.Usage.End)
+		}
+		event.Usage.Start, event.Usage.End = time.Time{}, time.Time{}
+		if event.Usage != expected[i] {
+			t.Fatalf("unexpected usage: %#v != %#v", event.Usage, expected[i])
+		}
+	}
+
+	written = nil
+	at := time.Now()
+	if err := ua.Stored("library", 1000, 500, at); err != nil {
+		t.Fatalf("unexpected error writing storage usage: %v", err)
+	}
+	if len(written) != 1 || written[0].Usage != (UsageRecord{Namespace: "library", End: at, UniqueBytes: 1000, SharedBytes: 500}) {
+		t.Fatalf("unexpected storage usage events: %#v", written)
+	}
+}
+
+func TestIgnoredSinkUsage(t *testing.T) {
+	usage := UsageEvent{Action: EventActionUsage}
+
+	for _, tc := range []struct {
+		ignoreActions []string
+		expected      events.Event
+	}{
+		{ignoreActions: []string{"pull"}, expected: usage},
+		{ignoreActions: []string{"usage"}},
+	} {
+		ts := &testSink{}
+		s := newIgnoredSink(ts, []string{"blob"}, tc.ignoreActions)
+		if err := s.Write(usage); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+		if ts.event != tc.expected {
+			t.Fatalf("unexpected event: %#v != %#v", ts.event, tc.expected)
+		}
+	}
+}
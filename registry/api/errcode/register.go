@@ -73,6 +73,16 @@ var (
 		service too many times`,
 		HTTPStatusCode: http.StatusTooManyRequests,
 	})
+
+	// ErrorCodeInsufficientStorage is returned if a request would exceed
+	// the storage allotted to the client.
+	ErrorCodeInsufficientStorage = register("errcode", ErrorDescriptor{
+		Value:   "INSUFFICIENT_STORAGE",
+		Message: "insufficient storage",
+		Description: `Returned when a request would exceed the storage
+		allotted to the client or repository`,
+		HTTPStatusCode: http.StatusInsufficientStorage,
+	})
 )
 
 const errGroup = "registry.api.v2"
@@ -224,6 +234,37 @@ var (
 		the maximum allowed.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodeCursorInvalid is returned when the `since` parameter of a
+	// request to the change log is neither a cursor nor a timestamp.
+	ErrorCodeCursorInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "CURSOR_INVALID",
+		Message: "invalid cursor",
+		Description: `Returned when the "since" parameter is neither the
+		cursor returned by a previous request nor an RFC 3339 timestamp.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeCursorExpired is returned when the changes following the
+	// `since` parameter of a request to the change log are no longer kept.
+	ErrorCodeCursorExpired = register(errGroup, ErrorDescriptor{
+		Value:   "CURSOR_EXPIRED",
+		Message: "cursor expired",
+		Description: `Returned when the changes following the "since"
+		parameter have been pruned from the change log. The client must list
+		the catalog again, then read the changes from a new cursor.`,
+		HTTPStatusCode: http.StatusGone,
+	})
+
+	// ErrorCodeParameterInvalid is returned when a query parameter of a
+	// request has an invalid value.
+	ErrorCodeParameterInvalid = register(errGroup, ErrorDescriptor{
+		Value:   "PARAMETER_INVALID",
+		Message: "invalid parameter",
+		Description: `Returned when a query parameter of the request has an
+		invalid value. The detail names the parameter.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
 )
 
 var (
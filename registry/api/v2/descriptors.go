@@ -199,6 +199,48 @@ var (
 		},
 	}
 
+	uploadLimitExceededDescriptor = ResponseDescriptor{
+		Name:        "Upload Limit Exceeded",
+		StatusCode:  http.StatusTooManyRequests,
+		Description: "The repository already has the maximum number of uploads in progress allowed by the registry configuration.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeTooManyRequests,
+		},
+	}
+
+	uploadQuotaExceededDescriptor = ResponseDescriptor{
+		Name:        "Upload Quota Exceeded",
+		StatusCode:  http.StatusInsufficientStorage,
+		Description: "The uploads in progress in the repository already hold the maximum number of bytes allowed by the registry configuration.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeInsufficientStorage,
+		},
+	}
+
 	tooManyRequestsDescriptor = ResponseDescriptor{
 		Name:        "Too Many Requests",
 		StatusCode:  http.StatusTooManyRequests,
@@ -452,13 +494,13 @@ var routeDescriptors = []RouteDescriptor{
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode:  http.StatusOK,
-								Description: "A list of tags for the named repository.",
+								Description: "A list of tags for the named repository. Long lists are streamed as they are read from storage.",
 								Headers: []ParameterDescriptor{
 									{
-										Name:        "Content-Length",
-										Type:        "integer",
-										Description: "Length of the JSON response body.",
-										Format:      "<length>",
+										Name:        "Transfer-Encoding",
+										Type:        "string",
+										Description: "Set when the response is streamed. A response which ends before the JSON body is complete was interrupted by an error.",
+										Format:      "chunked",
 									},
 								},
 								Body: BodyDescriptor{
@@ -482,7 +524,7 @@ var routeDescriptors = []RouteDescriptor{
 					},
 					{
 						Name:            "Tags Paginated",
-						Description:     "Return a portion of the tags for the specified repository.",
+						Description:     "Return a portion of the tags for the specified repository. At most 1000 tags are returned, with a `Link` header to the next portion, however large `n` is.",
 						PathParameters:  []ParameterDescriptor{nameParameterDescriptor},
 						QueryParameters: paginationParameters,
 						Successes: []ResponseDescriptor{
@@ -962,6 +1004,187 @@ var routeDescriptors = []RouteDescriptor{
 		},
 	},
 
+	{
+		Name:        RouteNameBlobsExist,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/_exists",
+		Entity:      "Blob Existence",
+		Description: "Check the existence of several blobs in the repository identified by `name` with a single request. This is an extension to the distribution specification.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Report which of the listed blobs exist in the repository, and their sizes. Only `pull` access to the repository is required.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Check Blobs",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+    "digests": [<digest>, ...]
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The existence of each blob, in the order requested. The size is only set for blobs which exist.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "blobs": [
+        {
+            "digest": <digest>,
+            "exists": <bool>,
+            "size": <bytes>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "A digest in the request body is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The request body is malformed or lists too many digests.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:        RouteNameBlobDelta,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/{digest:" + digest.DigestRegexp.String() + "}/_delta",
+		Entity:      "Blob Delta",
+		Description: "Fetch a blob as a delta against another blob of the repository, which the client already has. This is an extension to the distribution specification, served when deltas are enabled.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the blob identified by `digest` compressed with zstd, using the content of the blob identified by `have` as a raw dictionary, as `zstd --patch-from` does. Only `pull` access to the repository is required.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Fetch Blob Delta",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "have",
+								Type:        "query",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Required:    true,
+								Description: "Digest of the blob the client already has, against which the delta is computed.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The delta of the blob identified by `digest`. Decompressing it with the content of the blob identified by `have` as the dictionary yields the blob.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "The length of the delta.",
+										Format:      "<length>",
+									},
+									digestHeader,
+									{
+										Name:        "Docker-Distribution-Delta-Base",
+										Type:        "digest",
+										Description: "Digest of the blob the delta was computed against.",
+										Format:      "<digest>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/zstd",
+									Format:      "<zstd frame>",
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "There was a problem with the request that needs to be addressed by the client, such as an invalid `name` or digest.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "Either blob is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameUnknown,
+									errcode.ErrorCodeBlobUnknown,
+								},
+							},
+							{
+								Description: "Deltas are disabled, or either blob is too large to be served as a delta. The client should fetch the blob instead.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+
 	{
 		Name:        RouteNameBlobUpload,
 		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/uploads/",
@@ -1036,6 +1259,8 @@ var routeDescriptors = []RouteDescriptor{
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
 							tooManyRequestsDescriptor,
+							uploadLimitExceededDescriptor,
+							uploadQuotaExceededDescriptor,
 						},
 					},
 					{
@@ -1083,6 +1308,8 @@ var routeDescriptors = []RouteDescriptor{
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
 							tooManyRequestsDescriptor,
+							uploadLimitExceededDescriptor,
+							uploadQuotaExceededDescriptor,
 						},
 					},
 					{
@@ -1148,6 +1375,8 @@ var routeDescriptors = []RouteDescriptor{
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
 							tooManyRequestsDescriptor,
+							uploadLimitExceededDescriptor,
+							uploadQuotaExceededDescriptor,
 						},
 					},
 				},
@@ -1609,4 +1838,374 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameChanges,
+		Path:        "/v2/_changes",
+		Entity:      "Changes",
+		Description: "List the changes to the tags, manifests and repositories of the registry since a cursor, so that mirrors and indexers can follow the registry without listing the whole catalog again. The change log must be enabled in the configuration, and reading it requires the same access as the catalog.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the changes recorded after the given cursor or time, in the order they were recorded, with the repositories and tags they changed.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Changes Fetch",
+						Description: "Return the changes following `since`. Follow the `Link` header, or pass the returned `cursor` as `since`, to read the next changes.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "since",
+								Type:        "string",
+								Description: "The cursor returned by a previous request, or an RFC 3339 timestamp. If not present, changes are returned from the oldest change kept.",
+								Format:      "<cursor>",
+								Required:    false,
+							},
+							{
+								Name:        "n",
+								Type:        "integer",
+								Description: "Limit the number of changes in the response. If not present, 100 changes will be returned.",
+								Format:      "<integer>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The changes following `since`. A `Link` header is returned if more changes may follow.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									linkHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"changes": [
+		{
+			"id": <cursor>,
+			"time": <time>,
+			"repository": <name>,
+			"action": "tag" | "untag" | "manifest-put" | "manifest-delete" | "repository-delete",
+			"tag": <tag>,
+			"digest": <digest>
+		},
+		...
+	],
+	"repositories": [
+		{
+			"name": <name>,
+			"tags": [<tag>, ...]
+		},
+		...
+	],
+	"cursor": <cursor>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+							{
+								Name:        "Invalid Cursor",
+								Description: "The `since` parameter is neither a cursor nor a timestamp.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeCursorInvalid,
+								},
+							},
+							{
+								Name:        "Expired Cursor",
+								Description: "The changes following `since` are no longer kept. The client must list the catalog again, then read the changes from the cursor of a new request.",
+								StatusCode:  http.StatusGone,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeCursorExpired,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameSpec,
+		Path:        "/v2/_spec",
+		Entity:      "Spec",
+		Description: "Retrieve a machine-readable description of the API implemented by the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve an OpenAPI 3.0 document describing every route served by the registry, including extensions to the distribution specification.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The OpenAPI document describing the API.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "openapi": "3.0.3",
+    "info": {
+        "title": "Distribution Registry HTTP API V2",
+        "version": <version>
+    },
+    "paths": {
+        <path>: {
+            <method>: <operation>,
+            ...
+        },
+        ...
+    }
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminPurgeUploads,
+		Path:        "/v2/_admin/uploads/purge",
+		Entity:      "Admin",
+		Description: "Administrative operations on the registry. The admin API must be enabled in the configuration and requires access to the `registry:admin` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Purge upload sessions older than the given age, as the upload purger does periodically.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "age",
+								Type:        "string",
+								Format:      "<duration>",
+								Description: "Purge uploads started longer than this duration ago. Defaults to `168h`.",
+							},
+							{
+								Name:        "dryrun",
+								Type:        "boolean",
+								Format:      "<bool>",
+								Description: "If true, report the uploads which would be purged without deleting them.",
+							},
+							{
+								Name:        "repository",
+								Type:        "string",
+								Format:      "<name>",
+								Description: "Only purge uploads to this repository or repositories below it.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The uploads purged, or which would be purged in a dry run.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "dryRun": <bool>,
+    "uploads": [
+        {
+            "repository": <name>,
+            "id": <uuid>,
+            "startedAt": <time>,
+            ...
+        },
+        ...
+    ],
+    "errors": [<error>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Parameter",
+								Description: "The `age` or `dryrun` query parameter is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeParameterInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The admin API is disabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminUploads,
+		Path:        "/v2/_admin/uploads",
+		Entity:      "Admin Uploads",
+		Description: "The upload sessions in progress in the registry. The admin API must be enabled in the configuration and requires access to the `registry:admin` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "List the upload sessions in progress, with the client which started each of them if known.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "repository",
+								Type:        "string",
+								Format:      "<name>",
+								Description: "Only list uploads to this repository or repositories below it.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The upload sessions in progress, sorted by repository and id.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "uploads": [
+        {
+            "repository": <name>,
+            "id": <uuid>,
+            "startedAt": <time>,
+            "size": <bytes>,
+            ...
+        },
+        ...
+    ],
+    "errors": [<error>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The admin API is disabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminUpload,
+		Path:        "/v2/_admin/uploads/{repository:" + reference.NameRegexp.String() + "}/{uuid:[a-zA-Z0-9-_.=]+}",
+		Entity:      "Admin Upload",
+		Description: "An upload session in progress in the registry. The admin API must be enabled in the configuration and requires access to the `registry:admin` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodDelete,
+				Description: "Abort the upload session, deleting the data uploaded so far. The client holding the session will fail to continue the upload.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							{
+								Name:        "repository",
+								Type:        "string",
+								Format:      reference.NameRegexp.String(),
+								Required:    true,
+								Description: "Name of the repository holding the upload.",
+							},
+							uuidParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Name:        "Upload Aborted",
+								Description: "The upload has been aborted and its data deleted.",
+								StatusCode:  http.StatusNoContent,
+								Headers: []ParameterDescriptor{
+									contentLengthZeroHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The upload is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The admin API is disabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
 }
@@ -0,0 +1,335 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the generated
+// document conforms to.
+const openAPIVersion = "3.0.3"
+
+// OpenAPIDocument is an OpenAPI description of the routes registered by
+// Router. It is generated from the route descriptors, so it covers every
+// route this build of the registry implements.
+type OpenAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    OpenAPIInfo                            `json:"info"`
+	Servers []OpenAPIServer                        `json:"servers,omitempty"`
+	Tags    []OpenAPITag                           `json:"tags,omitempty"`
+	Paths   map[string]map[string]OpenAPIOperation `json:"paths"`
+}
+
+// OpenAPIInfo describes the API.
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIServer is the location the API is served from.
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+// OpenAPITag groups the operations on an entity.
+type OpenAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIOperation describes a method on a route. The variants of a request
+// described by the route descriptors are merged into a single operation.
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter describes a path, query or header parameter.
+type OpenAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Schema      OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema is the schema of a parameter or header.
+type OpenAPISchema struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// OpenAPIRequestBody describes the accepted request bodies.
+type OpenAPIRequestBody struct {
+	Content map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIMediaType describes the body for a content type.
+type OpenAPIMediaType struct {
+	Example string `json:"example,omitempty"`
+}
+
+// OpenAPIResponse describes a response status code. ErrorCodes lists the
+// error codes that may be returned in the body.
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]OpenAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+	ErrorCodes  []string                    `json:"x-error-codes,omitempty"`
+}
+
+// OpenAPIHeader describes a response header.
+type OpenAPIHeader struct {
+	Description string        `json:"description,omitempty"`
+	Schema      OpenAPISchema `json:"schema"`
+}
+
+// OpenAPI generates the OpenAPI document for the routes of a router built
+// with RouterWithPrefix(prefix).
+func OpenAPI(prefix, version string) OpenAPIDocument {
+	doc := OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:   "Distribution Registry HTTP API V2",
+			Version: version,
+		},
+		Paths: make(map[string]map[string]OpenAPIOperation),
+	}
+	if prefix != "" {
+		doc.Servers = []OpenAPIServer{{URL: prefix}}
+	}
+
+	seenTags := make(map[string]bool)
+	for _, route := range routeDescriptors {
+		if !seenTags[route.Entity] {
+			seenTags[route.Entity] = true
+			doc.Tags = append(doc.Tags, OpenAPITag{Name: route.Entity, Description: route.Description})
+		}
+
+		path, patterns := openAPIPath(route.Path)
+		operations := make(map[string]OpenAPIOperation)
+		for _, method := range route.Methods {
+			operations[strings.ToLower(method.Method)] = openAPIOperation(route, method, patterns)
+		}
+		doc.Paths[path] = operations
+	}
+	return doc
+}
+
+// RemoveRoutes removes the operations of the named routes from the
+// document, for routes which are not served. The tags of the entities left
+// without operations are removed as well.
+func (doc *OpenAPIDocument) RemoveRoutes(names ...string) {
+	for _, name := range names {
+		route, ok := routeDescriptorsMap[name]
+		if !ok {
+			continue
+		}
+		path, _ := openAPIPath(route.Path)
+		delete(doc.Paths, path)
+	}
+
+	used := make(map[string]bool)
+	for _, operations := range doc.Paths {
+		for _, op := range operations {
+			for _, tag := range op.Tags {
+				used[tag] = true
+			}
+		}
+	}
+	tags := doc.Tags[:0]
+	for _, tag := range doc.Tags {
+		if used[tag.Name] {
+			tags = append(tags, tag)
+		}
+	}
+	doc.Tags = tags
+}
+
+// MarshalOpenAPI returns the OpenAPI document for the routes of a router
+// built with RouterWithPrefix(prefix), encoded as JSON.
+func MarshalOpenAPI(prefix, version string) ([]byte, error) {
+	return json.MarshalIndent(OpenAPI(prefix, version), "", "  ")
+}
+
+// openAPIPath converts a gorilla mux path template into an OpenAPI path,
+// returning the regular expression of each path variable.
+func openAPIPath(template string) (string, map[string]string) {
+	var (
+		path     strings.Builder
+		patterns = make(map[string]string)
+	)
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			path.WriteByte(template[i])
+			continue
+		}
+		// Find the matching brace; the pattern may contain braces itself.
+		depth, end := 0, i
+		for ; end < len(template); end++ {
+			if template[end] == '{' {
+				depth++
+			} else if template[end] == '}' {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+		}
+		name, pattern, _ := strings.Cut(template[i+1:end], ":")
+		patterns[name] = pattern
+		path.WriteString("{" + name + "}")
+		i = end
+	}
+	return path.String(), patterns
+}
+
+func openAPIOperation(route RouteDescriptor, method MethodDescriptor, patterns map[string]string) OpenAPIOperation {
+	op := OpenAPIOperation{
+		OperationID: route.Name + "-" + strings.ToLower(method.Method),
+		Summary:     method.Description,
+		Tags:        []string{route.Entity},
+		Responses:   make(map[string]OpenAPIResponse),
+	}
+
+	var descriptions []string
+	seenParameters := make(map[string]bool)
+	addParameter := func(in string, p ParameterDescriptor) {
+		// OpenAPI describes these headers elsewhere.
+		if in == "header" && (strings.EqualFold(p.Name, "Authorization") ||
+			strings.EqualFold(p.Name, "Content-Type") ||
+			strings.EqualFold(p.Name, "Accept")) {
+			return
+		}
+		if seenParameters[in+"/"+p.Name] {
+			return
+		}
+		seenParameters[in+"/"+p.Name] = true
+		param := OpenAPIParameter{
+			Name:        p.Name,
+			In:          in,
+			Description: p.Description,
+			Required:    p.Required || in == "path",
+			Schema:      openAPISchema(p),
+		}
+		if in == "path" && param.Schema.Pattern == "" {
+			param.Schema.Pattern = patterns[p.Name]
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+
+	for _, request := range method.Requests {
+		if request.Description != "" {
+			if request.Name != "" {
+				descriptions = append(descriptions, "**"+request.Name+"**: "+request.Description)
+			} else {
+				descriptions = append(descriptions, request.Description)
+			}
+		}
+		for _, p := range request.PathParameters {
+			addParameter("path", p)
+		}
+		for _, p := range request.QueryParameters {
+			addParameter("query", p)
+		}
+		for _, p := range request.Headers {
+			addParameter("header", p)
+		}
+		if request.Body.ContentType != "" {
+			if op.RequestBody == nil {
+				op.RequestBody = &OpenAPIRequestBody{Content: make(map[string]OpenAPIMediaType)}
+			}
+			op.RequestBody.Content[request.Body.ContentType] = OpenAPIMediaType{Example: request.Body.Format}
+		}
+		for _, response := range append(append([]ResponseDescriptor{}, request.Successes...), request.Failures...) {
+			addOpenAPIResponse(op.Responses, response)
+		}
+	}
+
+	// Path variables must always be declared, even if no request
+	// describes them.
+	var missing []string
+	for name := range patterns {
+		if !seenParameters["path/"+name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		addParameter("path", ParameterDescriptor{Name: name, Type: "string", Required: true})
+	}
+
+	op.Description = strings.Join(descriptions, "\n\n")
+	return op
+}
+
+func addOpenAPIResponse(responses map[string]OpenAPIResponse, response ResponseDescriptor) {
+	code := strconv.Itoa(response.StatusCode)
+	r, ok := responses[code]
+	if !ok {
+		r = OpenAPIResponse{}
+	}
+
+	description := response.Description
+	if description == "" {
+		description = response.Name
+	}
+	if description == "" {
+		description = http.StatusText(response.StatusCode)
+	}
+	if r.Description == "" {
+		r.Description = description
+	} else if !strings.Contains(r.Description, description) {
+		r.Description += "\n\n" + description
+	}
+
+	for _, h := range response.Headers {
+		if r.Headers == nil {
+			r.Headers = make(map[string]OpenAPIHeader)
+		}
+		if _, ok := r.Headers[h.Name]; !ok {
+			r.Headers[h.Name] = OpenAPIHeader{Description: h.Description, Schema: openAPISchema(h)}
+		}
+	}
+	if response.Body.ContentType != "" {
+		if r.Content == nil {
+			r.Content = make(map[string]OpenAPIMediaType)
+		}
+		if _, ok := r.Content[response.Body.ContentType]; !ok {
+			r.Content[response.Body.ContentType] = OpenAPIMediaType{Example: response.Body.Format}
+		}
+	}
+	for _, errorCode := range response.ErrorCodes {
+		value := errorCode.Descriptor().Value
+		found := false
+		for _, v := range r.ErrorCodes {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.ErrorCodes = append(r.ErrorCodes, value)
+		}
+	}
+	responses[code] = r
+}
+
+func openAPISchema(p ParameterDescriptor) OpenAPISchema {
+	schema := OpenAPISchema{Type: "string"}
+	if p.Type == "integer" {
+		schema.Type = "integer"
+	}
+	if p.Regexp != nil {
+		schema.Pattern = p.Regexp.String()
+	}
+	return schema
+}
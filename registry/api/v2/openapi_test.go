@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/distribution/reference"
+)
+
+func TestOpenAPIPath(t *testing.T) {
+	path, patterns := openAPIPath("/v2/{name:" + reference.NameRegexp.String() + "}/blobs/{digest:[a-z0-9]+:[a-f0-9]{32,}}")
+	if path != "/v2/{name}/blobs/{digest}" {
+		t.Fatalf("unexpected path: %s", path)
+	}
+	if patterns["digest"] != "[a-z0-9]+:[a-f0-9]{32,}" {
+		t.Fatalf("unexpected digest pattern: %q", patterns["digest"])
+	}
+	if patterns["name"] != reference.NameRegexp.String() {
+		t.Fatalf("unexpected name pattern: %q", patterns["name"])
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	p, err := MarshalOpenAPI("/prefix", "v3.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error marshaling document: %v", err)
+	}
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(p, &doc); err != nil {
+		t.Fatalf("unexpected error unmarshaling document: %v", err)
+	}
+
+	if doc.Info.Version != "v3.0.0" {
+		t.Fatalf("unexpected version: %s", doc.Info.Version)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "/prefix" {
+		t.Fatalf("unexpected servers: %v", doc.Servers)
+	}
+	if len(doc.Paths) != len(routeDescriptors) {
+		t.Fatalf("expected %d paths, got %d", len(routeDescriptors), len(doc.Paths))
+	}
+
+	manifest, ok := doc.Paths["/v2/{name}/manifests/{reference}"]["get"]
+	if !ok {
+		t.Fatalf("manifest GET operation missing: %v", doc.Paths)
+	}
+	var pathParams []string
+	for _, param := range manifest.Parameters {
+		if param.In == "path" {
+			if !param.Required || param.Schema.Pattern == "" {
+				t.Fatalf("path parameter %s must be required and have a pattern", param.Name)
+			}
+			pathParams = append(pathParams, param.Name)
+		}
+	}
+	if len(pathParams) != 2 {
+		t.Fatalf("unexpected path parameters: %v", pathParams)
+	}
+	if _, ok := manifest.Responses["200"]; !ok {
+		t.Fatalf("manifest GET missing 200 response: %v", manifest.Responses)
+	}
+	notFound := manifest.Responses["404"]
+	if len(notFound.ErrorCodes) == 0 {
+		t.Fatalf("expected error codes on 404 response")
+	}
+
+	if _, ok := doc.Paths["/v2/_spec"]["get"]; !ok {
+		t.Fatalf("spec route missing from document")
+	}
+
+	doc.RemoveRoutes(RouteNameAdminUploads, RouteNameAdminUpload, RouteNameAdminPurgeUploads)
+	if len(doc.Paths) != len(routeDescriptors)-3 {
+		t.Fatalf("expected %d paths, got %d", len(routeDescriptors)-3, len(doc.Paths))
+	}
+	if _, ok := doc.Paths["/v2/_admin/uploads"]; ok {
+		t.Fatalf("removed route still in document")
+	}
+	for _, tag := range doc.Tags {
+		if strings.HasPrefix(tag.Name, "Admin") {
+			t.Fatalf("tag %s of removed routes still in document", tag.Name)
+		}
+	}
+}
@@ -13,9 +13,18 @@ const (
 	RouteNameManifest        = "manifest"
 	RouteNameTags            = "tags"
 	RouteNameBlob            = "blob"
+	RouteNameBlobsExist      = "blobs-exist"
+	RouteNameBlobDelta       = "blob-delta"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameChanges         = "changes"
+	RouteNameSpec            = "spec"
+
+	// Administrative routes, served when the admin API is enabled.
+	RouteNameAdminPurgeUploads = "admin-purge-uploads"
+	RouteNameAdminUploads      = "admin-uploads"
+	RouteNameAdminUpload       = "admin-upload"
 )
 
 var (
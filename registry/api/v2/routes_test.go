@@ -35,6 +35,34 @@ func TestRouter(t *testing.T) {
 			RequestURI: "/v2/",
 			Vars:       map[string]string{},
 		},
+		{
+			RouteName:  RouteNameSpec,
+			RequestURI: "/v2/_spec",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameChanges,
+			RequestURI: "/v2/_changes",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminPurgeUploads,
+			RequestURI: "/v2/_admin/uploads/purge",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminUploads,
+			RequestURI: "/v2/_admin/uploads",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminUpload,
+			RequestURI: "/v2/_admin/uploads/foo/bar/uuid",
+			Vars: map[string]string{
+				"repository": "foo/bar",
+				"uuid":       "uuid",
+			},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/manifests/bar",
@@ -87,6 +115,21 @@ func TestRouter(t *testing.T) {
 				"name": "docker.com/foo/bar/baz",
 			},
 		},
+		{
+			RouteName:  RouteNameBlobsExist,
+			RequestURI: "/v2/foo/bar/blobs/_exists",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameBlobDelta,
+			RequestURI: "/v2/foo/bar/blobs/sha256:abcdef0919234/_delta",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
 		{
 			RouteName:  RouteNameBlob,
 			RequestURI: "/v2/foo/bar/blobs/sha256:abcdef0919234",
@@ -8,6 +8,7 @@ import (
 
 	"github.com/distribution/reference"
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 )
 
 // URLBuilder creates registry API urls from a single base endpoint. It can be
@@ -127,6 +128,71 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildChangesURL constructs a url to read the changes to the repositories
+// following the since value.
+func (ub *URLBuilder) BuildChangesURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameChanges)
+
+	changesURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(changesURL, values...).String(), nil
+}
+
+// BuildSpecURL constructs a url to retrieve the OpenAPI description of the
+// API.
+func (ub *URLBuilder) BuildSpecURL() (string, error) {
+	route := ub.cloneRoute(RouteNameSpec)
+
+	specURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return specURL.String(), nil
+}
+
+// BuildAdminPurgeUploadsURL constructs a url to purge upload sessions,
+// filtered by the age, dryrun and repository values.
+func (ub *URLBuilder) BuildAdminPurgeUploadsURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminPurgeUploads)
+
+	purgeURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(purgeURL, values...).String(), nil
+}
+
+// BuildAdminUploadsURL constructs a url to list the upload sessions in
+// progress, filtered by the repository value.
+func (ub *URLBuilder) BuildAdminUploadsURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminUploads)
+
+	uploadsURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(uploadsURL, values...).String(), nil
+}
+
+// BuildAdminUploadURL constructs a url to abort the upload session uuid in
+// the named repository.
+func (ub *URLBuilder) BuildAdminUploadURL(name reference.Named, uuid string) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminUpload)
+
+	uploadURL, err := route.URL("repository", name.Name(), "uuid", uuid)
+	if err != nil {
+		return "", err
+	}
+
+	return uploadURL.String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -174,6 +240,32 @@ func (ub *URLBuilder) BuildBlobURL(ref reference.Canonical) (string, error) {
 	return layerURL.String(), nil
 }
 
+// BuildBlobsExistURL constructs the url to check the existence of blobs in
+// the repository identified by name.
+func (ub *URLBuilder) BuildBlobsExistURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameBlobsExist)
+
+	existURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return existURL.String(), nil
+}
+
+// BuildBlobDeltaURL constructs the url to fetch the blob identified by ref as
+// a delta against the blob identified by have.
+func (ub *URLBuilder) BuildBlobDeltaURL(ref reference.Canonical, have digest.Digest) (string, error) {
+	route := ub.cloneRoute(RouteNameBlobDelta)
+
+	deltaURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(deltaURL, url.Values{"have": []string{have.String()}}).String(), nil
+}
+
 // BuildBlobUploadURL constructs a url to begin a blob upload in the
 // repository identified by name.
 func (ub *URLBuilder) BuildBlobUploadURL(name reference.Named, values ...url.Values) (string, error) {
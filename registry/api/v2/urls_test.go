@@ -26,6 +26,16 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 			expectedErr:  nil,
 			build:        urlBuilder.BuildBaseURL,
 		},
+		{
+			description:  "test changes url with since query parameter",
+			expectedPath: "/v2/_changes?since=01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildChangesURL(url.Values{
+					"since": []string{"01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+				})
+			},
+		},
 		{
 			description:  "test tags url",
 			expectedPath: "/v2/foo/bar/tags/list",
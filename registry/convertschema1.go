@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/spf13/cobra"
+)
+
+var convertDryRun bool
+
+// ConvertSchema1Cmd is the cobra command that corresponds to the
+// convert-schema1 subcommand
+var ConvertSchema1Cmd = &cobra.Command{
+	Use:   "convert-schema1 <config>",
+	Short: "`convert-schema1` rewrites schema1 manifests remaining in storage as schema2",
+	Long: "`convert-schema1` rewrites schema1 manifests remaining in storage as schema2 manifests " +
+		"and moves their tags to the converted manifests. Manifests which cannot be converted, " +
+		"for example because a layer is missing, are reported and left untouched.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		results, err := storage.ConvertSchema1(ctx, registry, storage.Schema1ConvertOpts{
+			DryRun: convertDryRun,
+			Quiet:  quiet,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to convert schema1 manifests: %v", err)
+			os.Exit(1)
+		}
+
+		var failed int
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+			}
+		}
+		fmt.Printf("%d schema1 manifests found, %d could not be converted\n", len(results), failed)
+		if failed > 0 {
+			os.Exit(2)
+		}
+	},
+}
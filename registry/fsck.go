@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckRepair     bool
+	fsckQuarantine bool
+	fsckRepository string
+)
+
+// FsckCmd is the cobra command that corresponds to the fsck subcommand
+var FsckCmd = &cobra.Command{
+	Use:   "fsck <config>",
+	Short: "`fsck` checks the storage backend for broken links, manifests and uploads",
+	Long: "`fsck` checks the links, manifests and uploads of every repository for problems left by " +
+		"partial writes, outages or changes to the storage backend, and prints the problems found as JSON. " +
+		"With --repair, problems which can be fixed from the blob store are fixed. With --quarantine, " +
+		"the other problems are moved out of the repositories. It exits with status 1 if problems remain.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver, registry := repoStorage(cmd, args)
+
+		report, err := storage.Fsck(ctx, driver, registry, storage.FsckOpts{
+			Repository: fsckRepository,
+			Repair:     fsckRepair,
+			Quarantine: fsckQuarantine,
+		})
+		repoPrintJSON(report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check storage: %v\n", err)
+			os.Exit(1)
+		}
+		if report.Unresolved() > 0 {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+// defaultAdminPurgeAge is the upload age used by the purge endpoint when the
+// request does not specify one.
+const defaultAdminPurgeAge = 168 * time.Hour
+
+// adminRoutes are the routes of the admin API, which require access to the
+// registry:admin resource.
+var adminRoutes = map[string]bool{
+	v2.RouteNameAdminPurgeUploads: true,
+	v2.RouteNameAdminUploads:      true,
+	v2.RouteNameAdminUpload:       true,
+}
+
+func isAdminRoute(routeName string) bool {
+	return adminRoutes[routeName]
+}
+
+// appendAdminAccessRecord requires access to the registry:admin resource for
+// the routes of the admin API.
+func appendAdminAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	route := mux.CurrentRoute(r)
+	if route == nil || !isAdminRoute(route.GetName()) {
+		return accessRecords
+	}
+
+	return append(accessRecords, auth.Access{
+		Resource: auth.Resource{
+			Type: "registry",
+			Name: "admin",
+		},
+		Action: "*",
+	})
+}
+
+// adminHandler serves the admin API.
+type adminHandler struct {
+	*Context
+}
+
+// adminDispatcher returns a handler serving the methods of an admin route
+// if the admin API is enabled.
+func adminDispatcher(ctx *Context, methods handlers.MethodHandler) http.Handler {
+	if !ctx.Config.HTTP.Admin.Enabled {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnsupported.WithDetail("the admin API is disabled"))
+		})
+	}
+	return methods
+}
+
+func adminPurgeUploadsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	adminHandler := &adminHandler{
+		Context: ctx,
+	}
+
+	return adminDispatcher(ctx, handlers.MethodHandler{
+		http.MethodPost: http.HandlerFunc(adminHandler.PurgeUploads),
+	})
+}
+
+// adminPurgeUploadsResponse is the body returned by the purge endpoint.
+type adminPurgeUploadsResponse struct {
+	DryRun  bool                 `json:"dryRun"`
+	Uploads []storage.UploadInfo `json:"uploads"`
+	Errors  []string             `json:"errors,omitempty"`
+}
+
+// PurgeUploads purges the uploads selected by the age, dryrun and repository
+// query parameters, returning the uploads purged.
+func (ah *adminHandler) PurgeUploads(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := storage.PurgeUploadsOpts{
+		Age:        defaultAdminPurgeAge,
+		Repository: q.Get("repository"),
+		Subjects:   ah.App.purgeSubjects,
+	}
+	if age := q.Get("age"); age != "" {
+		d, err := time.ParseDuration(age)
+		if err != nil || d < 0 {
+			ah.Errors = append(ah.Errors, errcode.ErrorCodeParameterInvalid.WithDetail(map[string]string{"age": age}))
+			return
+		}
+		opts.Age = d
+	}
+	if dryRun := q.Get("dryrun"); dryRun != "" {
+		b, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			ah.Errors = append(ah.Errors, errcode.ErrorCodeParameterInvalid.WithDetail(map[string]string{"dryrun": dryRun}))
+			return
+		}
+		opts.DryRun = b
+	}
+
+	dcontext.GetLogger(ah).Infof("purging uploads on request of %q", getUserName(ah, r))
+	purged, errs := storage.PurgeRepositoryUploads(ah, ah.driver, opts)
+
+	resp := adminPurgeUploadsResponse{
+		DryRun:  opts.DryRun,
+		Uploads: purged,
+	}
+	for _, err := range errs {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+func adminUploadsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	adminHandler := &adminHandler{
+		Context: ctx,
+	}
+
+	return adminDispatcher(ctx, handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(adminHandler.ListUploads),
+	})
+}
+
+// adminUploadsResponse is the body returned by the upload listing endpoint.
+type adminUploadsResponse struct {
+	Uploads []storage.UploadInfo `json:"uploads"`
+	Errors  []string             `json:"errors,omitempty"`
+}
+
+// ListUploads lists the upload sessions in progress, selected by the
+// repository query parameter.
+func (ah *adminHandler) ListUploads(w http.ResponseWriter, r *http.Request) {
+	uploads, errs := storage.ListUploads(ah, ah.driver, r.URL.Query().Get("repository"))
+
+	resp := adminUploadsResponse{
+		Uploads: uploads,
+	}
+	for _, err := range errs {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+func adminUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
+	adminHandler := &adminHandler{
+		Context: ctx,
+	}
+
+	return adminDispatcher(ctx, handlers.MethodHandler{
+		http.MethodDelete: http.HandlerFunc(adminHandler.AbortUpload),
+	})
+}
+
+// AbortUpload deletes the upload session identified by the repository and
+// uuid path variables, releasing the quota held by it.
+func (ah *adminHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	vars := dcontext.GetVars(ah)
+	repository, id := vars["repository"], getUploadUUID(ah)
+
+	dcontext.GetLogger(ah).Infof("aborting upload %s in %s on request of %q", id, repository, getUserName(ah, r))
+	if err := storage.AbortUpload(ah, ah.driver, repository, id); err != nil {
+		if err == distribution.ErrBlobUploadUnknown {
+			ah.Errors = append(ah.Errors, errcode.ErrorCodeBlobUploadUnknown.WithDetail(err))
+		} else {
+			ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -17,19 +17,27 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+	"github.com/distribution/distribution/v3/registry/storage"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
+	events "github.com/docker/go-events"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
@@ -77,6 +85,554 @@ func TestCheckAPI(t *testing.T) {
 	}
 }
 
+// TestSpecAPI hits the /v2/_spec endpoint and checks that it returns an
+// OpenAPI document of the routes served.
+func TestSpecAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	doc := getSpec(t, env)
+	if doc.OpenAPI == "" {
+		t.Fatalf("missing openapi version")
+	}
+	if _, ok := doc.Paths["/v2/{name}/blobs/uploads/"]["post"]; !ok {
+		t.Fatalf("blob upload route missing from spec")
+	}
+	if _, ok := doc.Paths["/v2/_admin/uploads"]; ok {
+		t.Fatalf("admin route in spec of registry without admin API")
+	}
+	if _, ok := doc.Paths["/v2/_changes"]; ok {
+		t.Fatalf("changes route in spec of registry without change log")
+	}
+	if _, ok := doc.Paths["/v2/{name}/blobs/{digest}/_delta"]; ok {
+		t.Fatalf("blob delta route in spec of registry without deltas")
+	}
+
+	config := env.config
+	config.Auth = configuration.Auth{
+		"silly": {
+			"realm":   "realm-test",
+			"service": "service-test",
+		},
+	}
+	config.HTTP.Admin.Enabled = true
+	config.Catalog.Changes.Enabled = true
+	config.Deltas.Enabled = true
+	enabledEnv := newTestEnvWithConfig(t, &config)
+	defer enabledEnv.Shutdown()
+
+	doc = getSpec(t, enabledEnv)
+	for _, path := range []string{"/v2/_admin/uploads", "/v2/_changes", "/v2/{name}/blobs/{digest}/_delta"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Fatalf("route %s of enabled feature missing from spec", path)
+		}
+	}
+}
+
+// getSpec fetches the OpenAPI document served by the registry.
+func getSpec(t *testing.T, env *testEnv) v2.OpenAPIDocument {
+	specURL, err := env.builder.BuildSpecURL()
+	if err != nil {
+		t.Fatalf("unexpected error building spec url: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, specURL, nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "retrieving api spec", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type": []string{"application/json"},
+	})
+
+	var doc v2.OpenAPIDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("unexpected error decoding spec: %v", err)
+	}
+	return doc
+}
+
+// TestAdminPurgeUploadsAPI tests the /v2/_admin/uploads/purge endpoint.
+func TestAdminPurgeUploadsAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Admin.Enabled = true
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, name := range []string{"foo/bar", "baz"} {
+		named, _ := reference.WithName(name)
+		repo, err := env.app.registry.Repository(env.ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository: %v", err)
+		}
+		if _, err := repo.Blobs(env.ctx).Create(env.ctx); err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+	}
+
+	purge := func(values url.Values, authorized bool) *http.Response {
+		purgeURL, err := env.builder.BuildAdminPurgeUploadsURL(values)
+		if err != nil {
+			t.Fatalf("unexpected error building purge url: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, purgeURL, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		if authorized {
+			req.Header.Set("Authorization", "Bearer admin")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	resp := purge(nil, false)
+	defer resp.Body.Close()
+	checkResponse(t, "purging uploads without credentials", resp, http.StatusUnauthorized)
+
+	resp = purge(url.Values{"age": []string{"forever"}}, true)
+	defer resp.Body.Close()
+	checkResponse(t, "purging uploads with invalid age", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "purging uploads with invalid age", resp, errcode.ErrorCodeParameterInvalid)
+
+	var purged struct {
+		DryRun  bool                 `json:"dryRun"`
+		Uploads []storage.UploadInfo `json:"uploads"`
+	}
+	resp = purge(url.Values{"age": []string{"0s"}, "dryrun": []string{"true"}, "repository": []string{"foo"}}, true)
+	defer resp.Body.Close()
+	checkResponse(t, "purging uploads in dry run", resp, http.StatusOK)
+	if err := json.NewDecoder(resp.Body).Decode(&purged); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	if !purged.DryRun || len(purged.Uploads) != 1 || purged.Uploads[0].Repository != "foo/bar" {
+		t.Fatalf("unexpected dry run result: %+v", purged)
+	}
+
+	resp = purge(url.Values{"age": []string{"0s"}}, true)
+	defer resp.Body.Close()
+	checkResponse(t, "purging uploads", resp, http.StatusOK)
+	if err := json.NewDecoder(resp.Body).Decode(&purged); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	if purged.DryRun || len(purged.Uploads) != 2 {
+		t.Fatalf("unexpected purge result: %+v", purged)
+	}
+
+	resp = purge(url.Values{"age": []string{"0s"}}, true)
+	defer resp.Body.Close()
+	purged.Uploads = nil
+	if err := json.NewDecoder(resp.Body).Decode(&purged); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	if len(purged.Uploads) != 0 {
+		t.Fatalf("expected uploads to have been purged, got %+v", purged.Uploads)
+	}
+}
+
+// TestManifestLimits tests that manifests exceeding the configured limits
+// are rejected when they are put, and that per-repository overrides apply.
+func TestManifestLimits(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Policy: configuration.Policy{
+			Manifests: configuration.ManifestPolicy{
+				MaxBytes:     1024,
+				MaxLayers:    2,
+				MaxImageSize: 1000,
+				Repositories: []configuration.ManifestPolicyOverride{
+					{Names: []string{"large/.*"}, MaxBytes: -1, MaxLayers: 10, MaxImageSize: -1},
+					{Names: []string{"huge/.*"}, MaxBytes: 2 * maxManifestBodySize},
+				},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	layer := func(i int) v1.Descriptor {
+		return v1.Descriptor{
+			MediaType: schema2.MediaTypeLayer,
+			Digest:    digest.FromString(strconv.Itoa(i)),
+			Size:      100,
+		}
+	}
+	manifest := func(layers int) *schema2.Manifest {
+		m := &schema2.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: schema2.MediaTypeManifest,
+			Config: v1.Descriptor{
+				MediaType: schema2.MediaTypeImageConfig,
+				Digest:    digest.FromString("config"),
+				Size:      100,
+			},
+		}
+		for i := 0; i < layers; i++ {
+			m.Layers = append(m.Layers, layer(i))
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		repo      string
+		manifest  *schema2.Manifest
+		limit     string
+		errorCode errcode.ErrorCode
+	}{
+		{repo: "small/repo", manifest: manifest(3), limit: "maxlayers", errorCode: errcode.ErrorCodeManifestInvalid},
+		{repo: "small/repo", manifest: func() *schema2.Manifest {
+			m := manifest(1)
+			m.Layers[0].Size = 1000
+			return m
+		}(), limit: "maximagesize", errorCode: errcode.ErrorCodeManifestInvalid},
+		{repo: "small/repo", manifest: func() *schema2.Manifest {
+			m := manifest(1)
+			m.Layers[0].URLs = []string{"https://example.com/" + strings.Repeat("a", 1024)}
+			return m
+		}(), limit: "maxbytes", errorCode: errcode.ErrorCodeManifestInvalid},
+		// The override raises the limits, so the manifest only fails
+		// verification of its blobs.
+		{repo: "large/repo", manifest: manifest(3), errorCode: errcode.ErrorCodeManifestBlobUnknown},
+		// Overrides do not raise the limit above the built-in one.
+		{repo: "huge/repo", manifest: func() *schema2.Manifest {
+			m := manifest(1)
+			m.Layers[0].Annotations = map[string]string{"a": strings.Repeat("a", maxManifestBodySize)}
+			return m
+		}(), errorCode: errcode.ErrorCodeManifestInvalid},
+	} {
+		name, _ := reference.WithName(tc.repo)
+		ref, _ := reference.WithTag(name, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatalf("unexpected error building manifest url: %v", err)
+		}
+
+		resp := putManifest(t, "putting manifest", manifestURL, schema2.MediaTypeManifest, tc.manifest)
+		defer resp.Body.Close()
+		checkResponse(t, "putting manifest", resp, http.StatusBadRequest)
+
+		var body struct {
+			Errors []struct {
+				Code   string          `json:"code"`
+				Detail json.RawMessage `json:"detail"`
+			} `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("unexpected error decoding error response: %v", err)
+		}
+		if len(body.Errors) == 0 || body.Errors[0].Code != tc.errorCode.String() {
+			t.Fatalf("%s: expected %s error, got %+v", tc.repo, tc.errorCode, body.Errors)
+		}
+		if tc.limit == "" {
+			continue
+		}
+		var detail manifestLimitDetail
+		if err := json.Unmarshal(body.Errors[0].Detail, &detail); err != nil {
+			t.Fatalf("unexpected error decoding error detail: %v", err)
+		}
+		if detail.Limit != tc.limit {
+			t.Fatalf("%s: expected %q limit in detail, got %+v", tc.repo, tc.limit, detail)
+		}
+	}
+}
+
+// eventSinkFunc is an events.Sink calling a function for each event.
+type eventSinkFunc func(event events.Event) error
+
+func (f eventSinkFunc) Write(event events.Event) error { return f(event) }
+func (f eventSinkFunc) Close() error                   { return nil }
+
+// TestManifestProvenance tests the provenance headers and resolve events
+// of manifest pulls by tag.
+func TestManifestProvenance(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.ProvenanceHeaders = true
+	config.Log.TagPulls = true
+	config.Notifications.EventConfig.TagResolves = true
+
+	// The instance ID is provided by the registry context.
+	app := NewApp(dcontext.Background(), &config)
+	server := httptest.NewServer(app)
+	builder, err := v2.NewURLBuilderFromString(server.URL, false)
+	if err != nil {
+		t.Fatalf("error creating url builder: %v", err)
+	}
+	env := &testEnv{ctx: context.Background(), config: config, app: app, server: server, builder: builder}
+	defer env.Shutdown()
+	if app.events.source.InstanceID == "" {
+		t.Fatalf("expected an instance ID")
+	}
+
+	var (
+		mu       sync.Mutex
+		resolves []notifications.Event
+	)
+	env.app.events.sink = eventSinkFunc(func(event events.Event) error {
+		if e := event.(notifications.Event); e.Action == notifications.EventActionResolve {
+			mu.Lock()
+			resolves = append(resolves, e)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	dgst := createRepository(env, t, "foo/provenance", "latest")
+	name, _ := reference.WithName("foo/provenance")
+	ref, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodHead, manifestURL, nil)
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "checking manifest provenance", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest":                []string{dgst.String()},
+		"Docker-Distribution-Canonical-Digest": []string{dgst.String()},
+		"Docker-Distribution-Instance-Id":      []string{env.app.events.source.InstanceID},
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(resolves) != 1 {
+		t.Fatalf("expected one resolve event, got %d", len(resolves))
+	}
+	if target := resolves[0].Target; target.Tag != "latest" || target.Digest != dgst || target.Repository != "foo/provenance" {
+		t.Fatalf("unexpected resolve event target: %+v", target)
+	}
+}
+
+// TestManifestMediaTypeMappingETag tests that the ETag and digest of a
+// manifest converted for the client are those of the payload served, and
+// that the converted manifest can be fetched by its digest.
+func TestManifestMediaTypeMappingETag(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Compatibility: configuration.Compatibility{
+			MediaTypes: []configuration.MediaTypeMapping{
+				{From: schema2.MediaTypeManifest, To: v1.MediaTypeImageManifest},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/mapped", "latest")
+	name, _ := reference.WithName("foo/mapped")
+	tagRef, _ := reference.WithTag(name, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	digestRef, _ := reference.WithDigest(name, dgst)
+	digestURL, err := env.builder.BuildManifestURL(digestRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+
+	get := func(u, etag string, accept ...string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		if len(accept) == 0 {
+			accept = []string{v1.MediaTypeImageManifest}
+		}
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	resp := get(tagURL, "")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching converted manifest", resp, http.StatusOK)
+	p, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted := digest.FromBytes(p)
+	if converted == dgst {
+		t.Fatal("expected the manifest to be converted")
+	}
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{v1.MediaTypeImageManifest},
+		"Docker-Content-Digest": []string{converted.String()},
+		"Etag":                  []string{fmt.Sprintf(`"%s"`, converted)},
+	})
+
+	// The ETag of the stored manifest does not match the converted one.
+	resp = get(tagURL, fmt.Sprintf(`"%s"`, dgst))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching converted manifest with stored etag", resp, http.StatusOK)
+
+	resp = get(tagURL, fmt.Sprintf(`"%s"`, converted))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching converted manifest with its etag", resp, http.StatusNotModified)
+
+	// Manifests fetched by digest are not converted.
+	resp = get(digestURL, fmt.Sprintf(`"%s"`, dgst))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest by digest with its etag", resp, http.StatusNotModified)
+
+	// The converted manifest is served by its digest.
+	convertedRef, _ := reference.WithDigest(name, converted)
+	convertedURL, err := env.builder.BuildManifestURL(convertedRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp = get(convertedURL, "")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching converted manifest by digest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{v1.MediaTypeImageManifest},
+		"Docker-Content-Digest": []string{converted.String()},
+	})
+
+	// Clients accepting the stored media type are served it unchanged.
+	resp = get(tagURL, "", v1.MediaTypeImageManifest, schema2.MediaTypeManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest accepting the stored media type", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{schema2.MediaTypeManifest},
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+}
+
+// TestAdminUploadsAPI tests listing and aborting uploads with the
+// /v2/_admin/uploads endpoints.
+func TestAdminUploadsAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Admin.Enabled = true
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	named, _ := reference.WithName("foo/bar")
+	repo, err := env.app.registry.Repository(env.ctx, named)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	upload, err := repo.Blobs(env.ctx).Create(env.ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+
+	do := func(method, u string) *http.Response {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+	list := func() []storage.UploadInfo {
+		uploadsURL, err := env.builder.BuildAdminUploadsURL(url.Values{"repository": []string{"foo"}})
+		if err != nil {
+			t.Fatalf("unexpected error building uploads url: %v", err)
+		}
+		resp := do(http.MethodGet, uploadsURL)
+		defer resp.Body.Close()
+		checkResponse(t, "listing uploads", resp, http.StatusOK)
+		var uploads struct {
+			Uploads []storage.UploadInfo `json:"uploads"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&uploads); err != nil {
+			t.Fatalf("unexpected error decoding response: %v", err)
+		}
+		return uploads.Uploads
+	}
+
+	if uploads := list(); len(uploads) != 1 || uploads[0].Repository != "foo/bar" || uploads[0].ID != upload.ID() {
+		t.Fatalf("unexpected uploads: %+v", uploads)
+	}
+
+	abortURL, err := env.builder.BuildAdminUploadURL(named, upload.ID())
+	if err != nil {
+		t.Fatalf("unexpected error building upload url: %v", err)
+	}
+	resp := do(http.MethodDelete, abortURL)
+	defer resp.Body.Close()
+	checkResponse(t, "aborting upload", resp, http.StatusNoContent)
+
+	if uploads := list(); len(uploads) != 0 {
+		t.Fatalf("expected upload to have been aborted, got %+v", uploads)
+	}
+
+	resp = do(http.MethodDelete, abortURL)
+	defer resp.Body.Close()
+	checkResponse(t, "aborting unknown upload", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "aborting unknown upload", resp, errcode.ErrorCodeBlobUploadUnknown)
+}
+
 // TestCatalogAPI tests the /v2/_catalog endpoint
 func TestCatalogAPI(t *testing.T) {
 	env := newTestEnv(t, false)
@@ -451,6 +1007,116 @@ func TestCatalogAPI(t *testing.T) {
 	}
 }
 
+// TestChangesAPI tests reading the changes to the repositories from the
+// /v2/_changes endpoint.
+func TestChangesAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	changesURL, err := env.builder.BuildChangesURL()
+	if err != nil {
+		t.Fatalf("unexpected error building changes url: %v", err)
+	}
+	resp, err := http.Get(changesURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "reading disabled change log", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "reading disabled change log", resp, errcode.ErrorCodeUnsupported)
+	env.Shutdown()
+
+	config := env.config
+	config.Catalog.Changes.Enabled = true
+	env = newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+	changesURL, err = env.builder.BuildChangesURL()
+	if err != nil {
+		t.Fatalf("unexpected error building changes url: %v", err)
+	}
+
+	aaaa := createRepository(env, t, "foo/aaaa", "latest")
+	bbbb := createRepository(env, t, "foo/bbbb", "v1")
+
+	getChanges := func(u string) (changesAPIResponse, *http.Response) {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "reading changes", resp, http.StatusOK)
+		var changes changesAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+			t.Fatalf("error decoding changes response: %v", err)
+		}
+		return changes, resp
+	}
+
+	// Changes are read once settled.
+	var changes changesAPIResponse
+	for deadline := time.Now().Add(15 * time.Second); len(changes.Changes) < 4 && time.Now().Before(deadline); {
+		time.Sleep(500 * time.Millisecond)
+		changes, _ = getChanges(changesURL)
+	}
+	if len(changes.Changes) != 4 {
+		t.Fatalf("expected 4 changes, got %+v", changes.Changes)
+	}
+
+	firstURL, err := env.builder.BuildChangesURL(url.Values{"n": []string{"2"}})
+	if err != nil {
+		t.Fatalf("unexpected error building changes url: %v", err)
+	}
+	changes, pageResp := getChanges(firstURL)
+	if len(changes.Changes) != 2 || changes.Changes[1].Action != storage.ChangeTag || changes.Changes[1].Digest != aaaa {
+		t.Fatalf("unexpected changes: %+v", changes.Changes)
+	}
+	expected := []changedRepository{{Name: "foo/aaaa", Tags: []string{"latest"}}}
+	if !reflect.DeepEqual(changes.Repositories, expected) {
+		t.Fatalf("unexpected repositories: %+v != %+v", changes.Repositories, expected)
+	}
+	if changes.Cursor != changes.Changes[1].ID {
+		t.Fatalf("unexpected cursor %q, expected %q", changes.Cursor, changes.Changes[1].ID)
+	}
+	link := pageResp.Header.Get("Link")
+	if !strings.Contains(link, "since="+changes.Cursor) {
+		t.Fatalf("unexpected link header: %q", link)
+	}
+
+	// Read the changes following the cursor.
+	nextURL, err := env.builder.BuildChangesURL(url.Values{"since": []string{changes.Cursor}})
+	if err != nil {
+		t.Fatalf("unexpected error building changes url: %v", err)
+	}
+	changes, pageResp = getChanges(nextURL)
+	if len(changes.Changes) != 2 || changes.Changes[1].Repository != "foo/bbbb" || changes.Changes[1].Digest != bbbb {
+		t.Fatalf("unexpected changes: %+v", changes.Changes)
+	}
+	if link := pageResp.Header.Get("Link"); link != "" {
+		t.Fatalf("unexpected link header: %q", link)
+	}
+
+	// Nothing changed after now.
+	now := time.Now().UTC().Format(time.RFC3339)
+	sinceURL, err := env.builder.BuildChangesURL(url.Values{"since": []string{now}})
+	if err != nil {
+		t.Fatalf("unexpected error building changes url: %v", err)
+	}
+	changes, _ = getChanges(sinceURL)
+	if len(changes.Changes) != 0 || len(changes.Repositories) != 0 || changes.Cursor == "" {
+		t.Fatalf("unexpected changes since %s: %+v", now, changes)
+	}
+
+	invalidURL, err := env.builder.BuildChangesURL(url.Values{"since": []string{"yesterday"}})
+	if err != nil {
+		t.Fatalf("unexpected error building changes url: %v", err)
+	}
+	resp, err = http.Get(invalidURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "reading changes from invalid cursor", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "reading changes from invalid cursor", resp, errcode.ErrorCodeCursorInvalid)
+}
+
 // TestTagsAPI tests the /v2/<name>/tags/list endpoint
 func TestTagsAPI(t *testing.T) {
 	env := newTestEnv(t, false)
@@ -564,10 +1230,17 @@ func TestTagsAPI(t *testing.T) {
 			queryParams:        url.Values{"last": []string{"does-not-exist"}, "n": []string{"3"}},
 			expectedStatusCode: http.StatusOK,
 			expectedBody: tagsAPIResponse{Name: imageName.Name(), Tags: []string{
+				"jyi7b",
 				"kb0j5",
 				"sb71y",
 			}},
 		},
+		{
+			name:               "empty page",
+			queryParams:        url.Values{"n": []string{"0"}},
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       tagsAPIResponse{Name: imageName.Name(), Tags: []string{}},
+		},
 	}
 
 	for _, test := range tt {
@@ -721,6 +1394,57 @@ func TestBlobDelete(t *testing.T) {
 	testBlobDelete(t, env, args)
 }
 
+// TestBlobsExistAPI tests checking the existence of several blobs at once.
+func TestBlobsExistAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+	args := makeBlobArgs(t)
+	size, err := args.layerFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("error getting layer size: %v", err)
+	}
+	args.layerFile.Seek(0, io.SeekStart)
+	uploadURLBase, _ := startPushLayer(t, env, args.imageName)
+	pushLayer(t, env.builder, args.imageName, args.layerDigest, uploadURLBase, args.layerFile)
+
+	existURL, err := env.builder.BuildBlobsExistURL(args.imageName)
+	if err != nil {
+		t.Fatalf("error building url: %v", err)
+	}
+	unknown := digest.FromString("unknown")
+	check := func(body string) *http.Response {
+		resp, err := http.Post(existURL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("unexpected error checking blobs: %v", err)
+		}
+		return resp
+	}
+
+	resp := check(fmt.Sprintf(`{"digests": [%q, %q]}`, unknown, args.layerDigest))
+	defer resp.Body.Close()
+	checkResponse(t, "checking blobs", resp, http.StatusOK)
+	var blobs blobsExistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&blobs); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	expected := []blobExistence{
+		{Digest: unknown},
+		{Digest: args.layerDigest, Exists: true, Size: size},
+	}
+	if !reflect.DeepEqual(blobs.Blobs, expected) {
+		t.Fatalf("unexpected blobs: %+v != %+v", blobs.Blobs, expected)
+	}
+
+	resp = check(`{"digests": ["sha256:invalid"]}`)
+	defer resp.Body.Close()
+	checkResponse(t, "checking invalid digest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "checking invalid digest", resp, errcode.ErrorCodeDigestInvalid)
+
+	resp = check(`{"digests": "sha256"}`)
+	defer resp.Body.Close()
+	checkResponse(t, "checking malformed body", resp, http.StatusMethodNotAllowed)
+}
+
 func TestRelativeURL(t *testing.T) {
 	config := configuration.Configuration{
 		Storage: configuration.Storage{
@@ -2234,6 +2958,169 @@ func newTestEnvMirror(t *testing.T, deleteEnabled bool) *testEnv {
 	return newTestEnvWithConfig(t, &config)
 }
 
+// TestUploadReplayProtection checks that upload urls cannot be reused when
+// replay protection is enabled.
+func TestUploadReplayProtection(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.ReplayProtection.Enabled = true
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer file: %v", err)
+	}
+	p, err := io.ReadAll(layerFile)
+	if err != nil {
+		t.Fatalf("error reading layer: %v", err)
+	}
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	nextURL, _ := pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(p), int64(len(p)))
+
+	resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(p), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error replaying chunk: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "replaying chunk", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "replaying chunk", resp, errcode.ErrorCodeBlobUploadInvalid)
+
+	// Status requests do not consume the nonce, and answer with the same
+	// url rather than a fresh one.
+	statusURL, _, err := getUploadStatus(nextURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting upload status: %v", err)
+	}
+	if statusURL != nextURL {
+		t.Fatalf("expected status request to return the same upload url, got %s", statusURL)
+	}
+
+	// The url returned for a used url carries a fresh nonce, which expires
+	// along with the used one.
+	usedURL, _, err := getUploadStatus(uploadURLBase)
+	if err != nil {
+		t.Fatalf("unexpected error getting upload status with a used url: %v", err)
+	}
+	usedState, freshState := uploadURLState(t, env, uploadURLBase), uploadURLState(t, env, usedURL)
+	if freshState.Nonce == usedState.Nonce {
+		t.Fatalf("expected status of a used url to carry a fresh nonce")
+	}
+	if freshState.Expires != usedState.Expires {
+		t.Fatalf("expected status of a used url to keep its expiry, got %d instead of %d", freshState.Expires, usedState.Expires)
+	}
+	u, err := url.Parse(nextURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.RawQuery = ""
+	resp, err = http.Get(u.String())
+	if err != nil {
+		t.Fatalf("unexpected error getting upload status without state: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting upload status without state", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "getting upload status without state", resp, errcode.ErrorCodeBlobUploadInvalid)
+
+	finishUpload(t, env.builder, imageName, nextURL, layerDigest)
+
+	resp, err = doPushLayer(t, env.builder, imageName, layerDigest, nextURL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error replaying upload completion: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "replaying upload completion", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "replaying upload completion", resp, errcode.ErrorCodeBlobUploadInvalid)
+}
+
+// TestUploadReplayProtectionResume checks that an upload whose PATCH was
+// interrupted can be resumed from the url returned by a status request when
+// replay protection is enabled.
+func TestUploadReplayProtectionResume(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.ReplayProtection.Enabled = true
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer file: %v", err)
+	}
+	p, err := io.ReadAll(layerFile)
+	if err != nil {
+		t.Fatalf("error reading layer: %v", err)
+	}
+	half := int64(len(p) / 2)
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+
+	// The client fails after sending half of the layer.
+	body := io.MultiReader(bytes.NewReader(p[:half]), iotest.ErrReader(errors.New("interrupted")))
+	if resp, err := doPushChunk(t, uploadURLBase, body, chunkOptions{}); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected interrupted chunk to fail, got status %d", resp.StatusCode)
+	}
+
+	// The server may still be handling the interrupted request.
+	var (
+		statusURL string
+		end       int64
+	)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		statusURL, end, err = getUploadStatus(uploadURLBase)
+		if err != nil {
+			t.Fatalf("unexpected error getting upload status: %v", err)
+		}
+		if end == half-1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if end != half-1 {
+		t.Fatalf("expected upload to resume at offset %d, got range end %d", half, end)
+	}
+
+	resp, err := doPushChunk(t, statusURL, bytes.NewReader(p[half:]), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "resuming interrupted upload", resp, http.StatusAccepted)
+
+	finishUpload(t, env.builder, imageName, resp.Header.Get("Location"), layerDigest)
+}
+
+// uploadURLState returns the upload state carried by an upload url.
+func uploadURLState(t *testing.T, env *testEnv, uploadURL string) blobUploadState {
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := hmacKey(env.app.Config.HTTP.Secret).unpackUploadState(u.Query().Get("_state"))
+	if err != nil {
+		t.Fatalf("unexpected error unpacking upload state: %v", err)
+	}
+	return state
+}
+
 func newTestEnv(t *testing.T, deleteEnabled bool) *testEnv {
 	config := configuration.Configuration{
 		Storage: configuration.Storage{
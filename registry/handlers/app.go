@@ -24,15 +24,19 @@ import (
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/health/checks"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/spiffe"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/jobs"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -79,6 +83,13 @@ type App struct {
 		source notifications.SourceRecord
 	}
 
+	// usage aggregates the bytes pulled and pushed by repository when usage
+	// events are enabled, otherwise it is nil.
+	usage *notifications.UsageAggregator
+
+	// scheduler runs the maintenance jobs, if any are enabled.
+	scheduler *jobs.Scheduler
+
 	redis redis.UniversalClient
 
 	// isCache is true if this registry is configured as a pull through cache
@@ -86,6 +97,60 @@ type App struct {
 
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
+
+	// purgeSubjects are the subjects by which purged uploads are counted.
+	purgeSubjects []string
+
+	// mediaTypeMappings holds the manifest conversions served to legacy
+	// clients.
+	mediaTypeMappings []mediaTypeMapping
+
+	// manifestPolicy holds the limits on manifests put to each repository.
+	manifestPolicy manifestPolicy
+
+	// cluster assigns blobs to the members of the cluster, when configured.
+	cluster *clusterRing
+
+	// deltas bounds the number of blob deltas computed at once, when deltas
+	// are enabled, otherwise it is nil.
+	deltas chan struct{}
+
+	// nonces records the nonces of used upload URLs when replay protection
+	// is enabled, otherwise it is nil.
+	nonces   cache.NonceStore
+	nonceTTL time.Duration
+
+	// namespaceLabels labels the route metrics by repository namespace when
+	// enabled, otherwise it is nil.
+	namespaceLabels *namespaceLabeler
+}
+
+// defaultInlineBlobSize is the size of the largest blob served from the
+// inline blob cache, unless configured.
+const defaultInlineBlobSize = 16 << 10
+
+// StorageOptions returns the options of the registry which determine how its
+// content is stored, indexed and recorded. They are shared by the app and the
+// commands working on the storage of the registry, so that content written
+// by one is read and maintained alike by the others.
+func StorageOptions(config *configuration.Configuration) []storage.RegistryOption {
+	var options []storage.RegistryOption
+	if enabled, compactAfter := config.Storage.TagIndex(); enabled {
+		options = append(options, storage.EnableTagIndex(compactAfter))
+	}
+	if enabled, minSize, averageSize := config.Storage.Chunking(); enabled {
+		options = append(options, storage.EnableChunking(minSize, averageSize))
+	}
+	if config.Storage.VerifyManifests() {
+		options = append(options, storage.VerifyManifests)
+	}
+	if config.Storage.LinkChecksums() {
+		options = append(options, storage.EnableLinkChecksums)
+	}
+	if config.Catalog.Changes.Enabled {
+		options = append(options, storage.EnableChangeLog)
+	}
+	return options
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -97,6 +162,8 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		Context: ctx,
 		router:  v2.RouterWithPrefix(config.HTTP.Prefix),
 		isCache: config.Proxy.RemoteURL != "",
+
+		namespaceLabels: newNamespaceLabeler(config.HTTP.Debug.Prometheus.Repositories),
 	}
 
 	// Register the handler dispatchers.
@@ -105,22 +172,26 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	})
 	app.register(v2.RouteNameManifest, manifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
+	app.register(v2.RouteNameChanges, changesDispatcher)
+	app.register(v2.RouteNameSpec, specDispatcher)
+	app.register(v2.RouteNameAdminPurgeUploads, adminPurgeUploadsDispatcher)
+	app.register(v2.RouteNameAdminUploads, adminUploadsDispatcher)
+	app.register(v2.RouteNameAdminUpload, adminUploadDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
 	app.register(v2.RouteNameBlob, blobDispatcher)
+	app.register(v2.RouteNameBlobsExist, blobsExistDispatcher)
+	app.register(v2.RouteNameBlobDelta, blobDeltaDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
 
-	// override the storage driver's UA string for registry outbound HTTP requests
-	storageParams := config.Storage.Parameters()
-	if storageParams == nil {
-		storageParams = make(configuration.Parameters)
-	}
-	if storageParams["useragent"] == "" {
-		storageParams["useragent"] = fmt.Sprintf("distribution/%s %s", version.Version(), runtime.Version())
+	for _, rt := range config.HTTP.Timeouts.Routes {
+		if app.router.Get(rt.Route) == nil {
+			panic(fmt.Sprintf("http.timeouts.routes: unknown route %q", rt.Route))
+		}
 	}
 
 	var err error
-	app.driver, err = factory.Create(app, config.Storage.Type(), storageParams)
+	app.driver, err = factory.Create(app, config.Storage.Type(), storageParameters(config))
 	if err != nil {
 		// TODO(stevvooe): Move the creation of a service into a protected
 		// method, where this is created lazily. Its status can be queried via
@@ -150,7 +221,16 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	if v, ok := purgeConfig["subjects"]; ok {
+		app.purgeSubjects = parsePurgeSubjects(v)
+	}
+
+	var maintenanceJobs []jobs.Job
+	if config.Storage.WORM() {
+		dcontext.GetLogger(app).Info("WORM mode enabled, upload purging disabled")
+	} else if job := uploadPurgeJob(app.driver, dcontext.GetLogger(app), purgeConfig, app.purgeSubjects); job != nil {
+		maintenanceJobs = append(maintenanceJobs, *job)
+	}
 
 	app.driver, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
 	if err != nil {
@@ -164,176 +244,66 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 	app.configureEvents(config)
 	app.configureRedis(config)
+	app.configureReplayProtection(config)
 	app.configureLogHook(config)
 
-	options := registrymiddleware.GetRegistryOptions()
-
-	if config.HTTP.Host != "" {
-		u, err := url.Parse(config.HTTP.Host)
-		if err != nil {
-			panic(fmt.Sprintf(`could not parse http "host" parameter: %v`, err))
-		}
-		app.httpHost = *u
+	app.mediaTypeMappings, err = parseMediaTypeMappings(config.Compatibility.MediaTypes)
+	if err != nil {
+		panic(err)
 	}
 
-	if app.isCache {
-		options = append(options, storage.DisableDigestResumption)
+	app.manifestPolicy, err = parseManifestPolicy(config.Policy.Manifests)
+	if err != nil {
+		panic(err)
 	}
 
-	// configure deletion
-	if d, ok := config.Storage["delete"]; ok {
-		e, ok := d["enabled"]
-		if ok {
-			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
-				options = append(options, storage.EnableDelete)
-			}
+	if config.Cluster.Self != "" || len(config.Cluster.Peers) > 0 {
+		app.cluster, err = newClusterRing(config.Cluster)
+		if err != nil {
+			panic(err)
 		}
 	}
 
-	// configure tag lookup concurrency limit
-	if p := config.Storage.TagParameters(); p != nil {
-		l, ok := p["concurrencylimit"]
-		if ok {
-			limit, ok := l.(int)
-			if !ok {
-				panic("tag lookup concurrency limit config key must have a integer value")
-			}
-			if limit < 0 {
-				panic("tag lookup concurrency limit should be a non-negative integer value")
-			}
-			options = append(options, storage.TagLookupConcurrencyLimit(limit))
+	if config.Deltas.Enabled {
+		maxConcurrent := config.Deltas.MaxConcurrent
+		if maxConcurrent <= 0 {
+			maxConcurrent = defaultDeltaMaxConcurrent
 		}
+		app.deltas = make(chan struct{}, maxConcurrent)
 	}
 
-	// configure redirects
-	var redirectDisabled bool
-	if redirectConfig, ok := config.Storage["redirect"]; ok {
-		v := redirectConfig["disable"]
-		switch v := v.(type) {
-		case bool:
-			redirectDisabled = v
-		default:
-			panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+	if config.HTTP.Host != "" {
+		u, err := url.Parse(config.HTTP.Host)
+		if err != nil {
+			panic(fmt.Sprintf(`could not parse http "host" parameter: %v`, err))
 		}
-	}
-	if redirectDisabled {
-		dcontext.GetLogger(app).Infof("backend redirection disabled")
-	} else {
-		options = append(options, storage.EnableRedirect)
+		app.httpHost = *u
 	}
 
-	if !config.Validation.Enabled {
-		config.Validation.Enabled = !config.Validation.Disabled
+	options := app.registryOptions(config)
+	options = append(options, app.cacheOptions(config)...)
+	app.registry, err = storage.NewRegistry(app, app.driver, options...)
+	if err != nil {
+		panic("could not create registry: " + err.Error())
 	}
 
-	// configure validation
-	if config.Validation.Enabled {
-		if len(config.Validation.Manifests.URLs.Allow) == 0 && len(config.Validation.Manifests.URLs.Deny) == 0 {
-			// If Allow and Deny are empty, allow nothing.
-			options = append(options, storage.ManifestURLsAllowRegexp(regexp.MustCompile("^$")))
-		} else {
-			if len(config.Validation.Manifests.URLs.Allow) > 0 {
-				for i, s := range config.Validation.Manifests.URLs.Allow {
-					// Validate via compilation.
-					if _, err := regexp.Compile(s); err != nil {
-						panic(fmt.Sprintf("validation.manifests.urls.allow: %s", err))
-					}
-					// Wrap with non-capturing group.
-					config.Validation.Manifests.URLs.Allow[i] = fmt.Sprintf("(?:%s)", s)
-				}
-				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Allow, "|"))
-				options = append(options, storage.ManifestURLsAllowRegexp(re))
-			}
-			if len(config.Validation.Manifests.URLs.Deny) > 0 {
-				for i, s := range config.Validation.Manifests.URLs.Deny {
-					// Validate via compilation.
-					if _, err := regexp.Compile(s); err != nil {
-						panic(fmt.Sprintf("validation.manifests.urls.deny: %s", err))
-					}
-					// Wrap with non-capturing group.
-					config.Validation.Manifests.URLs.Deny[i] = fmt.Sprintf("(?:%s)", s)
-				}
-				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Deny, "|"))
-				options = append(options, storage.ManifestURLsDenyRegexp(re))
-			}
-		}
-
-		switch config.Validation.Manifests.Indexes.Platforms {
-		case "list":
-			options = append(options, storage.EnableValidateImageIndexImagesExist)
-			for _, platform := range config.Validation.Manifests.Indexes.PlatformList {
-				options = append(options, storage.AddValidateImageIndexImagesExistPlatform(platform.Architecture, platform.OS))
-			}
-			fallthrough
-		case "none":
-			dcontext.GetLogger(app).Warn("Image index completeness validation has been disabled, which is an experimental option because other container tooling might expect all image indexes to be complete")
-		case "all":
-			fallthrough
-		default:
-			options = append(options, storage.EnableValidateImageIndexImagesExist)
-		}
+	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
+	if err != nil {
+		panic(err)
 	}
 
-	// configure storage caches
-	if cc, ok := config.Storage["cache"]; ok {
-		v, ok := cc["blobdescriptor"]
-		if !ok {
-			// Backwards compatible: "layerinfo" == "blobdescriptor"
-			v = cc["layerinfo"]
-		}
-
-		switch v {
-		case "redis":
-			if app.redis == nil {
-				panic("redis configuration required to use for layerinfo cache")
-			}
-			if _, ok := cc["blobdescriptorsize"]; ok {
-				dcontext.GetLogger(app).Warnf("blobdescriptorsize parameter is not supported with redis cache")
-			}
-			cacheProvider := rediscache.NewRedisBlobDescriptorCacheProvider(app.redis)
-			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
-			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
-			if err != nil {
-				panic("could not create registry: " + err.Error())
-			}
-			dcontext.GetLogger(app).Infof("using redis blob descriptor cache")
-		case "inmemory":
-			blobDescriptorSize := memorycache.DefaultSize
-			configuredSize, ok := cc["blobdescriptorsize"]
-			if ok {
-				// Since Parameters is not strongly typed, render to a string and convert back
-				blobDescriptorSize, err = strconv.Atoi(fmt.Sprint(configuredSize))
-				if err != nil {
-					panic(fmt.Sprintf("invalid blobdescriptorsize value %s: %s", configuredSize, err))
-				}
-			}
-
-			cacheProvider := memorycache.NewInMemoryBlobDescriptorCacheProvider(blobDescriptorSize)
-			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
-			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
-			if err != nil {
-				panic("could not create registry: " + err.Error())
+	if mc, ok := config.Storage["maintenance"]; ok {
+		if v, ok := mc["usage"]; ok {
+			usageConfig, ok := v.(map[interface{}]interface{})
+			if !ok {
+				panic("usage config key must contain additional keys")
 			}
-			dcontext.GetLogger(app).Infof("using inmemory blob descriptor cache")
-		default:
-			if v != "" {
-				dcontext.GetLogger(app).Warnf("unknown cache type %q, caching disabled", config.Storage["cache"])
+			if job := usageJob(app.registry, app.driver, dcontext.GetLogger(app), usageConfig, app.usage); job != nil {
+				maintenanceJobs = append(maintenanceJobs, *job)
 			}
 		}
 	}
-
-	if app.registry == nil {
-		// configure the registry if no cache section is available.
-		app.registry, err = storage.NewRegistry(app.Context, app.driver, options...)
-		if err != nil {
-			panic("could not create registry: " + err.Error())
-		}
-	}
-
-	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
-	if err != nil {
-		panic(err)
-	}
+	app.configureJobs(config, maintenanceJobs)
 
 	authType := config.Auth.Type()
 
@@ -345,16 +315,42 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		app.accessController = accessController
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
 	}
+	if config.HTTP.Admin.Enabled && app.accessController == nil {
+		panic("the admin API requires an auth configuration")
+	}
 
 	// configure as a pull through cache
 	if config.Proxy.RemoteURL != "" {
-		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
+		var proxyOpts []proxy.Option
+		switch store := config.Proxy.Scheduler.Store; store {
+		case "", "storage":
+		case "redis":
+			if app.redis == nil {
+				panic("redis configuration required to use for the proxy scheduler state")
+			}
+			proxyOpts = append(proxyOpts, proxy.WithSchedulerStateStore(scheduler.NewRedisStateStore(app.redis, "proxy::scheduler")))
+		default:
+			panic(fmt.Sprintf("unknown proxy scheduler store %q", store))
+		}
+		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy, proxyOpts...)
 		if err != nil {
 			panic(err.Error())
 		}
 		app.isCache = true
 		dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
 	}
+
+	// fall back to peer registries for missing content
+	if len(config.Federation.Peers) > 0 {
+		if app.isCache {
+			panic("federation peers cannot be configured for a proxy cache")
+		}
+		app.registry, err = proxy.NewFederatedRegistry(ctx, app.registry, config.Federation)
+		if err != nil {
+			panic(err.Error())
+		}
+		dcontext.GetLogger(app).Infof("Registry federated with %d peer registries", len(config.Federation.Peers))
+	}
 	var ok bool
 	app.repoRemover, ok = app.registry.(distribution.RepositoryRemover)
 	if !ok {
@@ -449,17 +445,286 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 
 // Shutdown close the underlying registry
 func (app *App) Shutdown() error {
+	if app.scheduler != nil {
+		app.scheduler.Stop()
+	}
+	if app.usage != nil {
+		app.usage.Close()
+	}
 	if r, ok := app.registry.(proxy.Closer); ok {
 		return r.Close()
 	}
 	return nil
 }
 
+// storageParameters returns the parameters of the storage driver of config,
+// overriding the driver's UA string for registry outbound HTTP requests.
+func storageParameters(config *configuration.Configuration) configuration.Parameters {
+	storageParams := config.Storage.Parameters()
+	if storageParams == nil {
+		storageParams = make(configuration.Parameters)
+	}
+	if storageParams["useragent"] == "" {
+		storageParams["useragent"] = fmt.Sprintf("distribution/%s %s", version.Version(), runtime.Version())
+	}
+	return storageParams
+}
+
+// registryOptions returns the options of the registry configured by config,
+// apart from its caches. It panics if the configuration is invalid.
+func (app *App) registryOptions(config *configuration.Configuration) []storage.RegistryOption {
+	options := registrymiddleware.GetRegistryOptions()
+
+	if app.isCache {
+		options = append(options, storage.DisableDigestResumption)
+	}
+
+	// configure deletion
+	if d, ok := config.Storage["delete"]; ok {
+		e, ok := d["enabled"]
+		if ok {
+			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
+				options = append(options, storage.EnableDelete)
+			}
+		}
+	}
+
+	// configure tag lookup concurrency limit
+	if p := config.Storage.TagParameters(); p != nil {
+		l, ok := p["concurrencylimit"]
+		if ok {
+			limit, ok := l.(int)
+			if !ok {
+				panic("tag lookup concurrency limit config key must have a integer value")
+			}
+			if limit < 0 {
+				panic("tag lookup concurrency limit should be a non-negative integer value")
+			}
+			options = append(options, storage.TagLookupConcurrencyLimit(limit))
+		}
+	}
+
+	options = append(options, StorageOptions(config)...)
+	if enabled, _, _ := config.Storage.Chunking(); enabled {
+		dcontext.GetLogger(app).Warn("storing large blobs as chunks, which is an experimental option: blobs stored as chunks cannot be read once it is disabled")
+	}
+
+	if limits := config.Policy.Uploads; limits.MaxConcurrent != 0 || limits.MaxBytes != 0 {
+		options = append(options, storage.UploadLimits(limits.MaxConcurrent, limits.MaxBytes))
+	}
+
+	if mounts := config.Policy.Mounts; mounts.Scope != "" || mounts.VerifySource {
+		options = append(options, storage.MountPolicy(storage.MountScope(mounts.Scope), mounts.VerifySource))
+	}
+	if pools := config.Policy.Mounts.Pools; len(pools) > 0 {
+		options = append(options, storage.MountPools(pools...))
+	}
+
+	// configure redirects
+	var redirectDisabled bool
+	if redirectConfig, ok := config.Storage["redirect"]; ok {
+		v := redirectConfig["disable"]
+		switch v := v.(type) {
+		case bool:
+			redirectDisabled = v
+		default:
+			panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+		}
+	}
+	if redirectDisabled {
+		dcontext.GetLogger(app).Infof("backend redirection disabled")
+	} else {
+		options = append(options, storage.EnableRedirect)
+	}
+
+	if !config.Validation.Enabled {
+		config.Validation.Enabled = !config.Validation.Disabled
+	}
+
+	// configure validation
+	if config.Validation.Enabled {
+		if len(config.Validation.Manifests.URLs.Allow) == 0 && len(config.Validation.Manifests.URLs.Deny) == 0 {
+			// If Allow and Deny are empty, allow nothing.
+			options = append(options, storage.ManifestURLsAllowRegexp(regexp.MustCompile("^$")))
+		} else {
+			if len(config.Validation.Manifests.URLs.Allow) > 0 {
+				for i, s := range config.Validation.Manifests.URLs.Allow {
+					// Validate via compilation.
+					if _, err := regexp.Compile(s); err != nil {
+						panic(fmt.Sprintf("validation.manifests.urls.allow: %s", err))
+					}
+					// Wrap with non-capturing group.
+					config.Validation.Manifests.URLs.Allow[i] = fmt.Sprintf("(?:%s)", s)
+				}
+				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Allow, "|"))
+				options = append(options, storage.ManifestURLsAllowRegexp(re))
+			}
+			if len(config.Validation.Manifests.URLs.Deny) > 0 {
+				for i, s := range config.Validation.Manifests.URLs.Deny {
+					// Validate via compilation.
+					if _, err := regexp.Compile(s); err != nil {
+						panic(fmt.Sprintf("validation.manifests.urls.deny: %s", err))
+					}
+					// Wrap with non-capturing group.
+					config.Validation.Manifests.URLs.Deny[i] = fmt.Sprintf("(?:%s)", s)
+				}
+				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Deny, "|"))
+				options = append(options, storage.ManifestURLsDenyRegexp(re))
+			}
+		}
+
+		switch config.Validation.Manifests.Indexes.Platforms {
+		case "list":
+			options = append(options, storage.EnableValidateImageIndexImagesExist)
+			for _, platform := range config.Validation.Manifests.Indexes.PlatformList {
+				options = append(options, storage.AddValidateImageIndexImagesExistPlatform(platform.Architecture, platform.OS))
+			}
+			fallthrough
+		case "none":
+			dcontext.GetLogger(app).Warn("Image index completeness validation has been disabled, which is an experimental option because other container tooling might expect all image indexes to be complete")
+		case "all":
+			fallthrough
+		default:
+			options = append(options, storage.EnableValidateImageIndexImagesExist)
+		}
+	}
+
+	return options
+}
+
+// cacheOptions returns the options of the registry configuring the storage
+// caches of config. It panics if the configuration is invalid, and requires
+// the redis client to be configured first if a cache uses redis.
+func (app *App) cacheOptions(config *configuration.Configuration) []storage.RegistryOption {
+	cc, ok := config.Storage["cache"]
+	if !ok {
+		return nil
+	}
+
+	var options []storage.RegistryOption
+	switch v := cc["inlineblobs"]; v {
+	case nil, "":
+	case "redis", "inmemory":
+		maxSize := int64(defaultInlineBlobSize)
+		if configured, ok := cc["inlinemaxsize"]; ok {
+			var err error
+			maxSize, err = strconv.ParseInt(fmt.Sprint(configured), 10, 64)
+			if err != nil || maxSize <= 0 {
+				panic(fmt.Sprintf("invalid inlinemaxsize value %v", configured))
+			}
+		}
+
+		var contentCache cache.BlobContentCache
+		if v == "redis" {
+			if app.redis == nil {
+				panic("redis configuration required to use for inline blobs")
+			}
+			contentCache = rediscache.NewRedisBlobContentCache(app.redis)
+		} else {
+			cacheSize := int64(memorycache.DefaultContentSize)
+			if configured, ok := cc["inlinecachesize"]; ok {
+				var err error
+				cacheSize, err = strconv.ParseInt(fmt.Sprint(configured), 10, 64)
+				if err != nil {
+					panic(fmt.Sprintf("invalid inlinecachesize value %v", configured))
+				}
+			}
+			contentCache = memorycache.NewInMemoryBlobContentCache(cacheSize)
+		}
+		options = append(options, storage.InlineBlobs(contentCache, maxSize))
+		dcontext.GetLogger(app).Infof("serving blobs of up to %d bytes from %s cache", maxSize, v)
+	default:
+		panic(fmt.Sprintf("unknown inline blobs cache %v", v))
+	}
+
+	switch v := blobDescriptorCache(config); v {
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for layerinfo cache")
+		}
+		if _, ok := cc["blobdescriptorsize"]; ok {
+			dcontext.GetLogger(app).Warnf("blobdescriptorsize parameter is not supported with redis cache")
+		}
+		if _, ok := cc["invalidation"]; ok {
+			dcontext.GetLogger(app).Warnf("invalidation parameter is not supported with redis cache, which is shared by all registry instances")
+		}
+		cacheProvider := rediscache.NewRedisBlobDescriptorCacheProvider(app.redis)
+		options = append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
+		dcontext.GetLogger(app).Infof("using redis blob descriptor cache")
+	case "inmemory":
+		blobDescriptorSize := memorycache.DefaultSize
+		configuredSize, ok := cc["blobdescriptorsize"]
+		if ok {
+			// Since Parameters is not strongly typed, render to a string and convert back
+			var err error
+			blobDescriptorSize, err = strconv.Atoi(fmt.Sprint(configuredSize))
+			if err != nil {
+				panic(fmt.Sprintf("invalid blobdescriptorsize value %s: %s", configuredSize, err))
+			}
+		}
+
+		cacheProvider := memorycache.NewInMemoryBlobDescriptorCacheProvider(blobDescriptorSize)
+		switch invalidation := cc["invalidation"]; invalidation {
+		case nil, "", "none":
+		case "redis":
+			if app.redis == nil {
+				panic("redis configuration required to use for blob descriptor cache invalidation")
+			}
+			cacheProvider = rediscache.NewInvalidatingBlobDescriptorCacheProvider(app, cacheProvider, app.redis)
+			dcontext.GetLogger(app).Infof("publishing blob descriptor cache invalidations to redis")
+		default:
+			panic(fmt.Sprintf("unknown blob descriptor cache invalidation %v", invalidation))
+		}
+		options = append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
+		dcontext.GetLogger(app).Infof("using inmemory blob descriptor cache")
+	default:
+		if v != "" {
+			dcontext.GetLogger(app).Warnf("unknown cache type %q, caching disabled", config.Storage["cache"])
+		}
+	}
+	return options
+}
+
+// blobDescriptorCache returns the type of the configured blob descriptor
+// cache, or an empty string if none is configured.
+func blobDescriptorCache(config *configuration.Configuration) string {
+	cc, ok := config.Storage["cache"]
+	if !ok {
+		return ""
+	}
+	v, ok := cc["blobdescriptor"]
+	if !ok {
+		// Backwards compatible: "layerinfo" == "blobdescriptor"
+		v = cc["layerinfo"]
+	}
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// LoadCertPool returns a pool of the PEM encoded CA certificates read from
+// files.
+func LoadCertPool(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, ca := range files {
+		caPem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		if ok := pool.AppendCertsFromPEM(caPem); !ok {
+			return nil, fmt.Errorf("could not add CA %s to pool", ca)
+		}
+	}
+	return pool, nil
+}
+
 // register a handler with the application, by route name. The handler will be
 // passed through the application filters and context will be constructed at
 // request time.
 func (app *App) register(routeName string, dispatch dispatchFunc) {
-	handler := app.dispatcher(dispatch)
+	handler := app.recordUsage(routeName, app.dispatcher(dispatch))
+	handler = app.routeTimeout(routeName, handler)
 
 	// Chain the handler with prometheus instrumented handler
 	if app.Config.HTTP.Debug.Prometheus.Enabled {
@@ -467,6 +732,7 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 		httpMetrics := namespace.NewDefaultHttpMetrics(strings.Replace(routeName, "-", "_", -1))
 		metrics.Register(namespace)
 		handler = metrics.InstrumentHandler(httpMetrics, handler)
+		handler = instrumentRoute(routeName, handler, app.namespaceLabels)
 	}
 
 	// TODO(stevvooe): This odd dispatcher/route registration is by-product of
@@ -478,6 +744,25 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 	app.router.GetRoute(routeName).Handler(handler)
 }
 
+// endpointTransport returns the transport of the requests to a notification
+// endpoint, or nil for the default transport.
+func endpointTransport(endpoint configuration.Endpoint) (*http.Transport, error) {
+	if !endpoint.SPIFFE.Enabled {
+		return nil, nil
+	}
+	source, err := spiffe.SourceFor(endpoint.SPIFFE.EndpointSocket)
+	if err != nil {
+		return nil, err
+	}
+	config, err := source.TLSConfig(endpoint.SPIFFE.ServerIDs)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
 // configureEvents prepares the event sink for action.
 func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// Configure all of the endpoint sinks.
@@ -494,6 +779,10 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 		}
 
 		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
+		transport, err := endpointTransport(endpoint)
+		if err != nil {
+			panic(fmt.Sprintf("endpoint %s: %v", endpoint.Name, err))
+		}
 		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
@@ -501,6 +790,7 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 			Headers:           endpoint.Headers,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
+			Transport:         transport,
 		})
 
 		sinks = append(sinks, endpoint)
@@ -526,10 +816,53 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 
 	app.events.source = notifications.SourceRecord{
 		Addr:       hostname,
-		InstanceID: dcontext.GetStringValue(app, "instance.id"),
+		InstanceID: dcontext.GetStringValue(app, dcontext.InstanceIDKey),
+	}
+
+	if usage := configuration.Notifications.EventConfig.Usage; usage.Enabled {
+		interval := usage.Interval
+		if interval <= 0 {
+			interval = defaultUsageInterval
+		}
+		dcontext.GetLogger(app).Infof("sending usage events every %s", interval)
+		app.usage = notifications.NewUsageAggregator(app.events.sink, app.events.source, interval)
 	}
 }
 
+// defaultReplayProtectionTTL is how long upload URLs remain valid when replay
+// protection is enabled without a ttl.
+const defaultReplayProtectionTTL = 24 * time.Hour
+
+// configureReplayProtection sets up the nonce store used to reject replayed
+// upload requests. It must be called after configureRedis.
+func (app *App) configureReplayProtection(cfg *configuration.Configuration) {
+	rp := cfg.HTTP.ReplayProtection
+	if !rp.Enabled {
+		return
+	}
+
+	if rp.Cache == "" {
+		rp.Cache = "inmemory"
+	}
+	switch rp.Cache {
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for replay protection")
+		}
+		app.nonces = rediscache.NewRedisNonceStore(app.redis)
+	case "inmemory":
+		app.nonces = memorycache.NewInMemoryNonceStore()
+	default:
+		panic(fmt.Sprintf("unknown replay protection cache %q", rp.Cache))
+	}
+
+	app.nonceTTL = rp.TTL
+	if app.nonceTTL <= 0 {
+		app.nonceTTL = defaultReplayProtectionTTL
+	}
+	dcontext.GetLogger(app).Infof("replay protection enabled for upload URLs, using %s cache", rp.Cache)
+}
+
 func (app *App) configureRedis(cfg *configuration.Configuration) {
 	if len(cfg.Redis.Options.Addrs) == 0 {
 		dcontext.GetLogger(app).Infof("redis not configured")
@@ -580,18 +913,10 @@ func (app *App) configureRedis(cfg *configuration.Configuration) {
 			panic(err)
 		}
 		if len(cfg.Redis.TLS.ClientCAs) != 0 {
-			pool := x509.NewCertPool()
-			for _, ca := range cfg.Redis.TLS.ClientCAs {
-				caPem, err := os.ReadFile(ca)
-				if err != nil {
-					dcontext.GetLogger(app).Errorf("failed reading redis client CA: %v", err)
-					return
-				}
-
-				if ok := pool.AppendCertsFromPEM(caPem); !ok {
-					dcontext.GetLogger(app).Error("could not add CA to pool")
-					return
-				}
+			pool, err := LoadCertPool(cfg.Redis.TLS.ClientCAs)
+			if err != nil {
+				dcontext.GetLogger(app).Errorf("failed reading redis client CA: %v", err)
+				return
 			}
 			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
 			tlsConf.ClientCAs = pool
@@ -714,7 +1039,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 			if context.Errors.Len() > 0 {
 				_ = errcode.ServeJSON(w, context.Errors)
 				app.logError(context, context.Errors)
-			} else if status, ok := context.Value("http.response.status").(int); ok && status >= 200 && status <= 399 {
+			} else if status, ok := context.Value(dcontext.ResponseStatusKey).(int); ok && status >= 200 && status <= 399 {
 				dcontext.GetResponseLogger(context).Infof("response completed")
 			}
 		}()
@@ -725,7 +1050,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		}
 
 		// Add username to request logging
-		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, userNameKey))
+		context.Context = dcontext.WithValueLogger(context.Context, dcontext.UserNameKey)
 
 		// sync up context on the request.
 		r = r.WithContext(context)
@@ -814,10 +1139,10 @@ func (app *App) logError(ctx context.Context, errors errcode.Errors) {
 			c = context.WithValue(c, errMessageKey{}, e.Error())
 		}
 
-		c = dcontext.WithLogger(c, dcontext.GetLogger(c,
+		c = dcontext.WithValueLogger(c,
 			errCodeKey{},
 			errMessageKey{},
-			errDetailKey{}))
+			errDetailKey{})
 		dcontext.GetResponseLogger(c).Errorf("response completed with error")
 	}
 }
@@ -827,11 +1152,11 @@ func (app *App) logError(ctx context.Context, errors errcode.Errors) {
 func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 	ctx := r.Context()
 	ctx = dcontext.WithVars(ctx, r)
-	ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx,
+	ctx = dcontext.WithValueLogger(ctx,
 		"vars.name",
 		"vars.reference",
 		"vars.digest",
-		"vars.uuid"))
+		"vars.uuid")
 
 	context := &Context{
 		App:     app,
@@ -864,7 +1189,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	var accessRecords []auth.Access
 
 	if repo != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
+		accessRecords = appendAccessRecords(accessRecords, accessMethod(r), repo)
 		if fromRepo := r.FormValue("from"); fromRepo != "" {
 			// mounting a blob from one repository to another requires pull (GET)
 			// access to the source repository.
@@ -885,6 +1210,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			return fmt.Errorf("forbidden: no repository name")
 		}
 		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		accessRecords = appendAdminAccessRecord(accessRecords, r)
 	}
 
 	grant, err := app.accessController.Authorized(r.WithContext(context.Context), accessRecords...)
@@ -915,7 +1241,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	ctx := withUser(context.Context, grant.User)
 	ctx = withResources(ctx, grant.Resources)
 
-	dcontext.GetLogger(ctx, userNameKey).Info("authorized request")
+	dcontext.GetLogger(ctx, dcontext.UserNameKey).Info("authorized request")
 	// TODO(stevvooe): This pattern needs to be cleaned up a bit. One context
 	// should be replaced by another, rather than replacing the context on a
 	// mutable object.
@@ -941,7 +1267,7 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameChanges && routeName != v2.RouteNameSpec && !isAdminRoute(routeName)
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -989,12 +1315,23 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 	return records
 }
 
+// accessMethod returns the method whose access requirements apply to the
+// request. Checking the existence of blobs is a POST request, but only
+// requires pull access like the HEAD requests it replaces.
+func accessMethod(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() == v2.RouteNameBlobsExist {
+		return http.MethodGet
+	}
+	return r.Method
+}
+
 // Add the access record for the catalog if it's our current route
 func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	// Reading the change log lists repositories like the catalog does.
+	if routeName == v2.RouteNameCatalog || routeName == v2.RouteNameChanges {
 		resource := auth.Resource{
 			Type: "registry",
 			Name: "catalog",
@@ -1061,11 +1398,12 @@ func badPurgeUploadConfig(reason string) {
 	panic(fmt.Sprintf("Unable to parse upload purge configuration: %s", reason))
 }
 
-// startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+// uploadPurgeJob returns a job which will periodically check upload
+// directories for old files and delete them, or nil if upload purging is
+// disabled. The uploads purged are counted by subject if one of subjects.
+func uploadPurgeJob(storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}, subjects []string) *jobs.Job {
 	if config["enabled"] == false {
-		return
+		return nil
 	}
 
 	var purgeAgeDuration time.Duration
@@ -1111,21 +1449,159 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		badPurgeUploadConfig("dryrun missing")
 	}
 
-	go func() {
-		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	var policies []storage.PurgePolicy
+	if v, ok := config["policies"]; ok {
+		policies = parsePurgePolicies(v, dryRunBool)
+	}
+	defaultPolicy := storage.PurgePolicy{Age: purgeAgeDuration, DryRun: dryRunBool}
+
+	randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		log.Infof("Failed to generate random jitter: %v", err)
+		// sleep 30min for failure case
+		randInt = big.NewInt(30)
+	}
+	jitter := time.Duration(randInt.Int64()%60) * time.Minute
+
+	return &jobs.Job{
+		Name:       "uploadpurging",
+		Schedule:   jobs.Every(intervalDuration),
+		RunAtStart: true,
+		StartDelay: jitter,
+		Run: func(ctx context.Context) error {
+			_, errs := storage.PurgeUploadsWithPolicies(ctx, storageDriver, defaultPolicy, policies, subjects)
+			if len(errs) > 0 {
+				return fmt.Errorf("%d errors purging uploads, first: %v", len(errs), errs[0])
+			}
+			return nil
+		},
+	}
+}
+
+// parsePurgePolicies parses the per-prefix upload purge policies. Policies
+// without a dryrun setting inherit dryRun.
+func parsePurgePolicies(v interface{}, dryRun bool) []storage.PurgePolicy {
+	list, ok := v.([]interface{})
+	if !ok {
+		badPurgeUploadConfig("policies is not a list")
+	}
+
+	var policies []storage.PurgePolicy
+	for _, item := range list {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			badPurgeUploadConfig("policy must contain additional keys")
+		}
+
+		policy := storage.PurgePolicy{DryRun: dryRun}
+		prefix, ok := m["prefix"].(string)
+		if !ok || prefix == "" {
+			badPurgeUploadConfig("policy prefix missing")
+		}
+		policy.Prefix = prefix
+
+		ageStr, ok := m["age"].(string)
+		if !ok {
+			badPurgeUploadConfig(fmt.Sprintf("policy %s: age missing", prefix))
+		}
+		age, err := time.ParseDuration(ageStr)
 		if err != nil {
-			log.Infof("Failed to generate random jitter: %v", err)
-			// sleep 30min for failure case
-			randInt = big.NewInt(30)
+			badPurgeUploadConfig(fmt.Sprintf("policy %s: Cannot parse duration: %s", prefix, err.Error()))
 		}
-		jitter := time.Duration(randInt.Int64()%60) * time.Minute
-		log.Infof("Starting upload purge in %s", jitter)
-		time.Sleep(jitter)
+		policy.Age = age
 
-		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
-			log.Infof("Starting upload purge in %s", intervalDuration)
-			time.Sleep(intervalDuration)
+		if v, ok := m["dryrun"]; ok {
+			policy.DryRun, ok = v.(bool)
+			if !ok {
+				badPurgeUploadConfig(fmt.Sprintf("policy %s: cannot parse dryrun", prefix))
+			}
 		}
-	}()
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// parsePurgeSubjects parses the subjects by which purged uploads are
+// counted.
+func parsePurgeSubjects(v interface{}) []string {
+	list, ok := v.([]interface{})
+	if !ok {
+		badPurgeUploadConfig("subjects is not a list")
+	}
+
+	subjects := make([]string, 0, len(list))
+	for _, item := range list {
+		subject, ok := item.(string)
+		if !ok || subject == "" {
+			badPurgeUploadConfig("subjects must be non-empty strings")
+		}
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+func badUsageConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse usage configuration: %s", reason))
+}
+
+// usageJob returns a job which will periodically compute the storage used by
+// each namespace, export it as metrics and optionally write a report to the
+// storage backend, or nil if usage reporting is disabled. If usage events are
+// enabled, the storage used by each namespace is also sent as usage events.
+func usageJob(registry distribution.Namespace, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}, usage *notifications.UsageAggregator) *jobs.Job {
+	if config["enabled"] != true {
+		return nil
+	}
+
+	intervalDuration := 24 * time.Hour
+	if interval, ok := config["interval"]; ok {
+		intervalStr, ok := interval.(string)
+		if !ok {
+			badUsageConfig("interval is not a string")
+		}
+		var err error
+		intervalDuration, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			badUsageConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
+		}
+	}
+
+	var reportFormat string
+	if report, ok := config["report"]; ok {
+		reportFormat, ok = report.(string)
+		if !ok {
+			badUsageConfig("report is not a string")
+		}
+		switch reportFormat {
+		case "", storage.UsageReportJSON, storage.UsageReportCSV:
+		default:
+			badUsageConfig(fmt.Sprintf("unknown report format %q", reportFormat))
+		}
+	}
+
+	return &jobs.Job{
+		Name:       "usage",
+		Schedule:   jobs.Every(intervalDuration),
+		RunAtStart: true,
+		Run: func(ctx context.Context) error {
+			report, err := storage.ComputeUsage(ctx, registry)
+			if err != nil {
+				return fmt.Errorf("failed to compute storage usage: %v", err)
+			}
+			storage.ExportUsage(report)
+			if usage != nil {
+				for _, ns := range report.Namespaces {
+					if err := usage.Stored(ns.Namespace, ns.UniqueBytes, ns.SharedBytes, report.GeneratedAt); err != nil {
+						log.Errorf("Failed to send usage event for namespace %s: %v", ns.Namespace, err)
+					}
+				}
+			}
+			if reportFormat != "" {
+				if err := storage.WriteUsageReport(ctx, storageDriver, report, reportFormat); err != nil {
+					return fmt.Errorf("failed to write usage report: %v", err)
+				}
+			}
+			return nil
+		},
+	}
 }
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -33,13 +34,15 @@ func TestAppDispatcher(t *testing.T) {
 	app := &App{
 		Config:   &configuration.Configuration{},
 		Context:  ctx,
-		router:   v2.Router(),
+		router:   v2.RouterWithPrefix(""),
 		driver:   driver,
 		registry: registry,
 	}
 	server := httptest.NewServer(app)
 	defer server.Close()
-	router := v2.Router()
+	// The routes are bound to the test server below, so the app has its own
+	// router rather than the one shared by URL builders.
+	router := app.router
 
 	serverURL, err := url.Parse(server.URL)
 	if err != nil {
@@ -275,3 +278,39 @@ func TestAppendAccessRecords(t *testing.T) {
 		t.Fatal("Actual access record differs from expected")
 	}
 }
+
+func TestParsePurgePolicies(t *testing.T) {
+	policies := parsePurgePolicies([]interface{}{
+		map[interface{}]interface{}{"prefix": "ci", "age": "2h"},
+		map[interface{}]interface{}{"prefix": "prod", "age": "168h", "dryrun": false},
+	}, true)
+
+	expected := []storage.PurgePolicy{
+		{Prefix: "ci", Age: 2 * time.Hour, DryRun: true},
+		{Prefix: "prod", Age: 168 * time.Hour, DryRun: false},
+	}
+	if !reflect.DeepEqual(policies, expected) {
+		t.Fatalf("unexpected policies: %v != %v", policies, expected)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for policy without age")
+		}
+	}()
+	parsePurgePolicies([]interface{}{map[interface{}]interface{}{"prefix": "ci"}}, false)
+}
+
+func TestParsePurgeSubjects(t *testing.T) {
+	subjects := parsePurgeSubjects([]interface{}{"ci-runner", "release"})
+	if expected := []string{"ci-runner", "release"}; !reflect.DeepEqual(subjects, expected) {
+		t.Fatalf("unexpected subjects: %v != %v", subjects, expected)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for empty subject")
+		}
+	}()
+	parsePurgeSubjects([]interface{}{""})
+}
@@ -54,6 +54,15 @@ type blobHandler struct {
 // response.
 func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(bh).Debug("GetBlob")
+	if bh.cluster != nil {
+		owner := bh.cluster.owner(bh.Digest)
+		w.Header().Set(blobOwnerHeader, owner)
+		if owner != bh.cluster.self && bh.cluster.redirect && r.Method == http.MethodGet {
+			http.Redirect(w, r, owner+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
 	blobs := bh.Repository.Blobs(bh)
 	desc, err := blobs.Stat(bh, bh.Digest)
 	if err != nil {
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// defaultDeltaMaxSize is the size of the largest blobs served as deltas,
+	// unless configured. The zstd encoder finds fewer matches against larger
+	// dictionaries.
+	defaultDeltaMaxSize = 32 << 20
+
+	// defaultDeltaMaxConcurrent is the number of deltas computed at once,
+	// unless configured.
+	defaultDeltaMaxConcurrent = 4
+
+	// deltaBaseHeader is the response header holding the digest of the blob
+	// a delta was computed against.
+	deltaBaseHeader = "Docker-Distribution-Delta-Base"
+)
+
+// blobDeltaDispatcher uses the request context to build a blobDeltaHandler.
+func blobDeltaDispatcher(ctx *Context, r *http.Request) http.Handler {
+	if ctx.deltas == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnsupported.WithDetail("deltas are disabled"))
+		})
+	}
+
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+	have, err := digest.Parse(r.URL.Query().Get("have"))
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(fmt.Sprintf("invalid have digest: %v", err)))
+		})
+	}
+
+	blobDeltaHandler := &blobDeltaHandler{
+		Context: ctx,
+		Digest:  dgst,
+		Have:    have,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(blobDeltaHandler.GetBlobDelta),
+	}
+}
+
+// blobDeltaHandler serves blobs as deltas against blobs clients already
+// have.
+type blobDeltaHandler struct {
+	*Context
+
+	Digest digest.Digest
+	Have   digest.Digest
+}
+
+// GetBlobDelta serves the blob compressed with zstd using the content of the
+// blob the client has as a raw dictionary, so that a layer rebuilt with small
+// changes is pulled for a fraction of its size. The delta can be decoded with
+// `zstd -d --patch-from`.
+func (bdh *blobDeltaHandler) GetBlobDelta(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(bdh).Debug("GetBlobDelta")
+
+	maxSize := bdh.Config.Deltas.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultDeltaMaxSize
+	}
+
+	blobs := bdh.Repository.Blobs(bdh)
+	for _, dgst := range []digest.Digest{bdh.Have, bdh.Digest} {
+		desc, err := blobs.Stat(bdh, dgst)
+		if err != nil {
+			if err == distribution.ErrBlobUnknown {
+				bdh.Errors = append(bdh.Errors, errcode.ErrorCodeBlobUnknown.WithDetail(dgst))
+			} else {
+				bdh.Errors = append(bdh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
+			return
+		}
+		if desc.Size > maxSize {
+			bdh.Errors = append(bdh.Errors, errcode.ErrorCodeUnsupported.WithDetail(fmt.Sprintf("blob %s is too large to be served as a delta", dgst)))
+			return
+		}
+	}
+
+	select {
+	case bdh.deltas <- struct{}{}:
+		defer func() { <-bdh.deltas }()
+	default:
+		bdh.Errors = append(bdh.Errors, errcode.ErrorCodeTooManyRequests.WithMessage("too many deltas are being computed"))
+		return
+	}
+
+	base, err := blobs.Get(bdh, bdh.Have)
+	if err != nil {
+		bdh.Errors = append(bdh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	target, err := blobs.Get(bdh, bdh.Digest)
+	if err != nil {
+		bdh.Errors = append(bdh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	delta, err := encodeDelta(base, target)
+	if err != nil {
+		bdh.Errors = append(bdh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	dcontext.GetLogger(bdh).Infof("serving blob %s of %d bytes as a delta of %d bytes against %s", bdh.Digest, len(target), len(delta), bdh.Have)
+
+	w.Header().Set("Content-Type", "application/zstd")
+	w.Header().Set("Content-Length", fmt.Sprint(len(delta)))
+	w.Header().Set("Docker-Content-Digest", bdh.Digest.String())
+	w.Header().Set(deltaBaseHeader, bdh.Have.String())
+	if _, err := w.Write(delta); err != nil {
+		dcontext.GetLogger(bdh).Errorf("error writing blob delta: %v", err)
+	}
+}
+
+// encodeDelta compresses target with base as a raw dictionary, with a window
+// covering both, so that any part of target can refer to base.
+func encodeDelta(base, target []byte) ([]byte, error) {
+	window := zstd.MinWindowSize
+	for window < len(base)+len(target) && window < zstd.MaxWindowSize {
+		window <<= 1
+	}
+
+	var buf bytes.Buffer
+	enc, err := zstd.NewWriter(&buf,
+		zstd.WithEncoderDictRaw(0, base),
+		zstd.WithWindowSize(window),
+		zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+		zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := enc.Write(target); err != nil {
+		enc.Close()
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+func TestBlobDelta(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Deltas: configuration.Deltas{
+			Enabled: true,
+			MaxSize: 1 << 20,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	base := make([]byte, 256<<10)
+	if _, err := rand.Read(base); err != nil {
+		t.Fatal(err)
+	}
+	target := append([]byte(nil), base[:100000]...)
+	target = append(target, "rebuilt"...)
+	target = append(target, base[100000:]...)
+	large := make([]byte, 1<<20+1)
+
+	imageName, _ := reference.WithName("foo/bar")
+	baseDigest, targetDigest, largeDigest := digest.FromBytes(base), digest.FromBytes(target), digest.FromBytes(large)
+	repo, err := env.app.registry.Repository(env.ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	for _, content := range [][]byte{base, target, large} {
+		if _, err := repo.Blobs(env.ctx).Put(env.ctx, "application/octet-stream", content); err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+	}
+
+	getDelta := func(dgst, have digest.Digest) *http.Response {
+		ref, _ := reference.WithDigest(imageName, dgst)
+		deltaURL, err := env.builder.BuildBlobDeltaURL(ref, have)
+		if err != nil {
+			t.Fatalf("error building delta url: %v", err)
+		}
+		resp, err := http.Get(deltaURL)
+		if err != nil {
+			t.Fatalf("unexpected error fetching delta: %v", err)
+		}
+		return resp
+	}
+
+	resp := getDelta(targetDigest, baseDigest)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching delta", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{"application/zstd"},
+		"Docker-Content-Digest": []string{targetDigest.String()},
+		deltaBaseHeader:         []string{baseDigest.String()},
+	})
+	delta, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading delta: %v", err)
+	}
+	if len(delta) > len(target)/100 {
+		t.Fatalf("delta of %d bytes is too large for %d byte blob", len(delta), len(target))
+	}
+	dec, err := zstd.NewReader(bytes.NewReader(delta), zstd.WithDecoderDictRaw(0, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	decoded, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatalf("error decoding delta: %v", err)
+	}
+	if !bytes.Equal(decoded, target) {
+		t.Fatal("decoded delta does not match blob")
+	}
+
+	resp = getDelta(targetDigest, digest.FromString("unknown"))
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "fetching delta against unknown blob", resp, errcode.ErrorCodeBlobUnknown)
+
+	resp = getDelta(largeDigest, baseDigest)
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "fetching delta of large blob", resp, errcode.ErrorCodeUnsupported)
+}
+
+func TestBlobDeltaDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	ref, _ := reference.WithDigest(imageName, digest.FromString("target"))
+	deltaURL, err := env.builder.BuildBlobDeltaURL(ref, digest.FromString("base"))
+	if err != nil {
+		t.Fatalf("error building delta url: %v", err)
+	}
+	resp, err := http.Get(deltaURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching delta: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching delta", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "fetching delta", resp, errcode.ErrorCodeUnsupported)
+}
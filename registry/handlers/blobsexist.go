@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// maxBlobsExistDigests is the maximum number of digests which can be
+	// checked with a single request.
+	maxBlobsExistDigests = 1000
+
+	// maxBlobsExistBodySize bounds the size of the request body, leaving
+	// room for the longest digests.
+	maxBlobsExistBodySize = maxBlobsExistDigests * 160
+)
+
+// blobsExistDispatcher uses the request context to build a blobsExistHandler.
+func blobsExistDispatcher(ctx *Context, r *http.Request) http.Handler {
+	blobsExistHandler := &blobsExistHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodPost: http.HandlerFunc(blobsExistHandler.CheckBlobs),
+	}
+}
+
+// blobsExistHandler checks the existence of several blobs in a repository.
+type blobsExistHandler struct {
+	*Context
+}
+
+type blobsExistRequest struct {
+	Digests []digest.Digest `json:"digests"`
+}
+
+type blobExistence struct {
+	Digest digest.Digest `json:"digest"`
+	Exists bool          `json:"exists"`
+	Size   int64         `json:"size,omitempty"`
+}
+
+type blobsExistResponse struct {
+	Blobs []blobExistence `json:"blobs"`
+}
+
+// CheckBlobs reports which of the blobs listed in the request body exist in
+// the repository, saving clients a HEAD request per blob.
+func (beh *blobsExistHandler) CheckBlobs(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(beh).Debug("CheckBlobs")
+
+	var req blobsExistRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBlobsExistBodySize)).Decode(&req); err != nil {
+		beh.Errors = append(beh.Errors, errcode.ErrorCodeUnsupported.WithDetail(fmt.Sprintf("invalid request body: %v", err)))
+		return
+	}
+	if len(req.Digests) > maxBlobsExistDigests {
+		beh.Errors = append(beh.Errors, errcode.ErrorCodeUnsupported.WithDetail(fmt.Sprintf("at most %d digests can be checked", maxBlobsExistDigests)))
+		return
+	}
+	for _, dgst := range req.Digests {
+		if err := dgst.Validate(); err != nil {
+			beh.Errors = append(beh.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(dgst))
+			return
+		}
+	}
+
+	blobs := beh.Repository.Blobs(beh)
+	resp := blobsExistResponse{
+		Blobs: make([]blobExistence, 0, len(req.Digests)),
+	}
+	for _, dgst := range req.Digests {
+		existence := blobExistence{Digest: dgst}
+		desc, err := blobs.Stat(beh, dgst)
+		switch err {
+		case nil:
+			existence.Exists = true
+			existence.Size = desc.Size
+		case distribution.ErrBlobUnknown:
+		default:
+			beh.Errors = append(beh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		resp.Blobs = append(resp.Blobs, existence)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		beh.Errors = append(beh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
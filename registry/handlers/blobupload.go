@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -37,7 +38,9 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 	}
 
 	if buh.UUID != "" {
-		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		// With replay protection, status requests must present a valid
+		// upload url, as they are answered with the same url.
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && ctx.App.nonces == nil {
 			return handler
 		}
 		if h := buh.ResumeBlobUpload(ctx, r); h != nil {
@@ -86,6 +89,10 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 			}
 		} else if err == distribution.ErrUnsupported {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
+		} else if err == distribution.ErrBlobUploadLimitExceeded {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeTooManyRequests.WithMessage(err.Error()))
+		} else if err == distribution.ErrBlobUploadQuotaExceeded {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeInsufficientStorage.WithMessage(err.Error()))
 		} else {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
@@ -167,6 +174,10 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 	}
 
 	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PATCH"); err != nil {
+		if err == distribution.ErrBlobUploadQuotaExceeded {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeInsufficientStorage.WithMessage(err.Error()))
+			return
+		}
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
 		return
 	}
@@ -207,6 +218,10 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 	}
 
 	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
+		if err == distribution.ErrBlobUploadQuotaExceeded {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeInsufficientStorage.WithMessage(err.Error()))
+			return
+		}
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
 		return
 	}
@@ -294,6 +309,18 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 		})
 	}
 
+	// Requests which change the upload consume the nonce; status requests
+	// only check that the url has not expired.
+	status := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if ctx.App.nonces != nil {
+		if err := checkUploadNonce(ctx, ctx.App.nonces, ctx.App.nonceTTL, state, !status); err != nil {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				dcontext.GetLogger(ctx).Warnf("rejected upload url: %v", err)
+				buh.Errors = append(buh.Errors, errcode.ErrorCodeBlobUploadInvalid.WithDetail(err.Error()))
+			})
+		}
+	}
+
 	blobs := ctx.Repository.Blobs(buh)
 	upload, err := blobs.Resume(buh, buh.UUID)
 	if err != nil {
@@ -310,7 +337,9 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 	}
 	buh.Upload = upload
 
-	if size := upload.Size(); size != buh.State.Offset {
+	// The status of an upload whose last request failed part way through
+	// is reported at its actual offset.
+	if size := upload.Size(); size != buh.State.Offset && !status {
 		dcontext.GetLogger(ctx).Errorf("upload resumed at wrong offset: %d != %d", size, buh.State.Offset)
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeRangeInvalid.WithDetail(err))
@@ -329,6 +358,34 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 	buh.Upload.Close()
 	buh.State.Offset = buh.Upload.Size()
 	buh.State.StartedAt = buh.Upload.StartedAt()
+	switch {
+	case buh.App.nonces == nil:
+		buh.State.Nonce, buh.State.Expires = "", 0
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		// Status requests do not claim the nonce, and keep its expiry, so
+		// that asking for the status of an upload does not extend the life
+		// of its url. The nonce of a request which failed part way through
+		// was claimed already, so the url returned to resume the upload
+		// carries a fresh one.
+		claimed, err := buh.App.nonces.Claimed(buh, buh.State.Nonce)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			nonce, err := newUploadNonce()
+			if err != nil {
+				return err
+			}
+			buh.State.Nonce = nonce
+		}
+	default:
+		nonce, err := newUploadNonce()
+		if err != nil {
+			return err
+		}
+		buh.State.Nonce = nonce
+		buh.State.Expires = time.Now().Add(buh.App.nonceTTL).Unix()
+	}
 
 	token, err := hmacKey(buh.Config.HTTP.Secret).packUploadState(buh.State)
 	if err != nil {
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/uuid"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// defaultChangesRetention is how long changes are kept in the change log,
+// unless configured.
+const defaultChangesRetention = 7 * 24 * time.Hour
+
+// changesDispatcher returns a handler reading the change log if it is
+// enabled.
+func changesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	if !ctx.Config.Catalog.Changes.Enabled {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnsupported.WithDetail("the change log is disabled"))
+		})
+	}
+
+	changesHandler := &changesHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(changesHandler.GetChanges),
+	}
+}
+
+// changesHandler lists the changes to the repositories of the registry.
+type changesHandler struct {
+	*Context
+}
+
+type changedRepository struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+type changesAPIResponse struct {
+	Changes      []storage.Change    `json:"changes"`
+	Repositories []changedRepository `json:"repositories"`
+	Cursor       string              `json:"cursor"`
+}
+
+// GetChanges returns the changes recorded after the since cursor or time,
+// along with the repositories and tags they changed, and the cursor to read
+// the changes which follow.
+func (ch *changesHandler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	cursor := q.Get("since")
+	if cursor != "" {
+		if _, ok := uuid.ULIDTime(cursor); !ok {
+			since, err := time.Parse(time.RFC3339Nano, cursor)
+			if err != nil {
+				ch.Errors = append(ch.Errors, errcode.ErrorCodeCursorInvalid.WithDetail(map[string]string{"since": cursor}))
+				return
+			}
+			cursor = uuid.ULIDAt(since)
+		}
+	}
+
+	entries := defaultReturnedEntries
+	maximumConfiguredEntries := ch.App.Config.Catalog.MaxEntries
+	if n := q.Get("n"); n != "" {
+		parsedMax, err := strconv.Atoi(n)
+		if err != nil || parsedMax <= 0 || parsedMax > maximumConfiguredEntries {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+			return
+		}
+		entries = parsedMax
+	}
+	if entries > maximumConfiguredEntries {
+		entries = maximumConfiguredEntries
+	}
+
+	changes, err := storage.ReadChanges(ch, ch.App.driver, cursor, entries)
+	if err != nil {
+		switch err {
+		case storage.ErrChangesCursorInvalid:
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeCursorInvalid.WithDetail(map[string]string{"since": cursor}))
+		case storage.ErrChangesCursorExpired:
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeCursorExpired.WithDetail(map[string]string{"since": cursor}))
+		default:
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+	if changes == nil {
+		changes = []storage.Change{}
+	}
+	if len(changes) > 0 {
+		cursor = changes[len(changes)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Add a link header if more changes may follow
+	if len(changes) == entries {
+		urlStr, err := createChangesLinkEntry(r.URL.String(), entries, cursor)
+		if err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		w.Header().Set("Link", urlStr)
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(changesAPIResponse{
+		Changes:      changes,
+		Repositories: changedRepositories(changes),
+		Cursor:       cursor,
+	}); err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// changedRepositories returns the repositories changed by changes, sorted by
+// name, with the tags pushed or deleted in each of them.
+func changedRepositories(changes []storage.Change) []changedRepository {
+	tags := make(map[string]map[string]bool)
+	for _, change := range changes {
+		if tags[change.Repository] == nil {
+			tags[change.Repository] = make(map[string]bool)
+		}
+		if change.Tag != "" {
+			tags[change.Repository][change.Tag] = true
+		}
+	}
+
+	repositories := make([]changedRepository, 0, len(tags))
+	for name, changed := range tags {
+		repository := changedRepository{Name: name}
+		for tag := range changed {
+			repository.Tags = append(repository.Tags, tag)
+		}
+		sort.Strings(repository.Tags)
+		repositories = append(repositories, repository)
+	}
+	sort.Slice(repositories, func(i, j int) bool {
+		return repositories[i].Name < repositories[j].Name
+	})
+	return repositories
+}
+
+// createChangesLinkEntry uses the original URL from the request to create
+// the link to the changes following cursor.
+func createChangesLinkEntry(origURL string, maxEntries int, cursor string) (string, error) {
+	calledURL, err := url.Parse(origURL)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Add("n", strconv.Itoa(maxEntries))
+	v.Add("since", cursor)
+
+	calledURL.RawQuery = v.Encode()
+
+	calledURL.Fragment = ""
+	urlStr := fmt.Sprintf("<%s>; rel=\"next\"", calledURL.String())
+
+	return urlStr, nil
+}
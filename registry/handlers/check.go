@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/reference"
+)
+
+// defaultCheckTimeout bounds each connectivity probe of the startup check.
+const defaultCheckTimeout = 10 * time.Second
+
+// CheckResult is the outcome of checking a component of the registry.
+type CheckResult struct {
+	// Component is the kind of component checked, such as "storage" or
+	// "notifications".
+	Component string `json:"component"`
+	// Name identifies the component among those of its kind, such as the
+	// name of a notification endpoint.
+	Name string `json:"name,omitempty"`
+	OK   bool   `json:"ok"`
+	// Error describes why the check failed.
+	Error string `json:"error,omitempty"`
+	// Duration is how long the check took, such as "1.5ms".
+	Duration string `json:"duration"`
+}
+
+// CheckReport lists the results of the startup check.
+type CheckReport struct {
+	Results []CheckResult `json:"results"`
+}
+
+// Failed returns the number of checks which failed.
+func (r *CheckReport) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if !result.OK {
+			failed++
+		}
+	}
+	return failed
+}
+
+// run runs a check of a component, recovering from the panics with which
+// the registry reports invalid configurations.
+func (r *CheckReport) run(component, name string, check func() error) {
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("%v", p)
+			}
+		}()
+		return check()
+	}()
+
+	result := CheckResult{
+		Component: component,
+		Name:      name,
+		OK:        err == nil,
+		Duration:  time.Since(start).String(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	r.Results = append(r.Results, result)
+}
+
+// Check initializes the storage driver and middlewares, caches, access
+// controller, TLS certificates and notification sinks of config and probes
+// the services they connect to, without serving requests or running the
+// maintenance jobs. Each probe is bounded by timeout, or a default timeout if
+// zero. Check reports the outcome of each check rather than failing at the
+// first one.
+func Check(ctx context.Context, config *configuration.Configuration, timeout time.Duration) *CheckReport {
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	probe := func(check func(ctx context.Context) error) func() error {
+		return func() error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return check(ctx)
+		}
+	}
+	report := &CheckReport{}
+
+	var driver storagedriver.StorageDriver
+	report.run("storage", config.Storage.Type(), probe(func(ctx context.Context) error {
+		d, err := factory.Create(ctx, config.Storage.Type(), storageParameters(config))
+		if err != nil {
+			return err
+		}
+		if _, err := d.Stat(ctx, "/"); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		driver = d
+		return nil
+	}))
+
+	if driver != nil {
+		for _, mw := range config.Middleware["storage"] {
+			report.run("middleware", "storage/"+mw.Name, func() error {
+				_, err := applyStorageMiddleware(ctx, driver, []configuration.Middleware{mw})
+				return err
+			})
+		}
+	}
+
+	app := &App{Context: ctx, Config: config}
+	if len(config.Redis.Options.Addrs) > 0 {
+		report.run("redis", strings.Join(config.Redis.Options.Addrs, ","), probe(func(ctx context.Context) error {
+			app.configureRedis(config)
+			if app.redis == nil {
+				return fmt.Errorf("unable to configure redis client")
+			}
+			return app.redis.Ping(ctx).Err()
+		}))
+	}
+
+	var cacheOptions []storage.RegistryOption
+	if _, ok := config.Storage["cache"]; ok {
+		report.run("cache", blobDescriptorCache(config), func() error {
+			cacheOptions = app.cacheOptions(config)
+			return nil
+		})
+	}
+
+	// The registry is built as by NewApp, so that its options are checked
+	// and its middlewares wrap the same registry.
+	var registry distribution.Namespace
+	if driver != nil {
+		report.run("registry", config.Storage.Type(), func() error {
+			options := append(app.registryOptions(config), cacheOptions...)
+			r, err := storage.NewRegistry(ctx, driver, options...)
+			if err != nil {
+				return err
+			}
+			registry = r
+			return nil
+		})
+	}
+	if registry != nil {
+		for _, mw := range config.Middleware["registry"] {
+			report.run("middleware", "registry/"+mw.Name, func() error {
+				_, err := applyRegistryMiddleware(ctx, registry, driver, []configuration.Middleware{mw})
+				return err
+			})
+		}
+		for _, mw := range config.Middleware["repository"] {
+			report.run("middleware", "repository/"+mw.Name, func() error {
+				name, _ := reference.WithName("check")
+				repository, err := registry.Repository(ctx, name)
+				if err != nil {
+					return err
+				}
+				_, err = applyRepoMiddleware(ctx, repository, []configuration.Middleware{mw})
+				return err
+			})
+		}
+	}
+
+	if authType := config.Auth.Type(); authType != "" && !strings.EqualFold(authType, "none") {
+		report.run("auth", authType, func() error {
+			_, err := auth.GetAccessController(authType, config.Auth.Parameters())
+			return err
+		})
+	}
+
+	if config.HTTP.TLS.Certificate != "" {
+		report.run("tls", config.HTTP.TLS.Certificate, func() error {
+			if _, err := tls.LoadX509KeyPair(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key); err != nil {
+				return err
+			}
+			_, err := LoadCertPool(config.HTTP.TLS.ClientCAs)
+			return err
+		})
+	}
+
+	for _, endpoint := range config.Notifications.Endpoints {
+		if endpoint.Disabled {
+			continue
+		}
+		report.run("notifications", endpoint.Name, probe(func(ctx context.Context) error {
+			return pingEndpoint(ctx, endpoint)
+		}))
+	}
+
+	if config.Proxy.RemoteURL != "" {
+		report.run("proxy", config.Proxy.RemoteURL, probe(func(ctx context.Context) error {
+			return proxy.Ping(ctx, config.Proxy.RemoteURL, config.Proxy.Transport)
+		}))
+	}
+	for _, peer := range config.Federation.Peers {
+		report.run("federation", peer.URL, probe(func(ctx context.Context) error {
+			return proxy.Ping(ctx, peer.URL, peer.Transport)
+		}))
+	}
+
+	return report
+}
+
+// pingEndpoint checks that a notification endpoint answers a HEAD request,
+// whatever the status of the response, through the transport events are
+// sent with.
+func pingEndpoint(ctx context.Context, endpoint configuration.Endpoint) error {
+	transport, err := endpointTransport(endpoint)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{}
+	if transport != nil {
+		client.Transport = transport
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint.URL, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range endpoint.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
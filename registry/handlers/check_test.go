@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestCheck(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer sink.Close()
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer remote.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	newConfig := func() *configuration.Configuration {
+		config := &configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": configuration.Parameters{},
+				"cache":    configuration.Parameters{"blobdescriptor": "inmemory"},
+			},
+			Auth: configuration.Auth{
+				"silly": configuration.Parameters{"realm": "realm-test", "service": "service-test"},
+			},
+		}
+		config.Notifications.Endpoints = []configuration.Endpoint{
+			{Name: "sink", URL: sink.URL},
+			{Name: "disabled", URL: down.URL, Disabled: true},
+		}
+		config.Proxy.RemoteURL = remote.URL
+		return config
+	}
+
+	report := Check(context.Background(), newConfig(), time.Second)
+	if failed := report.Failed(); failed != 0 {
+		t.Fatalf("expected no failed check, got %d: %+v", failed, report.Results)
+	}
+	checked := make(map[string]string)
+	for _, result := range report.Results {
+		checked[result.Component] = result.Name
+	}
+	for component, name := range map[string]string{
+		"storage":       "inmemory",
+		"cache":         "inmemory",
+		"registry":      "inmemory",
+		"auth":          "silly",
+		"notifications": "sink",
+		"proxy":         remote.URL,
+	} {
+		if checked[component] != name {
+			t.Errorf("expected %s %s to be checked, got %q", component, name, checked[component])
+		}
+	}
+	if len(report.Results) != 6 {
+		t.Errorf("expected 6 checks, got %+v", report.Results)
+	}
+
+	config := newConfig()
+	config.Storage["cache"] = configuration.Parameters{"blobdescriptor": "redis"}
+	config.Auth = configuration.Auth{"silly": configuration.Parameters{}}
+	config.HTTP.TLS.Certificate = "/nonexistent/cert.pem"
+	config.HTTP.TLS.Key = "/nonexistent/key.pem"
+	config.Notifications.Endpoints[0].URL = down.URL
+	config.Proxy.RemoteURL = sink.URL
+	config.Validation.Manifests.URLs.Allow = []string{"("}
+
+	report = Check(context.Background(), config, time.Second)
+	failed := make(map[string]bool)
+	for _, result := range report.Results {
+		if !result.OK {
+			if result.Error == "" {
+				t.Errorf("expected error of failed %s check", result.Component)
+			}
+			failed[result.Component] = true
+		}
+	}
+	for _, component := range []string{"cache", "registry", "auth", "tls", "notifications", "proxy"} {
+		if !failed[component] {
+			t.Errorf("expected %s check to fail, got %+v", component, report.Results)
+		}
+	}
+	if failed["storage"] {
+		t.Errorf("unexpected failure of storage check: %+v", report.Results)
+	}
+	if report.Failed() != 6 {
+		t.Errorf("expected 6 failed checks, got %d", report.Failed())
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// blobOwnerHeader names the member of the cluster owning a blob in the
+// responses to blob pulls.
+const blobOwnerHeader = "Docker-Distribution-Blob-Owner"
+
+// defaultClusterReplicas is the number of points of each member on the hash
+// ring, unless configured.
+const defaultClusterReplicas = 100
+
+// clusterRing assigns each blob to a member of the cluster by consistent
+// hashing of its digest, so that a fleet of mirrors caches a single copy of
+// each blob and adding or removing a member only moves a share of the blobs.
+type clusterRing struct {
+	self     string
+	redirect bool
+	points   []uint64
+	owners   map[uint64]string
+}
+
+// newClusterRing validates the cluster configuration and builds the ring of
+// its members.
+func newClusterRing(config configuration.Cluster) (*clusterRing, error) {
+	replicas := config.Replicas
+	if replicas == 0 {
+		replicas = defaultClusterReplicas
+	}
+	if replicas < 0 {
+		return nil, fmt.Errorf("cluster: replicas must not be negative")
+	}
+
+	cr := &clusterRing{
+		redirect: config.Redirect,
+		owners:   make(map[uint64]string),
+	}
+	for i, member := range append([]string{config.Self}, config.Peers...) {
+		u, err := url.Parse(member)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("cluster: invalid member url %q", member)
+		}
+		member = strings.TrimSuffix(member, "/")
+		if i == 0 {
+			cr.self = member
+		}
+
+		for r := 0; r < replicas; r++ {
+			point := clusterHash(member + "#" + strconv.Itoa(r))
+			if _, ok := cr.owners[point]; ok {
+				continue
+			}
+			cr.owners[point] = member
+			cr.points = append(cr.points, point)
+		}
+	}
+	sort.Slice(cr.points, func(i, j int) bool { return cr.points[i] < cr.points[j] })
+
+	return cr, nil
+}
+
+// owner returns the URL of the member owning the blob.
+func (cr *clusterRing) owner(dgst digest.Digest) string {
+	h := clusterHash(dgst.String())
+	i := sort.Search(len(cr.points), func(i int) bool { return cr.points[i] >= h })
+	if i == len(cr.points) {
+		i = 0
+	}
+	return cr.owners[cr.points[i]]
+}
+
+func clusterHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
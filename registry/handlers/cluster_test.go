@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestClusterRing(t *testing.T) {
+	members := []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"}
+	ring, err := newClusterRing(configuration.Cluster{Self: members[0], Peers: members[1:]})
+	if err != nil {
+		t.Fatalf("unexpected error creating ring: %v", err)
+	}
+	grown, err := newClusterRing(configuration.Cluster{Self: members[0], Peers: append(members[1:], "https://d.example.com/")})
+	if err != nil {
+		t.Fatalf("unexpected error creating ring: %v", err)
+	}
+
+	owned := make(map[string]int)
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		dgst := digest.FromString(strconv.Itoa(i))
+		owner := ring.owner(dgst)
+		if owner != ring.owner(dgst) {
+			t.Fatalf("owner of %s is not stable", dgst)
+		}
+		owned[owner]++
+		if grown := grown.owner(dgst); grown != owner {
+			if grown != "https://d.example.com" {
+				t.Fatalf("blob %s moved between existing members: %s -> %s", dgst, owner, grown)
+			}
+			moved++
+		}
+	}
+
+	for _, member := range members {
+		if owned[member] < 500 {
+			t.Errorf("member %s owns too few blobs: %d", member, owned[member])
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Errorf("unexpected number of blobs moved to the new member: %d", moved)
+	}
+
+	for _, config := range []configuration.Cluster{
+		{Peers: members[1:]},
+		{Self: "a.example.com"},
+		{Self: members[0], Replicas: -1},
+	} {
+		if _, err := newClusterRing(config); err == nil {
+			t.Errorf("expected error for cluster configuration %+v", config)
+		}
+	}
+}
+
+func TestClusterBlobRedirect(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Cluster: configuration.Cluster{
+			Self:     "http://self.example.com",
+			Peers:    []string{"http://peer.example.com"},
+			Redirect: true,
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	var self, peer digest.Digest
+	for i := 0; self == "" || peer == ""; i++ {
+		dgst := digest.FromString(strconv.Itoa(i))
+		if env.app.cluster.owner(dgst) == "http://self.example.com" {
+			self = dgst
+		} else {
+			peer = dgst
+		}
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	imageName, _ := reference.WithName("foo/bar")
+	get := func(dgst digest.Digest) *http.Response {
+		ref, _ := reference.WithDigest(imageName, dgst)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		if err != nil {
+			t.Fatalf("error building blob url: %v", err)
+		}
+		resp, err := client.Get(blobURL)
+		if err != nil {
+			t.Fatalf("unexpected error fetching blob: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get(peer)
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("unexpected status fetching blob owned by peer: %d", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); !strings.HasPrefix(location, "http://peer.example.com/v2/foo/bar/blobs/"+peer.String()) {
+		t.Fatalf("unexpected redirect location: %s", location)
+	}
+	if owner := resp.Header.Get(blobOwnerHeader); owner != "http://peer.example.com" {
+		t.Fatalf("unexpected blob owner: %s", owner)
+	}
+
+	resp = get(self)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status fetching blob owned by self: %d", resp.StatusCode)
+	}
+	if owner := resp.Header.Get(blobOwnerHeader); owner != "http://self.example.com" {
+		t.Fatalf("unexpected blob owner: %s", owner)
+	}
+}
@@ -47,17 +47,17 @@ func (ctx *Context) Value(key interface{}) interface{} {
 }
 
 func getName(ctx context.Context) (name string) {
-	return dcontext.GetStringValue(ctx, "vars.name")
+	return dcontext.GetVars(ctx)["name"]
 }
 
 func getReference(ctx context.Context) (reference string) {
-	return dcontext.GetStringValue(ctx, "vars.reference")
+	return dcontext.GetVars(ctx)["reference"]
 }
 
 var errDigestNotAvailable = fmt.Errorf("digest not available in context")
 
 func getDigest(ctx context.Context) (dgst digest.Digest, err error) {
-	dgstStr := dcontext.GetStringValue(ctx, "vars.digest")
+	dgstStr := dcontext.GetVars(ctx)["digest"]
 
 	if dgstStr == "" {
 		dcontext.GetLogger(ctx).Errorf("digest not available")
@@ -74,23 +74,13 @@ func getDigest(ctx context.Context) (dgst digest.Digest, err error) {
 }
 
 func getUploadUUID(ctx context.Context) (uuid string) {
-	return dcontext.GetStringValue(ctx, "vars.uuid")
+	return dcontext.GetVars(ctx)["uuid"]
 }
 
-const (
-	// userKey is used to get the user object from
-	// a user context
-	userKey = "auth.user"
-
-	// userNameKey is used to get the user name from
-	// a user context
-	userNameKey = "auth.user.name"
-)
-
 // getUserName attempts to resolve a username from the context and request. If
 // a username cannot be resolved, the empty string is returned.
 func getUserName(ctx context.Context, r *http.Request) string {
-	username := dcontext.GetStringValue(ctx, userNameKey)
+	username := dcontext.GetUserName(ctx)
 
 	// Fallback to request user with basic auth
 	if username == "" {
@@ -106,26 +96,7 @@ func getUserName(ctx context.Context, r *http.Request) string {
 
 // withUser returns a context with the authorized user info.
 func withUser(ctx context.Context, user auth.UserInfo) context.Context {
-	return userInfoContext{
-		Context: ctx,
-		user:    user,
-	}
-}
-
-type userInfoContext struct {
-	context.Context
-	user auth.UserInfo
-}
-
-func (uic userInfoContext) Value(key interface{}) interface{} {
-	switch key {
-	case userKey:
-		return uic.user
-	case userNameKey:
-		return uic.user.Name
-	}
-
-	return uic.Context.Value(key)
+	return dcontext.WithUser(ctx, user, user.Name)
 }
 
 // withResources returns a context with the authorized resources.
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 )
 
 // blobUploadState captures the state serializable state of the blob upload.
@@ -22,11 +26,23 @@ type blobUploadState struct {
 
 	// StartedAt is the original start time of the upload.
 	StartedAt time.Time
+
+	// Nonce makes the token single use when replay protection is enabled.
+	Nonce string `json:",omitempty"`
+
+	// Expires is the unix time after which a token carrying a nonce is no
+	// longer accepted.
+	Expires int64 `json:",omitempty"`
 }
 
 type hmacKey string
 
-var errInvalidSecret = fmt.Errorf("invalid secret")
+var (
+	errInvalidSecret = fmt.Errorf("invalid secret")
+	errNonceMissing  = fmt.Errorf("upload url has no nonce")
+	errNonceExpired  = fmt.Errorf("upload url has expired")
+	errNonceReplayed = fmt.Errorf("upload url has already been used")
+)
 
 // unpackUploadState unpacks and validates the blob upload state from the
 // token, using the hmacKey secret.
@@ -72,3 +88,34 @@ func (secret hmacKey) packUploadState(lus blobUploadState) (string, error) {
 
 	return base64.URLEncoding.EncodeToString(append(mac.Sum(nil), p...)), nil
 }
+
+// newUploadNonce returns a random nonce for an upload state token.
+func newUploadNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// checkUploadNonce validates the nonce of an upload state token. The nonce is
+// claimed if claim is true, so that the token cannot be used again.
+func checkUploadNonce(ctx context.Context, nonces cache.NonceStore, ttl time.Duration, state blobUploadState, claim bool) error {
+	if state.Nonce == "" {
+		return errNonceMissing
+	}
+	if time.Now().Unix() > state.Expires {
+		return errNonceExpired
+	}
+	if !claim {
+		return nil
+	}
+	claimed, err := nonces.Claim(ctx, state.Nonce, ttl)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errNonceReplayed
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/uuid"
+	"github.com/distribution/distribution/v3/registry/jobs"
+	"github.com/distribution/distribution/v3/registry/storage"
+)
+
+// defaultGCSchedule is when scheduled garbage collection runs, unless
+// configured.
+const defaultGCSchedule = "@daily"
+
+// defaultChangesPruneSchedule is when changes past their retention are
+// removed from the change log, unless configured.
+const defaultChangesPruneSchedule = "@hourly"
+
+// configureJobs schedules the maintenance jobs, electing the replica which
+// runs them through the configured lock service. It must be called after
+// configureRedis.
+func (app *App) configureJobs(config *configuration.Configuration, maintenanceJobs []jobs.Job) {
+	if config.Jobs.GC.Enabled {
+		// Blobs pushed while garbage collection runs are not referenced by
+		// a manifest yet, and would be deleted.
+		if !app.readOnly && !config.Jobs.GC.DryRun {
+			panic("scheduled garbage collection requires the registry to be in readonly mode, or dryrun")
+		}
+		maintenanceJobs = append(maintenanceJobs, app.gcJob(config))
+	}
+	if config.Catalog.Changes.Enabled {
+		maintenanceJobs = append(maintenanceJobs, app.changesPruneJob(config))
+	}
+
+	known := make(map[string]bool)
+	for i, job := range maintenanceJobs {
+		known[job.Name] = true
+		spec, ok := config.Jobs.Schedules[job.Name]
+		if !ok {
+			continue
+		}
+		schedule, err := jobs.ParseSchedule(spec)
+		if err != nil {
+			panic(fmt.Sprintf("invalid schedule for job %s: %v", job.Name, err))
+		}
+		maintenanceJobs[i].Schedule = schedule
+		maintenanceJobs[i].RunAtStart = false
+	}
+	for name := range config.Jobs.Schedules {
+		if !known[name] {
+			dcontext.GetLogger(app).Warnf("ignoring schedule of job %s, which is unknown or disabled", name)
+		}
+	}
+	if len(maintenanceJobs) == 0 {
+		return
+	}
+
+	var locker jobs.Locker
+	lock := config.Jobs.Lock
+	switch lock {
+	case "", "inmemory":
+		lock = "inmemory"
+		locker = jobs.NewInMemoryLocker()
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for jobs lock")
+		}
+		locker = jobs.NewRedisLocker(app.redis)
+	default:
+		panic(fmt.Sprintf("unknown jobs lock %q", lock))
+	}
+
+	owner := dcontext.GetStringValue(app, dcontext.InstanceIDKey)
+	if owner == "" {
+		owner = uuid.NewString()
+	}
+	app.scheduler = jobs.NewScheduler(locker, owner, config.Jobs.LockTTL)
+	for _, job := range maintenanceJobs {
+		app.scheduler.Add(job)
+	}
+	dcontext.GetLogger(app).Infof("scheduling jobs %v with %s lock", app.scheduler.Jobs(), lock)
+	app.scheduler.Start(app)
+}
+
+// gcJob returns a job garbage collecting the registry on the configured
+// schedule.
+func (app *App) gcJob(config *configuration.Configuration) jobs.Job {
+	schedule, err := jobs.ParseSchedule(defaultGCSchedule)
+	if err != nil {
+		panic(err)
+	}
+	opts := storage.GCOpts{
+		DryRun:         config.Jobs.GC.DryRun,
+		RemoveUntagged: config.Jobs.GC.RemoveUntagged,
+		Quiet:          true,
+		WORM:           config.Storage.WORM(),
+	}
+	return jobs.Job{
+		Name:     "gc",
+		Schedule: schedule,
+		Run: func(ctx context.Context) error {
+			// The registry of the app is wrapped by its caches and
+			// middlewares, which the collection bypasses.
+			registry, err := storage.NewRegistry(ctx, app.driver, StorageOptions(config)...)
+			if err != nil {
+				return err
+			}
+			return storage.MarkAndSweep(ctx, app.driver, registry, opts)
+		},
+	}
+}
+
+// changesPruneJob returns a job removing the changes older than the
+// configured retention from the change log.
+func (app *App) changesPruneJob(config *configuration.Configuration) jobs.Job {
+	schedule, err := jobs.ParseSchedule(defaultChangesPruneSchedule)
+	if err != nil {
+		panic(err)
+	}
+	retention := config.Catalog.Changes.Retention
+	if retention <= 0 {
+		retention = defaultChangesRetention
+	}
+	return jobs.Job{
+		Name:     "changes-prune",
+		Schedule: schedule,
+		Run: func(ctx context.Context) error {
+			return storage.PruneChanges(ctx, app.driver, time.Now().Add(-retention))
+		},
+	}
+}
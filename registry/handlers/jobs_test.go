@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+func TestConfigureJobs(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled":  true,
+				"age":      "168h",
+				"interval": "24h",
+				"dryrun":   true,
+			}},
+		},
+		Jobs: configuration.Jobs{
+			Schedules: map[string]string{
+				"gc":            "0 3 * * *",
+				"uploadpurging": "@every 12h",
+			},
+			GC: configuration.GCJob{Enabled: true, DryRun: true},
+		},
+	}
+	app := NewApp(dcontext.Background(), &config)
+	defer app.Shutdown()
+
+	if app.scheduler == nil {
+		t.Fatal("jobs are not scheduled")
+	}
+	if jobs := app.scheduler.Jobs(); !reflect.DeepEqual(jobs, []string{"uploadpurging", "gc"}) {
+		t.Fatalf("unexpected jobs: %v", jobs)
+	}
+	if !app.scheduler.IsLeader() {
+		t.Fatal("single replica is not the leader")
+	}
+
+	config.Storage["maintenance"]["readonly"] = map[interface{}]interface{}{"enabled": true}
+	config.Jobs.GC.DryRun = false
+	NewApp(dcontext.Background(), &config).Shutdown()
+
+	func() {
+		config.Storage["maintenance"]["readonly"] = map[interface{}]interface{}{"enabled": false}
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic on garbage collection of writable registry")
+			}
+		}()
+		NewApp(dcontext.Background(), &config)
+	}()
+
+	config.Jobs.GC.DryRun = true
+	config.Jobs.Schedules["gc"] = "0 3 * *"
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on invalid schedule")
+		}
+	}()
+	NewApp(dcontext.Background(), &config)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// manifestLimits are the limits on a manifest put to a repository. Zero
+// means no limit.
+type manifestLimits struct {
+	maxBytes     int64
+	maxLayers    int
+	maxImageSize int64
+}
+
+// manifestLimitOverride is the parsed form of a
+// configuration.ManifestPolicyOverride.
+type manifestLimitOverride struct {
+	names  *regexp.Regexp
+	limits manifestLimits
+}
+
+// manifestPolicy holds the manifest limits applied to every repository and
+// the overrides for some repositories.
+type manifestPolicy struct {
+	defaults  manifestLimits
+	overrides []manifestLimitOverride
+}
+
+// parseManifestPolicy validates the configured manifest limits.
+func parseManifestPolicy(config configuration.ManifestPolicy) (manifestPolicy, error) {
+	if config.MaxBytes < 0 || config.MaxLayers < 0 || config.MaxImageSize < 0 {
+		return manifestPolicy{}, fmt.Errorf("policy.manifests: limits must not be negative")
+	}
+
+	policy := manifestPolicy{
+		defaults: manifestLimits{
+			maxBytes:     config.MaxBytes,
+			maxLayers:    config.MaxLayers,
+			maxImageSize: config.MaxImageSize,
+		},
+	}
+	for i, c := range config.Repositories {
+		if len(c.Names) == 0 {
+			return manifestPolicy{}, fmt.Errorf("policy.manifests.repositories[%d]: names must be set", i)
+		}
+		re, err := regexp.Compile("^(?:" + strings.Join(c.Names, "|") + ")$")
+		if err != nil {
+			return manifestPolicy{}, fmt.Errorf("policy.manifests.repositories[%d]: %v", i, err)
+		}
+		policy.overrides = append(policy.overrides, manifestLimitOverride{
+			names: re,
+			limits: manifestLimits{
+				maxBytes:     c.MaxBytes,
+				maxLayers:    c.MaxLayers,
+				maxImageSize: c.MaxImageSize,
+			},
+		})
+	}
+	return policy, nil
+}
+
+// forRepository returns the limits applied to the named repository.
+func (p manifestPolicy) forRepository(name string) manifestLimits {
+	limits := p.defaults
+	for _, o := range p.overrides {
+		if !o.names.MatchString(name) {
+			continue
+		}
+		limits.maxBytes = overrideLimit(limits.maxBytes, o.limits.maxBytes)
+		limits.maxLayers = int(overrideLimit(int64(limits.maxLayers), int64(o.limits.maxLayers)))
+		limits.maxImageSize = overrideLimit(limits.maxImageSize, o.limits.maxImageSize)
+		break
+	}
+	return limits
+}
+
+// overrideLimit applies an override to a limit: zero inherits the limit and
+// a negative override disables it.
+func overrideLimit(limit, override int64) int64 {
+	switch {
+	case override < 0:
+		return 0
+	case override > 0:
+		return override
+	}
+	return limit
+}
+
+// manifestLimitDetail is the detail of the error returned when a manifest
+// exceeds a limit.
+type manifestLimitDetail struct {
+	Limit string `json:"limit"`
+	Max   int64  `json:"max"`
+	Size  int64  `json:"size,omitempty"`
+}
+
+func manifestLimitExceeded(limit string, max, size int64) error {
+	return errcode.ErrorCodeManifestInvalid.WithMessage(fmt.Sprintf("manifest exceeds the %s limit of %d", limit, max)).
+		WithDetail(manifestLimitDetail{Limit: limit, Max: max, Size: size})
+}
+
+// errManifestTooLarge returns the error returned when a manifest payload is
+// larger than maxBytes. size is zero if the size of the payload is unknown.
+func errManifestTooLarge(max, size int64) error {
+	return manifestLimitExceeded("maxbytes", max, size)
+}
+
+// check returns an error if the manifest exceeds the layer count or image
+// size limits. The limits apply to image manifests only.
+func (l manifestLimits) check(manifest distribution.Manifest) error {
+	if l.maxLayers == 0 && l.maxImageSize == 0 {
+		return nil
+	}
+
+	var (
+		config distribution.Descriptor
+		layers []distribution.Descriptor
+	)
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		config, layers = m.Config, m.Layers
+	case *ocischema.DeserializedManifest:
+		config, layers = m.Config, m.Layers
+	default:
+		return nil
+	}
+
+	if l.maxLayers > 0 && len(layers) > l.maxLayers {
+		return manifestLimitExceeded("maxlayers", int64(l.maxLayers), int64(len(layers)))
+	}
+	if l.maxImageSize > 0 {
+		size := config.Size
+		for _, layer := range layers {
+			size += layer.Size
+		}
+		if size > l.maxImageSize {
+			return manifestLimitExceeded("maximagesize", l.maxImageSize, size)
+		}
+	}
+	return nil
+}
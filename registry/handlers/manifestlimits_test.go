@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestParseManifestPolicy(t *testing.T) {
+	for _, config := range []configuration.ManifestPolicy{
+		{MaxLayers: -1},
+		{Repositories: []configuration.ManifestPolicyOverride{{MaxLayers: 1}}},
+		{Repositories: []configuration.ManifestPolicyOverride{{Names: []string{"("}}}},
+	} {
+		if _, err := parseManifestPolicy(config); err == nil {
+			t.Errorf("expected error parsing %+v", config)
+		}
+	}
+
+	policy, err := parseManifestPolicy(configuration.ManifestPolicy{
+		MaxBytes:     1024,
+		MaxLayers:    10,
+		MaxImageSize: 1 << 30,
+		Repositories: []configuration.ManifestPolicyOverride{
+			{Names: []string{"ci/.*"}, MaxLayers: 100, MaxImageSize: -1},
+			{Names: []string{"ci/app", "other"}, MaxBytes: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error parsing manifest policy: %v", err)
+	}
+
+	for name, expected := range map[string]manifestLimits{
+		"library/ubuntu": {maxBytes: 1024, maxLayers: 10, maxImageSize: 1 << 30},
+		"ci/app":         {maxBytes: 1024, maxLayers: 100},
+		"other":          {maxBytes: 1, maxLayers: 10, maxImageSize: 1 << 30},
+		"other/repo":     {maxBytes: 1024, maxLayers: 10, maxImageSize: 1 << 30},
+	} {
+		if limits := policy.forRepository(name); limits != expected {
+			t.Errorf("%s: expected limits %+v, got %+v", name, expected, limits)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"mime"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
@@ -86,6 +88,7 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	var supports [numStorageTypes]bool
+	accepted := make(map[string]bool)
 
 	// this parsing of Accept headers is not quite as full-featured as godoc.org's parser, but we don't care about "q=" values
 	// https://github.com/golang/gddo/blob/e91d4165076d7474d20abda83f92d15c7ebc3e81/httputil/header/header.go#L165-L202
@@ -99,6 +102,7 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			if mediaType, _, err = mime.ParseMediaType(mediaType); err != nil {
 				continue
 			}
+			accepted[mediaType] = true
 
 			if mediaType == schema2.MediaTypeManifest {
 				supports[manifestSchema2] = true
@@ -129,7 +133,10 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		imh.Digest = desc.Digest
 	}
 
-	if etagMatch(r, imh.Digest.String()) {
+	// Manifests fetched by tag may be converted, so that their ETag is only
+	// known once they are.
+	converted := imh.Tag != "" && len(imh.App.mediaTypeMappings) > 0
+	if !converted && etagMatch(r, imh.Digest.String()) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
@@ -142,11 +149,29 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+		} else if err == distribution.ErrSchemaV1Unsupported {
+			imh.Errors = append(imh.Errors, imh.schema1Error())
 		} else {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
 		return
 	}
+	// resolved describes the manifest the tag resolved to, before any
+	// conversion for the client.
+	var resolved v1.Descriptor
+	if imh.Tag != "" {
+		mt, p, err := manifest.Payload()
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		resolved = v1.Descriptor{MediaType: mt, Digest: imh.Digest, Size: int64(len(p))}
+		manifest, imh.Digest = imh.applyMediaTypeMapping(manifest, imh.Digest, accepted)
+	}
+	if converted && etagMatch(r, imh.Digest.String()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	// determine the type of the returned manifest
 	manifestType := manifestSchema2
 	manifestList, isManifestList := manifest.(*manifestlist.DeserializedManifestList)
@@ -198,6 +223,8 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		manifest, manifestDigest = imh.applyMediaTypeMapping(manifest, manifestDigest, accepted)
+
 		if _, isSchema2 := manifest.(*schema2.DeserializedManifest); isSchema2 && !supports[manifestSchema2] {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithMessage("Schema 2 manifest not supported by client"))
 			return
@@ -215,6 +242,19 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
 	w.Header().Set("Etag", fmt.Sprintf(`"%s"`, imh.Digest))
+	if imh.App.Config.HTTP.ProvenanceHeaders {
+		canonical := imh.Digest
+		if imh.Tag != "" {
+			canonical = resolved.Digest
+		}
+		w.Header().Set("Docker-Distribution-Canonical-Digest", canonical.String())
+		if instanceID := imh.App.events.source.InstanceID; instanceID != "" {
+			w.Header().Set("Docker-Distribution-Instance-Id", instanceID)
+		}
+	}
+	if imh.Tag != "" {
+		imh.recordTagResolution(r, resolved)
+	}
 
 	if r.Method == http.MethodHead {
 		return
@@ -225,6 +265,46 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// recordTagResolution logs the digest the tag of the request resolved to and
+// sends a resolve event for it, if enabled.
+func (imh *manifestHandler) recordTagResolution(r *http.Request, resolved v1.Descriptor) {
+	if imh.App.Config.Log.TagPulls {
+		dcontext.GetLoggerWithFields(imh, map[interface{}]interface{}{
+			"tag":           imh.Tag,
+			"digest":        resolved.Digest,
+			"served.digest": imh.Digest,
+			"user":          getUserName(imh, r),
+		}).Info("tag resolved")
+	}
+
+	if imh.App.Config.Notifications.EventConfig.TagResolves {
+		listener, ok := imh.App.eventBridge(imh.Context, r).(notifications.ResolveListener)
+		if !ok {
+			return
+		}
+		if err := listener.TagResolved(imh.Repository.Named(), imh.Tag, resolved); err != nil {
+			dcontext.GetLogger(imh).Errorf("error dispatching tag resolve to listener: %v", err)
+		}
+	}
+}
+
+// schema1Error describes a schema1 manifest found in storage, naming the
+// affected tag so that operators can locate it.
+func (imh *manifestHandler) schema1Error() error {
+	detail := map[string]string{
+		"name":   imh.Repository.Named().Name(),
+		"digest": imh.Digest.String(),
+	}
+	ref := imh.Digest.String()
+	if imh.Tag != "" {
+		detail["tag"] = imh.Tag
+		ref = imh.Tag
+	}
+	return errcode.ErrorCodeManifestUnknown.WithMessage(fmt.Sprintf(
+		"manifest %s:%s uses schema version 1, which is no longer supported; it must be converted with `registry convert-schema1` or pushed again",
+		detail["name"], ref)).WithDetail(detail)
+}
+
 func etagMatch(r *http.Request, etag string) bool {
 	for _, headerVal := range r.Header["If-None-Match"] {
 		if headerVal == etag || headerVal == fmt.Sprintf(`"%s"`, etag) { // allow quoted or unquoted
@@ -243,9 +323,24 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	limits := imh.App.manifestPolicy.forRepository(imh.Repository.Named().Name())
+	readLimit := int64(maxManifestBodySize)
+	if limits.maxBytes > 0 {
+		if r.ContentLength > limits.maxBytes {
+			imh.Errors = append(imh.Errors, errManifestTooLarge(limits.maxBytes, r.ContentLength))
+			return
+		}
+		readLimit = min(limits.maxBytes, readLimit)
+	}
+
 	var jsonBuf bytes.Buffer
-	if err := copyFullPayload(imh, w, r, &jsonBuf, maxManifestBodySize, "image manifest PUT"); err != nil {
+	if err := copyFullPayload(imh, w, r, &jsonBuf, readLimit, "image manifest PUT"); err != nil {
 		// copyFullPayload reports the error if necessary
+		var maxBytesErr *http.MaxBytesError
+		if readLimit == limits.maxBytes && errors.As(err, &maxBytesErr) {
+			imh.Errors = append(imh.Errors, errManifestTooLarge(limits.maxBytes, 0))
+			return
+		}
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(err.Error()))
 		return
 	}
@@ -288,6 +383,11 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := limits.check(manifest); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
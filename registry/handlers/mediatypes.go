@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// mediaTypeMapping is the parsed form of a configuration.MediaTypeMapping.
+type mediaTypeMapping struct {
+	repositories *regexp.Regexp // nil matches every repository
+	from         string
+	to           string
+}
+
+// ociToSchema2 maps OCI descriptor media types to their Docker equivalents.
+var ociToSchema2 = map[string]string{
+	v1.MediaTypeImageManifest:  schema2.MediaTypeManifest,
+	v1.MediaTypeImageIndex:     manifestlist.MediaTypeManifestList,
+	v1.MediaTypeImageConfig:    schema2.MediaTypeImageConfig,
+	v1.MediaTypeImageLayerGzip: schema2.MediaTypeLayer,
+	v1.MediaTypeImageLayer:     schema2.MediaTypeUncompressedLayer,
+	//nolint:staticcheck // ignore SA1019: foreign layers are still served to old clients
+	v1.MediaTypeImageLayerNonDistributableGzip: schema2.MediaTypeForeignLayer,
+}
+
+// schema2ToOCI is the inverse of ociToSchema2.
+var schema2ToOCI = func() map[string]string {
+	m := make(map[string]string, len(ociToSchema2))
+	for k, v := range ociToSchema2 {
+		m[v] = k
+	}
+	return m
+}()
+
+// parseMediaTypeMappings validates the configured media type mappings.
+func parseMediaTypeMappings(config []configuration.MediaTypeMapping) ([]mediaTypeMapping, error) {
+	mappings := make([]mediaTypeMapping, 0, len(config))
+	for i, c := range config {
+		if c.From == "" || c.To == "" {
+			return nil, fmt.Errorf("compatibility.mediatypes[%d]: from and to must be set", i)
+		}
+		if !canConvertMediaType(c.From, c.To) {
+			return nil, fmt.Errorf("compatibility.mediatypes[%d]: conversion from %q to %q is not supported", i, c.From, c.To)
+		}
+
+		m := mediaTypeMapping{from: c.From, to: c.To}
+		if len(c.Repositories) > 0 {
+			re, err := regexp.Compile("^(?:" + strings.Join(c.Repositories, "|") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("compatibility.mediatypes[%d]: %v", i, err)
+			}
+			m.repositories = re
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+func canConvertMediaType(from, to string) bool {
+	switch from {
+	case v1.MediaTypeImageManifest, v1.MediaTypeImageIndex:
+		return ociToSchema2[from] == to
+	case schema2.MediaTypeManifest, manifestlist.MediaTypeManifestList:
+		return schema2ToOCI[from] == to
+	}
+	return false
+}
+
+// mediaTypeTarget returns the media type a manifest of the given media type
+// should be served as in the named repository, or the empty string if no
+// mapping applies.
+func (app *App) mediaTypeTarget(repo, mediaType string) string {
+	for _, m := range app.mediaTypeMappings {
+		if m.from != mediaType {
+			continue
+		}
+		if m.repositories != nil && !m.repositories.MatchString(repo) {
+			continue
+		}
+		return m.to
+	}
+	return ""
+}
+
+// applyMediaTypeMapping returns a converted view of the manifest if a media
+// type mapping applies to it and the client accepts the target media type but
+// not the stored one, together with the digest of the returned payload. The
+// converted manifest is stored in the repository, so that it can be fetched
+// by its digest. If the manifest cannot be converted or stored it is returned
+// unchanged.
+func (imh *manifestHandler) applyMediaTypeMapping(m distribution.Manifest, dgst digest.Digest, accepted map[string]bool) (distribution.Manifest, digest.Digest) {
+	if len(imh.App.mediaTypeMappings) == 0 {
+		return m, dgst
+	}
+	mediaType, _, err := m.Payload()
+	if err != nil {
+		return m, dgst
+	}
+	target := imh.App.mediaTypeTarget(imh.Repository.Named().Name(), mediaType)
+	if target == "" || accepted[mediaType] || !accepted[target] {
+		return m, dgst
+	}
+
+	converted, err := convertManifest(m, target)
+	if err != nil {
+		dcontext.GetLogger(imh).Warnf("unable to convert manifest %s to %s: %v", dgst, target, err)
+		return m, dgst
+	}
+	convertedDigest, err := imh.storeConvertedManifest(converted)
+	if err != nil {
+		dcontext.GetLogger(imh).Warnf("unable to store manifest %s converted to %s: %v", dgst, target, err)
+		return m, dgst
+	}
+	dcontext.GetLogger(imh).Infof("serving manifest %s as %s (%s)", dgst, target, convertedDigest)
+	return converted, convertedDigest
+}
+
+// storeConvertedManifest stores a converted manifest in the repository,
+// without tagging it or notifying listeners, unless it is already present.
+// It returns the digest of the converted manifest.
+func (imh *manifestHandler) storeConvertedManifest(m distribution.Manifest) (digest.Digest, error) {
+	_, p, err := m.Payload()
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(p)
+
+	repository, err := imh.App.registry.Repository(imh, imh.Repository.Named())
+	if err != nil {
+		return "", err
+	}
+	manifests, err := repository.Manifests(imh)
+	if err != nil {
+		return "", err
+	}
+	exists, err := manifests.Exists(imh, dgst)
+	if err != nil || exists {
+		return dgst, err
+	}
+	if _, err := manifests.Put(imh, m); err != nil {
+		return "", err
+	}
+	return dgst, nil
+}
+
+// convertManifest converts a manifest to the given media type. Only
+// conversions between the OCI and Docker schema2 formats are supported, and
+// only when every referenced descriptor has an equivalent in the target
+// format. Manifests referenced by an index are not converted, so an index is
+// only converted if none of them would need to be.
+func convertManifest(m distribution.Manifest, to string) (distribution.Manifest, error) {
+	switch m := m.(type) {
+	case *ocischema.DeserializedManifest:
+		if to != schema2.MediaTypeManifest {
+			break
+		}
+		config, err := mapDescriptor(m.Config, ociToSchema2)
+		if err != nil {
+			return nil, err
+		}
+		layers, err := mapDescriptors(m.Layers, ociToSchema2)
+		if err != nil {
+			return nil, err
+		}
+		return schema2.FromStruct(schema2.Manifest{
+			Versioned: m.Versioned,
+			MediaType: schema2.MediaTypeManifest,
+			Config:    config,
+			Layers:    layers,
+		})
+	case *schema2.DeserializedManifest:
+		if to != v1.MediaTypeImageManifest {
+			break
+		}
+		config, err := mapDescriptor(m.Config, schema2ToOCI)
+		if err != nil {
+			return nil, err
+		}
+		layers, err := mapDescriptors(m.Layers, schema2ToOCI)
+		if err != nil {
+			return nil, err
+		}
+		return ocischema.FromStruct(ocischema.Manifest{
+			Versioned: m.Versioned,
+			MediaType: v1.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    layers,
+		})
+	case *ocischema.DeserializedImageIndex:
+		if to != manifestlist.MediaTypeManifestList {
+			break
+		}
+		if err := checkIndexEntries(m.References(), ociToSchema2); err != nil {
+			return nil, err
+		}
+		descriptors := make([]manifestlist.ManifestDescriptor, len(m.Manifests))
+		for i, d := range m.Manifests {
+			if d.Platform == nil {
+				return nil, fmt.Errorf("index entry %s has no platform", d.Digest)
+			}
+			descriptors[i] = manifestlist.ManifestDescriptor{
+				Descriptor: v1.Descriptor{
+					MediaType: d.MediaType,
+					Digest:    d.Digest,
+					Size:      d.Size,
+					URLs:      d.URLs,
+				},
+				Platform: manifestlist.PlatformSpec{
+					Architecture: d.Platform.Architecture,
+					OS:           d.Platform.OS,
+					OSVersion:    d.Platform.OSVersion,
+					OSFeatures:   d.Platform.OSFeatures,
+					Variant:      d.Platform.Variant,
+				},
+			}
+		}
+		return manifestlist.FromDescriptors(descriptors)
+	case *manifestlist.DeserializedManifestList:
+		if m.MediaType != manifestlist.MediaTypeManifestList || to != v1.MediaTypeImageIndex {
+			break
+		}
+		if err := checkIndexEntries(m.References(), schema2ToOCI); err != nil {
+			return nil, err
+		}
+		return ocischema.FromDescriptors(m.References(), nil)
+	}
+
+	mediaType, _, _ := m.Payload()
+	return nil, fmt.Errorf("conversion from %q to %q is not supported", mediaType, to)
+}
+
+// checkIndexEntries returns an error if any manifest referenced by an index
+// has a media type which would need converting along with the index.
+func checkIndexEntries(descriptors []v1.Descriptor, mediaTypes map[string]string) error {
+	for _, d := range descriptors {
+		if _, ok := mediaTypes[d.MediaType]; ok {
+			return fmt.Errorf("index entry %s has media type %q, which would need converting", d.Digest, d.MediaType)
+		}
+	}
+	return nil
+}
+
+func mapDescriptors(descriptors []v1.Descriptor, mediaTypes map[string]string) ([]v1.Descriptor, error) {
+	mapped := make([]v1.Descriptor, len(descriptors))
+	for i, d := range descriptors {
+		var err error
+		if mapped[i], err = mapDescriptor(d, mediaTypes); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+func mapDescriptor(d v1.Descriptor, mediaTypes map[string]string) (v1.Descriptor, error) {
+	mediaType, ok := mediaTypes[d.MediaType]
+	if !ok {
+		return v1.Descriptor{}, fmt.Errorf("descriptor %s has media type %q with no equivalent", d.Digest, d.MediaType)
+	}
+	d.MediaType = mediaType
+	return d, nil
+}
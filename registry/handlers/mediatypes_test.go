@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParseMediaTypeMappings(t *testing.T) {
+	if _, err := parseMediaTypeMappings([]configuration.MediaTypeMapping{
+		{From: v1.MediaTypeImageManifest, To: manifestlist.MediaTypeManifestList},
+	}); err == nil {
+		t.Fatal("expected error for unsupported conversion")
+	}
+	if _, err := parseMediaTypeMappings([]configuration.MediaTypeMapping{
+		{Repositories: []string{"("}, From: v1.MediaTypeImageManifest, To: schema2.MediaTypeManifest},
+	}); err == nil {
+		t.Fatal("expected error for invalid repository expression")
+	}
+
+	mappings, err := parseMediaTypeMappings([]configuration.MediaTypeMapping{
+		{Repositories: []string{"legacy/.*"}, From: v1.MediaTypeImageManifest, To: schema2.MediaTypeManifest},
+		{From: v1.MediaTypeImageIndex, To: manifestlist.MediaTypeManifestList},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{mediaTypeMappings: mappings}
+
+	for _, tc := range []struct {
+		repo, mediaType, expected string
+	}{
+		{"legacy/app", v1.MediaTypeImageManifest, schema2.MediaTypeManifest},
+		{"legacy", v1.MediaTypeImageManifest, ""},
+		{"other/legacy/app", v1.MediaTypeImageManifest, ""},
+		{"other/app", v1.MediaTypeImageIndex, manifestlist.MediaTypeManifestList},
+		{"legacy/app", schema2.MediaTypeManifest, ""},
+	} {
+		if got := app.mediaTypeTarget(tc.repo, tc.mediaType); got != tc.expected {
+			t.Errorf("mediaTypeTarget(%q, %q) = %q, expected %q", tc.repo, tc.mediaType, got, tc.expected)
+		}
+	}
+}
+
+func TestConvertManifest(t *testing.T) {
+	oci, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config: v1.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+		Layers: []v1.Descriptor{{
+			MediaType: v1.MediaTypeImageLayerGzip,
+			Digest:    digest.FromString("layer"),
+			Size:      5,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	converted, err := convertManifest(oci, schema2.MediaTypeManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, ok := converted.(*schema2.DeserializedManifest)
+	if !ok {
+		t.Fatalf("expected schema2 manifest, got %T", converted)
+	}
+	if s2.Config.MediaType != schema2.MediaTypeImageConfig || s2.Config.Digest != oci.Config.Digest {
+		t.Errorf("unexpected config descriptor: %+v", s2.Config)
+	}
+	if s2.Layers[0].MediaType != schema2.MediaTypeLayer || s2.Layers[0].Digest != oci.Layers[0].Digest {
+		t.Errorf("unexpected layer descriptor: %+v", s2.Layers[0])
+	}
+	if mediaType, _, _ := converted.Payload(); mediaType != schema2.MediaTypeManifest {
+		t.Errorf("unexpected payload media type %q", mediaType)
+	}
+
+	back, err := convertManifest(converted, v1.MediaTypeImageManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p1, _ := oci.Payload()
+	_, p2, _ := back.Payload()
+	if string(p1) != string(p2) {
+		t.Errorf("round trip changed manifest:\n%s\n%s", p1, p2)
+	}
+
+	zstd, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    oci.Config,
+		Layers: []v1.Descriptor{{
+			MediaType: v1.MediaTypeImageLayerZstd,
+			Digest:    digest.FromString("layer"),
+			Size:      5,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := convertManifest(zstd, schema2.MediaTypeManifest); err == nil {
+		t.Error("expected error converting zstd layers to schema2")
+	}
+}
+
+func TestConvertImageIndex(t *testing.T) {
+	index, err := ocischema.FromDescriptors([]v1.Descriptor{{
+		MediaType: schema2.MediaTypeManifest,
+		Digest:    digest.FromString("manifest"),
+		Size:      8,
+		Platform:  &v1.Platform{Architecture: "amd64", OS: "linux"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	converted, err := convertManifest(index, manifestlist.MediaTypeManifestList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, ok := converted.(*manifestlist.DeserializedManifestList)
+	if !ok {
+		t.Fatalf("expected manifest list, got %T", converted)
+	}
+	if list.MediaType != manifestlist.MediaTypeManifestList || len(list.Manifests) != 1 {
+		t.Fatalf("unexpected manifest list: %+v", list.ManifestList)
+	}
+	if list.Manifests[0].Platform.Architecture != "amd64" || list.Manifests[0].Digest != index.Manifests[0].Digest {
+		t.Errorf("unexpected manifest descriptor: %+v", list.Manifests[0])
+	}
+
+	// An index of OCI manifests would need its manifests converted too.
+	index, err = ocischema.FromDescriptors([]v1.Descriptor{{
+		MediaType: v1.MediaTypeImageManifest,
+		Digest:    digest.FromString("manifest"),
+		Size:      8,
+		Platform:  &v1.Platform{Architecture: "amd64", OS: "linux"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := convertManifest(index, manifestlist.MediaTypeManifestList); err == nil {
+		t.Error("expected error converting an index of OCI manifests")
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultMaxNamespaces is the number of distinct repository namespaces
+	// labelled in the route metrics, unless configured.
+	defaultMaxNamespaces = 100
+
+	// otherNamespace labels the requests to the namespaces beyond the
+	// cardinality limits.
+	otherNamespace = "_other"
+)
+
+var (
+	// routeRequestDuration is the latency of requests to each route.
+	routeRequestDuration = prometheus.HTTPNamespace.NewLabeledTimer("route_request_duration", "The latency of HTTP requests by route", "method", "route", "status_class", "namespace", "action")
+
+	// routeResponseBytes is the number of response body bytes written by
+	// each route.
+	routeResponseBytes = prometheus.HTTPNamespace.NewLabeledCounter("route_response_bytes", "The number of HTTP response body bytes written by route", "method", "route", "status_class", "namespace", "action")
+)
+
+func init() {
+	metrics.Register(prometheus.HTTPNamespace)
+}
+
+// instrumentRoute records the latency and response size of requests handled
+// by handler. The status and size are read from the instrumented response
+// writer placed on the request context by App.ServeHTTP. Requests to a
+// repository are labelled with its namespace and their action if namespaces
+// is not nil, and the labels are left empty otherwise.
+func instrumentRoute(routeName string, handler http.Handler, namespaces *namespaceLabeler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler.ServeHTTP(w, r)
+
+		ctx := r.Context()
+		status, _ := ctx.Value(dcontext.ResponseStatusKey).(int)
+		written, _ := ctx.Value(dcontext.ResponseWrittenKey).(int64)
+		class := statusClass(status)
+
+		var namespace, action string
+		if name := mux.Vars(r)["name"]; name != "" && namespaces != nil {
+			// Failed requests, unauthorized or to repositories which do
+			// not exist, do not take a slot of the namespaces labelled.
+			namespace = namespaces.label(name, status < http.StatusBadRequest)
+			action = requestAction(r.Method)
+		}
+
+		routeRequestDuration.WithValues(r.Method, routeName, class, namespace, action).UpdateSince(start)
+		routeResponseBytes.WithValues(r.Method, routeName, class, namespace, action).Inc(float64(written))
+	})
+}
+
+// requestAction returns the action of a request to a repository with method,
+// as named by the scopes of the token authentication.
+func requestAction(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "pull"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "push"
+	}
+}
+
+// namespaceLabeler bounds the values of the namespace label of the route
+// metrics: the namespaces labelled are the allowed ones if configured, up to
+// a maximum, in the order they are first successfully requested. The requests
+// to other namespaces are labelled as otherNamespace.
+type namespaceLabeler struct {
+	max     int
+	allowed map[string]bool
+
+	mu       sync.Mutex
+	labelled map[string]bool
+}
+
+// newNamespaceLabeler returns the labeler of repository namespaces
+// configured, or nil if namespaces are not labelled.
+func newNamespaceLabeler(config configuration.PrometheusRepositories) *namespaceLabeler {
+	if !config.Enabled {
+		return nil
+	}
+	nl := &namespaceLabeler{
+		max:      config.MaxNamespaces,
+		labelled: make(map[string]bool),
+	}
+	if nl.max <= 0 {
+		nl.max = defaultMaxNamespaces
+	}
+	if len(config.Namespaces) > 0 {
+		nl.allowed = make(map[string]bool, len(config.Namespaces))
+		for _, namespace := range config.Namespaces {
+			nl.allowed[namespace] = true
+		}
+	}
+	return nl
+}
+
+// label returns the value of the namespace label of the requests to the
+// named repository. The namespace of a repository is the first component of
+// its name. A namespace not labelled yet is only labelled if claim is true.
+func (nl *namespaceLabeler) label(name string, claim bool) string {
+	namespace, _, _ := strings.Cut(name, "/")
+	if nl.allowed != nil && !nl.allowed[namespace] {
+		return otherNamespace
+	}
+
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	if !nl.labelled[namespace] {
+		if !claim || len(nl.labelled) >= nl.max {
+			return otherNamespace
+		}
+		nl.labelled[namespace] = true
+	}
+	return namespace
+}
+
+// statusClass returns the class of an HTTP status code, such as "2xx". A
+// response without a status, which is written as 200 OK by the server, is
+// reported as "2xx".
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestStatusClass(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		expected string
+	}{
+		{0, "2xx"},
+		{http.StatusSwitchingProtocols, "1xx"},
+		{http.StatusOK, "2xx"},
+		{http.StatusTemporaryRedirect, "3xx"},
+		{http.StatusNotFound, "4xx"},
+		{http.StatusInsufficientStorage, "5xx"},
+		{600, "unknown"},
+	} {
+		if class := statusClass(tc.status); class != tc.expected {
+			t.Errorf("status %d: expected %q, got %q", tc.status, tc.expected, class)
+		}
+	}
+}
+
+func TestRequestAction(t *testing.T) {
+	for method, expected := range map[string]string{
+		http.MethodGet:    "pull",
+		http.MethodHead:   "pull",
+		http.MethodPut:    "push",
+		http.MethodPost:   "push",
+		http.MethodPatch:  "push",
+		http.MethodDelete: "delete",
+	} {
+		if action := requestAction(method); action != expected {
+			t.Errorf("%s: expected %q, got %q", method, expected, action)
+		}
+	}
+}
+
+func TestNamespaceLabeler(t *testing.T) {
+	if nl := newNamespaceLabeler(configuration.PrometheusRepositories{}); nl != nil {
+		t.Fatal("expected no labeler when disabled")
+	}
+
+	nl := newNamespaceLabeler(configuration.PrometheusRepositories{Enabled: true, MaxNamespaces: 2})
+	for _, tc := range []struct {
+		name     string
+		claim    bool
+		expected string
+	}{
+		{"unknown/app", false, otherNamespace},
+		{"library/ubuntu", true, "library"},
+		{"library/debian", false, "library"},
+		{"ubuntu", true, "ubuntu"},
+		{"library/alpine", true, "library"},
+		{"team/app/api", true, otherNamespace},
+		{"ubuntu", false, "ubuntu"},
+	} {
+		if label := nl.label(tc.name, tc.claim); label != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, label)
+		}
+	}
+
+	nl = newNamespaceLabeler(configuration.PrometheusRepositories{Enabled: true, Namespaces: []string{"team"}})
+	if label := nl.label("team/app", true); label != "team" {
+		t.Errorf("expected allowed namespace to be labelled, got %q", label)
+	}
+	if label := nl.label("library/ubuntu", true); label != otherNamespace {
+		t.Errorf("expected namespace not allowed to be aggregated, got %q", label)
+	}
+	if nl.max != defaultMaxNamespaces {
+		t.Errorf("expected default maximum of namespaces, got %d", nl.max)
+	}
+}
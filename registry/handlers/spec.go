@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/version"
+	"github.com/gorilla/handlers"
+)
+
+func specDispatcher(ctx *Context, r *http.Request) http.Handler {
+	specHandler := &specHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(specHandler.GetSpec),
+	}
+}
+
+type specHandler struct {
+	*Context
+}
+
+// GetSpec serves the OpenAPI description of the routes served by the
+// registry. The routes of the admin API, the change log and blob deltas are
+// left out unless they are enabled.
+func (sh *specHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
+	doc := v2.OpenAPI(sh.App.Config.HTTP.Prefix, version.Version())
+	var names []string
+	if !sh.App.Config.HTTP.Admin.Enabled {
+		for name := range adminRoutes {
+			names = append(names, name)
+		}
+	}
+	if !sh.App.Config.Catalog.Changes.Enabled {
+		names = append(names, v2.RouteNameChanges)
+	}
+	if !sh.App.Config.Deltas.Enabled {
+		names = append(names, v2.RouteNameBlobDelta)
+	}
+	doc.RemoveRoutes(names...)
+	p, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(p)))
+	w.Write(p)
+}
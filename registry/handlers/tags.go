@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"sort"
 	"strconv"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/gorilla/handlers"
 )
@@ -32,46 +35,121 @@ type tagsAPIResponse struct {
 	Tags []string `json:"tags"`
 }
 
+// tagsPageSize is the number of tags read from the tag service at a time
+// when streaming a tag list, and the most tags returned for a paginated
+// request.
+const tagsPageSize = 1000
+
 // GetTags returns a json list of tags for a specific image name.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lastEntry := q.Get("last")
+
+	// a negative number of entries requests every tag
+	maxEntries := -1
+	if n := q.Get("n"); n != "" {
+		var err error
+		maxEntries, err = strconv.Atoi(n)
+		if err != nil || maxEntries < 0 {
+			th.Errors = append(th.Errors, errcode.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+			return
+		}
+	}
+
 	tagService := th.Repository.Tags(th)
-	tags, err := tagService.All(th)
+	lister, ok := tagService.(distribution.TagLister)
+	if !ok {
+		th.getAllTags(w, r, tagService, lastEntry, maxEntries)
+		return
+	}
+
+	pageSize := tagsPageSize
+	if maxEntries >= 0 && maxEntries < pageSize {
+		pageSize = maxEntries
+	}
+
+	// the first page is read before writing the response, so that errors
+	// are reported with the right status
+	tags := make([]string, pageSize)
+	filled, more, err := listTags(th, lister, tags, lastEntry)
 	if err != nil {
-		switch err := err.(type) {
-		case distribution.ErrRepositoryUnknown:
-			th.Errors = append(th.Errors, errcode.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
-		case errcode.Error:
-			th.Errors = append(th.Errors, err)
-		default:
-			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-		}
+		th.appendTagsError(err)
 		return
 	}
 
-	// do pagination if requested
-	q := r.URL.Query()
-	// get entries after latest, if any specified
-	if lastEntry := q.Get("last"); lastEntry != "" {
-		lastEntryIndex := sort.SearchStrings(tags, lastEntry)
-
-		// as`sort.SearchStrings` can return len(tags), if the
-		// specified `lastEntry` is not found, we need to
-		// ensure it does not panic when slicing.
-		if lastEntryIndex == len(tags) {
-			tags = []string{}
-		} else {
-			tags = tags[lastEntryIndex+1:]
+	if maxEntries >= 0 && more {
+		// requests for more than a page of tags get a page of tags
+		// and a link to the next page
+		urlStr, err := createLinkEntry(r.URL.String(), maxEntries, tags[filled-1])
+		if err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
 		}
+		w.Header().Set("Link", urlStr)
 	}
 
-	// if no error, means that the user requested `n` entries
-	if n := q.Get("n"); n != "" {
-		maxEntries, err := strconv.Atoi(n)
-		if err != nil || maxEntries < 0 {
-			th.Errors = append(th.Errors, errcode.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+	w.Header().Set("Content-Type", "application/json")
+
+	tw := newTagsWriter(w, th.Repository.Named().Name())
+	tw.write(tags[:filled])
+
+	// without pagination the remaining tags are streamed a page at a time,
+	// so that the tags held in memory are bounded by the page size
+	for maxEntries < 0 && more && tw.err == nil {
+		tw.flush()
+
+		last := tags[filled-1]
+		filled, more, err = listTags(th, lister, tags, last)
+		if err != nil {
+			// The status has already been sent: the response is left
+			// unterminated so that clients cannot mistake the tags
+			// written so far for the complete list.
+			dcontext.GetLogger(th).Errorf("error listing tags after %q: %v", last, err)
 			return
 		}
+		tw.write(tags[:filled])
+	}
+	tw.close()
+
+	if tw.err != nil {
+		dcontext.GetLogger(th).Errorf("error writing tags response: %v", tw.err)
+	}
+}
+
+// listTags fills tags with the tags following last, reporting whether more
+// tags follow those filled.
+func listTags(ctx context.Context, lister distribution.TagLister, tags []string, last string) (int, bool, error) {
+	if len(tags) == 0 {
+		// no tags are returned, but a missing repository is still
+		// reported
+		_, err := lister.List(ctx, make([]string, 1), last)
+		if err == io.EOF {
+			err = nil
+		}
+		return 0, false, err
+	}
+	filled, err := lister.List(ctx, tags, last)
+	if err == io.EOF {
+		return filled, false, nil
+	}
+	return filled, filled > 0, err
+}
+
+// getAllTags writes the tags of tag services unable to list tags a page at a
+// time.
+func (th *tagsHandler) getAllTags(w http.ResponseWriter, r *http.Request, tagService distribution.TagService, lastEntry string, maxEntries int) {
+	tags, err := tagService.All(th)
+	if err != nil {
+		th.appendTagsError(err)
+		return
+	}
+
+	// get entries after latest, if any specified
+	if lastEntry != "" {
+		tags = tags[sort.Search(len(tags), func(i int) bool { return tags[i] > lastEntry }):]
+	}
 
+	if maxEntries >= 0 {
 		// if there is requested more than or
 		// equal to the amount of tags we have,
 		// then set the request to equal `len(tags)`.
@@ -104,3 +182,67 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (th *tagsHandler) appendTagsError(err error) {
+	switch err := err.(type) {
+	case distribution.ErrRepositoryUnknown:
+		th.Errors = append(th.Errors, errcode.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
+	case errcode.Error:
+		th.Errors = append(th.Errors, err)
+	default:
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
+
+// tagsWriter writes a tagsAPIResponse incrementally. The first error
+// writing is kept and stops further writes.
+type tagsWriter struct {
+	w    http.ResponseWriter
+	tags int
+	err  error
+}
+
+func newTagsWriter(w http.ResponseWriter, name string) *tagsWriter {
+	tw := &tagsWriter{w: w}
+	tw.writeString(`{"name":`)
+	tw.writeJSON(name)
+	tw.writeString(`,"tags":[`)
+	return tw
+}
+
+func (tw *tagsWriter) write(tags []string) {
+	for _, tag := range tags {
+		if tw.tags > 0 {
+			tw.writeString(",")
+		}
+		tw.writeJSON(tag)
+		tw.tags++
+	}
+}
+
+// flush sends the tags written so far to the client.
+func (tw *tagsWriter) flush() {
+	if flusher, ok := tw.w.(http.Flusher); ok && tw.err == nil {
+		flusher.Flush()
+	}
+}
+
+func (tw *tagsWriter) close() {
+	tw.writeString("]}\n")
+}
+
+func (tw *tagsWriter) writeJSON(v string) {
+	p, err := json.Marshal(v)
+	if err != nil {
+		tw.err = err
+		return
+	}
+	tw.writeString(string(p))
+}
+
+func (tw *tagsWriter) writeString(s string) {
+	if tw.err != nil {
+		return
+	}
+	_, tw.err = io.WriteString(tw.w, s)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+// routeTimeout applies the timeouts configured for the requests to the named
+// route, by method, in place of the read and write timeouts of the server.
+// The context of the request is canceled once the timeout expires.
+func (app *App) routeTimeout(routeName string, handler http.Handler) http.Handler {
+	var routeTimeouts []configuration.RouteTimeout
+	for _, rt := range app.Config.HTTP.Timeouts.Routes {
+		if rt.Route == routeName {
+			routeTimeouts = append(routeTimeouts, rt)
+		}
+	}
+	if len(routeTimeouts) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok := matchRouteTimeout(routeTimeouts, r.Method)
+		if !ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// A zero deadline clears the timeouts of the server.
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			dcontext.GetLogger(r.Context()).Warnf("error setting read deadline of %s request: %v", routeName, err)
+		}
+		if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			dcontext.GetLogger(r.Context()).Warnf("error setting write deadline of %s request: %v", routeName, err)
+		}
+
+		if timeout > 0 {
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// matchRouteTimeout returns the timeout of the first of timeouts applying to
+// the requests with method.
+func matchRouteTimeout(timeouts []configuration.RouteTimeout, method string) (time.Duration, bool) {
+	for _, rt := range timeouts {
+		if len(rt.Methods) == 0 {
+			return rt.Timeout, true
+		}
+		for _, m := range rt.Methods {
+			if strings.EqualFold(m, method) {
+				return rt.Timeout, true
+			}
+		}
+	}
+	return 0, false
+}
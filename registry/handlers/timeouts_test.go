@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Timeouts.Routes = []configuration.RouteTimeout{
+		{Route: v2.RouteNameBlobUploadChunk, Methods: []string{"put", "PATCH"}, Timeout: time.Hour},
+		{Route: v2.RouteNameBlobUploadChunk, Timeout: time.Minute},
+		{Route: v2.RouteNameBlobUploadChunk, Methods: []string{http.MethodDelete}, Timeout: time.Second},
+		{Route: v2.RouteNameTags, Timeout: 0},
+	}
+	app := &App{Config: config}
+
+	for _, tc := range []struct {
+		route   string
+		method  string
+		timeout time.Duration
+	}{
+		{v2.RouteNameBlobUploadChunk, http.MethodPut, time.Hour},
+		{v2.RouteNameBlobUploadChunk, http.MethodPatch, time.Hour},
+		{v2.RouteNameBlobUploadChunk, http.MethodGet, time.Minute},
+		{v2.RouteNameBlobUploadChunk, http.MethodDelete, time.Minute},
+		{v2.RouteNameTags, http.MethodGet, 0},
+		{v2.RouteNameManifest, http.MethodGet, 0},
+	} {
+		var deadline time.Time
+		var hasDeadline bool
+		handler := app.routeTimeout(tc.route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, hasDeadline = r.Context().Deadline()
+		}))
+		start := time.Now()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, "/", nil))
+
+		if tc.timeout == 0 {
+			if hasDeadline {
+				t.Errorf("%s %s: unexpected deadline %v", tc.method, tc.route, deadline)
+			}
+			continue
+		}
+		if !hasDeadline {
+			t.Errorf("%s %s: expected a deadline", tc.method, tc.route)
+			continue
+		}
+		if d := deadline.Sub(start); d < tc.timeout || d > tc.timeout+time.Minute/2 {
+			t.Errorf("%s %s: expected timeout %v, got %v", tc.method, tc.route, tc.timeout, d)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/mux"
+)
+
+// defaultUsageInterval is the interval over which usage is aggregated,
+// unless configured.
+const defaultUsageInterval = 5 * time.Minute
+
+// usageRoutes are the routes transferring content, whose bytes are counted
+// in usage events.
+var usageRoutes = map[string]bool{
+	v2.RouteNameManifest:        true,
+	v2.RouteNameBlob:            true,
+	v2.RouteNameBlobDelta:       true,
+	v2.RouteNameBlobUpload:      true,
+	v2.RouteNameBlobUploadChunk: true,
+}
+
+// recordUsage counts the request and response body bytes of requests to
+// routes transferring content as bytes pushed to and pulled from the
+// repository, when usage events are enabled.
+func (app *App) recordUsage(routeName string, handler http.Handler) http.Handler {
+	if !usageRoutes[routeName] {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.usage == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		handler.ServeHTTP(w, r)
+
+		repository := mux.Vars(r)["name"]
+		written, _ := r.Context().Value(dcontext.ResponseWrittenKey).(int64)
+		app.usage.Pulled(repository, written)
+		app.usage.Pushed(repository, body.n)
+	})
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
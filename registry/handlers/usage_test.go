@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/reference"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+// usageSink collects the usage events written to it.
+type usageSink struct {
+	mu     sync.Mutex
+	events []notifications.UsageEvent
+}
+
+func (us *usageSink) Write(event events.Event) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.events = append(us.events, event.(notifications.UsageEvent))
+	return nil
+}
+
+func (us *usageSink) Close() error { return nil }
+
+func TestUsageEvents(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Notifications.EventConfig.Usage = configuration.UsageEvents{Enabled: true, Interval: time.Hour}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	if env.app.usage == nil {
+		t.Fatal("usage events are not enabled")
+	}
+	env.app.usage.Close()
+	sink := &usageSink{}
+	env.app.usage = notifications.NewUsageAggregator(sink, env.app.events.source, time.Hour)
+
+	content := bytes.Repeat([]byte("usage"), 1000)
+	dgst := digest.FromBytes(content)
+	imageName, _ := reference.WithName("foo/bar")
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatalf("error building blob url: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(blobURL)
+		if err != nil {
+			t.Fatalf("unexpected error pulling blob: %v", err)
+		}
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			t.Fatalf("unexpected error reading blob: %v", err)
+		}
+		resp.Body.Close()
+		checkResponse(t, "pulling blob", resp, http.StatusOK)
+	}
+
+	// The base route does not transfer content.
+	baseURL, err := env.builder.BuildBaseURL()
+	if err != nil {
+		t.Fatalf("error building base url: %v", err)
+	}
+	resp, err := http.Get(baseURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching base url: %v", err)
+	}
+	resp.Body.Close()
+
+	env.app.usage.Flush()
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 1 {
+		t.Fatalf("unexpected number of usage events: %d", len(sink.events))
+	}
+	usage := sink.events[0].Usage
+	if usage.Repository != imageName.Name() || usage.BytesPushed != int64(len(content)) || usage.BytesPulled != 2*int64(len(content)) {
+		t.Fatalf("unexpected usage: %#v", usage)
+	}
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Locker is a lock service shared by the registry replicas, used to elect
+// the replica which runs jobs. Locks are held by an owner for a ttl, after
+// which they expire unless refreshed, so that a lock held by a replica which
+// stopped is released.
+type Locker interface {
+	// Acquire takes the named lock for owner for ttl, or extends it if owner
+	// already holds it. It returns false if another owner holds the lock.
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+
+	// Release releases the named lock if owner holds it.
+	Release(ctx context.Context, name, owner string) error
+}
+
+// inMemoryLocker holds locks in memory. It only elects a replica among the
+// schedulers of a single process.
+type inMemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]inMemoryLock
+}
+
+type inMemoryLock struct {
+	owner   string
+	expires time.Time
+}
+
+// NewInMemoryLocker returns a Locker holding locks in memory, for registries
+// with a single replica.
+func NewInMemoryLocker() Locker {
+	return &inMemoryLocker{locks: make(map[string]inMemoryLock)}
+}
+
+func (l *inMemoryLocker) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if lock, ok := l.locks[name]; ok && lock.owner != owner && now.Before(lock.expires) {
+		return false, nil
+	}
+	l.locks[name] = inMemoryLock{owner: owner, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (l *inMemoryLocker) Release(ctx context.Context, name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lock, ok := l.locks[name]; ok && lock.owner == owner {
+		delete(l.locks, name)
+	}
+	return nil
+}
+
+// redisLocker holds locks as redis keys expiring after their ttl, so that
+// every replica sharing the redis server sees the same locks.
+type redisLocker struct {
+	pool redis.UniversalClient
+}
+
+// NewRedisLocker returns a Locker backed by redis.
+func NewRedisLocker(pool redis.UniversalClient) Locker {
+	return &redisLocker{pool: pool}
+}
+
+// acquireScript sets the lock key to the owner if it is not set, or extends
+// it if the owner holds it.
+var acquireScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+elseif owner then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// releaseScript deletes the lock key if the owner holds it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (l *redisLocker) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	acquired, err := acquireScript.Run(ctx, l.pool, []string{lockKey(name)}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return acquired == 1, nil
+}
+
+func (l *redisLocker) Release(ctx context.Context, name, owner string) error {
+	return releaseScript.Run(ctx, l.pool, []string{lockKey(name)}, owner).Err()
+}
+
+func lockKey(name string) string {
+	return "locks::" + name
+}
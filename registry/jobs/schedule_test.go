@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 10, 17, 30, 0, time.UTC) // a Wednesday
+
+	for _, tc := range []struct {
+		spec     string
+		expected []time.Time
+	}{
+		{
+			spec: "@every 90m",
+			expected: []time.Time{
+				start.Add(90 * time.Minute),
+				start.Add(180 * time.Minute),
+			},
+		},
+		{
+			spec: "*/20 * * * *",
+			expected: []time.Time{
+				time.Date(2024, time.January, 31, 10, 20, 0, 0, time.UTC),
+				time.Date(2024, time.January, 31, 10, 40, 0, 0, time.UTC),
+				time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec: "@daily",
+			expected: []time.Time{
+				time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec: "30 2 * * 1-5",
+			expected: []time.Time{
+				time.Date(2024, time.February, 1, 2, 30, 0, 0, time.UTC),
+				time.Date(2024, time.February, 2, 2, 30, 0, 0, time.UTC),
+				time.Date(2024, time.February, 5, 2, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			// Day of month or day of week, as both are restricted.
+			spec: "0 0 29 * 7",
+			expected: []time.Time{
+				time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 11, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 18, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 25, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec: "0 12 1 3,6 *",
+			expected: []time.Time{
+				time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC),
+				time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:     "0 0 30 2 *",
+			expected: []time.Time{{}},
+		},
+	} {
+		schedule, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.spec, err)
+		}
+		next := start
+		for _, expected := range tc.expected {
+			next = schedule.Next(next)
+			if !next.Equal(expected) {
+				t.Fatalf("%s: expected %s, got %s", tc.spec, expected, next)
+			}
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every",
+		"@every -1h",
+		"@yearly",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
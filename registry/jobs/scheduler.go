@@ -0,0 +1,271 @@
+// Package jobs runs maintenance jobs, such as garbage collection and upload
+// purging, on a schedule. When the registry runs as several replicas, the
+// replicas elect a leader through a shared lock service and only the leader
+// runs jobs.
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// leaderLock is the name of the lock held by the replica running jobs.
+const leaderLock = "jobs-leader"
+
+// DefaultLockTTL is the time for which the leader holds the lock without
+// refreshing it, if no ttl is configured. A replica taking over from a leader
+// which stopped waits up to this long.
+const DefaultLockTTL = time.Minute
+
+var (
+	// jobRuns is the number of times each job was due, by outcome.
+	jobRuns = prometheus.JobsNamespace.NewLabeledCounter("runs", "The number of times a job was due, by status", "job", "status")
+
+	// jobDuration is the duration of job runs.
+	jobDuration = prometheus.JobsNamespace.NewLabeledTimer("duration", "The duration of job runs", "job")
+
+	// jobLastSuccess is the time of the last successful run of each job.
+	jobLastSuccess = prometheus.JobsNamespace.NewLabeledGauge("last_success", "The time of the last successful run of a job", metrics.Seconds, "job")
+
+	// leaderGauge is 1 while this replica is the leader running jobs.
+	leaderGauge = prometheus.JobsNamespace.NewGauge("leader", "Whether this replica is the leader running jobs", "")
+)
+
+func init() {
+	metrics.Register(prometheus.JobsNamespace)
+}
+
+// Job statuses reported by the runs metric.
+const (
+	statusSuccess = "success"
+	statusFailure = "failure"
+	statusSkipped = "skipped"
+)
+
+// Job is a maintenance job.
+type Job struct {
+	// Name identifies the job in logs and metrics.
+	Name string
+
+	// Schedule determines when the job runs.
+	Schedule Schedule
+
+	// RunAtStart runs the job once when the scheduler starts, after
+	// StartDelay, before following its schedule.
+	RunAtStart bool
+	StartDelay time.Duration
+
+	// Run runs the job. The context is cancelled if the replica stops being
+	// the leader while the job runs.
+	Run func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their schedule while its replica is the leader.
+type Scheduler struct {
+	locker Locker
+	owner  string
+	ttl    time.Duration
+	jobs   []Job
+
+	mu       sync.Mutex
+	leader   bool
+	leaderCh chan struct{} // closed when leadership is lost
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler electing the leader with locker. The owner
+// identifies the replica in the lock, and ttl is the time for which the
+// leader holds the lock without refreshing it.
+func NewScheduler(locker Locker, owner string, ttl time.Duration) *Scheduler {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	return &Scheduler{
+		locker: locker,
+		owner:  owner,
+		ttl:    ttl,
+	}
+}
+
+// Add adds a job to the scheduler. Jobs must be added before the scheduler
+// is started.
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Jobs returns the names of the jobs added to the scheduler.
+func (s *Scheduler) Jobs() []string {
+	names := make([]string, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+// Start elects the leader and starts running the jobs. The scheduler runs
+// until Stop is called or ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	// Take part in the first election before the jobs start, so that jobs
+	// running at start run on the leader.
+	s.elect(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.elect(ctx)
+			case <-ctx.Done():
+				s.resign()
+				return
+			}
+		}
+	}()
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			s.schedule(ctx, job)
+		}(job)
+	}
+}
+
+// Stop stops the scheduler, cancelling running jobs and releasing the
+// leader lock, and waits for the jobs to return.
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// IsLeader reports whether this replica is the leader running jobs.
+func (s *Scheduler) IsLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// elect acquires or refreshes the leader lock.
+func (s *Scheduler) elect(ctx context.Context) {
+	acquired, err := s.locker.Acquire(ctx, leaderLock, s.owner, s.ttl)
+	if err != nil {
+		// Without knowing whether the lock is still held, stop running jobs
+		// rather than risk running them on two replicas.
+		dcontext.GetLogger(ctx).Errorf("jobs: error acquiring leader lock: %v", err)
+		acquired = false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if acquired == s.leader {
+		return
+	}
+	s.leader = acquired
+	if acquired {
+		s.leaderCh = make(chan struct{})
+		leaderGauge.Set(1)
+		dcontext.GetLogger(ctx).Infof("jobs: %s is now the leader running jobs", s.owner)
+	} else {
+		close(s.leaderCh)
+		leaderGauge.Set(0)
+		dcontext.GetLogger(ctx).Infof("jobs: %s is no longer the leader running jobs", s.owner)
+	}
+}
+
+// resign releases the leader lock, so that another replica can take over
+// without waiting for it to expire.
+func (s *Scheduler) resign() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.leader {
+		return
+	}
+	s.leader = false
+	close(s.leaderCh)
+	leaderGauge.Set(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.locker.Release(ctx, leaderLock, s.owner); err != nil {
+		dcontext.GetLogger(ctx).Errorf("jobs: error releasing leader lock: %v", err)
+	}
+}
+
+// schedule runs job each time it is due until ctx is cancelled.
+func (s *Scheduler) schedule(ctx context.Context, job Job) {
+	var next time.Time
+	if job.RunAtStart {
+		next = time.Now().Add(job.StartDelay)
+	} else {
+		next = job.Schedule.Next(time.Now())
+	}
+
+	for {
+		if next.IsZero() {
+			dcontext.GetLogger(ctx).Warnf("jobs: %s is never due again", job.Name)
+			return
+		}
+		dcontext.GetLogger(ctx).Infof("jobs: next run of %s at %s", job.Name, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		s.run(ctx, job)
+		next = job.Schedule.Next(time.Now())
+	}
+}
+
+// run runs job if this replica is the leader, cancelling it if leadership is
+// lost while it runs.
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	s.mu.Lock()
+	leader, leaderCh := s.leader, s.leaderCh
+	s.mu.Unlock()
+	if !leader {
+		dcontext.GetLogger(ctx).Debugf("jobs: skipping %s, another replica is the leader", job.Name)
+		jobRuns.WithValues(job.Name, statusSkipped).Inc(1)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-leaderCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log := dcontext.GetLogger(ctx)
+	log.Infof("jobs: running %s", job.Name)
+	start := time.Now()
+	err := job.Run(ctx)
+	jobDuration.WithValues(job.Name).UpdateSince(start)
+	if err != nil {
+		log.Errorf("jobs: %s failed after %s: %v", job.Name, time.Since(start), err)
+		jobRuns.WithValues(job.Name, statusFailure).Inc(1)
+		return
+	}
+	log.Infof("jobs: %s completed in %s", job.Name, time.Since(start))
+	jobRuns.WithValues(job.Name, statusSuccess).Inc(1)
+	jobLastSuccess.WithValues(job.Name).Set(float64(time.Now().Unix()))
+}
@@ -0,0 +1,138 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryLocker(t *testing.T) {
+	ctx := context.Background()
+	locker := NewInMemoryLocker()
+
+	for _, tc := range []struct {
+		owner    string
+		expected bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"a", true}, // refreshed by its owner
+	} {
+		acquired, err := locker.Acquire(ctx, "lock", tc.owner, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired != tc.expected {
+			t.Fatalf("%s: expected acquired to be %v", tc.owner, tc.expected)
+		}
+	}
+
+	// Only the owner releases the lock.
+	if err := locker.Release(ctx, "lock", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, _ := locker.Acquire(ctx, "lock", "b", time.Millisecond); acquired {
+		t.Fatal("lock released by another owner")
+	}
+	if err := locker.Release(ctx, "lock", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, _ := locker.Acquire(ctx, "lock", "b", time.Millisecond); !acquired {
+		t.Fatal("lock not released by its owner")
+	}
+
+	// An expired lock is taken over.
+	time.Sleep(5 * time.Millisecond)
+	if acquired, _ := locker.Acquire(ctx, "lock", "a", time.Hour); !acquired {
+		t.Fatal("expired lock not taken over")
+	}
+}
+
+// countingJob returns a job running every interval and counting its runs by
+// owner.
+func countingJob(interval time.Duration, mu *sync.Mutex, runs map[string]int, owner string) Job {
+	return Job{
+		Name:     "counting",
+		Schedule: Every(interval),
+		Run: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[owner]++
+			return nil
+		},
+	}
+}
+
+func TestSchedulerLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	locker := NewInMemoryLocker()
+
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	schedulers := make(map[string]*Scheduler)
+	for _, owner := range []string{"a", "b"} {
+		s := NewScheduler(locker, owner, 30*time.Millisecond)
+		s.Add(countingJob(5*time.Millisecond, &mu, runs, owner))
+		s.Start(ctx)
+		defer s.Stop()
+		schedulers[owner] = s
+	}
+
+	if !schedulers["a"].IsLeader() || schedulers["b"].IsLeader() {
+		t.Fatal("the first scheduler started is not the only leader")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	if runs["a"] == 0 || runs["b"] != 0 {
+		t.Fatalf("unexpected runs while a is the leader: %v", runs)
+	}
+	mu.Unlock()
+
+	// Another replica takes over once the leader stops.
+	schedulers["a"].Stop()
+	deadline := time.Now().Add(time.Second)
+	for !schedulers["b"].IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("leadership was not taken over")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs["b"] == 0 {
+		t.Fatalf("no runs after b became the leader: %v", runs)
+	}
+}
+
+func TestSchedulerCancelsJobOnStop(t *testing.T) {
+	s := NewScheduler(NewInMemoryLocker(), "a", time.Minute)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	s.Add(Job{
+		Name:       "blocking",
+		Schedule:   Every(time.Hour),
+		RunAtStart: true,
+		Run: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		},
+	})
+	s.Start(context.Background())
+	<-started
+	s.Stop()
+
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("running job was not cancelled")
+	}
+	if s.IsLeader() {
+		t.Fatal("stopped scheduler is still the leader")
+	}
+}
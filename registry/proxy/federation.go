@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/client/auth"
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage"
+)
+
+// federatedRegistry serves content from the local registry, falling back to
+// its peer registries in order for the manifests, tags and blobs it does not
+// have. Pushes and deletes only apply to the local registry.
+type federatedRegistry struct {
+	distribution.Namespace
+	peers []*federationPeer
+	cache bool
+}
+
+// federationPeer is a peer registry of a federated registry.
+type federationPeer struct {
+	remoteURL      url.URL
+	authChallenger authChallenger
+	basicAuth      auth.CredentialStore
+	transport      http.RoundTripper
+}
+
+// NewFederatedRegistry creates a registry serving the content missing from
+// registry from the configured peer registries.
+func NewFederatedRegistry(ctx context.Context, registry distribution.Namespace, config configuration.Federation) (distribution.Namespace, error) {
+	fr := &federatedRegistry{
+		Namespace: registry,
+		cache:     config.Cache,
+	}
+
+	for _, peer := range config.Peers {
+		remoteURL, err := url.Parse(peer.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid federation peer url %q: %w", peer.URL, err)
+		}
+
+		tr, err := newUpstreamTransport(peer.URL, peer.Transport)
+		if err != nil {
+			return nil, err
+		}
+
+		// The credentials answer every challenge of the peer, rather than
+		// only those of the token servers discovered at startup, so that
+		// peers referring to each other can start in any order.
+		creds := userpass{username: peer.Username, password: peer.Password}
+		fr.peers = append(fr.peers, &federationPeer{
+			remoteURL: *remoteURL,
+			authChallenger: &remoteAuthChallenger{
+				remoteURL: *remoteURL,
+				cm:        challenge.NewSimpleManager(),
+				cs:        creds,
+				transport: tr,
+			},
+			basicAuth: creds,
+			transport: tr,
+		})
+	}
+
+	return fr, nil
+}
+
+func (fr *federatedRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	repo, err := fr.Namespace.Repository(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &federatedRepository{
+		Repository: repo,
+		registry:   fr,
+	}, nil
+}
+
+// Remove removes the repository from the local registry.
+func (fr *federatedRegistry) Remove(ctx context.Context, name reference.Named) error {
+	remover, ok := fr.Namespace.(distribution.RepositoryRemover)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return remover.Remove(ctx, name)
+}
+
+// fromPeers calls fn with the named repository of each peer in turn, until
+// it succeeds, and reports whether it did.
+func (fr *federatedRegistry) fromPeers(ctx context.Context, name reference.Named, fn func(distribution.Repository) error) bool {
+	for _, peer := range fr.peers {
+		err := peer.authChallenger.tryEstablishChallenges(ctx)
+		if err == nil {
+			var remote distribution.Repository
+			remote, err = remoteRepository(ctx, name, peer.remoteURL, peer.authChallenger, peer.basicAuth, peer.transport)
+			if err == nil {
+				err = fn(remote)
+			}
+		}
+		if err == nil {
+			return true
+		}
+		if !contentUnknown(err) {
+			dcontext.GetLogger(ctx).Warnf("federation peer %s failed for %s: %v", peer.remoteURL.String(), name.Name(), err)
+		}
+	}
+	return false
+}
+
+// federatedRepository falls back to the peer registries for the content
+// missing from the local repository.
+type federatedRepository struct {
+	distribution.Repository
+	registry *federatedRegistry
+}
+
+func (fr *federatedRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	local, err := fr.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	fms := &federatedManifestStore{
+		ManifestService: local,
+		repo:            fr,
+	}
+	if fr.registry.cache {
+		// The blobs referenced by a cached manifest are fetched from the
+		// peers when they are pulled.
+		fms.cache, err = fr.Repository.Manifests(ctx, storage.SkipLayerVerification())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fms, nil
+}
+
+func (fr *federatedRepository) Blobs(ctx context.Context) distribution.BlobStore {
+	return &federatedBlobStore{
+		BlobStore: fr.Repository.Blobs(ctx),
+		repo:      fr,
+	}
+}
+
+func (fr *federatedRepository) Tags(ctx context.Context) distribution.TagService {
+	return &federatedTagService{
+		TagService: fr.Repository.Tags(ctx),
+		repo:       fr,
+	}
+}
+
+type federatedManifestStore struct {
+	distribution.ManifestService
+	repo  *federatedRepository
+	cache distribution.ManifestService
+}
+
+func (fms *federatedManifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	exists, err := fms.ManifestService.Exists(ctx, dgst)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	name := fms.repo.Named()
+	return fms.repo.registry.fromPeers(ctx, name, func(remote distribution.Repository) error {
+		manifests, err := remote.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+		exists, err := manifests.Exists(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return distribution.ErrManifestUnknownRevision{Name: name.Name(), Revision: dgst}
+		}
+		return nil
+	}), nil
+}
+
+func (fms *federatedManifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	manifest, err := fms.ManifestService.Get(ctx, dgst, options...)
+	if err == nil || !contentUnknown(err) {
+		return manifest, err
+	}
+
+	var fetched distribution.Manifest
+	found := fms.repo.registry.fromPeers(ctx, fms.repo.Named(), func(remote distribution.Repository) error {
+		manifests, err := remote.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+		m, err := manifests.Get(ctx, dgst, options...)
+		if err != nil {
+			return err
+		}
+		_, payload, err := m.Payload()
+		if err != nil {
+			return err
+		}
+		if dgst.Algorithm().FromBytes(payload) != dgst {
+			return fmt.Errorf("manifest %s does not match its digest", dgst)
+		}
+		fetched = m
+		return nil
+	})
+	if !found {
+		return nil, err
+	}
+
+	if fms.cache != nil {
+		if _, err := fms.cache.Put(ctx, fetched); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error caching manifest %s from federation peer: %v", dgst, err)
+		}
+	}
+	return fetched, nil
+}
+
+type federatedBlobStore struct {
+	distribution.BlobStore
+	repo *federatedRepository
+}
+
+func (fbs *federatedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	desc, err := fbs.BlobStore.Stat(ctx, dgst)
+	if err == nil || !contentUnknown(err) {
+		return desc, err
+	}
+
+	var fetched v1.Descriptor
+	if !fbs.repo.registry.fromPeers(ctx, fbs.repo.Named(), func(remote distribution.Repository) error {
+		var err error
+		fetched, err = remote.Blobs(ctx).Stat(ctx, dgst)
+		return err
+	}) {
+		return v1.Descriptor{}, err
+	}
+	return fetched, nil
+}
+
+func (fbs *federatedBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	blob, err := fbs.BlobStore.Get(ctx, dgst)
+	if err == nil || !contentUnknown(err) {
+		return blob, err
+	}
+
+	var fetched []byte
+	if !fbs.repo.registry.fromPeers(ctx, fbs.repo.Named(), func(remote distribution.Repository) error {
+		p, err := remote.Blobs(ctx).Get(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		if dgst.Algorithm().FromBytes(p) != dgst {
+			return fmt.Errorf("blob %s does not match its digest", dgst)
+		}
+		fetched = p
+		return nil
+	}) {
+		return nil, err
+	}
+
+	if fbs.repo.registry.cache {
+		if _, err := fbs.BlobStore.Put(ctx, "", fetched); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error caching blob %s from federation peer: %v", dgst, err)
+		}
+	}
+	return fetched, nil
+}
+
+func (fbs *federatedBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	rsc, err := fbs.BlobStore.Open(ctx, dgst)
+	if err == nil || !contentUnknown(err) {
+		return rsc, err
+	}
+
+	var fetched io.ReadSeekCloser
+	if !fbs.repo.registry.fromPeers(ctx, fbs.repo.Named(), func(remote distribution.Repository) error {
+		var err error
+		fetched, err = remote.Blobs(ctx).Open(ctx, dgst)
+		return err
+	}) {
+		return nil, err
+	}
+	return fetched, nil
+}
+
+func (fbs *federatedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	err := fbs.BlobStore.ServeBlob(ctx, w, r, dgst)
+	if err == nil || !contentUnknown(err) {
+		return err
+	}
+
+	var remoteBlobs distribution.BlobService
+	var desc v1.Descriptor
+	if !fbs.repo.registry.fromPeers(ctx, fbs.repo.Named(), func(remote distribution.Repository) error {
+		blobs := remote.Blobs(ctx)
+		d, err := blobs.Stat(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		remoteBlobs, desc = blobs, d
+		return nil
+	}) {
+		return err
+	}
+
+	setResponseHeaders(w.Header(), desc.Size, desc.MediaType, dgst)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	rc, err := remoteBlobs.Open(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if !fbs.repo.registry.cache {
+		_, err = io.CopyN(w, rc, desc.Size)
+		return err
+	}
+
+	bw, err := fbs.BlobStore.Create(ctx)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error caching blob %s from federation peer: %v", dgst, err)
+		_, err = io.CopyN(w, rc, desc.Size)
+		return err
+	}
+	if _, err := io.CopyN(io.MultiWriter(w, bw), rc, desc.Size); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	if _, err := bw.Commit(ctx, v1.Descriptor{Digest: dgst, Size: desc.Size, MediaType: desc.MediaType}); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error caching blob %s from federation peer: %v", dgst, err)
+	}
+	return nil
+}
+
+// federatedTagService resolves the tags missing from the local repository
+// with the peers. Tags are mutable, so they are never cached.
+type federatedTagService struct {
+	distribution.TagService
+	repo *federatedRepository
+}
+
+func (fts *federatedTagService) Get(ctx context.Context, tag string) (v1.Descriptor, error) {
+	desc, err := fts.TagService.Get(ctx, tag)
+	if err == nil || !contentUnknown(err) {
+		return desc, err
+	}
+
+	var fetched v1.Descriptor
+	if !fts.repo.registry.fromPeers(ctx, fts.repo.Named(), func(remote distribution.Repository) error {
+		var err error
+		fetched, err = remote.Tags(ctx).Get(ctx, tag)
+		return err
+	}) {
+		return v1.Descriptor{}, err
+	}
+	return fetched, nil
+}
+
+// List lists the tags of the local repository.
+func (fts *federatedTagService) List(ctx context.Context, tags []string, last string) (int, error) {
+	lister, ok := fts.TagService.(distribution.TagLister)
+	if !ok {
+		return 0, distribution.ErrUnsupported
+	}
+	return lister.List(ctx, tags, last)
+}
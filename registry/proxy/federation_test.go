@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// newFederationPeer serves the blobs and tags of the foo/bar repository.
+func newFederationPeer(blobs map[digest.Digest][]byte, tags map[string]digest.Digest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/v2/foo/bar/blobs/"):
+			p, ok := blobs[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/foo/bar/blobs/"))]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(p)))
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(p).String())
+			if r.Method == http.MethodGet {
+				w.Write(p)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v2/foo/bar/manifests/"):
+			dgst, ok := tags[strings.TrimPrefix(r.URL.Path, "/v2/foo/bar/manifests/")]
+			if !ok || r.Method != http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", "2")
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func newFederatedTestRegistry(t *testing.T, cache bool, peers ...string) (distribution.Namespace, distribution.Namespace) {
+	ctx := context.Background()
+	local, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	config := configuration.Federation{Cache: cache}
+	for _, peer := range peers {
+		config.Peers = append(config.Peers, configuration.FederationPeer{URL: peer})
+	}
+	federated, err := NewFederatedRegistry(ctx, local, config)
+	if err != nil {
+		t.Fatalf("error creating federated registry: %v", err)
+	}
+	return local, federated
+}
+
+func TestFederatedBlobs(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+	content := []byte("federated blob content")
+	dgst := digest.FromBytes(content)
+
+	down := newFederationPeer(nil, nil)
+	down.Close()
+	empty := newFederationPeer(nil, nil)
+	defer empty.Close()
+	peer := newFederationPeer(map[digest.Digest][]byte{dgst: content}, nil)
+	defer peer.Close()
+
+	for _, cache := range []bool{false, true} {
+		local, federated := newFederatedTestRegistry(t, cache, down.URL, empty.URL, peer.URL)
+		repo, err := federated.Repository(ctx, name)
+		if err != nil {
+			t.Fatalf("error getting repository: %v", err)
+		}
+		blobs := repo.Blobs(ctx)
+
+		desc, err := blobs.Stat(ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error statting blob from peer: %v", err)
+		}
+		if desc.Size != int64(len(content)) {
+			t.Fatalf("unexpected blob size: %d != %d", desc.Size, len(content))
+		}
+
+		if _, err := blobs.Stat(ctx, digest.FromString("missing")); err != distribution.ErrBlobUnknown {
+			t.Fatalf("expected unknown blob error, got %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "", nil)
+		if err := blobs.ServeBlob(ctx, w, r, dgst); err != nil {
+			t.Fatalf("unexpected error serving blob from peer: %v", err)
+		}
+		if w.Body.String() != string(content) {
+			t.Fatalf("unexpected blob content: %q", w.Body.String())
+		}
+
+		localRepo, err := local.Repository(ctx, name)
+		if err != nil {
+			t.Fatalf("error getting repository: %v", err)
+		}
+		_, err = localRepo.Blobs(ctx).Stat(ctx, dgst)
+		if cache && err != nil {
+			t.Fatalf("expected blob to be cached: %v", err)
+		}
+		if !cache && err != distribution.ErrBlobUnknown {
+			t.Fatalf("unexpected cached blob: %v", err)
+		}
+	}
+}
+
+func TestFederatedTags(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("foo/bar")
+	dgst := digest.FromString("manifest")
+
+	peer := newFederationPeer(nil, map[string]digest.Digest{"latest": dgst})
+	defer peer.Close()
+
+	_, federated := newFederatedTestRegistry(t, true, peer.URL)
+	repo, err := federated.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("error getting repository: %v", err)
+	}
+
+	desc, err := repo.Tags(ctx).Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error resolving tag with peer: %v", err)
+	}
+	if desc.Digest != dgst {
+		t.Fatalf("unexpected tag digest: %s != %s", desc.Digest, dgst)
+	}
+
+	if _, err := repo.Tags(ctx).Get(ctx, "missing"); err == nil {
+		t.Fatal("expected error resolving unknown tag")
+	}
+}
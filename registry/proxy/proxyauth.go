@@ -44,10 +44,10 @@ func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
 }
 
 // configureAuth stores credentials for challenge responses
-func configureAuth(username, password, remoteURL string) (auth.CredentialStore, auth.CredentialStore, error) {
+func configureAuth(username, password, remoteURL string, tr http.RoundTripper) (auth.CredentialStore, auth.CredentialStore, error) {
 	creds := map[string]userpass{}
 
-	authURLs, err := getAuthURLs(remoteURL)
+	authURLs, err := getAuthURLs(remoteURL, tr)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -63,10 +63,10 @@ func configureAuth(username, password, remoteURL string) (auth.CredentialStore,
 	return credentials{creds: creds}, userpass{username: username, password: password}, nil
 }
 
-func getAuthURLs(remoteURL string) ([]string, error) {
+func getAuthURLs(remoteURL string, tr http.RoundTripper) ([]string, error) {
 	authURLs := []string{}
 
-	resp, err := http.Get(remoteURL + "/v2/")
+	resp, err := (&http.Client{Transport: tr}).Get(remoteURL + "/v2/")
 	if err != nil {
 		return nil, err
 	}
@@ -81,8 +81,8 @@ func getAuthURLs(remoteURL string) ([]string, error) {
 	return authURLs, nil
 }
 
-func ping(manager challenge.Manager, endpoint, versionHeader string) error {
-	resp, err := http.Get(endpoint)
+func ping(manager challenge.Manager, endpoint, versionHeader string, tr http.RoundTripper) error {
+	resp, err := (&http.Client{Transport: tr}).Get(endpoint)
 	if err != nil {
 		return err
 	}
@@ -17,6 +17,10 @@ import (
 	"github.com/distribution/reference"
 )
 
+// defaultResumeAttempts is the number of times a blob fetch interrupted by
+// an upstream error is resumed within a request, unless configured.
+const defaultResumeAttempts = 3
+
 type proxyBlobStore struct {
 	localStore     distribution.BlobStore
 	remoteStore    distribution.BlobService
@@ -24,6 +28,10 @@ type proxyBlobStore struct {
 	ttl            *time.Duration
 	repositoryName reference.Named
 	authChallenger authChallenger
+	// resumeAttempts is the number of times an interrupted fetch is resumed
+	// with a range request. Zero selects the default and a negative value
+	// disables resumption.
+	resumeAttempts int
 }
 
 var _ distribution.BlobStore = &proxyBlobStore{}
@@ -31,9 +39,19 @@ var _ distribution.BlobStore = &proxyBlobStore{}
 // inflight tracks currently downloading blobs
 var inflight = make(map[digest.Digest]struct{})
 
-// mu protects inflight
+// partials holds the upload ids of the blobs partially stored by fetches
+// which failed, by repository and digest, so the next fetch can resume them.
+var partials = make(map[string]string)
+
+// mu protects inflight and partials
 var mu sync.Mutex
 
+// partialReader is implemented by blob writers which can read back the data
+// written so far.
+type partialReader interface {
+	Reader() (io.ReadCloser, error)
+}
+
 func setResponseHeaders(h http.Header, length int64, mediaType string, digest digest.Digest) {
 	h.Set("Content-Length", strconv.FormatInt(length, 10))
 	h.Set("Content-Type", mediaType)
@@ -42,14 +60,16 @@ func setResponseHeaders(h http.Header, length int64, mediaType string, digest di
 }
 
 func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest, writer io.Writer, h http.Header) (v1.Descriptor, error) {
-	desc, err := pbs.remoteStore.Stat(ctx, dgst)
+	desc, err := pbs.remoteStat(ctx, dgst)
 	if err != nil {
 		return v1.Descriptor{}, err
 	}
 
 	setResponseHeaders(h, desc.Size, desc.MediaType, dgst)
 
+	start := time.Now()
 	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
+	proxyMetrics.BlobUpstream("Open", start, err)
 	if err != nil {
 		return v1.Descriptor{}, err
 	}
@@ -113,16 +133,20 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 		mu.Unlock()
 	}()
 
-	bw, err := pbs.localStore.Create(ctx)
+	desc, err := pbs.remoteStat(ctx, dgst)
+	if err != nil {
+		return err
+	}
+
+	bw, err := pbs.partialOrCreate(ctx, dgst, desc.Size)
 	if err != nil {
 		return err
 	}
 
 	// Serving client and storing locally over same fetching request.
 	// This can prevent a redundant blob fetching.
-	multiWriter := io.MultiWriter(w, bw)
-	desc, err := pbs.copyContent(ctx, dgst, multiWriter, w.Header())
-	if err != nil {
+	if err := pbs.fetchContent(ctx, desc, w, bw); err != nil {
+		pbs.keepPartial(ctx, dgst, bw)
 		return err
 	}
 
@@ -147,6 +171,148 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 	return nil
 }
 
+// fetchContent serves the blob described by desc to the client while storing
+// it with bw. The data already held by a resumed partial upload is served
+// from local storage and only the remainder is fetched from the remote.
+func (pbs *proxyBlobStore) fetchContent(ctx context.Context, desc v1.Descriptor, w http.ResponseWriter, bw distribution.BlobWriter) error {
+	setResponseHeaders(w.Header(), desc.Size, desc.MediaType, desc.Digest)
+
+	offset := bw.Size()
+	if offset > 0 {
+		rc, err := bw.(partialReader).Reader()
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, rc, offset)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		proxyMetrics.BlobCached(uint64(offset))
+	}
+
+	attempts := pbs.resumeAttempts
+	if attempts == 0 {
+		attempts = defaultResumeAttempts
+	}
+	remoteReader := &resumingReader{
+		ctx:      ctx,
+		remote:   pbs.remoteStore,
+		dgst:     desc.Digest,
+		offset:   offset,
+		attempts: attempts,
+	}
+	defer remoteReader.Close()
+
+	if _, err := io.CopyN(io.MultiWriter(w, bw), remoteReader, desc.Size-offset); err != nil {
+		return err
+	}
+
+	proxyMetrics.BlobPull(uint64(desc.Size - offset))
+	proxyMetrics.BlobPush(uint64(desc.Size), false)
+
+	return nil
+}
+
+// partialOrCreate resumes the upload holding the data of a previous fetch of
+// the blob which failed, or starts a new upload.
+func (pbs *proxyBlobStore) partialOrCreate(ctx context.Context, dgst digest.Digest, size int64) (distribution.BlobWriter, error) {
+	key := pbs.repositoryName.Name() + "@" + dgst.String()
+	mu.Lock()
+	id, ok := partials[key]
+	delete(partials, key)
+	mu.Unlock()
+
+	if ok {
+		bw, err := pbs.localStore.Resume(ctx, id)
+		if err == nil {
+			if _, ok := bw.(partialReader); ok && bw.Size() <= size {
+				dcontext.GetLogger(ctx).Infof("resuming fetch of blob %s from offset %d", dgst, bw.Size())
+				return bw, nil
+			}
+			bw.Cancel(ctx)
+		} else {
+			dcontext.GetLogger(ctx).Warnf("unable to resume partial fetch of blob %s: %v", dgst, err)
+		}
+	}
+
+	return pbs.localStore.Create(ctx)
+}
+
+// keepPartial keeps the data of a failed fetch of the blob, so that the next
+// fetch only requests the remainder from the remote.
+func (pbs *proxyBlobStore) keepPartial(ctx context.Context, dgst digest.Digest, bw distribution.BlobWriter) {
+	if _, ok := bw.(partialReader); !ok || bw.Size() == 0 {
+		bw.Cancel(ctx)
+		return
+	}
+	if err := bw.Close(); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error closing partial fetch of blob %s: %v", dgst, err)
+		bw.Cancel(ctx)
+		return
+	}
+
+	mu.Lock()
+	partials[pbs.repositoryName.Name()+"@"+dgst.String()] = bw.ID()
+	mu.Unlock()
+}
+
+// resumingReader reads a blob from the remote store, reopening it with a
+// range request from the last byte read when the read fails, up to attempts
+// times.
+type resumingReader struct {
+	ctx      context.Context
+	remote   distribution.BlobService
+	dgst     digest.Digest
+	rc       io.ReadSeekCloser
+	offset   int64
+	attempts int
+}
+
+func (rr *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if rr.rc == nil {
+			start := time.Now()
+			rc, err := rr.remote.Open(rr.ctx, rr.dgst)
+			proxyMetrics.BlobUpstream("Open", start, err)
+			if err != nil {
+				return 0, err
+			}
+			if rr.offset > 0 {
+				if _, err := rc.Seek(rr.offset, io.SeekStart); err != nil {
+					rc.Close()
+					return 0, err
+				}
+			}
+			rr.rc = rc
+		}
+
+		n, err := rr.rc.Read(p)
+		rr.offset += int64(n)
+		if err != nil && err != io.EOF && rr.ctx.Err() == nil {
+			proxyMetrics.BlobUpstreamError(err)
+		}
+		if err == nil || err == io.EOF || rr.attempts <= 0 || rr.ctx.Err() != nil {
+			return n, err
+		}
+
+		rr.attempts--
+		dcontext.GetLogger(rr.ctx).Warnf("resuming fetch of blob %s from offset %d after error: %v", rr.dgst, rr.offset, err)
+		rr.rc.Close()
+		rr.rc = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (rr *resumingReader) Close() error {
+	if rr.rc == nil {
+		return nil
+	}
+	return rr.rc.Close()
+}
+
 func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
 	desc, err := pbs.localStore.Stat(ctx, dgst)
 	if err == nil {
@@ -161,7 +327,15 @@ func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (v1.Des
 		return v1.Descriptor{}, err
 	}
 
-	return pbs.remoteStore.Stat(ctx, dgst)
+	return pbs.remoteStat(ctx, dgst)
+}
+
+// remoteStat stats the blob in the remote store, tracking the request.
+func (pbs *proxyBlobStore) remoteStat(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	start := time.Now()
+	desc, err := pbs.remoteStore.Stat(ctx, dgst)
+	proxyMetrics.BlobUpstream("Stat", start, err)
+	return desc, err
 }
 
 func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
@@ -174,7 +348,9 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 		return []byte{}, err
 	}
 
+	start := time.Now()
 	blob, err = pbs.remoteStore.Get(ctx, dgst)
+	proxyMetrics.BlobUpstream("Get", start, err)
 	if err != nil {
 		return []byte{}, err
 	}
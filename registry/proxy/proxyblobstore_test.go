@@ -2,10 +2,12 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -332,6 +334,121 @@ func TestProxyStoreServeBig(t *testing.T) {
 	testProxyStoreServe(t, te, numClients)
 }
 
+// flakyBlobStore fails reads from the blobs it opens after failAfter bytes,
+// failures times, and records the offsets blobs are opened at.
+type flakyBlobStore struct {
+	distribution.BlobService
+	failAfter int64
+	failures  int
+	offsets   []int64
+}
+
+func (fbs *flakyBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	rsc, err := fbs.BlobService.Open(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyReader{ReadSeekCloser: rsc, store: fbs}, nil
+}
+
+type flakyReader struct {
+	io.ReadSeekCloser
+	store  *flakyBlobStore
+	offset int64
+	read   int64
+}
+
+func (fr *flakyReader) Seek(offset int64, whence int) (int64, error) {
+	fr.offset = offset
+	return fr.ReadSeekCloser.Seek(offset, whence)
+}
+
+func (fr *flakyReader) Read(p []byte) (int, error) {
+	if fr.read == 0 {
+		fr.store.offsets = append(fr.store.offsets, fr.offset)
+	}
+	if fr.store.failures > 0 && fr.read >= fr.store.failAfter {
+		fr.store.failures--
+		return 0, errors.New("connection reset")
+	}
+	if fr.store.failures > 0 && int64(len(p)) > fr.store.failAfter-fr.read {
+		p = p[:fr.store.failAfter-fr.read]
+	}
+	n, err := fr.ReadSeekCloser.Read(p)
+	fr.read += int64(n)
+	return n, err
+}
+
+func serveProxyBlob(te *testEnv, dgst digest.Digest) ([]byte, error) {
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = te.store.ServeBlob(te.ctx, w, r, dgst)
+	return w.Body.Bytes(), err
+}
+
+func TestProxyStoreServeResume(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 1000, 1)
+	remote := &flakyBlobStore{BlobService: te.store.remoteStore, failAfter: 300, failures: 2}
+	te.store.remoteStore = remote
+	proxyMetrics = &proxyMetricsCollector{}
+	dgst := te.inRemote[0].Digest
+
+	body, err := serveProxyBlob(te, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if digest.FromBytes(body) != dgst {
+		t.Fatalf("mismatching blob fetch from proxy")
+	}
+	if expected := []int64{0, 300, 600}; !reflect.DeepEqual(remote.offsets, expected) {
+		t.Fatalf("unexpected fetch offsets: %v != %v", remote.offsets, expected)
+	}
+	if proxyMetrics.blobMetrics.UpstreamErrors != 2 {
+		t.Errorf("Expected blobMetrics.UpstreamErrors %d but got %d", 2, proxyMetrics.blobMetrics.UpstreamErrors)
+	}
+	if _, err := te.store.localStore.Stat(te.ctx, dgst); err != nil {
+		t.Fatalf("expected resumed blob to be cached: %v", err)
+	}
+}
+
+func TestProxyStoreServePartial(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 1000, 1)
+	remote := &flakyBlobStore{BlobService: te.store.remoteStore, failAfter: 400, failures: 1}
+	te.store.remoteStore = remote
+	te.store.resumeAttempts = -1
+	dgst := te.inRemote[0].Digest
+	proxyMetrics = &proxyMetricsCollector{}
+
+	if _, err := serveProxyBlob(te, dgst); err == nil {
+		t.Fatal("expected error serving blob from failing remote")
+	}
+	if _, err := te.store.localStore.Stat(te.ctx, dgst); err == nil {
+		t.Fatal("unexpected partial blob in cache")
+	}
+
+	body, err := serveProxyBlob(te, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if digest.FromBytes(body) != dgst {
+		t.Fatalf("mismatching blob fetch from proxy")
+	}
+	if expected := []int64{0, 400}; !reflect.DeepEqual(remote.offsets, expected) {
+		t.Fatalf("unexpected fetch offsets: %v != %v", remote.offsets, expected)
+	}
+	if proxyMetrics.blobMetrics.BytesCached != 400 {
+		t.Errorf("Expected blobMetrics.BytesCached %d but got %d", 400, proxyMetrics.blobMetrics.BytesCached)
+	}
+	if _, err := te.store.localStore.Stat(te.ctx, dgst); err != nil {
+		t.Fatalf("expected blob to be cached: %v", err)
+	}
+}
+
 func TestProxyStoreServeMetrics(t *testing.T) {
 	te := makeTestEnv(t, "foo/bar")
 
@@ -351,6 +468,7 @@ func TestProxyStoreServeMetrics(t *testing.T) {
 			Misses:      uint64(blobCount),
 			BytesPushed: uint64(blobSize*blobCount*numClients + blobSize*blobCount),
 			BytesPulled: uint64(blobSize * blobCount),
+			BytesCached: uint64(blobSize * blobCount),
 		},
 	}
 
@@ -369,6 +487,9 @@ func TestProxyStoreServeMetrics(t *testing.T) {
 	if proxyMetrics.blobMetrics.BytesPulled != expected.blobMetrics.BytesPulled {
 		t.Errorf("Expected blobMetrics.BytesPulled %d but got %d", expected.blobMetrics.BytesPulled, proxyMetrics.blobMetrics.BytesPulled)
 	}
+	if proxyMetrics.blobMetrics.BytesCached != expected.blobMetrics.BytesCached {
+		t.Errorf("Expected blobMetrics.BytesCached %d but got %d", expected.blobMetrics.BytesCached, proxyMetrics.blobMetrics.BytesCached)
+	}
 }
 
 func TestProxyStoreServeMetricsConcurrent(t *testing.T) {
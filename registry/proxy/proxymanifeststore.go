@@ -35,7 +35,10 @@ func (pms proxyManifestStore) Exists(ctx context.Context, dgst digest.Digest) (b
 	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
 		return false, err
 	}
-	return pms.remoteManifests.Exists(ctx, dgst)
+	start := time.Now()
+	exists, err = pms.remoteManifests.Exists(ctx, dgst)
+	proxyMetrics.ManifestUpstream("Exists", start, err)
+	return exists, err
 }
 
 func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
@@ -48,7 +51,9 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 			return nil, err
 		}
 
+		start := time.Now()
 		manifest, err = pms.remoteManifests.Get(ctx, dgst, options...)
+		proxyMetrics.ManifestUpstream("Get", start, err)
 		if err != nil {
 			return nil, err
 		}
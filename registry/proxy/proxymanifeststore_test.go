@@ -340,4 +340,10 @@ func TestProxyManifestsMetrics(t *testing.T) {
 	if proxyMetrics.manifestMetrics.BytesPushed != (env.manifestSize * 2) {
 		t.Errorf("Expected manifestMetrics.BytesPushed %d but got %d", env.manifestSize*2, proxyMetrics.manifestMetrics.BytesPushed)
 	}
+	if proxyMetrics.manifestMetrics.BytesCached != env.manifestSize {
+		t.Errorf("Expected manifestMetrics.BytesCached %d but got %d", env.manifestSize, proxyMetrics.manifestMetrics.BytesCached)
+	}
+	if proxyMetrics.manifestMetrics.UpstreamErrors != 0 {
+		t.Errorf("Expected manifestMetrics.UpstreamErrors %d but got %d", 0, proxyMetrics.manifestMetrics.UpstreamErrors)
+	}
 }
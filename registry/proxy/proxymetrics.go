@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"errors"
 	"expvar"
 	"sync/atomic"
+	"time"
 
+	"github.com/distribution/distribution/v3"
 	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/docker/go-metrics"
 )
 
@@ -19,6 +23,12 @@ var (
 	pulledBytes = prometheus.ProxyNamespace.NewLabeledCounter("pulled_bytes", "The size of total bytes pulled from the upstream", "type")
 	// pushedBytes is the size of total bytes pushed to the client for blob/manifest
 	pushedBytes = prometheus.ProxyNamespace.NewLabeledCounter("pushed_bytes", "The size of total bytes pushed to the client", "type")
+	// cachedBytes is the size of total bytes pushed to the client from the local cache for blob/manifest
+	cachedBytes = prometheus.ProxyNamespace.NewLabeledCounter("cached_bytes", "The size of total bytes pushed to the client from the local cache", "type")
+	// upstreamErrors is the number of total failed requests to the upstream for blob/manifest
+	upstreamErrors = prometheus.ProxyNamespace.NewLabeledCounter("upstream_errors", "The number of total failed requests to the upstream", "type")
+	// upstreamDuration is the latency of requests to the upstream for blob/manifest by operation
+	upstreamDuration = prometheus.ProxyNamespace.NewLabeledTimer("upstream_request_duration", "The latency of requests to the upstream", "type", "operation")
 )
 
 // Metrics is used to hold metric counters
@@ -29,6 +39,10 @@ type Metrics struct {
 	Misses      uint64
 	BytesPulled uint64
 	BytesPushed uint64
+	BytesCached uint64
+	// UpstreamErrors counts the requests to the upstream which failed,
+	// other than for content the upstream does not have.
+	UpstreamErrors uint64
 }
 
 type proxyMetricsCollector struct {
@@ -72,6 +86,8 @@ func initPrometheusMetrics(value string) {
 	misses.WithValues(value).Inc(0)
 	pulledBytes.WithValues(value).Inc(0)
 	pushedBytes.WithValues(value).Inc(0)
+	cachedBytes.WithValues(value).Inc(0)
+	upstreamErrors.WithValues(value).Inc(0)
 }
 
 // BlobPull tracks metrics about blobs pulled into the cache
@@ -95,6 +111,31 @@ func (pmc *proxyMetricsCollector) BlobPush(bytesPushed uint64, isHit bool) {
 		atomic.AddUint64(&pmc.blobMetrics.Hits, 1)
 
 		hits.WithValues("blob").Inc(1)
+		pmc.BlobCached(bytesPushed)
+	}
+}
+
+// BlobCached tracks the bytes of blobs pushed to clients from the cache,
+// which did not have to be pulled from the upstream
+func (pmc *proxyMetricsCollector) BlobCached(bytesCached uint64) {
+	atomic.AddUint64(&pmc.blobMetrics.BytesCached, bytesCached)
+
+	cachedBytes.WithValues("blob").Inc(float64(bytesCached))
+}
+
+// BlobUpstream tracks the latency and failures of blob requests of the given
+// operation to the upstream
+func (pmc *proxyMetricsCollector) BlobUpstream(operation string, start time.Time, err error) {
+	upstreamDuration.WithValues("blob", operation).UpdateSince(start)
+	pmc.BlobUpstreamError(err)
+}
+
+// BlobUpstreamError tracks failures of blob requests to the upstream
+func (pmc *proxyMetricsCollector) BlobUpstreamError(err error) {
+	if upstreamFailed(err) {
+		atomic.AddUint64(&pmc.blobMetrics.UpstreamErrors, 1)
+
+		upstreamErrors.WithValues("blob").Inc(1)
 	}
 }
 
@@ -117,7 +158,55 @@ func (pmc *proxyMetricsCollector) ManifestPush(bytesPushed uint64, isHit bool) {
 
 	if isHit {
 		atomic.AddUint64(&pmc.manifestMetrics.Hits, 1)
+		atomic.AddUint64(&pmc.manifestMetrics.BytesCached, bytesPushed)
 
 		hits.WithValues("manifest").Inc(1)
+		cachedBytes.WithValues("manifest").Inc(float64(bytesPushed))
+	}
+}
+
+// ManifestUpstream tracks the latency and failures of manifest requests of
+// the given operation to the upstream
+func (pmc *proxyMetricsCollector) ManifestUpstream(operation string, start time.Time, err error) {
+	upstreamDuration.WithValues("manifest", operation).UpdateSince(start)
+
+	if upstreamFailed(err) {
+		atomic.AddUint64(&pmc.manifestMetrics.UpstreamErrors, 1)
+
+		upstreamErrors.WithValues("manifest").Inc(1)
+	}
+}
+
+// upstreamFailed reports whether err is a failure of a request to the
+// upstream. The upstream not having the requested content is not a failure.
+func upstreamFailed(err error) bool {
+	return err != nil && !contentUnknown(err)
+}
+
+// contentUnknown reports whether err reports that the requested blob,
+// manifest or tag is unknown.
+func contentUnknown(err error) bool {
+	if errors.Is(err, distribution.ErrBlobUnknown) {
+		return true
+	}
+
+	var unknownRevision distribution.ErrManifestUnknownRevision
+	var unknownManifest distribution.ErrManifestUnknown
+	var unknownTag distribution.ErrTagUnknown
+	if errors.As(err, &unknownRevision) || errors.As(err, &unknownManifest) || errors.As(err, &unknownTag) {
+		return true
 	}
+
+	var errs errcode.Errors
+	if errors.As(err, &errs) && len(errs) > 0 {
+		for _, e := range errs {
+			var ec errcode.Error
+			if !errors.As(e, &ec) || (ec.Code != errcode.ErrorCodeManifestUnknown && ec.Code != errcode.ErrorCodeBlobUnknown) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
 }
@@ -32,15 +32,40 @@ type proxyingRegistry struct {
 	remoteURL      url.URL
 	authChallenger authChallenger
 	basicAuth      auth.CredentialStore
+	resumeAttempts int
+	transport      http.RoundTripper
+}
+
+// Option configures a registry created by NewRegistryPullThroughCache.
+type Option func(*options)
+
+type options struct {
+	schedulerStore scheduler.StateStore
+}
+
+// WithSchedulerStateStore persists the expiry times of cached content with
+// store, rather than in a file in the storage backend.
+func WithSchedulerStateStore(store scheduler.StateStore) Option {
+	return func(o *options) {
+		o.schedulerStore = store
+	}
 }
 
 // NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy, opts ...Option) (distribution.Namespace, error) {
 	remoteURL, err := url.Parse(config.RemoteURL)
 	if err != nil {
 		return nil, err
 	}
 
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.schedulerStore == nil {
+		o.schedulerStore = scheduler.NewDriverStateStore(driver, "/scheduler-state.json")
+	}
+
 	v := storage.NewVacuum(ctx, driver)
 
 	var s *scheduler.TTLExpirationScheduler
@@ -56,7 +81,7 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 	}
 
 	if ttl != nil {
-		s = scheduler.New(ctx, driver, "/scheduler-state.json")
+		s = scheduler.NewWithStore(ctx, o.schedulerStore, config.Scheduler.SaveInterval)
 		s.OnBlobExpire(func(ref reference.Reference) error {
 			var r reference.Canonical
 			var ok bool
@@ -114,13 +139,18 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		}
 	}
 
+	tr, err := newUpstreamTransport(config.RemoteURL, config.Transport)
+	if err != nil {
+		return nil, err
+	}
+
 	cs, b, err := func() (auth.CredentialStore, auth.CredentialStore, error) {
 		switch {
 		case config.Exec != nil:
 			cs, err := configureExecAuth(*config.Exec)
 			return cs, cs, err
 		default:
-			return configureAuth(config.Username, config.Password, config.RemoteURL)
+			return configureAuth(config.Username, config.Password, config.RemoteURL, tr)
 		}
 	}()
 	if err != nil {
@@ -136,8 +166,11 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 			remoteURL: *remoteURL,
 			cm:        challenge.NewSimpleManager(),
 			cs:        cs,
+			transport: tr,
 		},
-		basicAuth: b,
+		basicAuth:      b,
+		resumeAttempts: config.ResumeAttempts,
+		transport:      tr,
 	}, nil
 }
 
@@ -150,25 +183,6 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 }
 
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
-	c := pr.authChallenger
-
-	tkopts := auth.TokenHandlerOptions{
-		Transport:   http.DefaultTransport,
-		Credentials: c.credentialStore(),
-		Scopes: []auth.Scope{
-			auth.RepositoryScope{
-				Repository: name.Name(),
-				Actions:    []string{"pull"},
-			},
-		},
-		Logger: dcontext.GetLogger(ctx),
-	}
-
-	tr := transport.NewTransport(http.DefaultTransport,
-		auth.NewAuthorizer(c.challengeManager(),
-			auth.NewTokenHandlerWithOptions(tkopts),
-			auth.NewBasicHandler(pr.basicAuth)))
-
 	localRepo, err := pr.embedded.Repository(ctx, name)
 	if err != nil {
 		return nil, err
@@ -178,7 +192,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	remoteRepo, err := client.NewRepository(name, pr.remoteURL.String(), tr)
+	remoteRepo, err := remoteRepository(ctx, name, pr.remoteURL, pr.authChallenger, pr.basicAuth, pr.transport)
 	if err != nil {
 		return nil, err
 	}
@@ -196,6 +210,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			ttl:            pr.ttl,
 			repositoryName: name,
 			authChallenger: pr.authChallenger,
+			resumeAttempts: pr.resumeAttempts,
 		},
 		manifests: &proxyManifestStore{
 			repositoryName:  name,
@@ -215,6 +230,28 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 	}, nil
 }
 
+// remoteRepository creates a client for the named repository of the remote
+// registry, answering its authentication challenges with the credentials of
+// the auth challenger.
+func remoteRepository(ctx context.Context, name reference.Named, remoteURL url.URL, c authChallenger, basicAuth auth.CredentialStore, tr http.RoundTripper) (distribution.Repository, error) {
+	tkopts := auth.TokenHandlerOptions{
+		Transport:   tr,
+		Credentials: c.credentialStore(),
+		Scopes: []auth.Scope{
+			auth.RepositoryScope{
+				Repository: name.Name(),
+				Actions:    []string{"pull"},
+			},
+		},
+		Logger: dcontext.GetLogger(ctx),
+	}
+
+	return client.NewRepository(name, remoteURL.String(), transport.NewTransport(tr,
+		auth.NewAuthorizer(c.challengeManager(),
+			auth.NewTokenHandlerWithOptions(tkopts),
+			auth.NewBasicHandler(basicAuth))))
+}
+
 func (pr *proxyingRegistry) Blobs() distribution.BlobEnumerator {
 	return pr.embedded.Blobs()
 }
@@ -242,8 +279,9 @@ type authChallenger interface {
 type remoteAuthChallenger struct {
 	remoteURL url.URL
 	sync.Mutex
-	cm challenge.Manager
-	cs auth.CredentialStore
+	cm        challenge.Manager
+	cs        auth.CredentialStore
+	transport http.RoundTripper
 }
 
 func (r *remoteAuthChallenger) credentialStore() auth.CredentialStore {
@@ -271,7 +309,7 @@ func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error
 	}
 
 	// establish challenge type with upstream
-	if err := ping(r.cm, remoteURL.String(), challengeHeader); err != nil {
+	if err := ping(r.cm, remoteURL.String(), challengeHeader, r.transport); err != nil {
 		return err
 	}
 
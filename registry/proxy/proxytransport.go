@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/client/transport"
+	"github.com/distribution/distribution/v3/internal/spiffe"
+)
+
+// newUpstreamTransport creates the transport used for all requests to the
+// remote registry at remoteURL and its authentication servers.
+func newUpstreamTransport(remoteURL string, config configuration.ProxyTransport) (http.RoundTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.HTTPProxy != "" || config.HTTPSProxy != "" {
+		proxy, err := proxyFunc(config)
+		if err != nil {
+			return nil, err
+		}
+		base.Proxy = proxy
+	}
+
+	if len(config.CAs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, ca := range config.CAs {
+			caPem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("failed reading upstream CA: %w", err)
+			}
+			if ok := pool.AppendCertsFromPEM(caPem); !ok {
+				return nil, fmt.Errorf("could not add upstream CA %s to pool", ca)
+			}
+		}
+		base.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if config.MaxIdleConns > 0 {
+		base.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		base.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.MaxConnsPerHost > 0 {
+		base.MaxConnsPerHost = config.MaxConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		base.IdleConnTimeout = config.IdleConnTimeout
+	}
+
+	var rt http.RoundTripper = base
+	if config.SPIFFE.Enabled {
+		st, err := newSPIFFETransport(remoteURL, config.SPIFFE, base)
+		if err != nil {
+			return nil, err
+		}
+		rt = st
+	}
+
+	if config.UserAgent == "" {
+		return rt, nil
+	}
+	return transport.NewTransport(rt, transport.NewHeaderRequestModifier(http.Header{
+		"User-Agent": []string{config.UserAgent},
+	})), nil
+}
+
+// spiffeTransport sends the requests to the host of the remote through a
+// transport authenticating with the SVID of the registry, and verifying the
+// remote by its own. Requests to other hosts, such as token servers and the
+// storage the remote redirects blob downloads to, are verified by their
+// certificates as usual.
+type spiffeTransport struct {
+	host   string
+	remote http.RoundTripper
+	other  http.RoundTripper
+}
+
+// newSPIFFETransport returns a spiffeTransport for the remote at remoteURL,
+// sending the requests to other hosts through base.
+func newSPIFFETransport(remoteURL string, config configuration.SPIFFE, base *http.Transport) (*spiffeTransport, error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote url %q: %w", remoteURL, err)
+	}
+	source, err := spiffe.SourceFor(config.EndpointSocket)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := source.TLSConfig(config.ServerIDs)
+	if err != nil {
+		return nil, err
+	}
+	remote := base.Clone()
+	remote.TLSClientConfig = tlsConfig
+	return &spiffeTransport{
+		host:   canonicalHost(u),
+		remote: remote,
+		other:  base,
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *spiffeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if canonicalHost(req.URL) == t.host {
+		return t.remote.RoundTrip(req)
+	}
+	return t.other.RoundTrip(req)
+}
+
+// canonicalHost returns the lower case host and port of u, with the default
+// port of its scheme if none is given.
+func canonicalHost(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// Ping checks that the remote registry at remoteURL answers requests to its
+// base API route through the transport configured, whether or not it
+// requires authentication.
+func Ping(ctx context.Context, remoteURL string, config configuration.ProxyTransport) error {
+	tr, err := newUpstreamTransport(remoteURL, config)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(remoteURL, "/")+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, req.URL)
+	}
+	return nil
+}
+
+// proxyFunc returns the function selecting the outbound proxy for a request
+// to the remote, which is none for hosts matching the no proxy list.
+func proxyFunc(config configuration.ProxyTransport) (func(*http.Request) (*url.URL, error), error) {
+	var httpProxy, httpsProxy *url.URL
+	var err error
+	if config.HTTPProxy != "" {
+		if httpProxy, err = url.Parse(config.HTTPProxy); err != nil {
+			return nil, fmt.Errorf("invalid http proxy: %w", err)
+		}
+	}
+	if config.HTTPSProxy != "" {
+		if httpsProxy, err = url.Parse(config.HTTPSProxy); err != nil {
+			return nil, fmt.Errorf("invalid https proxy: %w", err)
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy(config.NoProxy, req.URL.Hostname()) {
+			return nil, nil
+		}
+		if req.URL.Scheme == "https" {
+			return httpsProxy, nil
+		}
+		return httpProxy, nil
+	}, nil
+}
+
+// noProxy reports whether host matches one of the hosts, or domains when
+// prefixed with a dot, to connect to directly.
+func noProxy(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == "*" {
+			return true
+		}
+		if strings.HasPrefix(h, ".") {
+			if strings.HasSuffix(host, h) || host == h[1:] {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(h, host) {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(h); err == nil {
+			if ip := net.ParseIP(host); ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
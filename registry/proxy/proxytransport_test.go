@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestUpstreamTransportUserAgent(t *testing.T) {
+	var userAgent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+	}))
+	defer ts.Close()
+
+	tr, err := newUpstreamTransport("https://registry.example.com", configuration.ProxyTransport{UserAgent: "mirror/1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: tr}).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if userAgent != "mirror/1.0" {
+		t.Fatalf("unexpected user agent: %q", userAgent)
+	}
+}
+
+func TestUpstreamTransportProxy(t *testing.T) {
+	proxy, err := proxyFunc(configuration.ProxyTransport{
+		HTTPProxy:  "http://proxy.example.com:3128",
+		HTTPSProxy: "http://secure-proxy.example.com:3128",
+		NoProxy:    []string{"localhost", ".internal.example.com", "10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{url: "http://registry.example.com/v2/", expected: "http://proxy.example.com:3128"},
+		{url: "https://registry.example.com/v2/", expected: "http://secure-proxy.example.com:3128"},
+		{url: "https://localhost:5000/v2/"},
+		{url: "https://registry.internal.example.com/v2/"},
+		{url: "https://internal.example.com/v2/"},
+		{url: "https://10.1.2.3/v2/"},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxyURL, err := proxy(&http.Request{URL: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual string
+		if proxyURL != nil {
+			actual = proxyURL.String()
+		}
+		if actual != tc.expected {
+			t.Errorf("unexpected proxy for %s: %q != %q", tc.url, actual, tc.expected)
+		}
+	}
+}
+
+func TestUpstreamTransportInvalidCA(t *testing.T) {
+	if _, err := newUpstreamTransport("https://registry.example.com", configuration.ProxyTransport{CAs: []string{"/nonexistent/ca.pem"}}); err == nil {
+		t.Fatal("expected error for missing CA file")
+	}
+}
+
+func TestUpstreamTransportSPIFFE(t *testing.T) {
+	t.Setenv("SPIFFE_ENDPOINT_SOCKET", "")
+	if _, err := newUpstreamTransport("https://registry.example.com", configuration.ProxyTransport{SPIFFE: configuration.SPIFFE{Enabled: true}}); err == nil {
+		t.Fatal("expected error without workload api address")
+	}
+	if _, err := newUpstreamTransport("https://registry.example.com", configuration.ProxyTransport{
+		SPIFFE: configuration.SPIFFE{
+			Enabled:        true,
+			EndpointSocket: "unix:///run/spire/agent.sock",
+			ServerIDs:      []string{"https://example.org/upstream"},
+		},
+	}); err == nil {
+		t.Fatal("expected error for invalid server id")
+	}
+
+	tr, err := newUpstreamTransport("https://Registry.example.com", configuration.ProxyTransport{
+		SPIFFE: configuration.SPIFFE{Enabled: true, EndpointSocket: "unix:///run/spire/agent.sock"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := tr.(*spiffeTransport)
+	if config := st.remote.(*http.Transport).TLSClientConfig; config == nil || config.GetClientCertificate == nil {
+		t.Fatal("expected client certificates from the workload api for the remote")
+	}
+	if config := st.other.(*http.Transport).TLSClientConfig; config != nil && config.GetClientCertificate != nil {
+		t.Fatal("unexpected client certificates for other hosts")
+	}
+
+	// Only the requests to the host of the remote are sent with the SVID.
+	var sent []string
+	st.remote = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, "remote "+req.URL.String())
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	st.other = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, "other "+req.URL.String())
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	for _, u := range []string{
+		"https://registry.example.com/v2/",
+		"https://registry.example.com:443/v2/library/alpine/blobs/sha256:a",
+		"https://auth.example.com/token",
+		"https://registry.example.com:5000/v2/",
+		"http://registry.example.com/v2/",
+		"https://storage.example.net/blobs/a",
+	} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tr.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"remote https://registry.example.com/v2/",
+		"remote https://registry.example.com:443/v2/library/alpine/blobs/sha256:a",
+		"other https://auth.example.com/token",
+		"other https://registry.example.com:5000/v2/",
+		"other http://registry.example.com/v2/",
+		"other https://storage.example.net/blobs/a",
+	}
+	if strings.Join(sent, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected requests %q, got %q", expected, sent)
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
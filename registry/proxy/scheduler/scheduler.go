@@ -31,16 +31,26 @@ type schedulerEntry struct {
 	timer *time.Timer
 }
 
-// New returns a new instance of the scheduler
+// New returns a new instance of the scheduler, persisting its state in the
+// JSON file at path on driver.
 func New(ctx context.Context, driver driver.StorageDriver, path string) *TTLExpirationScheduler {
+	return NewWithStore(ctx, NewDriverStateStore(driver, path), 0)
+}
+
+// NewWithStore returns a new instance of the scheduler, persisting its state
+// with store every saveInterval. A zero saveInterval selects the default.
+func NewWithStore(ctx context.Context, store StateStore, saveInterval time.Duration) *TTLExpirationScheduler {
+	if saveInterval <= 0 {
+		saveInterval = indexSaveFrequency
+	}
 	return &TTLExpirationScheduler{
-		entries:         make(map[string]*schedulerEntry),
-		driver:          driver,
-		pathToStateFile: path,
-		ctx:             ctx,
-		stopped:         true,
-		doneChan:        make(chan struct{}),
-		saveTimer:       time.NewTicker(indexSaveFrequency),
+		entries:   make(map[string]*schedulerEntry),
+		store:     store,
+		ctx:       ctx,
+		stopped:   true,
+		doneChan:  make(chan struct{}),
+		saveTimer: time.NewTicker(saveInterval),
+		dirty:     make(map[string]struct{}),
 	}
 }
 
@@ -51,18 +61,19 @@ type TTLExpirationScheduler struct {
 
 	entries map[string]*schedulerEntry
 
-	driver          driver.StorageDriver
-	ctx             context.Context
-	pathToStateFile string
+	store StateStore
+	ctx   context.Context
 
 	stopped bool
 
 	onBlobExpire     expiryFunc
 	onManifestExpire expiryFunc
 
-	indexDirty bool
-	saveTimer  *time.Ticker
-	doneChan   chan struct{}
+	// dirty holds the keys of the entries added or removed since the
+	// state was last saved.
+	dirty     map[string]struct{}
+	saveTimer *time.Ticker
+	doneChan  chan struct{}
 }
 
 // OnBlobExpire is called when a scheduled blob's TTL expires
@@ -136,7 +147,7 @@ func (ttles *TTLExpirationScheduler) Start() error {
 			select {
 			case <-ttles.saveTimer.C:
 				ttles.Lock()
-				if !ttles.indexDirty {
+				if len(ttles.dirty) == 0 {
 					ttles.Unlock()
 					continue
 				}
@@ -144,8 +155,6 @@ func (ttles *TTLExpirationScheduler) Start() error {
 				err := ttles.writeState()
 				if err != nil {
 					dcontext.GetLogger(ttles.ctx).Errorf("Error writing scheduler state: %s", err)
-				} else {
-					ttles.indexDirty = false
 				}
 				ttles.Unlock()
 
@@ -170,7 +179,7 @@ func (ttles *TTLExpirationScheduler) add(r reference.Reference, ttl time.Duratio
 	}
 	ttles.entries[entry.Key] = entry
 	entry.timer = ttles.startTimer(entry, ttl)
-	ttles.indexDirty = true
+	ttles.dirty[entry.Key] = struct{}{}
 }
 
 func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.Duration) *time.Timer {
@@ -201,7 +210,7 @@ func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.
 		}
 
 		delete(ttles.entries, entry.Key)
-		ttles.indexDirty = true
+		ttles.dirty[entry.Key] = struct{}{}
 	})
 }
 
@@ -225,38 +234,47 @@ func (ttles *TTLExpirationScheduler) Stop() error {
 	return err
 }
 
+// writeState saves the entries added or removed since the last save.
 func (ttles *TTLExpirationScheduler) writeState() error {
-	jsonBytes, err := json.Marshal(ttles.entries)
-	if err != nil {
-		return err
+	if len(ttles.dirty) == 0 {
+		return nil
 	}
 
-	err = ttles.driver.PutContent(ttles.ctx, ttles.pathToStateFile, jsonBytes)
-	if err != nil {
+	changed := make(map[string]json.RawMessage)
+	var deleted []string
+	for key := range ttles.dirty {
+		entry, ok := ttles.entries[key]
+		if !ok {
+			deleted = append(deleted, key)
+			continue
+		}
+		jsonBytes, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		changed[key] = jsonBytes
+	}
+
+	if err := ttles.store.Save(ttles.ctx, changed, deleted); err != nil {
 		return err
 	}
 
+	ttles.dirty = make(map[string]struct{})
 	return nil
 }
 
 func (ttles *TTLExpirationScheduler) readState() error {
-	if _, err := ttles.driver.Stat(ttles.ctx, ttles.pathToStateFile); err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
-			return nil
-		default:
-			return err
-		}
-	}
-
-	bytes, err := ttles.driver.GetContent(ttles.ctx, ttles.pathToStateFile)
+	entries, err := ttles.store.Load(ttles.ctx)
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(bytes, &ttles.entries)
-	if err != nil {
-		return err
+	for key, jsonBytes := range entries {
+		var entry schedulerEntry
+		if err := json.Unmarshal(jsonBytes, &entry); err != nil {
+			return err
+		}
+		ttles.entries[key] = &entry
 	}
 	return nil
 }
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"encoding/json"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -215,3 +216,32 @@ func TestDoubleStart(t *testing.T) {
 		t.Fatal("Scheduler started twice without error")
 	}
 }
+
+func TestDriverStateStore(t *testing.T) {
+	ctx := dcontext.Background()
+	fs := inmemory.New()
+	store := NewDriverStateStore(fs, "/ttl")
+
+	entries, err := store.Load(ctx)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected initial state: %v, %v", entries, err)
+	}
+	if err := store.Save(ctx, map[string]json.RawMessage{"a": []byte(`1`), "b": []byte(`2`)}, nil); err != nil {
+		t.Fatalf("unexpected error saving state: %v", err)
+	}
+	if err := store.Save(ctx, map[string]json.RawMessage{"c": []byte(`3`)}, []string{"a"}); err != nil {
+		t.Fatalf("unexpected error saving state: %v", err)
+	}
+	if _, err := fs.Stat(ctx, "/ttl.tmp"); err == nil {
+		t.Error("temporary state file left behind")
+	}
+
+	entries, err = NewDriverStateStore(fs, "/ttl").Load(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %v", err)
+	}
+	expected := map[string]json.RawMessage{"b": []byte(`2`), "c": []byte(`3`)}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("unexpected state: %s != %s", entries, expected)
+	}
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/redis/go-redis/v9"
+)
+
+// StateStore persists the entries of a scheduler, so they survive restarts
+// and can be shared by the registry instances of a proxy cache. Entries are
+// encoded as JSON and identified by their key.
+type StateStore interface {
+	// Load returns the persisted entries.
+	Load(ctx context.Context) (map[string]json.RawMessage, error)
+
+	// Save persists the entries changed since the last save and removes
+	// the entries whose keys were deleted.
+	Save(ctx context.Context, changed map[string]json.RawMessage, deleted []string) error
+}
+
+// driverStateStore persists the entries in a single JSON file on a storage
+// driver.
+type driverStateStore struct {
+	driver  driver.StorageDriver
+	path    string
+	entries map[string]json.RawMessage
+}
+
+// NewDriverStateStore returns a StateStore keeping the entries in the JSON
+// file at path. The file is replaced as a whole on each save, by writing the
+// new state beside it and moving it into place, so a crash does not leave a
+// truncated file behind.
+func NewDriverStateStore(driver driver.StorageDriver, path string) StateStore {
+	return &driverStateStore{
+		driver:  driver,
+		path:    path,
+		entries: make(map[string]json.RawMessage),
+	}
+}
+
+func (s *driverStateStore) Load(ctx context.Context) (map[string]json.RawMessage, error) {
+	if _, err := s.driver.Stat(ctx, s.path); err != nil {
+		switch err := err.(type) {
+		case driver.PathNotFoundError:
+			return s.entries, nil
+		default:
+			return nil, err
+		}
+	}
+
+	bytes, err := s.driver.GetContent(ctx, s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(bytes, &entries); err != nil {
+		return nil, err
+	}
+	s.entries = entries
+	return entries, nil
+}
+
+func (s *driverStateStore) Save(ctx context.Context, changed map[string]json.RawMessage, deleted []string) error {
+	entries := make(map[string]json.RawMessage, len(s.entries)+len(changed))
+	for key, entry := range s.entries {
+		entries[key] = entry
+	}
+	for key, entry := range changed {
+		entries[key] = entry
+	}
+	for _, key := range deleted {
+		delete(entries, key)
+	}
+
+	jsonBytes, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := s.driver.PutContent(ctx, tmpPath, jsonBytes); err != nil {
+		return err
+	}
+	if err := s.driver.Move(ctx, tmpPath, s.path); err != nil {
+		return err
+	}
+
+	s.entries = entries
+	return nil
+}
+
+// redisStateStore persists the entries as the fields of a redis hash.
+type redisStateStore struct {
+	pool redis.UniversalClient
+	key  string
+}
+
+// NewRedisStateStore returns a StateStore keeping the entries in the redis
+// hash key, shared by every registry instance using it. Each save updates
+// the changed fields in a single transaction.
+func NewRedisStateStore(pool redis.UniversalClient, key string) StateStore {
+	return &redisStateStore{
+		pool: pool,
+		key:  key,
+	}
+}
+
+func (s *redisStateStore) Load(ctx context.Context) (map[string]json.RawMessage, error) {
+	fields, err := s.pool.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]json.RawMessage, len(fields))
+	for key, entry := range fields {
+		entries[key] = json.RawMessage(entry)
+	}
+	return entries, nil
+}
+
+func (s *redisStateStore) Save(ctx context.Context, changed map[string]json.RawMessage, deleted []string) error {
+	_, err := s.pool.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(changed) > 0 {
+			values := make([]interface{}, 0, 2*len(changed))
+			for key, entry := range changed {
+				values = append(values, key, []byte(entry))
+			}
+			pipe.HSet(ctx, s.key, values...)
+		}
+		if len(deleted) > 0 {
+			pipe.HDel(ctx, s.key, deleted...)
+		}
+		return nil
+	})
+	return err
+}
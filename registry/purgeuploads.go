@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/spf13/cobra"
+)
+
+var (
+	purgeAge        time.Duration
+	purgeDryRun     bool
+	purgeRepository string
+)
+
+// PurgeUploadsCmd is the cobra command that corresponds to the purge-uploads
+// subcommand
+var PurgeUploadsCmd = &cobra.Command{
+	Use:   "purge-uploads <config>",
+	Short: "`purge-uploads` deletes upload sessions older than a given age",
+	Long: "`purge-uploads` deletes upload sessions older than a given age, as the upload purger " +
+		"does periodically, and prints the uploads deleted as JSON.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		purged, errs := storage.PurgeRepositoryUploads(ctx, driver, storage.PurgeUploadsOpts{
+			Age:        purgeAge,
+			DryRun:     purgeDryRun,
+			Repository: purgeRepository,
+		})
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(purged); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode purged uploads: %v", err)
+			os.Exit(1)
+		}
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "failed to purge upload: %v\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+	},
+}
@@ -3,7 +3,6 @@ package registry
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
@@ -27,6 +26,7 @@ import (
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/uuid"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
 	"github.com/distribution/distribution/v3/tracing"
@@ -100,11 +100,18 @@ func RegisterHandler(handlerFunc HandlerFunc) {
 	handlerMiddlewares = append(handlerMiddlewares, handlerFunc)
 }
 
+var (
+	serveCheck        bool
+	serveCheckTimeout time.Duration
+)
+
 // ServeCmd is a cobra command for running the registry.
 var ServeCmd = &cobra.Command{
 	Use:   "serve <config>",
 	Short: "`serve` stores and distributes Docker images",
-	Long:  "`serve` stores and distributes Docker images.",
+	Long: "`serve` stores and distributes Docker images. With --check, it initializes the storage driver, " +
+		"middlewares, caches, access controller and notification sinks and probes the services they connect " +
+		"to instead, prints the results as JSON, and exits with status 1 if any check failed.",
 	Run: func(cmd *cobra.Command, args []string) {
 		// setup context
 		ctx := dcontext.WithVersion(dcontext.Background(), version.Version())
@@ -116,6 +123,21 @@ var ServeCmd = &cobra.Command{
 			cmd.Usage()
 			os.Exit(1)
 		}
+
+		if serveCheck {
+			ctx, err = configureLogging(ctx, config)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s\n", err)
+				os.Exit(1)
+			}
+			report := handlers.Check(ctx, config, serveCheckTimeout)
+			repoPrintJSON(report)
+			if report.Failed() > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
 		registry, err := NewRegistry(ctx, config)
 		if err != nil {
 			logrus.Fatalln(err)
@@ -141,6 +163,12 @@ type Registry struct {
 
 // NewRegistry creates a new registry from a context and configuration struct.
 func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Registry, error) {
+	// Configure the ID format first, as the instance ID of ctx may be
+	// generated when logging is configured.
+	if err := uuid.SetFormat(uuid.Format(config.IDs.Format)); err != nil {
+		return nil, fmt.Errorf("error configuring ids: %v", err)
+	}
+
 	var err error
 	ctx, err = configureLogging(ctx, config)
 	if err != nil {
@@ -167,13 +195,28 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	if err != nil {
 		return nil, fmt.Errorf("error during open telemetry initialization: %v", err)
 	}
+	h2s := &http2.Server{
+		MaxConcurrentStreams: config.HTTP.HTTP2.MaxConcurrentStreams,
+		MaxReadFrameSize:     config.HTTP.HTTP2.MaxReadFrameSize,
+		IdleTimeout:          config.HTTP.HTTP2.IdleTimeout,
+	}
 	if config.HTTP.H2C.Enabled {
-		handler = h2c.NewHandler(handler, &http2.Server{})
+		handler = h2c.NewHandler(handler, h2s)
 	}
 	handler = otelHandler(handler)
 
 	server := &http.Server{
-		Handler: handler,
+		Handler:           handler,
+		ReadHeaderTimeout: config.HTTP.Timeouts.ReadHeader,
+		ReadTimeout:       config.HTTP.Timeouts.Read,
+		WriteTimeout:      config.HTTP.Timeouts.Write,
+		IdleTimeout:       config.HTTP.Timeouts.Idle,
+		MaxHeaderBytes:    config.HTTP.MaxHeaderBytes,
+	}
+	if http2Configured(config) {
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			return nil, fmt.Errorf("error configuring http2: %v", err)
+		}
 	}
 
 	return &Registry{
@@ -289,17 +332,9 @@ func (registry *Registry) ListenAndServe() error {
 		}
 
 		if len(config.HTTP.TLS.ClientCAs) != 0 {
-			pool := x509.NewCertPool()
-
-			for _, ca := range config.HTTP.TLS.ClientCAs {
-				caPem, err := os.ReadFile(ca)
-				if err != nil {
-					return err
-				}
-
-				if ok := pool.AppendCertsFromPEM(caPem); !ok {
-					return fmt.Errorf("could not add CA to pool")
-				}
+			pool, err := handlers.LoadCertPool(config.HTTP.TLS.ClientCAs)
+			if err != nil {
+				return err
 			}
 
 			for _, subj := range pool.Subjects() { //nolint:staticcheck // FIXME(thaJeztah): ignore SA1019: ac.(*accessController).rootCerts.Subjects has been deprecated since Go 1.18: if s was returned by SystemCertPool, Subjects will not include the system roots. (staticcheck)
@@ -422,7 +457,7 @@ func configureLogging(ctx context.Context, config *configuration.Configuration)
 		}
 
 		ctx = dcontext.WithValues(ctx, config.Log.Fields)
-		ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx, fields...))
+		ctx = dcontext.WithValueLogger(ctx, fields...)
 	}
 
 	dcontext.SetDefaultLogger(dcontext.GetLogger(ctx))
@@ -498,6 +533,13 @@ func resolveConfiguration(args []string) (*configuration.Configuration, error) {
 	return config, nil
 }
 
+// http2Configured reports whether HTTP/2 over TLS is enabled with settings
+// other than the defaults of the server.
+func http2Configured(config *configuration.Configuration) bool {
+	h2 := config.HTTP.HTTP2
+	return !h2.Disabled && (h2.MaxConcurrentStreams != 0 || h2.MaxReadFrameSize != 0 || h2.IdleTimeout != 0)
+}
+
 func nextProtos(config *configuration.Configuration) []string {
 	switch config.HTTP.HTTP2.Disabled {
 	case true:
@@ -0,0 +1,326 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+var repoDeleteDryRun bool
+
+// RepoCmd is the cobra command that corresponds to the repo subcommand. Its
+// subcommands act directly on the storage backend of a configuration.
+var RepoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "`repo` lists, inspects and deletes repositories in the storage backend",
+	Long: "`repo` lists, inspects and deletes repositories directly in the storage backend " +
+		"of a configuration, without going through the registry API.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// nolint:errcheck
+		cmd.Usage()
+	},
+}
+
+// RepoListCmd is the cobra command that corresponds to the repo list
+// subcommand
+var RepoListCmd = &cobra.Command{
+	Use:   "list <config> [repository]",
+	Short: "`list` lists the repositories, or the tags of a repository",
+	Long: "`list` prints the name of every repository, one per line. Given a repository, " +
+		"it prints the tags of the repository and the digest each refers to instead.",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _, registry := repoStorage(cmd, args)
+
+		if len(args) == 1 {
+			repoEnumerate(ctx, registry, func(name string) error {
+				fmt.Println(name)
+				return nil
+			})
+			return
+		}
+
+		repository := repoRepository(ctx, registry, args[1])
+		tagService := repository.Tags(ctx)
+		tags, err := tagService.All(ctx)
+		if err != nil {
+			var tagsUnknown distribution.ErrRepositoryUnknown
+			if !errors.As(err, &tagsUnknown) {
+				fmt.Fprintf(os.Stderr, "failed to list tags of %s: %v\n", args[1], err)
+				os.Exit(1)
+			}
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			desc, err := tagService.Get(ctx, tag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to resolve tag %s: %v\n", tag, err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s\t%s\n", tag, desc.Digest)
+		}
+	},
+}
+
+// repoInspection describes a manifest of a repository.
+type repoInspection struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	MediaType  string        `json:"mediaType"`
+	Size       int64         `json:"size"`
+
+	// Tags are the tags of the repository referring to the manifest.
+	Tags []string `json:"tags"`
+
+	// References are the descriptors the manifest references, such as its
+	// configuration and layers, or the manifests of an index.
+	References []v1.Descriptor `json:"references"`
+
+	// TotalSize is the size of the manifest and of the content it
+	// references directly.
+	TotalSize int64 `json:"totalSize"`
+}
+
+// RepoInspectCmd is the cobra command that corresponds to the repo inspect
+// subcommand
+var RepoInspectCmd = &cobra.Command{
+	Use:   "inspect <config> <repository>:<tag>|<repository>@<digest>",
+	Short: "`inspect` describes a manifest",
+	Long: "`inspect` prints the digest, media type, size, tags and references of the manifest " +
+		"a tag refers to, or of a manifest given by digest, as JSON.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _, registry := repoStorage(cmd, args)
+
+		ref, err := reference.Parse(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid reference %s: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		named, ok := ref.(reference.Named)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "invalid reference %s: no repository\n", args[1])
+			os.Exit(1)
+		}
+		repository := repoRepository(ctx, registry, named.Name())
+		tagService := repository.Tags(ctx)
+
+		var dgst digest.Digest
+		switch ref := ref.(type) {
+		case reference.Digested:
+			dgst = ref.Digest()
+		case reference.Tagged:
+			desc, err := tagService.Get(ctx, ref.Tag())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to resolve tag %s: %v\n", ref.Tag(), err)
+				os.Exit(1)
+			}
+			dgst = desc.Digest
+		default:
+			fmt.Fprintf(os.Stderr, "invalid reference %s: a tag or digest is required\n", args[1])
+			os.Exit(1)
+		}
+
+		manifests, err := repository.Manifests(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct manifest service: %v\n", err)
+			os.Exit(1)
+		}
+		manifest, err := manifests.Get(ctx, dgst)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get manifest %s: %v\n", dgst, err)
+			os.Exit(1)
+		}
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get payload of manifest %s: %v\n", dgst, err)
+			os.Exit(1)
+		}
+
+		tags, err := tagService.Lookup(ctx, v1.Descriptor{Digest: dgst})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to look up tags of manifest %s: %v\n", dgst, err)
+			os.Exit(1)
+		}
+		sort.Strings(tags)
+
+		inspection := repoInspection{
+			Repository: named.Name(),
+			Digest:     dgst,
+			MediaType:  mediaType,
+			Size:       int64(len(payload)),
+			Tags:       append([]string{}, tags...),
+			References: append([]v1.Descriptor{}, manifest.References()...),
+			TotalSize:  int64(len(payload)),
+		}
+		for _, desc := range inspection.References {
+			inspection.TotalSize += desc.Size
+		}
+
+		repoPrintJSON(inspection)
+	},
+}
+
+// RepoDeleteCmd is the cobra command that corresponds to the repo delete
+// subcommand
+var RepoDeleteCmd = &cobra.Command{
+	Use:   "delete <config> <repository>:<tag>...",
+	Short: "`delete` deletes tags",
+	Long: "`delete` deletes tags from their repository. The manifests they refer to are kept, " +
+		"and removed by garbage collection with --delete-untagged if no tag refers to them.",
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _, registry := repoStorage(cmd, args)
+
+		failed := false
+		for _, arg := range args[1:] {
+			ref, err := reference.Parse(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid reference %s: %v\n", arg, err)
+				failed = true
+				continue
+			}
+			tagged, ok := ref.(reference.NamedTagged)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "invalid reference %s: a repository and tag are required\n", arg)
+				failed = true
+				continue
+			}
+
+			tagService := repoRepository(ctx, registry, tagged.Name()).Tags(ctx)
+			desc, err := tagService.Get(ctx, tagged.Tag())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to resolve tag %s: %v\n", arg, err)
+				failed = true
+				continue
+			}
+			if repoDeleteDryRun {
+				fmt.Printf("would delete %s (%s)\n", arg, desc.Digest)
+				continue
+			}
+			if err := tagService.Untag(ctx, tagged.Tag()); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete tag %s: %v\n", arg, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("deleted %s (%s)\n", arg, desc.Digest)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// RepoDUCmd is the cobra command that corresponds to the repo du subcommand
+var RepoDUCmd = &cobra.Command{
+	Use:   "du <config> [repository...]",
+	Short: "`du` computes the storage used by repositories",
+	Long: "`du` prints the number and size of the blobs and manifests linked into each " +
+		"repository, or into the given repositories, as JSON. Blobs shared between " +
+		"repositories are counted in full by each of them.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _, registry := repoStorage(cmd, args)
+
+		repositories := args[1:]
+		if len(repositories) == 0 {
+			repoEnumerate(ctx, registry, func(name string) error {
+				repositories = append(repositories, name)
+				return nil
+			})
+		}
+
+		usages := []storage.RepositoryUsage{}
+		for _, name := range repositories {
+			usage, err := storage.ComputeRepositoryUsage(ctx, registry, name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to compute usage of %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			usages = append(usages, usage)
+		}
+		repoPrintJSON(usages)
+	},
+}
+
+// repoStorage returns the storage driver of the configuration given as the
+// first argument, and a registry over it.
+func repoStorage(cmd *cobra.Command, args []string) (context.Context, driver.StorageDriver, distribution.Namespace) {
+	config, err := resolveConfiguration(args[:1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		// nolint:errcheck
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	storageDriver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+
+	registry, err := storage.NewRegistry(ctx, storageDriver, handlers.StorageOptions(config)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+		os.Exit(1)
+	}
+	return ctx, storageDriver, registry
+}
+
+// repoEnumerate calls ingester with the name of every repository of the
+// registry. A registry to which nothing was pushed has no repositories.
+func repoEnumerate(ctx context.Context, registry distribution.Namespace, ingester func(string) error) {
+	err := registry.(distribution.RepositoryEnumerator).Enumerate(ctx, ingester)
+	var pathNotFound driver.PathNotFoundError
+	if err != nil && !errors.As(err, &pathNotFound) {
+		fmt.Fprintf(os.Stderr, "failed to enumerate repositories: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// repoRepository returns the named repository of the registry.
+func repoRepository(ctx context.Context, registry distribution.Namespace, name string) distribution.Repository {
+	named, err := reference.WithName(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid repository name %s: %v\n", name, err)
+		os.Exit(1)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct repository %s: %v\n", name, err)
+		os.Exit(1)
+	}
+	return repository
+}
+
+// repoPrintJSON prints v as indented JSON.
+func repoPrintJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode output: %v", err)
+		os.Exit(1)
+	}
+}
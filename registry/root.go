@@ -3,8 +3,10 @@ package registry
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	"github.com/distribution/distribution/v3/version"
@@ -15,10 +17,38 @@ var showVersion bool
 
 func init() {
 	RootCmd.AddCommand(ServeCmd)
+	ServeCmd.Flags().BoolVar(&serveCheck, "check", false, "check the configured components and their connectivity, then exit without serving")
+	ServeCmd.Flags().BoolVar(&serveCheck, "dry-run", false, "alias of --check")
+	ServeCmd.Flags().DurationVar(&serveCheckTimeout, "check-timeout", 10*time.Second, "timeout of each connectivity probe of --check")
 	RootCmd.AddCommand(GCCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
 	GCCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "silence output")
+	GCCmd.Flags().StringVar(&checkpointDir, "checkpoint-dir", "", "save mark progress to this local directory and resume from it if present")
+	GCCmd.Flags().DurationVar(&checkpointInterval, "checkpoint-interval", time.Minute, "minimum time between checkpoints")
+	GCCmd.Flags().StringVar(&markSetDir, "mark-set-dir", "", "spill the marked set to this local directory instead of holding it in memory")
+	GCCmd.Flags().IntVar(&markSetMemoryLimit, "mark-set-memory-limit", 1000000, "number of marked digests held in memory when --mark-set-dir is set")
+	GCCmd.Flags().StringToStringVar(&expireTags, "expire-tag", nil, "tag unreferenced blobs with key=value instead of deleting them, for expiry by bucket lifecycle rules")
+	RootCmd.AddCommand(ConvertSchema1Cmd)
+	ConvertSchema1Cmd.Flags().BoolVarP(&convertDryRun, "dry-run", "d", false, "report schema1 manifests without converting them")
+	ConvertSchema1Cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "silence per-manifest output")
+	RootCmd.AddCommand(PurgeUploadsCmd)
+	PurgeUploadsCmd.Flags().DurationVar(&purgeAge, "age", 168*time.Hour, "purge uploads started longer than this ago")
+	PurgeUploadsCmd.Flags().BoolVarP(&purgeDryRun, "dry-run", "d", false, "report the uploads to purge without deleting them")
+	PurgeUploadsCmd.Flags().StringVar(&purgeRepository, "repository", "", "only purge uploads to this repository and the repositories under it")
+	RootCmd.AddCommand(TagIndexCmd)
+	TagIndexCmd.Flags().BoolVar(&tagIndexVerify, "verify", false, "report the differences between the tag index and the tags directory instead of rebuilding the tag index")
+	TagIndexCmd.Flags().StringVar(&tagIndexRepository, "repository", "", "only process this repository")
+	RootCmd.AddCommand(RepoCmd)
+	RepoCmd.AddCommand(RepoListCmd)
+	RepoCmd.AddCommand(RepoInspectCmd)
+	RepoCmd.AddCommand(RepoDeleteCmd)
+	RepoDeleteCmd.Flags().BoolVarP(&repoDeleteDryRun, "dry-run", "d", false, "report the tags to delete without deleting them")
+	RepoCmd.AddCommand(RepoDUCmd)
+	RootCmd.AddCommand(FsckCmd)
+	FsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "repair the problems which can be repaired from the blob store")
+	FsckCmd.Flags().BoolVar(&fsckQuarantine, "quarantine", false, "move the links, manifests and uploads which cannot be repaired out of the repositories")
+	FsckCmd.Flags().StringVar(&fsckRepository, "repository", "", "only check this repository and the repositories under it")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -38,9 +68,14 @@ var RootCmd = &cobra.Command{
 }
 
 var (
-	dryRun         bool
-	removeUntagged bool
-	quiet          bool
+	dryRun             bool
+	removeUntagged     bool
+	quiet              bool
+	checkpointDir      string
+	checkpointInterval time.Duration
+	markSetDir         string
+	markSetMemoryLimit int
+	expireTags         map[string]string
 )
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
@@ -70,16 +105,22 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		registry, err := storage.NewRegistry(ctx, driver)
+		registry, err := storage.NewRegistry(ctx, driver, handlers.StorageOptions(config)...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
 			os.Exit(1)
 		}
 
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
-			DryRun:         dryRun,
-			RemoveUntagged: removeUntagged,
-			Quiet:          quiet,
+			DryRun:             dryRun,
+			RemoveUntagged:     removeUntagged,
+			Quiet:              quiet,
+			CheckpointDir:      checkpointDir,
+			CheckpointInterval: checkpointInterval,
+			MarkSetDir:         markSetDir,
+			MarkSetMemoryLimit: markSetMemoryLimit,
+			ExpireTags:         expireTags,
+			WORM:               config.Storage.WORM(),
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
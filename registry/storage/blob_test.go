@@ -12,6 +12,7 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
@@ -50,6 +51,96 @@ func TestWriteSeek(t *testing.T) {
 	}
 }
 
+func TestUploadLimits(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+	registry, err := NewRegistry(ctx, driver, UploadLimits(2, 0))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	first, err := bs.Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := bs.Create(ctx); err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := bs.Create(ctx); err != distribution.ErrBlobUploadLimitExceeded {
+		t.Fatalf("expected %v, got %v", distribution.ErrBlobUploadLimitExceeded, err)
+	}
+	if err := first.Cancel(ctx); err != nil {
+		t.Fatalf("unexpected error cancelling upload: %v", err)
+	}
+	if _, err := bs.Create(ctx); err != nil {
+		t.Fatalf("unexpected error starting upload after cancel: %v", err)
+	}
+
+	// Limit the bytes held by uploads in progress.
+	registry, err = NewRegistry(ctx, inmemory.New(), UploadLimits(0, 3))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err = registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs = repository.Blobs(ctx)
+
+	upload, err := bs.Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := upload.Write([]byte{1, 2}); err != nil {
+		t.Fatalf("unexpected error writing contents: %v", err)
+	}
+	upload.Close()
+	if _, err := bs.Create(ctx); err != nil {
+		t.Fatalf("unexpected error starting upload under quota: %v", err)
+	}
+
+	upload, err = bs.Resume(ctx, upload.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if _, err := upload.Write([]byte{3}); err != nil {
+		t.Fatalf("unexpected error writing contents: %v", err)
+	}
+	upload.Close()
+	if _, err := bs.Create(ctx); err != distribution.ErrBlobUploadQuotaExceeded {
+		t.Fatalf("expected %v, got %v", distribution.ErrBlobUploadQuotaExceeded, err)
+	}
+
+	// Data written beyond the quota is refused.
+	upload, err = bs.Resume(ctx, upload.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if n, err := upload.Write([]byte{4}); n != 0 || err != distribution.ErrBlobUploadQuotaExceeded {
+		t.Fatalf("expected %v writing beyond quota, got %d bytes written and %v", distribution.ErrBlobUploadQuotaExceeded, n, err)
+	}
+	if err := upload.Cancel(ctx); err != nil {
+		t.Fatalf("unexpected error cancelling upload: %v", err)
+	}
+
+	upload, err = bs.Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if n, err := upload.ReadFrom(bytes.NewReader([]byte{1, 2, 3, 4, 5})); n != 3 || err != distribution.ErrBlobUploadQuotaExceeded {
+		t.Fatalf("expected %v reading beyond quota, got %d bytes written and %v", distribution.ErrBlobUploadQuotaExceeded, n, err)
+	}
+	if n, err := upload.ReadFrom(bytes.NewReader(nil)); n != 0 || err != nil {
+		t.Fatalf("unexpected result reading empty content at quota: %d bytes written and %v", n, err)
+	}
+}
+
 // TestSimpleBlobUpload covers the blob upload process, exercising common
 // error paths that might be seen during an upload.
 func TestSimpleBlobUpload(t *testing.T) {
@@ -512,6 +603,262 @@ func TestBlobMount(t *testing.T) {
 	}
 }
 
+// TestBlobMountPolicy checks that the mount policy restricts the repositories
+// blobs can be mounted from.
+func TestBlobMountPolicy(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		scope        MountScope
+		verifySource bool
+		from         string
+		stat         bool
+		mounted      bool
+	}{
+		{scope: MountScopeAny, from: "other/source", mounted: true},
+		{scope: MountScopeNamespace, from: "foo/source", mounted: true},
+		{scope: MountScopeNamespace, from: "other/source", mounted: false},
+		{scope: MountScopeNone, from: "foo/source", mounted: false},
+		{scope: MountScopeAny, from: "foo/empty", stat: true, mounted: true},
+		{scope: MountScopeAny, verifySource: true, from: "foo/empty", stat: true, mounted: false},
+	} {
+		registry, err := NewRegistry(ctx, inmemory.New(), EnableDelete, MountPolicy(tc.scope, tc.verifySource))
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		sourceName, _ := reference.WithName(tc.from)
+		sourceRepository, err := registry.Repository(ctx, sourceName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		desc, err := sourceRepository.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("mount me"))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+		if tc.from == "foo/empty" {
+			// Only the caller knows the blob, which is not linked into the
+			// source repository.
+			if err := sourceRepository.Blobs(ctx).Delete(ctx, desc.Digest); err != nil {
+				t.Fatalf("unexpected error unlinking blob: %v", err)
+			}
+		}
+
+		imageName, _ := reference.WithName("foo/bar")
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		canonicalRef, _ := reference.WithDigest(sourceName, desc.Digest)
+		opts := []distribution.BlobCreateOption{WithMountFrom(canonicalRef)}
+		if tc.stat {
+			opts = append(opts, optionFunc(func(v interface{}) error {
+				v.(*distribution.CreateOptions).Mount.Stat = &desc
+				return nil
+			}))
+		}
+		bw, err := repository.Blobs(ctx).Create(ctx, opts...)
+		_, mounted := err.(distribution.ErrBlobMounted)
+		if mounted != tc.mounted {
+			t.Errorf("scope %q, verify source %t, mounting from %s: expected mounted %t, got %v", tc.scope, tc.verifySource, tc.from, tc.mounted, err)
+		}
+		if !tc.mounted && bw == nil {
+			t.Errorf("scope %q, mounting from %s: expected an upload to be started, got %v", tc.scope, tc.from, err)
+		}
+	}
+}
+
+// TestBlobMountPools checks that a blob missing from the source repository
+// of a mount is mounted from the pool repositories.
+func TestBlobMountPools(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		scope   MountScope
+		mounted bool
+	}{
+		{scope: MountScopeAny, mounted: true},
+		{scope: MountScopeNamespace, mounted: true},
+		{scope: MountScopeNone, mounted: false},
+	} {
+		registry, err := NewRegistry(ctx, inmemory.New(), MountPolicy(tc.scope, false), MountPools("pool/empty", "pool/base"))
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		poolName, _ := reference.WithName("pool/base")
+		pool, err := registry.Repository(ctx, poolName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		desc, err := pool.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("pooled"))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+
+		imageName, _ := reference.WithName("foo/bar")
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		sourceName, _ := reference.WithName("foo/source")
+		canonicalRef, _ := reference.WithDigest(sourceName, desc.Digest)
+		_, err = repository.Blobs(ctx).Create(ctx, WithMountFrom(canonicalRef))
+		if _, mounted := err.(distribution.ErrBlobMounted); mounted != tc.mounted {
+			t.Errorf("scope %q: expected mounted %t, got %v", tc.scope, tc.mounted, err)
+		}
+	}
+
+	if _, err := NewRegistry(ctx, inmemory.New(), MountPools("Invalid")); err == nil {
+		t.Error("expected error for invalid pool name")
+	}
+}
+
+// moveCountingDriver counts the moves of its storage driver.
+type moveCountingDriver struct {
+	storagedriver.StorageDriver
+	moves int
+}
+
+func (d *moveCountingDriver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	d.moves++
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+// TestBlobUploadExisting checks that the chunked upload of a blob which is
+// already stored links the stored blob rather than moving the upload.
+func TestBlobUploadExisting(t *testing.T) {
+	ctx := context.Background()
+	driver := &moveCountingDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	content := []byte("existing blob content")
+	dgst := digest.FromBytes(content)
+
+	upload := func(name string) {
+		imageName, _ := reference.WithName(name)
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		bs := repository.Blobs(ctx)
+
+		wr, err := bs.Create(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		if _, err := wr.Write(content[:10]); err != nil {
+			t.Fatalf("unexpected error writing chunk: %v", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("unexpected error closing writer: %v", err)
+		}
+
+		wr, err = bs.Resume(ctx, wr.ID())
+		if err != nil {
+			t.Fatalf("unexpected error resuming upload: %v", err)
+		}
+		if _, err := wr.Write(content[10:]); err != nil {
+			t.Fatalf("unexpected error writing chunk: %v", err)
+		}
+		desc, err := wr.Commit(ctx, v1.Descriptor{Digest: dgst})
+		if err != nil {
+			t.Fatalf("unexpected error committing upload: %v", err)
+		}
+		if desc.Digest != dgst || desc.Size != int64(len(content)) {
+			t.Fatalf("unexpected descriptor: %v", desc)
+		}
+
+		p, err := bs.Get(ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error getting blob: %v", err)
+		}
+		if !bytes.Equal(p, content) {
+			t.Fatalf("unexpected blob content: %q", p)
+		}
+		if _, err := bs.Resume(ctx, wr.ID()); err != distribution.ErrBlobUploadUnknown {
+			t.Fatalf("expected upload to be removed, got %v", err)
+		}
+	}
+
+	upload("foo/bar")
+	if driver.moves != 1 {
+		t.Fatalf("expected first upload to be moved, got %d moves", driver.moves)
+	}
+
+	upload("foo/baz")
+	if driver.moves != 1 {
+		t.Fatalf("expected upload of existing blob not to be moved, got %d moves", driver.moves-1)
+	}
+}
+
+// TestBlobUploadResumeHashState checks that an upload resumes from the data
+// flushed by an interrupted chunk even though its hash state was not saved.
+func TestBlobUploadResumeHashState(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+	content := []byte("content of a blob pushed in three chunks")
+	dgst := digest.FromBytes(content)
+
+	wr, err := bs.Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	for _, chunk := range [][]byte{content[:10], content[10:20]} {
+		if _, err := wr.Write(chunk); err != nil {
+			t.Fatalf("unexpected error writing chunk: %v", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("unexpected error closing writer: %v", err)
+		}
+		wr, err = bs.Resume(ctx, wr.ID())
+		if err != nil {
+			t.Fatalf("unexpected error resuming upload: %v", err)
+		}
+	}
+
+	// Lose the hash state of the second chunk, as if its upload was
+	// interrupted after its data was flushed.
+	bw := wr.(*blobWriter)
+	statePath, err := pathFor(uploadHashStatePathSpec{
+		name:   imageName.Name(),
+		id:     bw.ID(),
+		alg:    digest.Canonical,
+		offset: 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Delete(ctx, statePath); err != nil {
+		t.Fatalf("unexpected error deleting hash state: %v", err)
+	}
+
+	if wr.Size() != 20 {
+		t.Fatalf("expected upload to resume at offset 20, got %d", wr.Size())
+	}
+	if _, err := wr.Write(content[20:]); err != nil {
+		t.Fatalf("unexpected error writing chunk: %v", err)
+	}
+	if bw.written != int64(len(content)) {
+		t.Fatalf("expected hash of %d bytes, got %d", len(content), bw.written)
+	}
+	desc, err := wr.Commit(ctx, v1.Descriptor{Digest: dgst})
+	if err != nil {
+		t.Fatalf("unexpected error committing upload: %v", err)
+	}
+	if desc.Digest != dgst || desc.Size != int64(len(content)) {
+		t.Fatalf("unexpected descriptor: %v", desc)
+	}
+}
+
 // TestLayerUploadZeroLength uploads zero-length
 func TestLayerUploadZeroLength(t *testing.T) {
 	ctx := context.Background()
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -21,6 +23,8 @@ type blobServer struct {
 	statter  distribution.BlobStatter
 	pathFn   func(dgst digest.Digest) (string, error)
 	redirect bool // allows disabling RedirectURL redirects
+	inline   *inlineBlobs
+	chunks   *chunkStore
 }
 
 func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -34,24 +38,46 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		return err
 	}
 
-	if bs.redirect {
-		redirectURL, err := bs.driver.RedirectURL(r, path)
+	var content io.ReadSeeker
+	if bs.inline != nil && desc.Size <= bs.inline.maxSize {
+		// Small blobs are served from the cache rather than redirected, to
+		// save the round trip to the storage backend.
+		p, err := bs.inline.get(ctx, desc.Digest, func() ([]byte, error) {
+			return getContent(ctx, bs.driver, path)
+		})
 		if err != nil {
 			return err
 		}
-		if redirectURL != "" {
-			// Redirect to storage URL.
-			http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
-			return nil
+		content = bytes.NewReader(p)
+	} else if index, err := bs.chunkIndex(ctx, desc.Digest); err != nil {
+		return err
+	} else if index != nil {
+		// Blobs stored as chunks are reassembled here, as the storage
+		// backend has no single object to redirect to.
+		cr := newChunkReader(ctx, bs.driver, index)
+		defer cr.Close()
+		content = cr
+	} else {
+		if bs.redirect {
+			redirectURL, err := bs.driver.RedirectURL(r, path)
+			if err != nil {
+				return err
+			}
+			if redirectURL != "" {
+				// Redirect to storage URL.
+				http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+				return nil
+			}
+			// Fallback to serving the content directly.
 		}
-		// Fallback to serving the content directly.
-	}
 
-	br, err := newFileReader(ctx, bs.driver, path, desc.Size)
-	if err != nil {
-		return err
+		br, err := newFileReader(ctx, bs.driver, path, desc.Size)
+		if err != nil {
+			return err
+		}
+		defer br.Close()
+		content = br
 	}
-	defer br.Close()
 
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest)) // If-None-Match handled by ServeContent
 	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds()))
@@ -70,6 +96,19 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
 	}
 
-	http.ServeContent(w, r, desc.Digest.String(), time.Time{}, br)
+	http.ServeContent(w, r, desc.Digest.String(), time.Time{}, content)
 	return nil
 }
+
+// chunkIndex returns the chunk index of the blob, or nil if it is not stored
+// as chunks.
+func (bs *blobServer) chunkIndex(ctx context.Context, dgst digest.Digest) (*chunkIndex, error) {
+	if bs.chunks == nil {
+		return nil, nil
+	}
+	index, err := bs.chunks.index(ctx, dgst)
+	if err == distribution.ErrBlobUnknown {
+		return nil, nil
+	}
+	return index, err
+}
@@ -19,12 +19,27 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+	inline  *inlineBlobs
+	chunks  *chunkStore
+
+	// linkChecksums causes link files to be written with a checksum.
+	linkChecksums bool
 }
 
 var _ distribution.BlobProvider = &blobStore{}
 
 // Get implements the BlobProvider.Get call.
 func (bs *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if bs.inline != nil {
+		return bs.inline.get(ctx, dgst, func() ([]byte, error) {
+			return bs.getContent(ctx, dgst)
+		})
+	}
+	return bs.getContent(ctx, dgst)
+}
+
+// getContent reads the content of the blob from the storage backend.
+func (bs *blobStore) getContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
 	bp, err := bs.path(dgst)
 	if err != nil {
 		return nil, err
@@ -34,6 +49,9 @@ func (bs *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error
 	if err != nil {
 		switch err.(type) {
 		case driver.PathNotFoundError:
+			if bs.chunks != nil {
+				return bs.getChunks(ctx, dgst)
+			}
 			return nil, distribution.ErrBlobUnknown
 		}
 
@@ -43,12 +61,33 @@ func (bs *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error
 	return p, nil
 }
 
+// getChunks reads the content of a blob stored as chunks.
+func (bs *blobStore) getChunks(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	index, err := bs.chunks.index(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	cr := newChunkReader(ctx, bs.driver, index)
+	defer cr.Close()
+	return io.ReadAll(cr)
+}
+
 func (bs *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
 	desc, err := bs.statter.Stat(ctx, dgst)
 	if err != nil {
 		return nil, err
 	}
 
+	if bs.chunks != nil {
+		index, err := bs.chunks.index(ctx, desc.Digest)
+		if err == nil {
+			return newChunkReader(ctx, bs.driver, index), nil
+		}
+		if err != distribution.ErrBlobUnknown {
+			return nil, err
+		}
+	}
+
 	path, err := bs.path(desc.Digest)
 	if err != nil {
 		return nil, err
@@ -77,6 +116,13 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (v1.De
 		return v1.Descriptor{}, err
 	}
 
+	if err := bs.driver.PutContent(ctx, bp, p); err != nil {
+		return v1.Descriptor{}, err
+	}
+	if bs.inline != nil {
+		bs.inline.set(ctx, dgst, p)
+	}
+
 	// TODO(stevvooe): Write out mediatype here, as well.
 	return v1.Descriptor{
 		Size: int64(len(p)),
@@ -86,7 +132,7 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (v1.De
 		// for the specific repository.
 		MediaType: "application/octet-stream",
 		Digest:    dgst,
-	}, bs.driver.PutContent(ctx, bp, p)
+	}, nil
 }
 
 func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error {
@@ -102,13 +148,14 @@ func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Di
 		}
 
 		currentPath := fileInfo.Path()
-		// we only want to parse paths that end with /data
-		_, fileName := path.Split(currentPath)
-		if fileName != "data" {
+		// we only want to parse paths that end with /data, or /chunks for
+		// blobs stored as chunks
+		dir, fileName := path.Split(currentPath)
+		if fileName != "data" && fileName != "chunks" {
 			return nil
 		}
 
-		digest, err := digestFromPath(currentPath)
+		digest, err := digestFromPath(path.Clean(dir))
 		if err != nil {
 			return err
 		}
@@ -134,8 +181,9 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 // target file. Caller must ensure that the blob actually exists.
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
 	// The contents of the "link" file are the exact string contents of the
-	// digest, which is specified in that package.
-	return bs.driver.PutContent(ctx, path, []byte(dgst))
+	// digest, which is specified in that package, optionally followed by a
+	// checksum.
+	return bs.driver.PutContent(ctx, path, encodeLink(dgst, bs.linkChecksums))
 }
 
 // readlink returns the linked digest at path.
@@ -145,16 +193,12 @@ func (bs *blobStore) readlink(ctx context.Context, path string) (digest.Digest,
 		return "", err
 	}
 
-	linked, err := digest.Parse(string(content))
-	if err != nil {
-		return "", err
-	}
-
-	return linked, nil
+	return decodeLink(path, content)
 }
 
 type blobStatter struct {
 	driver driver.StorageDriver
+	chunks *chunkStore
 }
 
 var _ distribution.BlobDescriptorService = &blobStatter{}
@@ -174,6 +218,9 @@ func (bs *blobStatter) Stat(ctx context.Context, dgst digest.Digest) (v1.Descrip
 	if err != nil {
 		switch err := err.(type) {
 		case driver.PathNotFoundError:
+			if bs.chunks != nil {
+				return bs.statChunks(ctx, dgst)
+			}
 			return v1.Descriptor{}, distribution.ErrBlobUnknown
 		default:
 			return v1.Descriptor{}, err
@@ -203,6 +250,19 @@ func (bs *blobStatter) Stat(ctx context.Context, dgst digest.Digest) (v1.Descrip
 	}, nil
 }
 
+// statChunks returns the descriptor for a blob stored as chunks.
+func (bs *blobStatter) statChunks(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	index, err := bs.chunks.index(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{
+		Size:      index.Size,
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+	}, nil
+}
+
 func (bs *blobStatter) Clear(ctx context.Context, dgst digest.Digest) error {
 	return distribution.ErrUnsupported
 }
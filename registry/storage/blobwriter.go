@@ -40,6 +40,12 @@ type blobWriter struct {
 
 	resumableDigestEnabled bool
 	committed              bool
+
+	// quota is the number of bytes the writer may still write within the
+	// limit on the bytes of uploads in progress in the repository, or -1
+	// if unlimited. It is computed on the first write.
+	quota        int64
+	quotaChecked bool
 }
 
 var _ distribution.BlobWriter = &blobWriter{}
@@ -65,16 +71,33 @@ func (bw *blobWriter) Commit(ctx context.Context, desc v1.Descriptor) (v1.Descri
 	bw.Close()
 	desc.Size = bw.Size()
 
-	canonical, err := bw.validateBlob(ctx, desc)
-	if err != nil {
-		return v1.Descriptor{}, err
-	}
+	// The upload of a blob which is already stored is linked to the stored
+	// blob, rather than read back to verify it and moved in place. The
+	// uploaded data is removed with the upload. The stored blob may have
+	// been tagged for expiry by garbage collection, which no longer applies.
+	canonical, ok := bw.existingBlob(ctx, desc)
+	if ok {
+		if err := clearExpiry(ctx, bw.blobStore.driver, canonical.Digest); err != nil {
+			return v1.Descriptor{}, err
+		}
+	} else {
+		var err error
+		canonical, err = bw.validateBlob(ctx, desc)
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
 
-	if err := bw.moveBlob(ctx, canonical); err != nil {
-		return v1.Descriptor{}, err
+		if err := bw.moveBlob(ctx, canonical); err != nil {
+			return v1.Descriptor{}, err
+		}
 	}
 
-	if err := bw.blobStore.linkBlob(ctx, canonical, desc.Digest); err != nil {
+	return bw.link(ctx, canonical, desc.Digest)
+}
+
+// link links the committed blob into the repository and removes the upload.
+func (bw *blobWriter) link(ctx context.Context, canonical v1.Descriptor, dgst digest.Digest) (v1.Descriptor, error) {
+	if err := bw.blobStore.linkBlob(ctx, canonical, dgst); err != nil {
 		return v1.Descriptor{}, err
 	}
 
@@ -82,11 +105,19 @@ func (bw *blobWriter) Commit(ctx context.Context, desc v1.Descriptor) (v1.Descri
 		return v1.Descriptor{}, err
 	}
 
-	err = bw.blobStore.blobAccessController.SetDescriptor(ctx, canonical.Digest, canonical)
+	err := bw.blobStore.blobAccessController.SetDescriptor(ctx, canonical.Digest, canonical)
 	if err != nil {
 		return v1.Descriptor{}, err
 	}
 
+	if inline := bw.blobStore.inline; inline != nil && canonical.Size <= inline.maxSize {
+		// Cache small blobs as they are pushed, so that even their first
+		// pull is served from the cache.
+		if _, err := bw.blobStore.blobStore.Get(ctx, canonical.Digest); err != nil {
+			dcontext.GetLogger(ctx).Warnf("error caching content of blob %s: %v", canonical.Digest, err)
+		}
+	}
+
 	bw.committed = true
 	return canonical, nil
 }
@@ -118,13 +149,27 @@ func (bw *blobWriter) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
-	_, err := bw.fileWriter.Write(p)
+	quota, err := bw.remainingQuota()
+	if err != nil {
+		return 0, err
+	}
+	var quotaErr error
+	if quota >= 0 && int64(len(p)) > quota {
+		p = p[:quota]
+		quotaErr = distribution.ErrBlobUploadQuotaExceeded
+	}
+
+	_, err = bw.fileWriter.Write(p)
 	if err != nil {
 		return 0, err
 	}
 
 	n, err := bw.digester.Hash().Write(p)
 	bw.written += int64(n)
+	bw.consumeQuota(int64(n))
+	if err == nil {
+		err = quotaErr
+	}
 
 	return n, err
 }
@@ -137,26 +182,119 @@ func (bw *blobWriter) ReadFrom(r io.Reader) (n int64, err error) {
 		return 0, err
 	}
 
+	quota, err := bw.remainingQuota()
+	if err != nil {
+		return 0, err
+	}
+	src := r
+	if quota >= 0 {
+		src = io.LimitReader(r, quota)
+	}
+
 	// Using a TeeReader instead of MultiWriter ensures Copy returns
 	// the amount written to the digester as well as ensuring that we
 	// write to the fileWriter first
-	tee := io.TeeReader(r, bw.fileWriter)
+	tee := io.TeeReader(src, bw.fileWriter)
 	nn, err := io.Copy(bw.digester.Hash(), tee)
 	bw.written += nn
+	bw.consumeQuota(nn)
+
+	if err == nil && quota >= 0 && nn == quota {
+		// The data beyond the quota is not written.
+		var b [1]byte
+		if n, _ := io.ReadFull(r, b[:]); n > 0 {
+			err = distribution.ErrBlobUploadQuotaExceeded
+		}
+	}
 
 	return nn, err
 }
 
+// remainingQuota returns the number of bytes the writer may still write, or
+// -1 if unlimited. The uploads in progress are only listed once, so
+// concurrent uploads to the repository may exceed the limit slightly.
+func (bw *blobWriter) remainingQuota() (int64, error) {
+	if !bw.quotaChecked {
+		quota, err := bw.blobStore.uploadBytesRemaining(bw.blobStore.ctx)
+		if err != nil {
+			return 0, err
+		}
+		bw.quota = quota
+		bw.quotaChecked = true
+	}
+	return bw.quota, nil
+}
+
+// consumeQuota deducts n bytes written from the quota of the writer.
+func (bw *blobWriter) consumeQuota(n int64) {
+	if bw.quota > 0 {
+		bw.quota = max(bw.quota-n, 0)
+	}
+}
+
+// Close flushes the data buffered by the file writer to the backend, then
+// saves the hash state of the data written, so that the upload resumes from
+// the offset it reached. Close is called once the client disconnected from
+// an interrupted PATCH, so the backend is not accessed with the context of
+// the request.
 func (bw *blobWriter) Close() error {
 	if bw.committed {
 		return errors.New("blobwriter close after commit")
 	}
 
-	if err := bw.storeHashState(bw.blobStore.ctx); err != nil && err != errResumableDigestNotAvailable {
+	if err := bw.fileWriter.Close(); err != nil {
+		return err
+	}
+
+	// The hash state is only saved if it covers exactly the data flushed:
+	// data hashed but not written, or written by a previous instance but
+	// not hashed, would make it disagree with the upload once resumed.
+	if bw.written != bw.fileWriter.Size() {
+		return nil
+	}
+	if err := bw.storeHashState(context.WithoutCancel(bw.blobStore.ctx)); err != nil && err != errResumableDigestNotAvailable {
 		return err
 	}
+	return nil
+}
+
+// existingBlob returns the descriptor of the blob already stored with the
+// digest of the uploaded data, when that digest is known without reading the
+// data back from the backend, such as from the hash state saved by each
+// chunk of the upload.
+func (bw *blobWriter) existingBlob(ctx context.Context, desc v1.Descriptor) (v1.Descriptor, bool) {
+	if desc.Digest == "" {
+		return v1.Descriptor{}, false
+	}
+
+	size := bw.Size()
+	if err := bw.resumeDigest(ctx); err != nil && (err != errResumableDigestNotAvailable || bw.written != size) {
+		return v1.Descriptor{}, false
+	}
+	if bw.digester.Digest() != desc.Digest {
+		return v1.Descriptor{}, false
+	}
 
-	return bw.fileWriter.Close()
+	blobPath, err := pathFor(blobDataPathSpec{
+		digest: desc.Digest,
+	})
+	if err != nil {
+		return v1.Descriptor{}, false
+	}
+	fi, err := bw.driver.Stat(ctx, blobPath)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok && bw.blobStore.blobStore.chunks != nil {
+		index, err := bw.blobStore.blobStore.chunks.index(ctx, desc.Digest)
+		if err != nil || index.Size != size {
+			return v1.Descriptor{}, false
+		}
+	} else if err != nil || fi.IsDir() || fi.Size() != size {
+		return v1.Descriptor{}, false
+	}
+
+	if desc.MediaType == "" {
+		desc.MediaType = "application/octet-stream"
+	}
+	return desc, true
 }
 
 // validateBlob checks the data against the digest, returning an error if it
@@ -311,8 +449,19 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc v1.Descriptor) error {
 		// If the path exists, we can assume that the content has already
 		// been uploaded, since the blob storage is content-addressable.
 		// While it may be corrupted, detection of such corruption belongs
-		// elsewhere.
-		return nil
+		// elsewhere. It may have been tagged for expiry by garbage
+		// collection, which no longer applies.
+		return clearExpiry(ctx, bw.blobStore.driver, desc.Digest)
+	}
+
+	if chunks := bw.blobStore.blobStore.chunks; chunks != nil {
+		if _, err := chunks.index(ctx, desc.Digest); err != distribution.ErrBlobUnknown {
+			// The blob is already stored as chunks, or an error occurred.
+			return err
+		}
+		if desc.Size >= chunks.minSize {
+			return chunks.store(ctx, bw.path, desc)
+		}
 	}
 
 	// If no data was received, we may not actually have a file on disk. Check
@@ -8,15 +8,20 @@ import (
 	"encoding"
 	"fmt"
 	"hash"
+	"io"
 	"path"
 	"strconv"
 
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/sirupsen/logrus"
 )
 
 // resumeDigest attempts to restore the state of the internal hash function
-// by loading the most recent saved hash state equal to the current size of the blob.
+// by loading the most recent saved hash state not past the current size of
+// the blob. Data written after that state was saved, for instance flushed by
+// an upload interrupted before its state could be saved, is read back from
+// the backend and hashed to catch up with the current size.
 func (bw *blobWriter) resumeDigest(ctx context.Context) error {
 	if !bw.resumableDigestEnabled {
 		return errResumableDigestNotAvailable
@@ -40,18 +45,18 @@ func (bw *blobWriter) resumeDigest(ctx context.Context) error {
 		return fmt.Errorf("unable to get stored hash states with offset %d: %s", offset, err)
 	}
 
-	// Find the highest stored hashState with offset equal to
+	// Find the highest stored hashState with offset less than or equal to
 	// the requested offset.
 	for _, hashState := range hashStates {
-		if hashState.offset == offset {
+		if hashState.offset <= offset && hashState.offset > hashStateMatch.offset {
 			hashStateMatch = hashState
-			break // Found an exact offset match.
 		}
 	}
 
 	if hashStateMatch.offset == 0 {
 		// No need to load any state, just reset the hasher.
 		h.(hash.Hash).Reset()
+		bw.written = 0
 	} else {
 		storedState, err := bw.driver.GetContent(ctx, hashStateMatch.path)
 		if err != nil {
@@ -66,12 +71,31 @@ func (bw *blobWriter) resumeDigest(ctx context.Context) error {
 
 	// Mind the gap.
 	if gapLen := offset - bw.written; gapLen > 0 {
-		return errResumableDigestNotAvailable
+		if err := bw.hashGap(ctx, gapLen); err != nil {
+			dcontext.GetLogger(ctx).Warnf("unable to hash %d bytes of upload %s from offset %d: %v", gapLen, bw.id, bw.written, err)
+			h.(hash.Hash).Reset()
+			bw.written = 0
+			return errResumableDigestNotAvailable
+		}
 	}
 
 	return nil
 }
 
+// hashGap reads the gapLen bytes of the upload following the data already
+// hashed back from the backend, and hashes them.
+func (bw *blobWriter) hashGap(ctx context.Context, gapLen int64) error {
+	rc, err := bw.driver.Reader(ctx, bw.path, bw.written)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	n, err := io.CopyN(bw.digester.Hash(), rc, gapLen)
+	bw.written += n
+	return err
+}
+
 type hashStateEntry struct {
 	offset int64
 	path   string
@@ -3,9 +3,12 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -17,6 +20,30 @@ type BlobDescriptorCacheProvider interface {
 	RepositoryScoped(repo string) (distribution.BlobDescriptorService, error)
 }
 
+// NonceStore records one-time tokens so that a request carrying one cannot
+// be replayed.
+type NonceStore interface {
+	// Claim marks nonce as used for at least ttl. It returns false if the
+	// nonce has already been claimed.
+	Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+
+	// Claimed returns true if nonce has been claimed and its ttl has not
+	// expired yet.
+	Claimed(ctx context.Context, nonce string) (bool, error)
+}
+
+// BlobContentCache holds the content of small blobs, so that they can be
+// served without a round trip to the storage backend. Blobs are content
+// addressed, so cached content never goes stale.
+type BlobContentCache interface {
+	// GetContent returns the content of the blob, or
+	// distribution.ErrBlobUnknown if it is not cached.
+	GetContent(ctx context.Context, dgst digest.Digest) ([]byte, error)
+
+	// SetContent caches the content of the blob.
+	SetContent(ctx context.Context, dgst digest.Digest, p []byte) error
+}
+
 // ValidateDescriptor provides a helper function to ensure that caches have
 // common criteria for admitting descriptors.
 func ValidateDescriptor(desc v1.Descriptor) error {
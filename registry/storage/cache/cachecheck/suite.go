@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache"
@@ -186,3 +187,57 @@ func checkBlobDescriptorCacheClear(ctx context.Context, t *testing.T, provider c
 		t.Fatalf("expected error statting deleted blob: %v", err)
 	}
 }
+
+// CheckNonceStore takes a nonce store implementation through a common set of
+// operations.
+func CheckNonceStore(t *testing.T, store cache.NonceStore) {
+	ctx := context.Background()
+
+	claimed, err := store.Claim(ctx, "nonce-a", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error claiming nonce: %v", err)
+	}
+	if !claimed {
+		t.Fatal("expected unused nonce to be claimed")
+	}
+
+	claimed, err = store.Claim(ctx, "nonce-a", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error claiming nonce: %v", err)
+	}
+	if claimed {
+		t.Fatal("expected used nonce not to be claimed again")
+	}
+
+	claimed, err = store.Claim(ctx, "nonce-b", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error claiming nonce: %v", err)
+	}
+	if !claimed {
+		t.Fatal("expected a different nonce to be claimed")
+	}
+}
+
+// CheckBlobContentCache takes a blob content cache implementation through a
+// common set of operations.
+func CheckBlobContentCache(t *testing.T, c cache.BlobContentCache) {
+	ctx := context.Background()
+	content := []byte("cached blob content")
+	dgst := digest.FromBytes(content)
+
+	if _, err := c.GetContent(ctx, dgst); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected unknown blob error with empty cache: %v", err)
+	}
+
+	if err := c.SetContent(ctx, dgst, content); err != nil {
+		t.Fatalf("unexpected error setting content: %v", err)
+	}
+
+	p, err := c.GetContent(ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error getting content: %v", err)
+	}
+	if string(p) != string(content) {
+		t.Fatalf("unexpected content: %q != %q", p, content)
+	}
+}
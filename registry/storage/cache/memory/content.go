@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/opencontainers/go-digest"
+)
+
+// DefaultContentSize is the default number of bytes of blob content held by
+// the cache if no size is explicitly configured.
+const DefaultContentSize = 64 << 20
+
+type contentEntry struct {
+	digest  digest.Digest
+	content []byte
+}
+
+// inMemoryBlobContentCache holds blob content up to a total size, evicting
+// the least recently used blobs.
+type inMemoryBlobContentCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	entries map[digest.Digest]*list.Element
+}
+
+// NewInMemoryBlobContentCache returns a BlobContentCache holding up to size
+// bytes of blob content in memory.
+func NewInMemoryBlobContentCache(size int64) cache.BlobContentCache {
+	if size <= 0 {
+		size = DefaultContentSize
+	}
+	return &inMemoryBlobContentCache{
+		maxSize: size,
+		lru:     list.New(),
+		entries: make(map[digest.Digest]*list.Element),
+	}
+}
+
+func (c *inMemoryBlobContentCache) GetContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[dgst]
+	if !ok {
+		return nil, distribution.ErrBlobUnknown
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*contentEntry).content, nil
+}
+
+func (c *inMemoryBlobContentCache) SetContent(ctx context.Context, dgst digest.Digest, p []byte) error {
+	if int64(len(p)) > c.maxSize {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[dgst]; ok {
+		c.lru.MoveToFront(e)
+		return nil
+	}
+
+	c.entries[dgst] = c.lru.PushFront(&contentEntry{digest: dgst, content: p})
+	c.size += int64(len(p))
+	for c.size > c.maxSize {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*contentEntry)
+		delete(c.entries, entry.digest)
+		c.size -= int64(len(entry.content))
+	}
+	return nil
+}
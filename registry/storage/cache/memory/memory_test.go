@@ -1,9 +1,12 @@
 package memory
 
 import (
+	"context"
 	"testing"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache/cachecheck"
+	"github.com/opencontainers/go-digest"
 )
 
 // TestInMemoryBlobInfoCache checks the in memory implementation is working
@@ -11,3 +14,44 @@ import (
 func TestInMemoryBlobInfoCache(t *testing.T) {
 	cachecheck.CheckBlobDescriptorCache(t, NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize))
 }
+
+func TestInMemoryNonceStore(t *testing.T) {
+	cachecheck.CheckNonceStore(t, NewInMemoryNonceStore())
+}
+
+func TestInMemoryBlobContentCache(t *testing.T) {
+	cachecheck.CheckBlobContentCache(t, NewInMemoryBlobContentCache(DefaultContentSize))
+}
+
+func TestInMemoryBlobContentCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	c := NewInMemoryBlobContentCache(10)
+	a, b, large := digest.FromString("a"), digest.FromString("b"), digest.FromString("large")
+
+	for _, err := range []error{
+		c.SetContent(ctx, a, []byte("aaaaa")),
+		c.SetContent(ctx, b, []byte("bbbbb")),
+		c.SetContent(ctx, large, []byte("larger than the cache")),
+	} {
+		if err != nil {
+			t.Fatalf("unexpected error setting content: %v", err)
+		}
+	}
+	if _, err := c.GetContent(ctx, large); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected content larger than the cache not to be cached: %v", err)
+	}
+
+	// a is now the most recently used, so b is evicted for c.
+	if _, err := c.GetContent(ctx, a); err != nil {
+		t.Fatalf("unexpected error getting content: %v", err)
+	}
+	if err := c.SetContent(ctx, digest.FromString("c"), []byte("c")); err != nil {
+		t.Fatalf("unexpected error setting content: %v", err)
+	}
+	if _, err := c.GetContent(ctx, b); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected least recently used content to be evicted: %v", err)
+	}
+	if _, err := c.GetContent(ctx, a); err != nil {
+		t.Fatalf("unexpected error getting content: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+)
+
+// inMemoryNonceStore records claimed nonces in a map. Expired nonces are
+// pruned once the map has doubled in size since the last prune.
+type inMemoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	pruneSize int
+}
+
+// NewInMemoryNonceStore returns a NonceStore which records nonces in memory.
+// Nonces are not shared between registry instances.
+func NewInMemoryNonceStore() cache.NonceStore {
+	return &inMemoryNonceStore{
+		nonces:    make(map[string]time.Time),
+		pruneSize: 1024,
+	}
+}
+
+func (s *inMemoryNonceStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expiry, ok := s.nonces[nonce]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+
+	if len(s.nonces) >= s.pruneSize {
+		for n, expiry := range s.nonces {
+			if !now.Before(expiry) {
+				delete(s.nonces, n)
+			}
+		}
+		s.pruneSize = 2 * len(s.nonces)
+		if s.pruneSize < 1024 {
+			s.pruneSize = 1024
+		}
+	}
+	return true, nil
+}
+
+func (s *inMemoryNonceStore) Claimed(ctx context.Context, nonce string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiry, ok := s.nonces[nonce]
+	return ok && time.Now().Before(expiry), nil
+}
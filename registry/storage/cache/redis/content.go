@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/opencontainers/go-digest"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBlobContentCache holds blob content as redis keys shared by every
+// registry instance. The keys do not expire, so redis should be configured
+// with an eviction policy such as allkeys-lru.
+type redisBlobContentCache struct {
+	pool redis.UniversalClient
+}
+
+// NewRedisBlobContentCache returns a BlobContentCache backed by redis.
+func NewRedisBlobContentCache(pool redis.UniversalClient) cache.BlobContentCache {
+	return &redisBlobContentCache{pool: pool}
+}
+
+func (c *redisBlobContentCache) GetContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	p, err := c.pool.Get(ctx, blobContentKey(dgst)).Bytes()
+	if err == redis.Nil {
+		return nil, distribution.ErrBlobUnknown
+	}
+	return p, err
+}
+
+func (c *redisBlobContentCache) SetContent(ctx context.Context, dgst digest.Digest, p []byte) error {
+	return c.pool.Set(ctx, blobContentKey(dgst), p, 0).Err()
+}
+
+func blobContentKey(dgst digest.Digest) string {
+	return "blobcontent::" + dgst.String()
+}
@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/opencontainers/go-digest"
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel is the redis channel blob descriptor cache
+// invalidations are published on.
+const invalidationChannel = "blobdescriptor::invalidations"
+
+// invalidation is the message published when a descriptor is cleared from
+// a cache. Repository is empty for the global descriptor cache.
+type invalidation struct {
+	Repository string        `json:"repository,omitempty"`
+	Digest     digest.Digest `json:"digest"`
+}
+
+// invalidatingBlobDescriptorCacheProvider wraps a cache local to a registry
+// instance, such as the in-memory cache, so that descriptors cleared by one
+// instance are cleared from the caches of every instance sharing the redis
+// server.
+type invalidatingBlobDescriptorCacheProvider struct {
+	cache.BlobDescriptorCacheProvider
+	pool redis.UniversalClient
+}
+
+// NewInvalidatingBlobDescriptorCacheProvider returns a provider clearing
+// descriptors from local and publishing the invalidation to redis. It clears
+// the descriptors invalidated by other registry instances from local until
+// ctx is done.
+func NewInvalidatingBlobDescriptorCacheProvider(ctx context.Context, local cache.BlobDescriptorCacheProvider, pool redis.UniversalClient) cache.BlobDescriptorCacheProvider {
+	p := &invalidatingBlobDescriptorCacheProvider{
+		BlobDescriptorCacheProvider: local,
+		pool:                        pool,
+	}
+
+	pubsub := pool.Subscribe(ctx, invalidationChannel)
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				p.handle(ctx, msg.Payload)
+			}
+		}
+	}()
+	return p
+}
+
+// handle clears the descriptor named by a published invalidation from the
+// local cache.
+func (p *invalidatingBlobDescriptorCacheProvider) handle(ctx context.Context, payload string) {
+	var inv invalidation
+	if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+		dcontext.GetLogger(ctx).Errorf("invalid blob descriptor cache invalidation %q: %v", payload, err)
+		return
+	}
+
+	var svc distribution.BlobDescriptorService = p.BlobDescriptorCacheProvider
+	if inv.Repository != "" {
+		scoped, err := p.BlobDescriptorCacheProvider.RepositoryScoped(inv.Repository)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("invalid blob descriptor cache invalidation %q: %v", payload, err)
+			return
+		}
+		svc = scoped
+	}
+	if err := svc.Clear(ctx, inv.Digest); err != nil && err != distribution.ErrBlobUnknown {
+		dcontext.GetLogger(ctx).Errorf("error clearing %s from blob descriptor cache: %v", inv.Digest, err)
+	}
+}
+
+// publish sends an invalidation to the other registry instances. Failing to
+// publish does not fail the operation clearing the descriptor, since the
+// descriptor has already been cleared locally.
+func (p *invalidatingBlobDescriptorCacheProvider) publish(ctx context.Context, repo string, dgst digest.Digest) {
+	payload, err := json.Marshal(invalidation{Repository: repo, Digest: dgst})
+	if err == nil {
+		err = p.pool.Publish(ctx, invalidationChannel, payload).Err()
+	}
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error publishing blob descriptor cache invalidation of %s: %v", dgst, err)
+	}
+}
+
+func (p *invalidatingBlobDescriptorCacheProvider) Clear(ctx context.Context, dgst digest.Digest) error {
+	if err := p.BlobDescriptorCacheProvider.Clear(ctx, dgst); err != nil {
+		return err
+	}
+	p.publish(ctx, "", dgst)
+	return nil
+}
+
+func (p *invalidatingBlobDescriptorCacheProvider) RepositoryScoped(repo string) (distribution.BlobDescriptorService, error) {
+	scoped, err := p.BlobDescriptorCacheProvider.RepositoryScoped(repo)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingBlobDescriptorService{
+		BlobDescriptorService: scoped,
+		repo:                  repo,
+		parent:                p,
+	}, nil
+}
+
+// invalidatingBlobDescriptorService publishes the descriptors cleared from
+// a repository scoped cache.
+type invalidatingBlobDescriptorService struct {
+	distribution.BlobDescriptorService
+	repo   string
+	parent *invalidatingBlobDescriptorCacheProvider
+}
+
+func (s *invalidatingBlobDescriptorService) Clear(ctx context.Context, dgst digest.Digest) error {
+	if err := s.BlobDescriptorService.Clear(ctx, dgst); err != nil {
+		return err
+	}
+	s.parent.publish(ctx, s.repo, dgst)
+	return nil
+}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisNonceStore records claimed nonces as redis keys which expire after
+// their ttl, so that every registry instance sharing the redis server sees
+// the same nonces.
+type redisNonceStore struct {
+	pool redis.UniversalClient
+}
+
+// NewRedisNonceStore returns a NonceStore backed by redis.
+func NewRedisNonceStore(pool redis.UniversalClient) cache.NonceStore {
+	return &redisNonceStore{pool: pool}
+}
+
+func (s *redisNonceStore) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.pool.SetNX(ctx, nonceKey(nonce), 1, ttl).Result()
+}
+
+func (s *redisNonceStore) Claimed(ctx context.Context, nonce string) (bool, error) {
+	n, err := s.pool.Exists(ctx, nonceKey(nonce)).Result()
+	return n > 0, err
+}
+
+func nonceKey(nonce string) string {
+	return "nonces::" + nonce
+}
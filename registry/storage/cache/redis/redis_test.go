@@ -5,8 +5,13 @@ import (
 	"flag"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache/cachecheck"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -48,3 +53,135 @@ func TestRedisBlobDescriptorCacheProvider(t *testing.T) {
 
 	cachecheck.CheckBlobDescriptorCache(t, NewRedisBlobDescriptorCacheProvider(pool))
 }
+
+// TestRedisNonceStore exercises a live redis instance using the nonce store
+// implementation.
+func TestRedisNonceStore(t *testing.T) {
+	if redisAddr == "" {
+		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
+	}
+	if redisAddr == "" {
+		t.Skip("please set -test.registry.storage.cache.redis.addr to test nonce store against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+	ctx := context.Background()
+	if err := pool.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	cachecheck.CheckNonceStore(t, NewRedisNonceStore(pool))
+}
+
+// TestRedisBlobContentCache exercises a live redis instance using the blob
+// content cache implementation.
+func TestRedisBlobContentCache(t *testing.T) {
+	if redisAddr == "" {
+		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
+	}
+	if redisAddr == "" {
+		t.Skip("please set -test.registry.storage.cache.redis.addr to test blob content cache against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+	ctx := context.Background()
+	if err := pool.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	cachecheck.CheckBlobContentCache(t, NewRedisBlobContentCache(pool))
+}
+
+// TestBlobDescriptorCacheInvalidation tests that invalidations clear
+// descriptors from the local cache.
+func TestBlobDescriptorCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	local := memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)
+	p := &invalidatingBlobDescriptorCacheProvider{BlobDescriptorCacheProvider: local}
+
+	desc := v1.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromString("blob"),
+		Size:      4,
+	}
+	scoped, err := local.RepositoryScoped("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting scoped cache: %v", err)
+	}
+	if err := scoped.SetDescriptor(ctx, desc.Digest, desc); err != nil {
+		t.Fatalf("unexpected error setting descriptor: %v", err)
+	}
+
+	p.handle(ctx, `{"repository":"foo/bar","digest":"`+desc.Digest.String()+`"}`)
+	if _, err := scoped.Stat(ctx, desc.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected repository descriptor to be cleared, got %v", err)
+	}
+	if _, err := local.Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("expected global descriptor to be kept, got %v", err)
+	}
+
+	p.handle(ctx, `{"digest":"`+desc.Digest.String()+`"}`)
+	if _, err := local.Stat(ctx, desc.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected global descriptor to be cleared, got %v", err)
+	}
+
+	// Invalid messages are ignored.
+	p.handle(ctx, `not json`)
+	p.handle(ctx, `{"repository":"Invalid Name","digest":"`+desc.Digest.String()+`"}`)
+}
+
+// TestRedisBlobDescriptorCacheInvalidation exercises a live redis instance
+// using two registry instances with in-memory caches.
+func TestRedisBlobDescriptorCacheInvalidation(t *testing.T) {
+	if redisAddr == "" {
+		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
+	}
+	if redisAddr == "" {
+		t.Skip("please set -test.registry.storage.cache.redis.addr to test cache invalidation against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := NewInvalidatingBlobDescriptorCacheProvider(ctx, memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize), pool)
+	bLocal := memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)
+	NewInvalidatingBlobDescriptorCacheProvider(ctx, bLocal, pool)
+
+	desc := v1.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromString("blob"),
+		Size:      4,
+	}
+	if err := bLocal.SetDescriptor(ctx, desc.Digest, desc); err != nil {
+		t.Fatalf("unexpected error setting descriptor: %v", err)
+	}
+
+	// The subscriptions are established asynchronously, so clear until the
+	// invalidation is received.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := a.Clear(ctx, desc.Digest); err != nil {
+			t.Fatalf("unexpected error clearing descriptor: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, err := bLocal.Stat(ctx, desc.Digest); err == distribution.ErrBlobUnknown {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("descriptor was not invalidated")
+		}
+	}
+}
@@ -88,7 +88,10 @@ func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 		return err
 	}
 	repoDir := path.Join(root, name.Name())
-	return reg.driver.Delete(ctx, repoDir)
+	if err := reg.driver.Delete(ctx, repoDir); err != nil {
+		return err
+	}
+	return reg.recordChange(ctx, name.Name(), ChangeRepositoryDelete, "", "")
 }
 
 // lessPath returns true if one path a is less than path b.
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/uuid"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// changeLogHourFormat formats the hour of the directories of the change log.
+const changeLogHourFormat = "2006010215"
+
+// changeLogSettle is how long after they are recorded changes are read from
+// the change log. Changes are named by the clock of the registry instance
+// recording them, so that an instance with its clock behind could record a
+// change named before changes already read. Waiting for changes to settle
+// lets clocks be that far apart.
+var changeLogSettle = 5 * time.Second
+
+// Actions of the changes recorded in the change log.
+const (
+	// ChangeTag is recorded when a tag is pushed.
+	ChangeTag = "tag"
+	// ChangeUntag is recorded when a tag is deleted.
+	ChangeUntag = "untag"
+	// ChangeManifestPut is recorded when a manifest is pushed, by tag or by
+	// digest.
+	ChangeManifestPut = "manifest-put"
+	// ChangeManifestDelete is recorded when a manifest is deleted.
+	ChangeManifestDelete = "manifest-delete"
+	// ChangeRepositoryDelete is recorded when a repository is deleted.
+	ChangeRepositoryDelete = "repository-delete"
+)
+
+var (
+	// ErrChangesCursorInvalid is returned reading the change log from a
+	// cursor which is not the id of a change.
+	ErrChangesCursorInvalid = errors.New("invalid change log cursor")
+
+	// ErrChangesCursorExpired is returned reading the change log from a
+	// cursor older than the changes pruned from the log. Readers must list
+	// the repositories again before reading the changes which follow.
+	ErrChangesCursorExpired = errors.New("change log cursor expired")
+)
+
+// Change is a change to a repository recorded in the change log.
+type Change struct {
+	// ID identifies the change, and is the cursor to read the changes
+	// recorded after it. IDs are ULIDs, sorting in the order the changes
+	// were recorded.
+	ID         string        `json:"id"`
+	Time       time.Time     `json:"time"`
+	Repository string        `json:"repository"`
+	Action     string        `json:"action"`
+	Tag        string        `json:"tag,omitempty"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+}
+
+// changeLogEntry is the content of a change log entry, named by the id of
+// the change.
+type changeLogEntry struct {
+	Repository string        `json:"repository"`
+	Action     string        `json:"action"`
+	Tag        string        `json:"tag,omitempty"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+}
+
+// EnableChangeLog is a functional option for NewRegistry. It records the
+// changes to the tags, manifests and repositories of the registry in the
+// change log, read with ReadChanges.
+func EnableChangeLog(registry *registry) error {
+	registry.changeLog = true
+	return nil
+}
+
+// changeRecorder records changes in the change log.
+type changeRecorder interface {
+	recordChange(ctx context.Context, name, action, tag string, dgst digest.Digest) error
+}
+
+// recordChange records a change to the named repository in the change log,
+// if enabled.
+func (reg *registry) recordChange(ctx context.Context, name, action, tag string, dgst digest.Digest) error {
+	if !reg.changeLog {
+		return nil
+	}
+	p, err := json.Marshal(changeLogEntry{
+		Repository: name,
+		Action:     action,
+		Tag:        tag,
+		Digest:     dgst,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		id := uuid.NewULID()
+		recorded, _ := uuid.ULIDTime(id)
+		entryPath, err := pathFor(changeEntryPathSpec{
+			hour: recorded.UTC().Format(changeLogHourFormat),
+			id:   id,
+		})
+		if err != nil {
+			return err
+		}
+		if err := reg.driver.PutContent(ctx, entryPath, p); err != nil {
+			return err
+		}
+		// Readers may have read past the id of an entry written after it
+		// settled, so that the change is recorded again under a new id.
+		// Readers may read such a change twice.
+		if time.Since(recorded) < changeLogSettle {
+			return nil
+		}
+		if err := reg.driver.Delete(ctx, entryPath); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadChanges returns up to n changes recorded in the change log after the
+// change identified by cursor, in the order they were recorded. An empty
+// cursor reads from the oldest change kept. Changes are only read once they
+// have settled, a few seconds after they are recorded.
+func ReadChanges(ctx context.Context, driver storagedriver.StorageDriver, cursor string, n int) ([]Change, error) {
+	var fromHour string
+	if cursor != "" {
+		from, ok := uuid.ULIDTime(cursor)
+		if !ok {
+			return nil, ErrChangesCursorInvalid
+		}
+		fromHour = from.UTC().Format(changeLogHourFormat)
+
+		pruned, err := changesPruned(ctx, driver)
+		if err != nil {
+			return nil, err
+		}
+		if fromHour < pruned {
+			return nil, ErrChangesCursorExpired
+		}
+	}
+
+	hours, err := listChanges(ctx, driver, changesPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+	settled := time.Now().Add(-changeLogSettle)
+	settledID := uuid.ULIDAt(settled)
+	settledHour := settled.UTC().Format(changeLogHourFormat)
+
+	var changes []Change
+	for _, hour := range hours {
+		if strings.HasPrefix(hour, "_") || hour < fromHour {
+			continue
+		}
+		if hour > settledHour {
+			break
+		}
+		ids, err := listChanges(ctx, driver, changesHourPathSpec{hour: hour})
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids[sort.SearchStrings(ids, cursor+"\x00"):] {
+			if id >= settledID || len(changes) == n {
+				return changes, nil
+			}
+			entryPath, err := pathFor(changeEntryPathSpec{hour: hour, id: id})
+			if err != nil {
+				return nil, err
+			}
+			p, err := driver.GetContent(ctx, entryPath)
+			if err != nil {
+				if _, ok := err.(storagedriver.PathNotFoundError); ok {
+					// pruned while being read
+					return nil, ErrChangesCursorExpired
+				}
+				return nil, err
+			}
+			var entry changeLogEntry
+			if err := json.Unmarshal(p, &entry); err != nil {
+				return nil, err
+			}
+			recorded, _ := uuid.ULIDTime(id)
+			changes = append(changes, Change{
+				ID:         id,
+				Time:       recorded.UTC(),
+				Repository: entry.Repository,
+				Action:     entry.Action,
+				Tag:        entry.Tag,
+				Digest:     entry.Digest,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// PruneChanges removes the changes recorded before the hour of before from
+// the change log. Reading the change log from a cursor identifying a change
+// removed fails with ErrChangesCursorExpired.
+func PruneChanges(ctx context.Context, driver storagedriver.StorageDriver, before time.Time) error {
+	keep := before.UTC().Format(changeLogHourFormat)
+	pruned, err := changesPruned(ctx, driver)
+	if err != nil {
+		return err
+	}
+	hours, err := listChanges(ctx, driver, changesPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	// Expire the cursors of the changes removed before removing them.
+	if keep > pruned {
+		prunedPath, err := pathFor(changesPrunedPathSpec{})
+		if err != nil {
+			return err
+		}
+		if err := driver.PutContent(ctx, prunedPath, []byte(keep)); err != nil {
+			return err
+		}
+	}
+	for _, hour := range hours {
+		if strings.HasPrefix(hour, "_") {
+			continue
+		}
+		if hour >= keep {
+			break
+		}
+		hourPath, err := pathFor(changesHourPathSpec{hour: hour})
+		if err != nil {
+			return err
+		}
+		if err := driver.Delete(ctx, hourPath); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changesPruned returns the first hour of the change log left by pruning, or
+// an empty string if it has not been pruned.
+func changesPruned(ctx context.Context, driver storagedriver.StorageDriver) (string, error) {
+	prunedPath, err := pathFor(changesPrunedPathSpec{})
+	if err != nil {
+		return "", err
+	}
+	p, err := driver.GetContent(ctx, prunedPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(p), nil
+}
+
+// listChanges returns the sorted names of the entries of the change log
+// directory described by spec, or none if the directory does not exist.
+func listChanges(ctx context.Context, driver storagedriver.StorageDriver, spec pathSpec) ([]string, error) {
+	dir, err := pathFor(spec)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := driver.List(ctx, dir)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, path.Base(entry))
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestChangeLog(t *testing.T) {
+	settle := changeLogSettle
+	changeLogSettle = 20 * time.Millisecond
+	defer func() { changeLogSettle = settle }()
+
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d, EnableDelete, EnableChangeLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoName, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := createRandomImage(t, t.Name(), v1.MediaTypeImageManifest, repo.Blobs(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := ms.Put(ctx, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * changeLogSettle)
+
+	changes, err := ReadChanges(ctx, d, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if c := changes[0]; c.Repository != "a/b" || c.Action != ChangeManifestPut || c.Digest != dgst {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if c := changes[1]; c.Repository != "a/b" || c.Action != ChangeTag || c.Tag != "latest" || c.Digest != dgst {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if time.Since(changes[0].Time) > time.Minute {
+		t.Errorf("unexpected time of change: %v", changes[0].Time)
+	}
+
+	// Changes are read from the cursor, n at a time.
+	cursor := changes[0].ID
+	changes, err = ReadChanges(ctx, d, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].ID != cursor {
+		t.Fatalf("expected first change, got %+v", changes)
+	}
+	if err := repo.Tags(ctx).Untag(ctx, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Delete(ctx, dgst); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.(*registry).Remove(ctx, repoName); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * changeLogSettle)
+	changes, err = ReadChanges(ctx, d, cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, c := range changes {
+		actions = append(actions, c.Action)
+	}
+	expected := []string{ChangeTag, ChangeUntag, ChangeManifestDelete, ChangeRepositoryDelete}
+	if len(actions) != len(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, actions)
+	}
+	for i := range expected {
+		if actions[i] != expected[i] {
+			t.Fatalf("expected changes %v, got %v", expected, actions)
+		}
+	}
+	last := changes[len(changes)-1].ID
+	changes, err = ReadChanges(ctx, d, last, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("unexpected changes after the last: %+v", changes)
+	}
+
+	if _, err := ReadChanges(ctx, d, "latest", 10); !errors.Is(err, ErrChangesCursorInvalid) {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+
+	// Pruning expires the cursors of the changes removed.
+	if err := PruneChanges(ctx, d, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = ReadChanges(ctx, d, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 5 {
+		t.Fatalf("expected recent changes to be kept, got %+v", changes)
+	}
+	if err := PruneChanges(ctx, d, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadChanges(ctx, d, last, 10); !errors.Is(err, ErrChangesCursorExpired) {
+		t.Fatalf("expected expired cursor error, got %v", err)
+	}
+	changes, err = ReadChanges(ctx, d, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("unexpected changes after pruning: %+v", changes)
+	}
+
+	// Registries without the change log record nothing.
+	plain, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainRepo, err := plain.Repository(ctx, repoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plainRepo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = ReadChanges(ctx, d, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("unexpected changes recorded without the change log: %+v", changes)
+	}
+}
+
+func TestChangeLogGarbageCollect(t *testing.T) {
+	settle := changeLogSettle
+	changeLogSettle = 20 * time.Millisecond
+	defer func() { changeLogSettle = settle }()
+
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d, EnableDelete, EnableChangeLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := makeRepository(t, reg, "a/b")
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := createRandomImage(t, t.Name(), v1.MediaTypeImageManifest, repo.Blobs(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := ms.Put(ctx, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Untag(ctx, "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The untagged manifest removed by the collection is recorded.
+	if err := MarkAndSweep(ctx, d, reg, GCOpts{RemoveUntagged: true}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * changeLogSettle)
+	changes, err := ReadChanges(ctx, d, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got %+v", changes)
+	}
+	if c := changes[3]; c.Repository != "a/b" || c.Action != ChangeManifestDelete || c.Digest != dgst {
+		t.Errorf("unexpected change: %+v", c)
+	}
+}
+
+// slowPutDriver delays the writes of its storage driver.
+type slowPutDriver struct {
+	storagedriver.StorageDriver
+	delay time.Duration
+	puts  int
+}
+
+func (d *slowPutDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	d.puts++
+	if d.puts == 1 {
+		time.Sleep(d.delay)
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func TestChangeLogLateWrite(t *testing.T) {
+	settle := changeLogSettle
+	changeLogSettle = 50 * time.Millisecond
+	defer func() { changeLogSettle = settle }()
+
+	ctx := context.Background()
+	d := &slowPutDriver{StorageDriver: inmemory.New(), delay: 2 * changeLogSettle}
+	reg, err := NewRegistry(ctx, d, EnableChangeLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A change written after it settled is recorded again, named after
+	// the write, rather than hidden before the cursors read past it.
+	before := time.Now()
+	if err := reg.(*registry).recordChange(ctx, "a/b", ChangeTag, "latest", ""); err != nil {
+		t.Fatal(err)
+	}
+	if d.puts != 2 {
+		t.Fatalf("expected the late change to be written again, got %d writes", d.puts)
+	}
+	time.Sleep(2 * changeLogSettle)
+	changes, err := ReadChanges(ctx, d, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %+v", changes)
+	}
+	if c := changes[0]; c.Tag != "latest" || c.Time.Before(before.Add(changeLogSettle).Truncate(time.Millisecond)) {
+		t.Errorf("unexpected change: %+v", c)
+	}
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// DefaultChunkingMinSize is the size of the smallest blob stored as
+	// chunks if no size is explicitly configured.
+	DefaultChunkingMinSize = 256 << 20
+
+	// DefaultChunkAverageSize is the average size of the chunks if no size
+	// is explicitly configured.
+	DefaultChunkAverageSize = 1 << 20
+)
+
+// chunkIndex lists the chunks of a blob, in order. It is stored in place of
+// the data of the blob.
+type chunkIndex struct {
+	Size   int64      `json:"size"`
+	Chunks []chunkRef `json:"chunks"`
+}
+
+// chunkRef identifies a chunk of a blob.
+type chunkRef struct {
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+// chunkStore stores large blobs as content-defined chunks, so that blobs
+// which differ in a small part, such as successive versions of a large
+// model, share most of their chunks in storage. Chunks are stored once,
+// under their own digest, and blobs are reassembled from them when read.
+type chunkStore struct {
+	driver      driver.StorageDriver
+	minSize     int64
+	averageSize int
+}
+
+// EnableChunking is a functional option for NewRegistry. It stores the blobs
+// of at least minSize bytes as content-defined chunks of averageSize bytes on
+// average, which must be a power of two. Blobs already stored as chunks can
+// only be read while chunking is enabled.
+func EnableChunking(minSize int64, averageSize int) RegistryOption {
+	return func(registry *registry) error {
+		if minSize <= 0 {
+			minSize = DefaultChunkingMinSize
+		}
+		if averageSize <= 0 {
+			averageSize = DefaultChunkAverageSize
+		}
+		if averageSize < 64 || averageSize&(averageSize-1) != 0 {
+			return fmt.Errorf("chunk average size must be a power of two of at least 64 bytes: %d", averageSize)
+		}
+
+		chunks := &chunkStore{
+			driver:      registry.driver,
+			minSize:     minSize,
+			averageSize: averageSize,
+		}
+		registry.statter.chunks = chunks
+		registry.blobStore.chunks = chunks
+		registry.blobServer.chunks = chunks
+		return nil
+	}
+}
+
+// index returns the chunk index of the blob, or distribution.ErrBlobUnknown
+// if the blob is not stored as chunks.
+func (cs *chunkStore) index(ctx context.Context, dgst digest.Digest) (*chunkIndex, error) {
+	indexPath, err := pathFor(blobChunkIndexPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+	p, err := cs.driver.GetContent(ctx, indexPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, distribution.ErrBlobUnknown
+		}
+		return nil, err
+	}
+
+	var index chunkIndex
+	if err := json.Unmarshal(p, &index); err != nil {
+		return nil, fmt.Errorf("invalid chunk index for blob %s: %v", dgst, err)
+	}
+	return &index, nil
+}
+
+// store splits the blob at srcPath into chunks, storing the chunks not
+// already present and the chunk index of the blob. The blob should be
+// validated beforehand.
+func (cs *chunkStore) store(ctx context.Context, srcPath string, desc v1.Descriptor) error {
+	rc, err := cs.driver.Reader(ctx, srcPath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	index := chunkIndex{Size: desc.Size}
+	var size, stored int64
+	c := newChunker(rc, cs.averageSize)
+	for {
+		p, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		dgst := digest.FromBytes(p)
+		chunkPath, err := pathFor(chunkDataPathSpec{digest: dgst})
+		if err != nil {
+			return err
+		}
+		// Chunks are content-addressable, so a chunk already present is
+		// shared rather than written again.
+		if _, err := cs.driver.Stat(ctx, chunkPath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+			if err := cs.driver.PutContent(ctx, chunkPath, p); err != nil {
+				return err
+			}
+			stored += int64(len(p))
+		}
+
+		index.Chunks = append(index.Chunks, chunkRef{Digest: dgst, Size: int64(len(p))})
+		size += int64(len(p))
+	}
+	if size != desc.Size {
+		return fmt.Errorf("chunked %d bytes of blob %s, expected %d", size, desc.Digest, desc.Size)
+	}
+
+	p, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	indexPath, err := pathFor(blobChunkIndexPathSpec{digest: desc.Digest})
+	if err != nil {
+		return err
+	}
+	if err := cs.driver.PutContent(ctx, indexPath, p); err != nil {
+		return err
+	}
+
+	dcontext.GetLogger(ctx).Infof("stored blob %s as %d chunks, %d of %d bytes new", desc.Digest, len(index.Chunks), stored, desc.Size)
+	return nil
+}
+
+// chunkReader reads a chunked blob, opening the chunks as they are reached.
+type chunkReader struct {
+	ctx     context.Context
+	driver  driver.StorageDriver
+	index   *chunkIndex
+	offsets []int64 // offset of each chunk in the blob
+
+	offset    int64
+	rc        io.ReadCloser // reader of the chunk at offset, if open
+	remaining int64         // bytes of the chunk left to read from rc
+}
+
+func newChunkReader(ctx context.Context, driver driver.StorageDriver, index *chunkIndex) *chunkReader {
+	offsets := make([]int64, len(index.Chunks))
+	var offset int64
+	for i, chunk := range index.Chunks {
+		offsets[i] = offset
+		offset += chunk.Size
+	}
+	return &chunkReader{
+		ctx:     ctx,
+		driver:  driver,
+		index:   index,
+		offsets: offsets,
+	}
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.offset >= cr.index.Size {
+		return 0, io.EOF
+	}
+
+	if cr.rc == nil {
+		i := sort.Search(len(cr.offsets), func(i int) bool {
+			return cr.offsets[i] > cr.offset
+		}) - 1
+		chunk := cr.index.Chunks[i]
+		chunkPath, err := pathFor(chunkDataPathSpec{digest: chunk.Digest})
+		if err != nil {
+			return 0, err
+		}
+		rc, err := cr.driver.Reader(cr.ctx, chunkPath, cr.offset-cr.offsets[i])
+		if err != nil {
+			return 0, err
+		}
+		cr.rc = rc
+		cr.remaining = chunk.Size - (cr.offset - cr.offsets[i])
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.rc.Read(p)
+	cr.offset += int64(n)
+	cr.remaining -= int64(n)
+	if cr.remaining == 0 {
+		cr.rc.Close()
+		cr.rc = nil
+		if err == io.EOF {
+			err = nil
+		}
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (cr *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += cr.offset
+	case io.SeekEnd:
+		offset += cr.index.Size
+	default:
+		return cr.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return cr.offset, fmt.Errorf("cannot seek to negative position")
+	}
+
+	if offset != cr.offset && cr.rc != nil {
+		cr.rc.Close()
+		cr.rc = nil
+	}
+	cr.offset = offset
+	return offset, nil
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.rc != nil {
+		err := cr.rc.Close()
+		cr.rc = nil
+		return err
+	}
+	return nil
+}
+
+// gearTable holds the random values of the rolling hash used to find chunk
+// boundaries. It is generated from a fixed seed with splitmix64; changing it
+// would change the boundaries, and stop new blobs from sharing chunks with
+// those already stored.
+var gearTable = func() (t [256]uint64) {
+	x := uint64(0x5851f42d4c957f2d)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// chunker splits a stream into content-defined chunks, using a gear hash
+// with normalized chunking as in FastCDC. Boundaries depend only on the
+// content around them, so that an insertion or deletion changes the chunks
+// close to it only. Chunks are between a quarter and eight times the
+// average size, except the last.
+type chunker struct {
+	r    io.Reader
+	buf  []byte
+	n    int // bytes buffered
+	cut  int // bytes of the buffer returned by the last call to next
+	eof  bool
+	min  int
+	avg  int
+	max  int
+	hard uint64 // mask matched before the average size
+	easy uint64 // mask matched after the average size
+}
+
+func newChunker(r io.Reader, averageSize int) *chunker {
+	b := bits.Len(uint(averageSize)) - 1
+	return &chunker{
+		r:    r,
+		buf:  make([]byte, averageSize*8),
+		min:  averageSize / 4,
+		avg:  averageSize,
+		max:  averageSize * 8,
+		hard: ^uint64(0) << (64 - (b + 1)),
+		easy: ^uint64(0) << (64 - (b - 1)),
+	}
+}
+
+// next returns the next chunk, or io.EOF at the end of the stream. The
+// chunk is only valid until the next call.
+func (c *chunker) next() ([]byte, error) {
+	c.n = copy(c.buf, c.buf[c.cut:c.n])
+	c.cut = 0
+
+	if !c.eof && c.n < c.max {
+		m, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += m
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			c.eof = true
+		default:
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	c.cut = c.boundary(c.buf[:c.n])
+	return c.buf[:c.cut], nil
+}
+
+// boundary returns the length of the chunk at the start of p.
+func (c *chunker) boundary(p []byte) int {
+	n := len(p)
+	if n <= c.min {
+		return n
+	}
+	avg := min(n, c.avg)
+
+	var h uint64
+	i := c.min
+	for ; i < avg; i++ {
+		h = (h << 1) + gearTable[p[i]]
+		if h&c.hard == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gearTable[p[i]]
+		if h&c.easy == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
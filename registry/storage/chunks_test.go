@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	p := make([]byte, n)
+	if _, err := rand.Read(p); err != nil {
+		t.Fatalf("error generating random data: %v", err)
+	}
+	return p
+}
+
+// chunks returns the chunks of p.
+func chunks(t *testing.T, p []byte, averageSize int) [][]byte {
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(p), averageSize)
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("unexpected error chunking: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	const averageSize = 4096
+	p := randomBytes(t, 1<<20)
+
+	original := chunks(t, p, averageSize)
+	if !bytes.Equal(bytes.Join(original, nil), p) {
+		t.Fatal("chunks do not add up to the data")
+	}
+	if len(original) < 128 || len(original) > 512 {
+		t.Fatalf("unexpected number of chunks: %d", len(original))
+	}
+	for i, chunk := range original[:len(original)-1] {
+		if len(chunk) < averageSize/4 || len(chunk) > averageSize*8 {
+			t.Fatalf("chunk %d has unexpected size %d", i, len(chunk))
+		}
+	}
+
+	// An insertion only changes the chunks around it.
+	edited := append(append(append([]byte(nil), p[:300000]...), "inserted"...), p[300000:]...)
+	seen := make(map[digest.Digest]bool)
+	for _, chunk := range original {
+		seen[digest.FromBytes(chunk)] = true
+	}
+	changed := 0
+	for _, chunk := range chunks(t, edited, averageSize) {
+		if !seen[digest.FromBytes(chunk)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Fatalf("unexpected number of chunks changed by an insertion: %d", changed)
+	}
+}
+
+// chunkedBytes returns the number and total size of the chunks stored.
+func chunkedBytes(t *testing.T, d driver.StorageDriver) (int, int64) {
+	chunksPath, err := pathFor(chunksPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var size int64
+	err = d.Walk(dcontext.Background(), chunksPath, func(fi driver.FileInfo) error {
+		if !fi.IsDir() {
+			n++
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error walking chunks: %v", err)
+	}
+	return n, size
+}
+
+func TestChunkedBlobs(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d, EnableChunking(64<<10, 1024))
+	bs := makeRepository(t, registry, "chunked").Blobs(ctx)
+
+	large := randomBytes(t, 256<<10)
+	edited := append([]byte(nil), large...)
+	copy(edited[100000:], "edited")
+	small := randomBytes(t, 1024)
+	for _, content := range [][]byte{large, edited, small, large} {
+		if _, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}, bytes.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error uploading blob: %v", err)
+		}
+	}
+
+	// The edited blob shares most of its chunks with the original.
+	if _, size := chunkedBytes(t, d); size < int64(len(large)) || size > int64(len(large))*11/10 {
+		t.Fatalf("unexpected size of chunks stored: %d", size)
+	}
+	for _, content := range [][]byte{large, small} {
+		dataPath, err := pathFor(blobDataPathSpec{digest: digest.FromBytes(content)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Stat(ctx, dataPath)
+		if _, ok := err.(driver.PathNotFoundError); ok != (len(content) >= 64<<10) {
+			t.Fatalf("unexpected result stating data of %d byte blob: %v", len(content), err)
+		}
+	}
+
+	for _, content := range [][]byte{large, edited, small} {
+		dgst := digest.FromBytes(content)
+		desc, err := bs.Stat(ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error stating blob: %v", err)
+		}
+		if desc.Size != int64(len(content)) {
+			t.Fatalf("unexpected blob size: %d != %d", desc.Size, len(content))
+		}
+
+		p, err := bs.Get(ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error getting blob: %v", err)
+		}
+		if !bytes.Equal(p, content) {
+			t.Fatalf("unexpected content of %d byte blob", len(content))
+		}
+
+		rsc, err := bs.Open(ctx, dgst)
+		if err != nil {
+			t.Fatalf("unexpected error opening blob: %v", err)
+		}
+		if _, err := rsc.Seek(int64(len(content))/3, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error seeking blob: %v", err)
+		}
+		p, err = io.ReadAll(rsc)
+		rsc.Close()
+		if err != nil {
+			t.Fatalf("unexpected error reading blob: %v", err)
+		}
+		if !bytes.Equal(p, content[len(content)/3:]) {
+			t.Fatalf("unexpected content read from %d byte blob", len(content))
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "", nil)
+		r.Header.Set("Range", "bytes=500-")
+		if err := bs.ServeBlob(ctx, w, r, dgst); err != nil {
+			t.Fatalf("unexpected error serving blob: %v", err)
+		}
+		if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), content[500:]) {
+			t.Fatalf("unexpected response serving blob: %d", w.Code)
+		}
+	}
+}
+
+func TestChunkedBlobsGarbageCollected(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d, EnableChunking(64<<10, 1024))
+	repo := makeRepository(t, registry, "chunked")
+
+	large := randomBytes(t, 256<<10)
+	edited := append([]byte(nil), large...)
+	copy(edited[100000:], "edited")
+	kept, orphaned := digest.FromBytes(large), digest.FromBytes(edited)
+
+	err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{
+		kept:     bytes.NewReader(large),
+		orphaned: bytes.NewReader(edited),
+	})
+	if err != nil {
+		t.Fatalf("failed to upload blobs: %v", err)
+	}
+	manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeManifestService(t, repo).Put(ctx, manifest); err != nil {
+		t.Fatalf("manifest upload failed: %v", err)
+	}
+	before, _ := chunkedBytes(t, d)
+
+	if err := MarkAndSweep(ctx, d, registry, GCOpts{}); err != nil {
+		t.Fatalf("failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	if _, ok := blobs[orphaned]; ok {
+		t.Fatalf("orphaned chunked blob is present: %v", orphaned)
+	}
+	if _, ok := blobs[kept]; !ok {
+		t.Fatalf("referenced chunked blob is missing: %v", kept)
+	}
+	p, err := repo.Blobs(ctx).Get(ctx, kept)
+	if err != nil || !bytes.Equal(p, large) {
+		t.Fatalf("unexpected content of referenced chunked blob: %v", err)
+	}
+
+	index, err := (&chunkStore{driver: d}).index(ctx, kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	distinct := make(map[digest.Digest]struct{})
+	for _, chunk := range index.Chunks {
+		distinct[chunk.Digest] = struct{}{}
+	}
+	after, _ := chunkedBytes(t, d)
+	if after != len(distinct) || after >= before {
+		t.Fatalf("unexpected number of chunks after garbage collection: %d before, %d after, %d referenced", before, after, len(distinct))
+	}
+}
+
+func TestChunkedBlobsRetained(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts GCOpts
+	}{
+		{name: "expiry", opts: GCOpts{ExpireTags: map[string]string{"registry-gc": "expired"}}},
+		{name: "worm", opts: GCOpts{WORM: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := dcontext.Background()
+			d := &taggingDriver{StorageDriver: inmemory.New(), tags: make(map[string]map[string]string)}
+			registry := createRegistry(t, d, EnableChunking(64<<10, 1024))
+			repo := makeRepository(t, registry, "chunked")
+
+			orphaned := randomBytes(t, 256<<10)
+			dgst := digest.FromBytes(orphaned)
+			err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{dgst: bytes.NewReader(orphaned)})
+			if err != nil {
+				t.Fatalf("failed to upload blobs: %v", err)
+			}
+			// a chunk left by an interrupted upload
+			stray := []byte("stray chunk")
+			strayPath, err := pathFor(chunkDataPathSpec{digest: digest.FromBytes(stray)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.PutContent(ctx, strayPath, stray); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := chunkedBytes(t, d)
+
+			if err := MarkAndSweep(ctx, d, registry, tc.opts); err != nil {
+				t.Fatalf("failed mark and sweep: %v", err)
+			}
+
+			// The chunks of the orphaned blob are retained with its chunk
+			// index, while the stray chunk is deleted other than in WORM
+			// mode.
+			deleted := 1
+			if tc.opts.WORM {
+				deleted = 0
+			}
+			if after, _ := chunkedBytes(t, d); after != before-deleted {
+				t.Fatalf("expected %d chunks to be deleted: %d chunks before, %d after", deleted, before, after)
+			}
+			if _, err := d.Stat(ctx, strayPath); (err == nil) != tc.opts.WORM {
+				t.Fatalf("unexpected stray chunk after garbage collection: %v", err)
+			}
+			indexPath, err := pathFor(blobChunkIndexPathSpec{digest: dgst})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.opts.WORM {
+				tombstone, err := readTombstone(ctx, d, dgst)
+				if err != nil {
+					t.Fatalf("expected orphaned chunked blob to be tombstoned: %v", err)
+				}
+				if tombstone.Size != int64(len(orphaned)) {
+					t.Fatalf("unexpected size of tombstone: %d", tombstone.Size)
+				}
+				return
+			}
+			if d.tags[indexPath]["registry-gc"] != "expired" {
+				t.Fatalf("expected chunk index of orphaned blob to be tagged, got %v", d.tags)
+			}
+
+			// Pushed again, the blob has the tags of its chunk index cleared.
+			err = testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{dgst: bytes.NewReader(orphaned)})
+			if err != nil {
+				t.Fatalf("failed to upload blobs: %v", err)
+			}
+			if len(d.tags[indexPath]) != 0 {
+				t.Fatalf("expected expiry tags of chunk index to be cleared, got %v", d.tags[indexPath])
+			}
+		})
+	}
+}
@@ -288,6 +288,72 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 	return err
 }
 
+// DeleteFiles wraps DeleteFiles of the underlying storage driver if it
+// implements storagedriver.BulkDeleter.
+func (base *Base) DeleteFiles(ctx context.Context, paths []string) error {
+	attrs := []attribute.KeyValue{
+		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+		attribute.Int(tracing.AttributePrefix+"storage.paths", len(paths)),
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"DeleteFiles",
+		trace.WithAttributes(attrs...))
+
+	defer span.End()
+
+	deleter, ok := base.StorageDriver.(storagedriver.BulkDeleter)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+	for _, path := range paths {
+		if !storagedriver.PathRegexp.MatchString(path) {
+			return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+		}
+	}
+
+	start := time.Now()
+	err := deleter.DeleteFiles(ctx, paths)
+	storageAction.WithValues(base.Name(), "DeleteFiles").UpdateSince(start)
+	if _, ok := err.(storagedriver.Errors); ok {
+		return err
+	}
+	return base.setDriverName(err)
+}
+
+// TagFiles wraps TagFiles of the underlying storage driver if it implements
+// storagedriver.Tagger.
+func (base *Base) TagFiles(ctx context.Context, paths []string, tags map[string]string) error {
+	attrs := []attribute.KeyValue{
+		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+		attribute.Int(tracing.AttributePrefix+"storage.paths", len(paths)),
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"TagFiles",
+		trace.WithAttributes(attrs...))
+
+	defer span.End()
+
+	tagger, ok := base.StorageDriver.(storagedriver.Tagger)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+	for _, path := range paths {
+		if !storagedriver.PathRegexp.MatchString(path) {
+			return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+		}
+	}
+
+	start := time.Now()
+	err := tagger.TagFiles(ctx, paths, tags)
+	storageAction.WithValues(base.Name(), "TagFiles").UpdateSince(start)
+	if _, ok := err.(storagedriver.Errors); ok {
+		return err
+	}
+	return base.setDriverName(err)
+}
+
 // RedirectURL wraps RedirectURL of the underlying storage driver.
 func (base *Base) RedirectURL(r *http.Request, path string) (string, error) {
 	attrs := []attribute.KeyValue{
@@ -173,6 +173,34 @@ func (r *regulator) Delete(ctx context.Context, path string) error {
 	return r.StorageDriver.Delete(ctx, path)
 }
 
+// DeleteFiles deletes the objects at the given paths if the wrapped
+// driver implements storagedriver.BulkDeleter.
+func (r *regulator) DeleteFiles(ctx context.Context, paths []string) error {
+	deleter, ok := r.StorageDriver.(storagedriver.BulkDeleter)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: r.StorageDriver.Name()}
+	}
+
+	r.enter()
+	defer r.exit()
+
+	return deleter.DeleteFiles(ctx, paths)
+}
+
+// TagFiles tags the objects at the given paths if the wrapped driver
+// implements storagedriver.Tagger.
+func (r *regulator) TagFiles(ctx context.Context, paths []string, tags map[string]string) error {
+	tagger, ok := r.StorageDriver.(storagedriver.Tagger)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: r.StorageDriver.Name()}
+	}
+
+	r.enter()
+	defer r.exit()
+
+	return tagger.TagFiles(ctx, paths, tags)
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at
 // the given path.
 func (r *regulator) RedirectURL(req *http.Request, path string) (string, error) {
@@ -27,6 +27,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -37,6 +38,7 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
@@ -51,6 +53,10 @@ const (
 	defaultMaxConcurrency = 50
 	minConcurrency        = 25
 
+	// deleteConcurrency is the number of objects deleted concurrently by
+	// DeleteFiles.
+	deleteConcurrency = 50
+
 	uploadSessionContentType = "application/x-docker-upload-session"
 	blobContentType          = "application/octet-stream"
 
@@ -791,6 +797,44 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	return err
 }
 
+// DeleteFiles deletes the objects at the given paths. The GCS JSON API client
+// has no batch delete, so objects are deleted concurrently instead.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(deleteConcurrency)
+	for _, path := range paths {
+		key := d.pathToKey(path)
+		g.Go(func() error {
+			err := d.bucket.Object(key).Delete(ctx)
+			if err == nil || err == storage.ErrObjectNotExist {
+				return nil
+			}
+			if status, ok := err.(*googleapi.Error); ok && status.Code == http.StatusNotFound {
+				return nil
+			}
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return storagedriver.Errors{
+			DriverName: driverName,
+			Errs:       errs,
+		}
+	}
+	return nil
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at
 // the given path, possibly using the given options.
 func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
@@ -69,6 +69,10 @@ const (
 // listMax is the largest amount of objects you can request from S3 in a list call
 const listMax = 1000
 
+// deleteMax is the largest amount of objects you can delete from S3 in a
+// single DeleteObjects call
+const deleteMax = 1000
+
 // noStorageClass defines the value to be used if storage class is not supported by the S3 endpoint
 const noStorageClass = "NONE"
 
@@ -1069,6 +1073,82 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// DeleteFiles deletes the objects at the given paths using DeleteObjects,
+// which removes up to 1000 keys per request.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	var errs []error
+	for len(paths) > 0 {
+		n := min(len(paths), deleteMax)
+		s3Objects := make([]*s3.ObjectIdentifier, 0, n)
+		for _, path := range paths[:n] {
+			s3Objects = append(s3Objects, &s3.ObjectIdentifier{
+				Key: aws.String(d.s3Path(path)),
+			})
+		}
+		paths = paths[n:]
+
+		resp, err := d.S3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(d.Bucket),
+			Delete: &s3.Delete{
+				Objects: s3Objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		for _, err := range resp.Errors {
+			errs = append(errs, errors.New(err.String()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return storagedriver.Errors{
+			DriverName: driverName,
+			Errs:       errs,
+		}
+	}
+	return nil
+}
+
+// TagFiles replaces the tags of the objects at the given paths using
+// PutObjectTagging, so that they can be matched by bucket lifecycle rules.
+func (d *driver) TagFiles(ctx context.Context, paths []string, tags map[string]string) error {
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, &s3.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+
+	var errs []error
+	for _, path := range paths {
+		_, err := d.S3.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(d.Bucket),
+			Key:     aws.String(d.s3Path(path)),
+			Tagging: &s3.Tagging{TagSet: tagSet},
+		})
+		if err != nil {
+			if s3Err, ok := err.(awserr.Error); ok && s3Err.Code() == s3.ErrCodeNoSuchKey {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return storagedriver.Errors{
+			DriverName: driverName,
+			Errs:       errs,
+		}
+	}
+	return nil
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at the given path.
 func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
 	expiresIn := 20 * time.Minute
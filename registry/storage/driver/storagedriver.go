@@ -112,6 +112,27 @@ type StorageDriver interface {
 	Walk(ctx context.Context, path string, f WalkFn, options ...func(*WalkOptions)) error
 }
 
+// BulkDeleter is an optional interface for storage drivers which can delete
+// many objects in a single backend operation. Drivers wrapped by base.Base
+// always satisfy it, returning ErrUnsupportedMethod when the underlying
+// driver does not.
+type BulkDeleter interface {
+	// DeleteFiles deletes the objects stored at the given paths. Unlike
+	// Delete, it does not recurse into subpaths. Paths which do not exist
+	// are ignored.
+	DeleteFiles(ctx context.Context, paths []string) error
+}
+
+// Tagger is an optional interface for storage drivers which can attach
+// key/value tags to stored objects, such as S3 object tags used by bucket
+// lifecycle rules. Drivers wrapped by base.Base always satisfy it, returning
+// ErrUnsupportedMethod when the underlying driver does not.
+type Tagger interface {
+	// TagFiles sets the given tags on the objects stored at paths, replacing
+	// any tags already present. Paths which do not exist are ignored.
+	TagFiles(ctx context.Context, paths []string, tags map[string]string) error
+}
+
 // FileWriter provides an abstraction for an opened writable file-like object in
 // the storage backend. The FileWriter must flush all content written to it on
 // the call to Close, but is only required to make its content readable on a
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// markExpiring records that the blobs were tagged for expiry, so that their
+// tags can be cleared if they are referenced again before the storage
+// backend removes them.
+func markExpiring(ctx context.Context, storageDriver driver.StorageDriver, dgsts []digest.Digest) error {
+	for _, dgst := range dgsts {
+		markerPath, err := pathFor(expiringBlobPathSpec{digest: dgst})
+		if err != nil {
+			return err
+		}
+		if err := storageDriver.PutContent(ctx, markerPath, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearExpiry removes the tags applied to a blob tagged for expiry, which is
+// referenced again. Blobs which were not tagged for expiry are left as is.
+func clearExpiry(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) error {
+	markerPath, err := pathFor(expiringBlobPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	if _, err := storageDriver.Stat(ctx, markerPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	tagger, ok := storageDriver.(driver.Tagger)
+	if !ok {
+		return driver.ErrUnsupportedMethod{DriverName: storageDriver.Name()}
+	}
+	objectPath, _, err := blobObject(ctx, storageDriver, dgst)
+	if err != nil {
+		return err
+	}
+
+	dcontext.GetLogger(ctx).Infof("Clearing expiry tags of blob referenced again: %s", dgst)
+
+	if err := tagger.TagFiles(ctx, []string{objectPath}, map[string]string{}); err != nil {
+		return err
+	}
+	return storageDriver.Delete(ctx, markerPath)
+}
+
+// walkExpiring calls fn with the digest of every blob tagged for expiry.
+func walkExpiring(ctx context.Context, storageDriver driver.StorageDriver, fn func(dgst digest.Digest) error) error {
+	root, err := pathFor(expiringPathSpec{})
+	if err != nil {
+		return err
+	}
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		dgst, err := digestFromPath(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		return fn(dgst)
+	})
+	if errors.As(err, new(driver.PathNotFoundError)) {
+		return nil
+	}
+	return err
+}
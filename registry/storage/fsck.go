@@ -0,0 +1,603 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Kinds of problems found by Fsck.
+const (
+	// FsckBrokenLink is a link to a blob or manifest which is missing.
+	FsckBrokenLink = "broken-link"
+	// FsckCorruptLink is a link file which cannot be read.
+	FsckCorruptLink = "corrupt-link"
+	// FsckInvalidManifest is a manifest which cannot be read.
+	FsckInvalidManifest = "invalid-manifest"
+	// FsckMissingReference is a manifest referencing blobs or manifests
+	// missing from the blob store or from its repository.
+	FsckMissingReference = "missing-reference"
+	// FsckOrphanedUpload is an upload without a valid start time, which
+	// upload purging never removes.
+	FsckOrphanedUpload = "orphaned-upload"
+)
+
+// Actions taken by Fsck on problems.
+const (
+	// FsckReported problems are left as they are.
+	FsckReported = "reported"
+	// FsckRepaired problems were fixed using the content of the blob store.
+	FsckRepaired = "repaired"
+	// FsckQuarantined problems were moved out of the repositories, to the
+	// quarantine directory of the run.
+	FsckQuarantined = "quarantined"
+)
+
+// FsckOpts selects the repositories checked by Fsck and what it does with the
+// problems found.
+type FsckOpts struct {
+	// Repository limits the check to the repository and the repositories
+	// under it, matched as a PurgePolicy prefix. All repositories are
+	// checked if it is empty.
+	Repository string
+	// Repair fixes the problems which can be fixed from the content of the
+	// blob store: links are rewritten to the blob they are named after,
+	// references missing from a repository are linked into it, and orphaned
+	// uploads are deleted.
+	Repair bool
+	// Quarantine moves the links, manifests and uploads with problems which
+	// cannot be repaired out of the repositories.
+	Quarantine bool
+}
+
+// FsckProblem describes a problem found by Fsck.
+type FsckProblem struct {
+	Kind       string        `json:"kind"`
+	Repository string        `json:"repository"`
+	Path       string        `json:"path"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Detail     string        `json:"detail"`
+	Action     string        `json:"action"`
+	// Error is the error which prevented the problem from being repaired or
+	// quarantined.
+	Error string `json:"error,omitempty"`
+}
+
+// FsckReport is the result of a run of Fsck.
+type FsckReport struct {
+	Repositories int `json:"repositories"`
+	Links        int `json:"links"`
+	Manifests    int `json:"manifests"`
+	Uploads      int `json:"uploads"`
+	// Quarantine is the directory the problems quarantined during the run
+	// were moved to, relative to the root of the storage driver.
+	Quarantine string        `json:"quarantine,omitempty"`
+	Problems   []FsckProblem `json:"problems"`
+}
+
+// Unresolved returns the number of problems which were neither repaired nor
+// quarantined.
+func (r FsckReport) Unresolved() int {
+	n := 0
+	for _, problem := range r.Problems {
+		if problem.Action == FsckReported {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks the links, manifests and uploads of the repositories selected
+// by opts for problems left by partial writes, outages and modifications of
+// the storage backend, and repairs or quarantines them as requested.
+func Fsck(ctx context.Context, storageDriver driver.StorageDriver, namespace distribution.Namespace, opts FsckOpts) (FsckReport, error) {
+	reg, ok := namespace.(*registry)
+	if !ok {
+		return FsckReport{}, fmt.Errorf("unable to check %T", namespace)
+	}
+	f := &fsck{
+		ctx:    ctx,
+		driver: storageDriver,
+		reg:    reg,
+		opts:   opts,
+		id:     time.Now().UTC().Format("20060102T150405Z"),
+		report: FsckReport{Problems: []FsckProblem{}},
+	}
+	filter := PurgePolicy{Prefix: opts.Repository}
+
+	var repositories []string
+	err := reg.Enumerate(ctx, func(name string) error {
+		if filter.matches(name) {
+			repositories = append(repositories, name)
+		}
+		return nil
+	})
+	if err != nil {
+		var pathNotFound driver.PathNotFoundError
+		if !errors.As(err, &pathNotFound) {
+			return f.report, fmt.Errorf("failed to enumerate repositories: %v", err)
+		}
+	}
+	for _, name := range repositories {
+		if err := f.checkRepository(name); err != nil {
+			return f.report, fmt.Errorf("failed to check repository %s: %v", name, err)
+		}
+		f.report.Repositories++
+	}
+
+	if err := f.checkUploads(filter); err != nil {
+		return f.report, err
+	}
+	return f.report, nil
+}
+
+type fsck struct {
+	ctx    context.Context
+	driver driver.StorageDriver
+	reg    *registry
+	opts   FsckOpts
+	id     string
+	report FsckReport
+}
+
+// checkRepository checks the links and manifests of a repository.
+func (f *fsck) checkRepository(name string) error {
+	layersPath, err := pathFor(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	if err := f.walkLinks(layersPath, func(linkPath string) error {
+		_, err := f.checkLink(name, linkPath)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	revisionsPath, err := pathFor(manifestRevisionsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	var manifests []digest.Digest
+	if err := f.walkLinks(revisionsPath, func(linkPath string) error {
+		dgst, err := f.checkLink(name, linkPath)
+		if dgst != "" {
+			manifests = append(manifests, dgst)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	quarantined := make(map[digest.Digest]bool)
+	for _, dgst := range manifests {
+		moved, err := f.checkManifest(name, dgst)
+		if err != nil {
+			return err
+		}
+		quarantined[dgst] = moved
+	}
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	return f.walkLinks(tagsPath, func(linkPath string) error {
+		if path.Base(path.Dir(linkPath)) != "current" {
+			// Links of the index of a tag record its past revisions.
+			return nil
+		}
+		return f.checkTag(name, linkPath, quarantined)
+	})
+}
+
+// walkLinks calls fn with the path of every link file under root. The links
+// are listed before fn is called, so that fn can move them.
+func (f *fsck) walkLinks(root string, fn func(linkPath string) error) error {
+	var links []string
+	err := f.driver.Walk(f.ctx, root, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() && path.Base(fileInfo.Path()) == "link" {
+			links = append(links, fileInfo.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	for _, linkPath := range links {
+		f.report.Links++
+		if err := fn(linkPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLink checks that a link to a blob or manifest, named after the digest
+// of its directory, can be read and refers to a blob in the blob store. It
+// returns the digest linked to, or an empty digest if the link is not usable.
+func (f *fsck) checkLink(name, linkPath string) (digest.Digest, error) {
+	pathDigest, err := digestFromPath(path.Dir(linkPath))
+	if err != nil {
+		dcontext.GetLogger(f.ctx).Warnf("fsck: ignoring link %s outside of a digest directory", linkPath)
+		return "", nil
+	}
+
+	target, err := f.readlink(linkPath)
+	if err != nil {
+		var corrupt LinkCorruptError
+		if !errors.As(err, &corrupt) {
+			return "", err
+		}
+		return f.relink(FsckProblem{
+			Kind:       FsckCorruptLink,
+			Repository: name,
+			Path:       linkPath,
+			Digest:     pathDigest,
+			Detail:     corrupt.Reason,
+		}, pathDigest)
+	}
+
+	exists, err := f.blobExists(target)
+	if err != nil || exists {
+		return target, err
+	}
+	return f.relink(FsckProblem{
+		Kind:       FsckBrokenLink,
+		Repository: name,
+		Path:       linkPath,
+		Digest:     target,
+		Detail:     fmt.Sprintf("blob %s is missing from the blob store", target),
+	}, pathDigest)
+}
+
+// relink repairs a link by linking it to the blob it is named after, if the
+// blob store has it, or quarantines it. It returns the digest linked to if
+// the link was repaired.
+func (f *fsck) relink(problem FsckProblem, pathDigest digest.Digest) (digest.Digest, error) {
+	if f.opts.Repair {
+		exists, err := f.blobExists(pathDigest)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			if err := f.reg.blobStore.link(f.ctx, problem.Path, pathDigest); err != nil {
+				return "", err
+			}
+			f.add(problem, FsckRepaired)
+			return pathDigest, nil
+		}
+	}
+	f.quarantine(problem, problem.Path)
+	return "", nil
+}
+
+// checkTag checks that the current link of a tag can be read and refers to a
+// manifest of the repository. Tags referring to manifests quarantined during
+// the run are quarantined with them.
+func (f *fsck) checkTag(name, linkPath string, quarantined map[digest.Digest]bool) error {
+	// The tag directory holds the current link and the index of the tag.
+	tagPath := path.Dir(path.Dir(linkPath))
+	tag := path.Base(tagPath)
+
+	target, err := f.readlink(linkPath)
+	if err != nil {
+		var corrupt LinkCorruptError
+		if !errors.As(err, &corrupt) {
+			return err
+		}
+		f.quarantine(FsckProblem{
+			Kind:       FsckCorruptLink,
+			Repository: name,
+			Path:       linkPath,
+			Detail:     corrupt.Reason,
+		}, tagPath)
+		return nil
+	}
+
+	problem := FsckProblem{
+		Kind:       FsckBrokenLink,
+		Repository: name,
+		Path:       linkPath,
+		Digest:     target,
+		Detail:     fmt.Sprintf("tag %s refers to manifest %s, which was quarantined", tag, target),
+	}
+	if quarantined[target] {
+		f.quarantine(problem, tagPath)
+		return nil
+	}
+
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: target})
+	if err != nil {
+		return err
+	}
+	if linked, err := f.exists(revisionPath); err != nil || linked {
+		return err
+	}
+
+	problem.Detail = fmt.Sprintf("tag %s refers to manifest %s, which is not in the repository", tag, target)
+	if f.opts.Repair {
+		repaired, err := f.linkInto(problem, revisionPath, target)
+		if err != nil {
+			return err
+		}
+		if repaired {
+			// Check the manifest linked back into the repository.
+			moved, err := f.checkManifest(name, target)
+			if err != nil || !moved {
+				return err
+			}
+			quarantined[target] = true
+			problem.Detail = fmt.Sprintf("tag %s refers to manifest %s, which was quarantined", tag, target)
+		}
+	}
+	f.quarantine(problem, tagPath)
+	return nil
+}
+
+// checkManifest checks that a manifest of the repository can be read, and
+// that the blobs and manifests it references are in the blob store and in
+// the repository. It returns true if the manifest was quarantined.
+func (f *fsck) checkManifest(name string, dgst digest.Digest) (bool, error) {
+	f.report.Manifests++
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return false, err
+	}
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		return false, err
+	}
+	repository, err := f.reg.Repository(f.ctx, named)
+	if err != nil {
+		return false, err
+	}
+	manifests, err := repository.Manifests(f.ctx)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := manifests.Get(f.ctx, dgst)
+	if err != nil {
+		return f.quarantine(FsckProblem{
+			Kind:       FsckInvalidManifest,
+			Repository: name,
+			Path:       revisionPath,
+			Digest:     dgst,
+			Detail:     err.Error(),
+		}, revisionPath), nil
+	}
+
+	var isIndex bool
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+		isIndex = true
+	}
+
+	var missing []string
+	for _, desc := range manifest.References() {
+		if len(desc.URLs) > 0 {
+			// Foreign layers are not pushed to the registry.
+			continue
+		}
+
+		var linkPath string
+		if isIndex {
+			linkPath, err = pathFor(manifestRevisionLinkPathSpec{name: name, revision: desc.Digest})
+		} else {
+			linkPath, err = pathFor(layerLinkPathSpec{name: name, digest: desc.Digest})
+		}
+		if err != nil {
+			return false, err
+		}
+		if linked, err := f.exists(linkPath); err != nil {
+			return false, err
+		} else if linked {
+			// Links to missing blobs have been reported.
+			continue
+		}
+
+		problem := FsckProblem{
+			Kind:       FsckMissingReference,
+			Repository: name,
+			Path:       linkPath,
+			Digest:     desc.Digest,
+			Detail:     fmt.Sprintf("manifest %s references %s, which is not in the repository", dgst, desc.Digest),
+		}
+		if f.opts.Repair {
+			repaired, err := f.linkInto(problem, linkPath, desc.Digest)
+			if err != nil {
+				return false, err
+			}
+			if repaired {
+				continue
+			}
+		}
+		missing = append(missing, desc.Digest.String())
+	}
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	return f.quarantine(FsckProblem{
+		Kind:       FsckMissingReference,
+		Repository: name,
+		Path:       revisionPath,
+		Digest:     dgst,
+		Detail:     fmt.Sprintf("manifest references %s, missing from the repository or the blob store", strings.Join(missing, ", ")),
+	}, revisionPath), nil
+}
+
+// linkInto repairs a missing link by linking the blob into the repository, if
+// the blob store has it. It returns true if the problem was repaired.
+func (f *fsck) linkInto(problem FsckProblem, linkPath string, dgst digest.Digest) (bool, error) {
+	exists, err := f.blobExists(dgst)
+	if err != nil || !exists {
+		return false, err
+	}
+	if err := f.reg.blobStore.link(f.ctx, linkPath, dgst); err != nil {
+		return false, err
+	}
+	f.add(problem, FsckRepaired)
+	return true, nil
+}
+
+// checkUploads checks for uploads without a valid start time, which upload
+// purging never removes.
+func (f *fsck) checkUploads(filter PurgePolicy) error {
+	uploads, errs := getOutstandingUploads(f.ctx, f.driver)
+	for _, err := range errs {
+		dcontext.GetLogger(f.ctx).Warnf("fsck: %v", err)
+	}
+
+	now := time.Now()
+	for _, ud := range uploads {
+		if ud.containingDir == "" || !filter.matches(ud.repository) {
+			continue
+		}
+		f.report.Uploads++
+		// Uploads whose start time could not be read are given one in the
+		// future, so that they are not purged.
+		if !ud.startedAt.After(now) {
+			continue
+		}
+
+		problem := FsckProblem{
+			Kind:       FsckOrphanedUpload,
+			Repository: ud.repository,
+			Path:       ud.containingDir,
+			Detail:     "upload has no valid start time and is never purged",
+		}
+		if f.opts.Repair {
+			if err := f.driver.Delete(f.ctx, ud.containingDir); err != nil {
+				problem.Error = err.Error()
+				f.add(problem, FsckReported)
+			} else {
+				f.add(problem, FsckRepaired)
+			}
+			continue
+		}
+		f.quarantine(problem, ud.containingDir)
+	}
+	return nil
+}
+
+// quarantine moves the file or directory at p to the quarantine directory of
+// the run if quarantining is enabled, and adds the problem to the report. It
+// returns true if p was moved.
+func (f *fsck) quarantine(problem FsckProblem, p string) bool {
+	if !f.opts.Quarantine {
+		f.add(problem, FsckReported)
+		return false
+	}
+
+	quarantinePath, err := pathFor(quarantinePathSpec{id: f.id})
+	if err == nil {
+		err = f.move(p, quarantinePath)
+	}
+	if err != nil {
+		problem.Error = err.Error()
+		f.add(problem, FsckReported)
+		return false
+	}
+	f.report.Quarantine = quarantinePath
+	f.add(problem, FsckQuarantined)
+	return true
+}
+
+// move moves the file or directory at p under quarantinePath, keeping its
+// path relative to <root>/v2. Directories are moved file by file, as not all
+// drivers move directories.
+func (f *fsck) move(p, quarantinePath string) error {
+	root := path.Dir(path.Dir(quarantinePath))
+	destination := func(src string) string {
+		return path.Join(quarantinePath, strings.TrimPrefix(src, root))
+	}
+
+	fileInfo, err := f.driver.Stat(f.ctx, p)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return f.driver.Move(f.ctx, p, destination(p))
+	}
+
+	var files []string
+	err = f.driver.Walk(f.ctx, p, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			files = append(files, fileInfo.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := f.driver.Move(f.ctx, file, destination(file)); err != nil {
+			return err
+		}
+	}
+	return f.driver.Delete(f.ctx, p)
+}
+
+// add adds a problem to the report, with the action taken.
+func (f *fsck) add(problem FsckProblem, action string) {
+	problem.Action = action
+	log := dcontext.GetLoggerWithFields(f.ctx, map[interface{}]interface{}{
+		"kind":       problem.Kind,
+		"repository": problem.Repository,
+		"path":       problem.Path,
+		"action":     action,
+	})
+	if problem.Error != "" {
+		log.Errorf("fsck: %s: %s", problem.Detail, problem.Error)
+	} else {
+		log.Warnf("fsck: %s", problem.Detail)
+	}
+	f.report.Problems = append(f.report.Problems, problem)
+}
+
+// readlink reads a link file, returning LinkCorruptError if it cannot be read.
+func (f *fsck) readlink(linkPath string) (digest.Digest, error) {
+	content, err := f.driver.GetContent(f.ctx, linkPath)
+	if err != nil {
+		return "", err
+	}
+	return decodeLink(linkPath, content)
+}
+
+// blobExists returns true if the blob store has the blob.
+func (f *fsck) blobExists(dgst digest.Digest) (bool, error) {
+	if _, err := f.reg.BlobStatter().Stat(f.ctx, dgst); err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// exists returns true if the driver has a file at p.
+func (f *fsck) exists(p string) (bool, error) {
+	if _, err := f.driver.Stat(f.ctx, p); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
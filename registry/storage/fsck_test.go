@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/uuid"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoName, _ := reference.WithName("a/b")
+	repo, err := registry.Repository(ctx, repoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var images []digest.Digest
+	var layers []digest.Digest
+	for _, tag := range []string{"first", "second"} {
+		manifest, err := createRandomImage(t, t.Name(), v1.MediaTypeImageManifest, repo.Blobs(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := ms.Put(ctx, manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Tags(ctx).Tag(ctx, tag, v1.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, dgst)
+		if tag == "first" {
+			for _, desc := range manifest.References()[1:] {
+				layers = append(layers, desc.Digest)
+			}
+		}
+	}
+
+	report, err := Fsck(ctx, d, registry, FsckOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 || report.Repositories != 1 || report.Manifests != 2 {
+		t.Fatalf("unexpected report of consistent storage: %+v", report)
+	}
+
+	// Corrupt the link of the first layer, which can be relinked.
+	corruptPath, err := pathFor(layerLinkPathSpec{name: repoName.Name(), digest: layers[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, corruptPath, []byte("sha256:0123")); err != nil {
+		t.Fatal(err)
+	}
+	// Delete the second layer from the blob store, which cannot be repaired.
+	blobPath, err := pathFor(blobPathSpec{digest: layers[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, blobPath); err != nil {
+		t.Fatal(err)
+	}
+	// Unlink the second manifest from the repository, which can be linked
+	// back as it is still tagged.
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName.Name(), revision: images[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, path.Dir(revisionPath)); err != nil {
+		t.Fatal(err)
+	}
+	// Leave an upload without a start time.
+	uploadPath, err := pathFor(uploadDataPathSpec{name: repoName.Name(), id: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, uploadPath, []byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first manifest references the deleted layer through its broken
+	// link, which is reported rather than the manifest.
+	expected := []string{
+		FsckBrokenLink + " " + FsckReported,
+		FsckBrokenLink + " " + FsckReported,
+		FsckCorruptLink + " " + FsckReported,
+		FsckOrphanedUpload + " " + FsckReported,
+	}
+	report, err = Fsck(ctx, d, registry, FsckOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFsckProblems(t, report, expected)
+	if report.Unresolved() != len(expected) {
+		t.Fatalf("expected %d unresolved problems, got %d", len(expected), report.Unresolved())
+	}
+
+	// Reporting changes nothing.
+	report, err = Fsck(ctx, d, registry, FsckOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFsckProblems(t, report, expected)
+
+	report, err = Fsck(ctx, d, registry, FsckOpts{Repair: true, Quarantine: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Once the link of the deleted layer is quarantined, the first manifest
+	// is quarantined as it references a missing layer, and its tag with it.
+	checkFsckProblems(t, report, []string{
+		FsckBrokenLink + " " + FsckQuarantined,
+		FsckBrokenLink + " " + FsckQuarantined,
+		FsckBrokenLink + " " + FsckRepaired,
+		FsckCorruptLink + " " + FsckRepaired,
+		FsckMissingReference + " " + FsckQuarantined,
+		FsckOrphanedUpload + " " + FsckRepaired,
+	})
+	if report.Unresolved() != 0 {
+		t.Fatalf("unexpected unresolved problems: %+v", report.Problems)
+	}
+	quarantined := path.Join(report.Quarantine, "repositories", repoName.Name(), "_layers", layers[1].Algorithm().String(), layers[1].Encoded(), "link")
+	if _, err := d.Stat(ctx, quarantined); err != nil {
+		t.Fatalf("expected quarantined link at %s: %v", quarantined, err)
+	}
+
+	report, err = Fsck(ctx, d, registry, FsckOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("unexpected problems after repair: %+v", report.Problems)
+	}
+
+	// The second manifest is served again, and the first is not, nor tagged.
+	if _, err := ms.Get(ctx, images[1]); err != nil {
+		t.Fatalf("unexpected error getting relinked manifest: %v", err)
+	}
+	if _, err := ms.Get(ctx, images[0]); err == nil {
+		t.Fatal("expected quarantined manifest to be unknown")
+	} else if _, ok := err.(distribution.ErrManifestUnknownRevision); !ok {
+		t.Fatalf("unexpected error getting quarantined manifest: %v", err)
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "first"); err == nil {
+		t.Fatal("expected tag of quarantined manifest to be unknown")
+	} else if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Fatalf("unexpected error getting tag of quarantined manifest: %v", err)
+	}
+}
+
+// checkFsckProblems checks the kinds and actions of the problems reported.
+func checkFsckProblems(t *testing.T, report FsckReport, expected []string) {
+	t.Helper()
+	var problems []string
+	for _, problem := range report.Problems {
+		problems = append(problems, problem.Kind+" "+problem.Action)
+	}
+	sort.Strings(problems)
+	sort.Strings(expected)
+	if len(problems) != len(expected) {
+		t.Fatalf("expected problems %v, got %+v", expected, report.Problems)
+	}
+	for i := range problems {
+		if problems[i] != expected[i] {
+			t.Fatalf("expected problems %v, got %+v", expected, report.Problems)
+		}
+	}
+}
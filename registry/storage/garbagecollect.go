@@ -2,10 +2,15 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"path"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
@@ -16,11 +21,40 @@ func emit(format string, a ...interface{}) {
 	fmt.Printf(format+"\n", a...)
 }
 
+// blobDeleteBatchSize is the number of blobs removed at once during the
+// sweep phase. It matches the limit of the S3 DeleteObjects call.
+const blobDeleteBatchSize = 1000
+
 // GCOpts contains options for garbage collector
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
 	Quiet          bool
+
+	// CheckpointDir is a local directory in which the progress of the mark
+	// phase is periodically saved. If a checkpoint exists when garbage
+	// collection starts, marking resumes from it.
+	CheckpointDir string
+	// CheckpointInterval is the minimum time between checkpoints.
+	CheckpointInterval time.Duration
+
+	// MarkSetDir is a local directory in which the marked set is stored
+	// once it grows beyond MarkSetMemoryLimit digests. If empty, the marked
+	// set is held entirely in memory.
+	MarkSetDir string
+	// MarkSetMemoryLimit is the number of marked digests held in memory
+	// when MarkSetDir is set.
+	MarkSetMemoryLimit int
+
+	// ExpireTags, if set, are applied to unreferenced blobs instead of
+	// deleting them, leaving their removal to the storage backend's
+	// lifecycle rules. The storage driver must implement driver.Tagger.
+	ExpireTags map[string]string
+
+	// WORM, if true, never deletes blob data. Unreferenced blobs are
+	// recorded with a tombstone instead, and the data retained by the
+	// storage backend is reported.
+	WORM bool
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -30,18 +64,74 @@ type ManifestDel struct {
 	Tags   []string
 }
 
-// MarkAndSweep performs a mark and sweep of registry data
+// MarkAndSweep performs a mark and sweep of registry data. The manifests
+// removed are recorded in the change log of registry, if enabled.
 func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts) error {
 	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
 	if !ok {
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
+	if opts.WORM && len(opts.ExpireTags) > 0 {
+		return fmt.Errorf("blobs cannot be tagged for expiry in WORM mode")
+	}
+	if len(opts.ExpireTags) > 0 && !opts.DryRun {
+		tagger, ok := storageDriver.(driver.Tagger)
+		if !ok {
+			return fmt.Errorf("storage driver %s does not support tagging blobs for expiry", storageDriver.Name())
+		}
+		// Tagging no paths only checks that the driver supports it.
+		if err := tagger.TagFiles(ctx, nil, opts.ExpireTags); err != nil {
+			return fmt.Errorf("storage driver %s does not support tagging blobs for expiry: %v", storageDriver.Name(), err)
+		}
+	}
+
 	// mark
-	markSet := make(map[digest.Digest]struct{})
+	var markSet markSet = make(memoryMarkSet)
+	if opts.MarkSetDir != "" {
+		diskSet, err := newDiskMarkSet(opts.MarkSetDir, opts.MarkSetMemoryLimit)
+		if err != nil {
+			return fmt.Errorf("failed to create marked set: %v", err)
+		}
+		markSet = diskSet
+	}
+	defer markSet.close()
 	deleteLayerSet := make(map[string][]digest.Digest)
 	manifestArr := make([]ManifestDel, 0)
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+
+	var checkpoint *gcCheckpoint
+	if opts.CheckpointDir != "" {
+		var marked []digest.Digest
+		var err error
+		checkpoint, marked, err = openGCCheckpoint(opts.CheckpointDir, opts.CheckpointInterval, opts)
+		if err != nil {
+			return fmt.Errorf("failed to open checkpoint: %v", err)
+		}
+		defer checkpoint.close()
+
+		for _, dgst := range marked {
+			markSet.add(dgst)
+		}
+		if checkpoint.state.Manifests != nil {
+			manifestArr = checkpoint.state.Manifests
+		}
+		if checkpoint.state.DeleteLayers != nil {
+			deleteLayerSet = checkpoint.state.DeleteLayers
+		}
+		if checkpoint.resumed && !opts.Quiet {
+			emit("resuming from checkpoint after repository %q, %d blobs marked", checkpoint.state.LastRepository, markSet.len())
+		}
+	}
+	mark := func(dgst digest.Digest) {
+		if markSet.add(dgst) {
+			return
+		}
+		if checkpoint != nil {
+			checkpoint.mark(dgst)
+		}
+	}
+
+	markRepository := func(repoName string) error {
 		if !opts.Quiet {
 			emit(repoName)
 		}
@@ -95,12 +185,12 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			if !opts.Quiet {
 				emit("%s: marking manifest %s ", repoName, dgst)
 			}
-			markSet[dgst] = struct{}{}
+			mark(dgst)
 
 			return markManifestReferences(dgst, manifestService, ctx, func(d digest.Digest) bool {
-				_, marked := markSet[d]
+				marked := markSet.has(d)
 				if !marked {
-					markSet[d] = struct{}{}
+					mark(d)
 					if !opts.Quiet {
 						emit("%s: marking blob %s", repoName, d)
 					}
@@ -127,7 +217,7 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 		var deleteLayers []digest.Digest
 		err = layerEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if _, ok := markSet[dgst]; !ok {
+			if !markSet.has(dgst) {
 				deleteLayers = append(deleteLayers, dgst)
 			}
 			return nil
@@ -136,18 +226,70 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			deleteLayerSet[repoName] = deleteLayers
 		}
 		return err
-	})
+	}
+
+	var err error
+	switch {
+	case checkpoint == nil:
+		err = repositoryEnumerator.Enumerate(ctx, markRepository)
+	case !checkpoint.state.MarkComplete:
+		err = enumerateRepositoriesFrom(ctx, registry, checkpoint.state.LastRepository, func(repoName string) error {
+			if err := markRepository(repoName); err != nil {
+				return err
+			}
+			return checkpoint.repositoryDone(repoName, manifestArr, deleteLayerSet)
+		})
+		if err == nil {
+			err = checkpoint.markDone(manifestArr, deleteLayerSet)
+		}
+	}
+	if err == nil {
+		err = markSet.err()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to mark: %v", err)
 	}
 
 	manifestArr = unmarkReferencedManifest(manifestArr, markSet, opts.Quiet)
 
+	// Blobs tagged for expiry by a previous collection may be referenced
+	// again, and must not be removed by the storage backend.
+	err = walkExpiring(ctx, storageDriver, func(dgst digest.Digest) error {
+		if !markSet.has(dgst) {
+			return nil
+		}
+		if !opts.Quiet {
+			emit("blob referenced again, clearing expiry tags: %s", dgst)
+		}
+		if opts.DryRun {
+			return nil
+		}
+		return clearExpiry(ctx, storageDriver, dgst)
+	})
+	if err == nil {
+		err = markSet.err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clear expiry tags: %v", err)
+	}
+
+	// A resumed collection may have already swept some of the content.
+	sweepErr := func(err error) error {
+		var notFound driver.PathNotFoundError
+		if checkpoint != nil && checkpoint.resumed && errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
+	if changes, ok := registry.(changeRecorder); ok {
+		vacuum.changes = changes
+	}
 	if !opts.DryRun {
 		for _, obj := range manifestArr {
-			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
+			err = sweepErr(vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags))
 			if err != nil {
 				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
@@ -157,17 +299,32 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	deleteSet := make(map[digest.Digest]struct{})
 	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
 		// check if digest is in markSet. If not, delete it!
-		if _, ok := markSet[dgst]; !ok {
-			deleteSet[dgst] = struct{}{}
+		if markSet.has(dgst) {
+			return nil
+		}
+		if opts.WORM {
+			// Skip blobs which have already been deleted.
+			_, err := readTombstone(ctx, storageDriver, dgst)
+			if err == nil {
+				return nil
+			}
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
 		}
+		deleteSet[dgst] = struct{}{}
 		return nil
 	})
+	if err == nil {
+		err = markSet.err()
+	}
 	if err != nil {
 		return fmt.Errorf("error enumerating blobs: %v", err)
 	}
 	if !opts.Quiet {
-		emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
+		emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", markSet.len(), len(deleteSet), len(manifestArr))
 	}
+	batch := make([]digest.Digest, 0, blobDeleteBatchSize)
 	for dgst := range deleteSet {
 		if !opts.Quiet {
 			emit("blob eligible for deletion: %s", dgst)
@@ -175,9 +332,36 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		if opts.DryRun {
 			continue
 		}
-		err = vacuum.RemoveBlob(string(dgst))
-		if err != nil {
-			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
+		batch = append(batch, dgst)
+		if len(batch) == blobDeleteBatchSize {
+			if err := sweepBlobs(vacuum, batch, opts); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := sweepBlobs(vacuum, batch, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.WORM {
+		if err := sweepTombstones(ctx, vacuum, storageDriver, markSet, opts); err != nil {
+			return err
+		}
+	}
+
+	// Chunks no longer part of any blob are deleted unless in WORM mode,
+	// where nothing is deleted from the backend. Blobs tagged for expiry
+	// keep their chunks until the storage backend removes their chunk index.
+	if !opts.WORM {
+		var deletedBlobs map[digest.Digest]struct{}
+		if len(opts.ExpireTags) == 0 {
+			deletedBlobs = deleteSet
+		}
+		if err := sweepChunks(ctx, vacuum, storageDriver, deletedBlobs, opts); err != nil {
+			return err
 		}
 	}
 
@@ -189,21 +373,53 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			if opts.DryRun {
 				continue
 			}
-			err = vacuum.RemoveLayer(repo, dgst)
+			err = sweepErr(vacuum.RemoveLayer(repo, dgst))
 			if err != nil {
 				return fmt.Errorf("failed to delete layer link %s of repo %s: %v", dgst, repo, err)
 			}
 		}
 	}
 
+	if checkpoint != nil {
+		if err := checkpoint.remove(); err != nil {
+			return fmt.Errorf("failed to remove checkpoint: %v", err)
+		}
+	}
+
 	return err
 }
 
+// enumerateRepositoriesFrom calls ingester for each repository ordered after
+// last, so that an interrupted enumeration can be continued.
+func enumerateRepositoriesFrom(ctx context.Context, registry distribution.Namespace, last string, ingester func(string) error) error {
+	repos := make([]string, 100)
+	for {
+		n, err := registry.Repositories(ctx, repos, last)
+		for _, repo := range repos[:n] {
+			if err := ingester(repo); err != nil {
+				return err
+			}
+			last = repo
+		}
+		switch err.(type) {
+		case nil:
+		case driver.PathNotFoundError:
+			// nothing has been pushed to the registry
+			return nil
+		default:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
 // unmarkReferencedManifest filters out manifest present in markSet
-func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Digest]struct{}, quietOutput bool) []ManifestDel {
+func unmarkReferencedManifest(manifestArr []ManifestDel, markSet markSet, quietOutput bool) []ManifestDel {
 	filtered := make([]ManifestDel, 0)
 	for _, obj := range manifestArr {
-		if _, ok := markSet[obj.Digest]; !ok {
+		if !markSet.has(obj.Digest) {
 			if !quietOutput {
 				emit("manifest eligible for deletion: %s", obj)
 			}
@@ -238,3 +454,142 @@ func markManifestReferences(dgst digest.Digest, manifestService distribution.Man
 	}
 	return nil
 }
+
+// sweepBlobs deletes a batch of unreferenced blobs, tombstones them in WORM
+// mode, or tags them for expiry if opts.ExpireTags is set.
+func sweepBlobs(vacuum Vacuum, dgsts []digest.Digest, opts GCOpts) error {
+	if opts.WORM {
+		tombstoned, err := vacuum.TombstoneBlobs(dgsts)
+		if err != nil {
+			return fmt.Errorf("failed to tombstone blobs: %v", err)
+		}
+		if !opts.Quiet {
+			emit("%d blobs (%d bytes) tombstoned", tombstoned.Count, tombstoned.Bytes)
+		}
+		return nil
+	}
+	if len(opts.ExpireTags) > 0 {
+		if err := vacuum.TagBlobs(dgsts, opts.ExpireTags); err != nil {
+			return fmt.Errorf("failed to tag blobs for expiry: %v", err)
+		}
+		return nil
+	}
+	if err := vacuum.RemoveBlobs(dgsts); err != nil {
+		return fmt.Errorf("failed to delete blobs: %v", err)
+	}
+	return nil
+}
+
+// sweepChunks deletes the chunks which are no longer part of any blob, other
+// than those deleted.
+func sweepChunks(ctx context.Context, vacuum Vacuum, storageDriver driver.StorageDriver, deleteSet map[digest.Digest]struct{}, opts GCOpts) error {
+	chunked, err := vacuum.chunksStored()
+	if err != nil || !chunked {
+		return err
+	}
+
+	blobsPath, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return err
+	}
+	referenced := make(map[digest.Digest]struct{})
+	err = storageDriver.Walk(ctx, blobsPath, func(fileInfo driver.FileInfo) error {
+		dir, fileName := path.Split(fileInfo.Path())
+		if fileInfo.IsDir() || fileName != "chunks" {
+			return nil
+		}
+		dgst, err := digestFromPath(path.Clean(dir))
+		if err != nil {
+			return err
+		}
+		if _, ok := deleteSet[dgst]; ok {
+			// Only left in place by a dry run.
+			return nil
+		}
+		p, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		var index chunkIndex
+		if err := json.Unmarshal(p, &index); err != nil {
+			return fmt.Errorf("invalid chunk index for blob %s: %v", dgst, err)
+		}
+		for _, chunk := range index.Chunks {
+			referenced[chunk.Digest] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error enumerating chunked blobs: %v", err)
+	}
+
+	chunksPath, err := pathFor(chunksPathSpec{})
+	if err != nil {
+		return err
+	}
+	var unreferenced []digest.Digest
+	err = storageDriver.Walk(ctx, chunksPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "data" {
+			return nil
+		}
+		dgst, err := digestFromPath(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		if _, ok := referenced[dgst]; !ok {
+			unreferenced = append(unreferenced, dgst)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error enumerating chunks: %v", err)
+	}
+
+	if !opts.Quiet {
+		emit("%d chunks referenced, %d chunks eligible for deletion", len(referenced), len(unreferenced))
+	}
+	for _, dgst := range unreferenced {
+		if !opts.Quiet {
+			emit("chunk eligible for deletion: %s", dgst)
+		}
+		if opts.DryRun {
+			continue
+		}
+		err := vacuum.RemoveChunk(dgst)
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return fmt.Errorf("failed to delete chunk %s: %v", dgst, err)
+		}
+	}
+	return nil
+}
+
+// sweepTombstones restores the tombstones of blobs which are referenced
+// again, and reports the size of the blobs retained by WORM storage.
+func sweepTombstones(ctx context.Context, vacuum Vacuum, storageDriver driver.StorageDriver, markSet markSet, opts GCOpts) error {
+	var retained RetainedBlobs
+	err := walkTombstones(ctx, storageDriver, func(t tombstone) error {
+		if !markSet.has(t.Digest) {
+			retained.Count++
+			retained.Bytes += t.Size
+			return nil
+		}
+		if !opts.Quiet {
+			emit("blob referenced again, restoring tombstone: %s", t.Digest)
+		}
+		if opts.DryRun {
+			return nil
+		}
+		return vacuum.RestoreTombstone(t)
+	})
+	if err == nil {
+		err = markSet.err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sweep tombstones: %v", err)
+	}
+	if !opts.Quiet {
+		emit("%d deleted blobs (%d bytes) retained by WORM storage", retained.Count, retained.Bytes)
+	}
+	dcontext.GetLogger(ctx).Infof("%d deleted blobs (%d bytes) retained by WORM storage", retained.Count, retained.Bytes)
+	return nil
+}
@@ -1,9 +1,17 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -702,6 +710,387 @@ func TestOrphanBlobDeleted(t *testing.T) {
 	}
 }
 
+// bulkDeleteDriver records calls to DeleteFiles, removing each path with
+// Delete.
+type bulkDeleteDriver struct {
+	driver.StorageDriver
+	calls int
+	paths []string
+}
+
+func (d *bulkDeleteDriver) DeleteFiles(ctx context.Context, paths []string) error {
+	d.calls++
+	d.paths = append(d.paths, paths...)
+	for _, p := range paths {
+		if err := d.Delete(ctx, p); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestOrphanBlobsBulkDeleted(t *testing.T) {
+	bulkDriver := &bulkDeleteDriver{StorageDriver: inmemory.New()}
+
+	registry := createRegistry(t, bulkDriver)
+	repo := makeRepository(t, registry, "bulk")
+
+	digests, err := testutil.CreateRandomLayers(3)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	uploadRandomSchema2Image(t, repo)
+
+	err = MarkAndSweep(dcontext.Background(), bulkDriver, registry, GCOpts{})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	if bulkDriver.calls != 1 {
+		t.Fatalf("expected a single bulk delete, got %d", bulkDriver.calls)
+	}
+	if len(bulkDriver.paths) != len(digests) {
+		t.Fatalf("expected %d paths to be deleted, got %v", len(digests), bulkDriver.paths)
+	}
+	blobs := allBlobs(t, registry)
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; ok {
+			t.Fatalf("Orphan layer is present: %v", dgst)
+		}
+	}
+}
+
+// taggingDriver records the tags applied by TagFiles.
+type taggingDriver struct {
+	driver.StorageDriver
+	tags map[string]map[string]string
+}
+
+func (d *taggingDriver) TagFiles(ctx context.Context, paths []string, tags map[string]string) error {
+	for _, p := range paths {
+		d.tags[p] = tags
+	}
+	return nil
+}
+
+func TestOrphanBlobsTaggedForExpiry(t *testing.T) {
+	tagDriver := &taggingDriver{StorageDriver: inmemory.New(), tags: make(map[string]map[string]string)}
+
+	registry := createRegistry(t, tagDriver)
+	repo := makeRepository(t, registry, "expiry")
+
+	digests, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	img := uploadRandomSchema2Image(t, repo)
+
+	expireTags := map[string]string{"registry-gc": "expired"}
+	err = MarkAndSweep(dcontext.Background(), tagDriver, registry, GCOpts{ExpireTags: expireTags})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("Orphan layer was deleted: %v", dgst)
+		}
+		p, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tagDriver.tags[p]["registry-gc"] != "expired" {
+			t.Fatalf("Orphan layer was not tagged: %v", dgst)
+		}
+	}
+	if len(tagDriver.tags) != len(digests) {
+		t.Fatalf("expected %d blobs to be tagged, got %d", len(digests), len(tagDriver.tags))
+	}
+
+	// Blobs tagged for expiry which are pushed again, or referenced again
+	// by the time of the next collection, have their tags cleared.
+	var pushed, referenced digest.Digest
+	for dgst := range digests {
+		pushed = dgst
+	}
+	for dgst := range img.layers {
+		referenced = dgst
+	}
+	if _, err := digests[pushed].Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{pushed: digests[pushed]}); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	// as tagged by a collection run while the image was untagged
+	if err := NewVacuum(dcontext.Background(), tagDriver).TagBlobs([]digest.Digest{referenced}, expireTags); err != nil {
+		t.Fatal(err)
+	}
+	expectCleared := func(dgst digest.Digest) {
+		t.Helper()
+		p, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tagDriver.tags[p]) != 0 {
+			t.Fatalf("expected expiry tags of blob %s to be cleared, got %v", dgst, tagDriver.tags[p])
+		}
+		markerPath, err := pathFor(expiringBlobPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tagDriver.Stat(dcontext.Background(), markerPath); err == nil {
+			t.Fatalf("expected expiry marker of blob %s to be removed", dgst)
+		}
+	}
+	expectCleared(pushed)
+
+	err = MarkAndSweep(dcontext.Background(), tagDriver, registry, GCOpts{ExpireTags: expireTags})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	expectCleared(referenced)
+
+	// The inmemory driver cannot tag blobs.
+	inmemoryDriver := inmemory.New()
+	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, createRegistry(t, inmemoryDriver), GCOpts{ExpireTags: expireTags})
+	if err == nil {
+		t.Fatal("expected error tagging blobs with the inmemory driver")
+	}
+}
+
+func TestBlobTaggedForExpiryReferencedByManifest(t *testing.T) {
+	ctx := dcontext.Background()
+	tagDriver := &taggingDriver{StorageDriver: inmemory.New(), tags: make(map[string]map[string]string)}
+
+	registry := createRegistry(t, tagDriver)
+	repo := makeRepository(t, registry, "expiry")
+
+	digests, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	var layer digest.Digest
+	for dgst := range digests {
+		layer = dgst
+	}
+	// as tagged by a collection run before the layer is referenced
+	expireTags := map[string]string{"registry-gc": "expired"}
+	if err := NewVacuum(ctx, tagDriver).TagBlobs([]digest.Digest{layer}, expireTags); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client finds the layer present and pushes a manifest referencing
+	// it, which clears its expiry tags.
+	if _, err := repo.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("unexpected error checking for layer: %v", err)
+	}
+	manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeManifestService(t, repo).Put(ctx, manifest); err != nil {
+		t.Fatalf("manifest upload failed: %v", err)
+	}
+
+	p, err := pathFor(blobDataPathSpec{digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tagDriver.tags[p]) != 0 {
+		t.Fatalf("expected expiry tags of referenced layer to be cleared, got %v", tagDriver.tags[p])
+	}
+	markerPath, err := pathFor(expiringBlobPathSpec{digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tagDriver.Stat(ctx, markerPath); err == nil {
+		t.Fatal("expected expiry marker of referenced layer to be removed")
+	}
+}
+
+func TestOrphanBlobsRetainedInWORMMode(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "worm")
+
+	digests, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	uploadRandomSchema2Image(t, repo)
+
+	dir := t.TempDir()
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{WORM: true, CheckpointDir: dir}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("Orphan layer was deleted in WORM mode: %v", dgst)
+		}
+		if _, err := readTombstone(ctx, inmemoryDriver, dgst); err != nil {
+			t.Fatalf("Orphan layer has no tombstone: %v", err)
+		}
+		if _, err := repo.Blobs(ctx).Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+			t.Fatalf("expected layer link of orphan layer to be removed in WORM mode, got %v", err)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("Checkpoint not removed after collection in WORM mode: %v %v", entries, err)
+	}
+
+	// Link one of the blobs again: its tombstone is removed by the next
+	// collection.
+	var relinked digest.Digest
+	for dgst := range digests {
+		relinked = dgst
+		break
+	}
+	if _, err := digests[relinked].Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{relinked: digests[relinked]}); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{relinked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manifests.Put(ctx, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{WORM: true}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	for dgst := range digests {
+		_, err := readTombstone(ctx, inmemoryDriver, dgst)
+		_, notFound := err.(driver.PathNotFoundError)
+		if dgst == relinked && !notFound {
+			t.Fatalf("expected tombstone of relinked blob to be removed, got %v", err)
+		}
+		if dgst != relinked && err != nil {
+			t.Fatalf("expected tombstone of orphan blob to remain, got %v", err)
+		}
+	}
+}
+
+// wormDriver fails on any delete outside of the repositories, as a bucket
+// with WORM protection would.
+type wormDriver struct {
+	driver.StorageDriver
+}
+
+func (d *wormDriver) checkDelete(p string) error {
+	repositoriesPath, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(p, repositoriesPath+"/") {
+		return fmt.Errorf("delete issued in WORM mode: %s", p)
+	}
+	return nil
+}
+
+func (d *wormDriver) Delete(ctx context.Context, p string) error {
+	if err := d.checkDelete(p); err != nil {
+		return err
+	}
+	return d.StorageDriver.Delete(ctx, p)
+}
+
+func (d *wormDriver) DeleteFiles(ctx context.Context, paths []string) error {
+	for _, p := range paths {
+		if err := d.Delete(ctx, p); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestWORMModeNeverDeletes(t *testing.T) {
+	ctx := dcontext.Background()
+	d := &wormDriver{StorageDriver: inmemory.New()}
+	registry := createRegistry(t, d, EnableChunking(64<<10, 1024))
+	repo := makeRepository(t, registry, "worm")
+
+	orphaned := randomBytes(t, 256<<10)
+	dgst := digest.FromBytes(orphaned)
+	if err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{dgst: bytes.NewReader(orphaned)}); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	// a chunk left by an interrupted upload
+	stray := []byte("stray chunk")
+	strayPath, err := pathFor(chunkDataPathSpec{digest: digest.FromBytes(stray)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, strayPath, stray); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MarkAndSweep(ctx, d, registry, GCOpts{WORM: true}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if _, err := readTombstone(ctx, d, dgst); err != nil {
+		t.Fatalf("Orphan blob has no tombstone: %v", err)
+	}
+
+	// Referenced again, the blob has its tombstone overwritten.
+	if err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{dgst: bytes.NewReader(orphaned)}); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{dgst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeManifestService(t, repo).Put(ctx, manifest); err != nil {
+		t.Fatalf("manifest upload failed: %v", err)
+	}
+
+	if err := MarkAndSweep(ctx, d, registry, GCOpts{WORM: true}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if _, err := readTombstone(ctx, d, dgst); !errors.As(err, new(driver.PathNotFoundError)) {
+		t.Fatalf("expected tombstone of relinked blob to be restored, got %v", err)
+	}
+	tombstonePath, err := pathFor(tombstonePathSpec{digest: dgst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, tombstonePath); err != nil {
+		t.Fatalf("expected restored tombstone to be retained: %v", err)
+	}
+	if _, err := d.Stat(ctx, strayPath); err != nil {
+		t.Fatalf("expected stray chunk to be retained: %v", err)
+	}
+}
+
 func TestTaggedManifestlistWithUntaggedManifest(t *testing.T) {
 	ctx := dcontext.Background()
 	inmemoryDriver := inmemory.New()
@@ -923,3 +1312,151 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 		t.Fatalf("Garbage collection affected storage: %d != %d", len(after), 0)
 	}
 }
+
+// failingRepositoryDriver fails on any access to the repository with the
+// given path prefix while fail is set, as an interrupted run would.
+type failingRepositoryDriver struct {
+	driver.StorageDriver
+	prefix string
+	fail   bool
+}
+
+func (d *failingRepositoryDriver) check(p string) error {
+	if d.fail && strings.HasPrefix(p, d.prefix) {
+		return fmt.Errorf("interrupted at %s", p)
+	}
+	return nil
+}
+
+func (d *failingRepositoryDriver) GetContent(ctx context.Context, p string) ([]byte, error) {
+	if err := d.check(p); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, p)
+}
+
+func (d *failingRepositoryDriver) Stat(ctx context.Context, p string) (driver.FileInfo, error) {
+	if err := d.check(p); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.Stat(ctx, p)
+}
+
+func (d *failingRepositoryDriver) List(ctx context.Context, p string) ([]string, error) {
+	if err := d.check(p); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.List(ctx, p)
+}
+
+func (d *failingRepositoryDriver) Walk(ctx context.Context, p string, f driver.WalkFn, options ...func(*driver.WalkOptions)) error {
+	if err := d.check(p); err != nil {
+		return err
+	}
+	return d.StorageDriver.Walk(ctx, p, f, options...)
+}
+
+func TestGCResumeFromCheckpoint(t *testing.T) {
+	ctx := dcontext.Background()
+	repositoryPath, err := pathFor(manifestRevisionsPathSpec{name: "b/two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &failingRepositoryDriver{StorageDriver: inmemory.New(), prefix: repositoryPath}
+
+	registry := createRegistry(t, d)
+	repo1 := makeRepository(t, registry, "a/one")
+	repo2 := makeRepository(t, registry, "b/two")
+	image1 := uploadRandomSchema2Image(t, repo1)
+	image2 := uploadRandomSchema2Image(t, repo2)
+
+	orphans, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	if err = testutil.UploadBlobs(repo2, orphans); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+
+	// The first run is interrupted while marking the second repository.
+	dir := t.TempDir()
+	opts := GCOpts{CheckpointDir: dir, CheckpointInterval: time.Nanosecond, Quiet: true}
+	d.fail = true
+	if err := MarkAndSweep(ctx, d, registry, opts); err == nil {
+		t.Fatal("expected interrupted mark and sweep to fail")
+	}
+	d.fail = false
+
+	checkpoint, marked, err := openGCCheckpoint(dir, opts.CheckpointInterval, opts)
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint: %v", err)
+	}
+	if err := checkpoint.close(); err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.resumed || checkpoint.state.LastRepository != "a/one" {
+		t.Fatalf("unexpected checkpoint state: %+v", checkpoint.state)
+	}
+	markedSet := make(map[digest.Digest]struct{})
+	for _, dgst := range marked {
+		markedSet[dgst] = struct{}{}
+	}
+	if _, ok := markedSet[image1.manifestDigest]; !ok {
+		t.Fatalf("expected the manifest of the first repository to be marked, got %v", marked)
+	}
+	for dgst := range image1.layers {
+		if _, ok := markedSet[dgst]; !ok {
+			t.Fatalf("expected the layers of the first repository to be marked, got %v", marked)
+		}
+	}
+
+	if err := MarkAndSweep(ctx, d, registry, opts); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	// All referenced content survives the resumed collection.
+	blobs := allBlobs(t, registry)
+	for _, img := range []image{image1, image2} {
+		if _, ok := blobs[img.manifestDigest]; !ok {
+			t.Fatalf("Referenced manifest was deleted: %v", img.manifestDigest)
+		}
+		for dgst := range img.layers {
+			if _, ok := blobs[dgst]; !ok {
+				t.Fatalf("Referenced layer was deleted: %v", dgst)
+			}
+		}
+	}
+	for dgst := range orphans {
+		if _, ok := blobs[dgst]; ok {
+			t.Fatalf("Orphan layer is present: %v", dgst)
+		}
+	}
+
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("Checkpoint not removed after collection: %v %v", entries, err)
+	}
+}
+
+func TestGCCheckpointWithMissingMarks(t *testing.T) {
+	dir := t.TempDir()
+	opts := GCOpts{CheckpointDir: dir}
+	checkpoint, _, err := openGCCheckpoint(dir, time.Nanosecond, opts)
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint: %v", err)
+	}
+	checkpoint.mark(digest.FromString("marked"))
+	if err := checkpoint.repositoryDone("a/one", nil, nil); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+	if err := checkpoint.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The marks of the completed repository are lost.
+	if err := os.Truncate(filepath.Join(dir, gcCheckpointMarkedFile), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := openGCCheckpoint(dir, time.Nanosecond, opts); err == nil {
+		t.Fatal("expected error opening a checkpoint with missing marks")
+	}
+}
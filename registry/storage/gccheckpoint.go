@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	gcCheckpointStateFile  = "state.json"
+	gcCheckpointMarkedFile = "marked"
+
+	// defaultGCCheckpointInterval is used when GCOpts.CheckpointInterval is
+	// not set.
+	defaultGCCheckpointInterval = time.Minute
+)
+
+// gcCheckpointState is the progress of the mark phase recorded in a
+// checkpoint. The marked set itself is kept in a separate append-only file,
+// of which only the first MarkedSize bytes are valid. Completed holds, for
+// each repository marked, the size of the marked set file once its marks
+// were written.
+type gcCheckpointState struct {
+	RemoveUntagged bool                       `json:"removeUntagged"`
+	LastRepository string                     `json:"lastRepository,omitempty"`
+	MarkComplete   bool                       `json:"markComplete,omitempty"`
+	MarkedSize     int64                      `json:"markedSize"`
+	Completed      map[string]int64           `json:"completed,omitempty"`
+	Manifests      []ManifestDel              `json:"manifests,omitempty"`
+	DeleteLayers   map[string][]digest.Digest `json:"deleteLayers,omitempty"`
+}
+
+// gcCheckpoint periodically persists the state of the mark phase to a local
+// directory so that an interrupted garbage collection can be resumed.
+type gcCheckpoint struct {
+	dir      string
+	interval time.Duration
+	written  time.Time
+	state    gcCheckpointState
+	marked   *os.File
+	pending  []digest.Digest
+	// done holds the repositories marked since the last checkpoint.
+	done []string
+
+	// resumed is true if the checkpoint was loaded from a previous run.
+	resumed bool
+}
+
+// openGCCheckpoint opens the checkpoint in dir, creating it if needed. The
+// digests marked by a previous run are returned so that they can be added
+// back to the marked set.
+func openGCCheckpoint(dir string, interval time.Duration, opts GCOpts) (*gcCheckpoint, []digest.Digest, error) {
+	if interval <= 0 {
+		interval = defaultGCCheckpointInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	c := &gcCheckpoint{
+		dir:      dir,
+		interval: interval,
+		written:  time.Now(),
+		state:    gcCheckpointState{RemoveUntagged: opts.RemoveUntagged},
+	}
+
+	p, err := os.ReadFile(filepath.Join(dir, gcCheckpointStateFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(p, &c.state); err != nil {
+			return nil, nil, fmt.Errorf("invalid checkpoint state: %v", err)
+		}
+		if c.state.RemoveUntagged != opts.RemoveUntagged {
+			return nil, nil, fmt.Errorf("checkpoint in %s was written with different options, remove it to start over", dir)
+		}
+		c.resumed = true
+	case errors.Is(err, os.ErrNotExist):
+	default:
+		return nil, nil, err
+	}
+
+	c.marked, err = os.OpenFile(filepath.Join(dir, gcCheckpointMarkedFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.verify(); err != nil {
+		c.marked.Close()
+		return nil, nil, fmt.Errorf("checkpoint in %s is inconsistent, remove it to start over: %v", dir, err)
+	}
+	// Discard anything appended after the last complete checkpoint.
+	if err := c.marked.Truncate(c.state.MarkedSize); err != nil {
+		c.marked.Close()
+		return nil, nil, err
+	}
+
+	var marked []digest.Digest
+	scanner := bufio.NewScanner(c.marked)
+	for scanner.Scan() {
+		dgst, err := digest.Parse(scanner.Text())
+		if err != nil {
+			c.marked.Close()
+			return nil, nil, fmt.Errorf("invalid checkpoint marked set: %v", err)
+		}
+		marked = append(marked, dgst)
+	}
+	if err := scanner.Err(); err != nil {
+		c.marked.Close()
+		return nil, nil, err
+	}
+	if _, err := c.marked.Seek(0, io.SeekEnd); err != nil {
+		c.marked.Close()
+		return nil, nil, err
+	}
+
+	return c, marked, nil
+}
+
+// verify checks that the marks of every repository recorded as completed are
+// in the marked set file.
+func (c *gcCheckpoint) verify() error {
+	fi, err := c.marked.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < c.state.MarkedSize {
+		return fmt.Errorf("marked set is %d bytes, expected at least %d", fi.Size(), c.state.MarkedSize)
+	}
+	if _, ok := c.state.Completed[c.state.LastRepository]; c.state.LastRepository != "" && !ok {
+		return fmt.Errorf("repository %s has no recorded marks", c.state.LastRepository)
+	}
+	for repo, size := range c.state.Completed {
+		if size > c.state.MarkedSize {
+			return fmt.Errorf("marks of repository %s are beyond the end of the marked set", repo)
+		}
+	}
+	return nil
+}
+
+// mark records a newly marked digest, to be written with the next checkpoint.
+func (c *gcCheckpoint) mark(dgst digest.Digest) {
+	c.pending = append(c.pending, dgst)
+}
+
+// repositoryDone records that marking of repo is complete, writing a
+// checkpoint if the interval has elapsed.
+func (c *gcCheckpoint) repositoryDone(repo string, manifestArr []ManifestDel, deleteLayerSet map[string][]digest.Digest) error {
+	c.state.LastRepository = repo
+	c.done = append(c.done, repo)
+	if time.Since(c.written) < c.interval {
+		return nil
+	}
+	return c.write(manifestArr, deleteLayerSet)
+}
+
+// markDone records that the mark phase is complete.
+func (c *gcCheckpoint) markDone(manifestArr []ManifestDel, deleteLayerSet map[string][]digest.Digest) error {
+	c.state.MarkComplete = true
+	return c.write(manifestArr, deleteLayerSet)
+}
+
+// write flushes pending marked digests and atomically replaces the state
+// file.
+func (c *gcCheckpoint) write(manifestArr []ManifestDel, deleteLayerSet map[string][]digest.Digest) error {
+	w := bufio.NewWriter(c.marked)
+	for _, dgst := range c.pending {
+		if _, err := w.WriteString(dgst.String() + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := c.marked.Sync(); err != nil {
+		return err
+	}
+	size, err := c.marked.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	c.pending = c.pending[:0]
+
+	if c.state.Completed == nil {
+		c.state.Completed = make(map[string]int64)
+	}
+	for _, repo := range c.done {
+		c.state.Completed[repo] = size
+	}
+	c.done = c.done[:0]
+
+	c.state.MarkedSize = size
+	c.state.Manifests = manifestArr
+	c.state.DeleteLayers = deleteLayerSet
+	p, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(c.dir, gcCheckpointStateFile+".tmp")
+	if err := os.WriteFile(tmp, p, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, gcCheckpointStateFile)); err != nil {
+		return err
+	}
+	c.written = time.Now()
+	return nil
+}
+
+// remove deletes the checkpoint once garbage collection has completed.
+func (c *gcCheckpoint) remove() error {
+	for _, name := range []string{gcCheckpointStateFile, gcCheckpointMarkedFile} {
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// close releases the checkpoint without removing it.
+func (c *gcCheckpoint) close() error {
+	return c.marked.Close()
+}
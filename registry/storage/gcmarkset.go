@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// defaultMarkSetMemoryLimit is the number of digests a disk backed
+	// marked set holds in memory when GCOpts.MarkSetMemoryLimit is not set.
+	defaultMarkSetMemoryLimit = 1000000
+
+	// maxMarkSetSegments is the number of segment files after which a disk
+	// backed marked set merges them into one.
+	maxMarkSetSegments = 8
+
+	// markRecordSize is the size of a digest record in a segment file: an
+	// algorithm identifier followed by the hash, padded to the largest
+	// supported hash size.
+	markRecordSize = 1 + sha512.Size
+)
+
+// markSet is the set of digests marked as in use during garbage collection.
+type markSet interface {
+	// add marks dgst, returning true if it was already marked.
+	add(dgst digest.Digest) bool
+	// has returns true if dgst is marked.
+	has(dgst digest.Digest) bool
+	// len returns the number of marked digests.
+	len() int
+	// err returns the first error encountered by the set. Once an error
+	// has occurred the contents of the set can no longer be trusted.
+	err() error
+	// close releases any resources held by the set.
+	close() error
+}
+
+// memoryMarkSet is a markSet held entirely in memory.
+type memoryMarkSet map[digest.Digest]struct{}
+
+func (s memoryMarkSet) add(dgst digest.Digest) bool {
+	if _, ok := s[dgst]; ok {
+		return true
+	}
+	s[dgst] = struct{}{}
+	return false
+}
+
+func (s memoryMarkSet) has(dgst digest.Digest) bool {
+	_, ok := s[dgst]
+	return ok
+}
+
+func (s memoryMarkSet) len() int     { return len(s) }
+func (s memoryMarkSet) err() error   { return nil }
+func (s memoryMarkSet) close() error { return nil }
+
+// diskMarkSet is a markSet which keeps at most limit digests in memory. When
+// the limit is reached the digests are written to a new sorted segment file
+// in dir, and lookups binary search each segment. Segments are merged when
+// there are more than maxMarkSetSegments of them, so memory use is bounded
+// regardless of the number of blobs in the registry.
+type diskMarkSet struct {
+	dir      string
+	limit    int
+	memory   map[digest.Digest]struct{}
+	segments []markSegment
+	count    int
+	seq      int
+	firstErr error
+}
+
+// markSegment is a file of sorted, fixed size digest records.
+type markSegment struct {
+	f *os.File
+	n int64
+}
+
+func newDiskMarkSet(dir string, limit int) (*diskMarkSet, error) {
+	if limit <= 0 {
+		limit = defaultMarkSetMemoryLimit
+	}
+	dir, err := os.MkdirTemp(dir, "markset-")
+	if err != nil {
+		return nil, err
+	}
+	return &diskMarkSet{
+		dir:    dir,
+		limit:  limit,
+		memory: make(map[digest.Digest]struct{}),
+	}, nil
+}
+
+func (s *diskMarkSet) add(dgst digest.Digest) bool {
+	if s.has(dgst) {
+		return true
+	}
+	s.memory[dgst] = struct{}{}
+	s.count++
+	if len(s.memory) >= s.limit {
+		s.setErr(s.flush())
+	}
+	return false
+}
+
+func (s *diskMarkSet) has(dgst digest.Digest) bool {
+	if _, ok := s.memory[dgst]; ok {
+		return true
+	}
+	if len(s.segments) == 0 || s.firstErr != nil {
+		return false
+	}
+
+	record, err := encodeMarkRecord(dgst)
+	if err != nil {
+		s.setErr(err)
+		return false
+	}
+	for _, segment := range s.segments {
+		found, err := segment.search(record)
+		if err != nil {
+			s.setErr(err)
+			return false
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *diskMarkSet) len() int {
+	return s.count
+}
+
+func (s *diskMarkSet) err() error {
+	return s.firstErr
+}
+
+func (s *diskMarkSet) close() error {
+	for _, segment := range s.segments {
+		segment.f.Close()
+	}
+	s.segments = nil
+	return os.RemoveAll(s.dir)
+}
+
+func (s *diskMarkSet) setErr(err error) {
+	if err != nil && s.firstErr == nil {
+		s.firstErr = fmt.Errorf("marked set: %v", err)
+	}
+}
+
+// flush writes the digests held in memory to a new segment.
+func (s *diskMarkSet) flush() error {
+	records := make([][markRecordSize]byte, 0, len(s.memory))
+	for dgst := range s.memory {
+		record, err := encodeMarkRecord(dgst)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return bytes.Compare(records[i][:], records[j][:]) < 0
+	})
+
+	segment, err := s.writeSegment(func(w io.Writer) (int64, error) {
+		for i := range records {
+			if _, err := w.Write(records[i][:]); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(records)), nil
+	})
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, segment)
+	s.memory = make(map[digest.Digest]struct{})
+
+	if len(s.segments) > maxMarkSetSegments {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact merges all segments into one. Records are unique across segments
+// since a digest is only added if it is not already present.
+func (s *diskMarkSet) compact() error {
+	readers := make([]*bufio.Reader, len(s.segments))
+	heads := make([][]byte, len(s.segments))
+	for i, segment := range s.segments {
+		readers[i] = bufio.NewReader(io.NewSectionReader(segment.f, 0, segment.n*markRecordSize))
+	}
+	next := func(i int) error {
+		record := make([]byte, markRecordSize)
+		if _, err := io.ReadFull(readers[i], record); err != nil {
+			if err == io.EOF {
+				heads[i] = nil
+				return nil
+			}
+			return err
+		}
+		heads[i] = record
+		return nil
+	}
+	for i := range readers {
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+
+	merged, err := s.writeSegment(func(w io.Writer) (int64, error) {
+		var n int64
+		for {
+			min := -1
+			for i, head := range heads {
+				if head != nil && (min < 0 || bytes.Compare(head, heads[min]) < 0) {
+					min = i
+				}
+			}
+			if min < 0 {
+				return n, nil
+			}
+			if _, err := w.Write(heads[min]); err != nil {
+				return 0, err
+			}
+			n++
+			if err := next(min); err != nil {
+				return 0, err
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range s.segments {
+		segment.f.Close()
+		os.Remove(segment.f.Name())
+	}
+	s.segments = []markSegment{merged}
+	return nil
+}
+
+// writeSegment creates a new segment file with the records written by fn,
+// which returns the number of records written.
+func (s *diskMarkSet) writeSegment(fn func(w io.Writer) (int64, error)) (markSegment, error) {
+	s.seq++
+	f, err := os.Create(filepath.Join(s.dir, fmt.Sprintf("segment-%d", s.seq)))
+	if err != nil {
+		return markSegment{}, err
+	}
+	w := bufio.NewWriter(f)
+	n, err := fn(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return markSegment{}, err
+	}
+	return markSegment{f: f, n: n}, nil
+}
+
+// search binary searches the segment for record.
+func (s markSegment) search(record [markRecordSize]byte) (bool, error) {
+	var (
+		buf    [markRecordSize]byte
+		lo, hi = int64(0), s.n
+	)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, err := s.f.ReadAt(buf[:], mid*markRecordSize); err != nil {
+			return false, err
+		}
+		switch c := bytes.Compare(buf[:], record[:]); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// markAlgorithms assigns the record identifier of each supported digest
+// algorithm.
+var markAlgorithms = map[digest.Algorithm]byte{
+	digest.SHA256: 1,
+	digest.SHA384: 2,
+	digest.SHA512: 3,
+}
+
+func encodeMarkRecord(dgst digest.Digest) ([markRecordSize]byte, error) {
+	var record [markRecordSize]byte
+	id, ok := markAlgorithms[dgst.Algorithm()]
+	if !ok {
+		return record, fmt.Errorf("unsupported digest algorithm %q", dgst.Algorithm())
+	}
+	hash, err := hex.DecodeString(dgst.Encoded())
+	if err != nil || len(hash) != dgst.Algorithm().Size() {
+		return record, fmt.Errorf("invalid digest %q", dgst)
+	}
+	record[0] = id
+	copy(record[1:], hash)
+	return record, nil
+}
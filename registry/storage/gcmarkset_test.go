@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDiskMarkSet(t *testing.T) {
+	set, err := newDiskMarkSet(t.TempDir(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.close()
+
+	var marked []digest.Digest
+	// enough digests to force several flushes and a compaction
+	for i := 0; i < 3*(maxMarkSetSegments+2)+1; i++ {
+		dgst := digest.FromString(strconv.Itoa(i))
+		if set.add(dgst) {
+			t.Fatalf("%s reported as already marked", dgst)
+		}
+		marked = append(marked, dgst)
+	}
+	marked = append(marked, digest.SHA512.FromString("sha512"))
+	set.add(marked[len(marked)-1])
+
+	if set.err() != nil {
+		t.Fatalf("unexpected error: %v", set.err())
+	}
+	if len(set.segments) > maxMarkSetSegments {
+		t.Fatalf("expected segments to be compacted, have %d", len(set.segments))
+	}
+	if set.len() != len(marked) {
+		t.Fatalf("expected %d marked digests, got %d", len(marked), set.len())
+	}
+	for _, dgst := range marked {
+		if !set.has(dgst) {
+			t.Errorf("%s is not marked", dgst)
+		}
+		if !set.add(dgst) {
+			t.Errorf("%s not reported as already marked", dgst)
+		}
+	}
+	if set.has(digest.FromString("unmarked")) {
+		t.Error("unmarked digest reported as marked")
+	}
+	if set.len() != len(marked) {
+		t.Fatalf("expected %d marked digests after re-adding, got %d", len(marked), set.len())
+	}
+}
+
+func TestGCWithDiskMarkSet(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "komnenos")
+	manifests, _ := repo.Manifests(ctx)
+
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	if err := manifests.Delete(ctx, image2.manifestDigest); err != nil {
+		t.Fatalf("failed deleting manifest digest: %v", err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Quiet:              true,
+		MarkSetDir:         t.TempDir(),
+		MarkSetMemoryLimit: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	if _, ok := blobs[image1.manifestDigest]; !ok {
+		t.Fatal("First manifest is missing")
+	}
+	for layer := range image1.layers {
+		if _, ok := blobs[layer]; !ok {
+			t.Fatalf("manifest 1 layer is missing: %v", layer)
+		}
+	}
+	for layer := range image2.layers {
+		if _, ok := blobs[layer]; ok {
+			t.Fatalf("manifest 2 layer is present: %v", layer)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/opencontainers/go-digest"
+)
+
+// inlineBlobs holds the content of blobs up to maxSize, such as image
+// configs, in a cache, so that they are served without a round trip to the
+// storage backend. The backend remains the source of truth: blobs are always
+// written to it, and cache misses read through to it.
+type inlineBlobs struct {
+	cache   cache.BlobContentCache
+	maxSize int64
+}
+
+// InlineBlobs returns a functional option for NewRegistry. It serves the
+// blobs of up to maxSize bytes from contentCache, caching them when they are
+// written or first read.
+func InlineBlobs(contentCache cache.BlobContentCache, maxSize int64) RegistryOption {
+	return func(registry *registry) error {
+		if contentCache == nil || maxSize <= 0 {
+			return nil
+		}
+		inline := &inlineBlobs{
+			cache:   contentCache,
+			maxSize: maxSize,
+		}
+		registry.blobStore.inline = inline
+		registry.blobServer.inline = inline
+		return nil
+	}
+}
+
+// get returns the content of the blob from the cache, reading it with read
+// and caching it on a miss.
+func (ib *inlineBlobs) get(ctx context.Context, dgst digest.Digest, read func() ([]byte, error)) ([]byte, error) {
+	p, err := ib.cache.GetContent(ctx, dgst)
+	if err == nil {
+		return p, nil
+	}
+	if err != distribution.ErrBlobUnknown {
+		dcontext.GetLogger(ctx).Warnf("error getting content of blob %s from cache: %v", dgst, err)
+	}
+
+	p, err = read()
+	if err != nil {
+		return nil, err
+	}
+	ib.set(ctx, dgst, p)
+	return p, nil
+}
+
+// set caches the content of the blob, if it is small enough.
+func (ib *inlineBlobs) set(ctx context.Context, dgst digest.Digest, p []byte) {
+	if int64(len(p)) > ib.maxSize {
+		return
+	}
+	if err := ib.cache.SetContent(ctx, dgst, p); err != nil {
+		dcontext.GetLogger(ctx).Warnf("error caching content of blob %s: %v", dgst, err)
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// dataReadCountingDriver counts the reads of blob data from its storage
+// driver.
+type dataReadCountingDriver struct {
+	storagedriver.StorageDriver
+	reads int
+}
+
+func (d *dataReadCountingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if strings.HasSuffix(path, "/data") {
+		d.reads++
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *dataReadCountingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if strings.HasSuffix(path, "/data") {
+		d.reads++
+	}
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+func TestInlineBlobs(t *testing.T) {
+	ctx := context.Background()
+	driver := &dataReadCountingDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, driver, InlineBlobs(memory.NewInMemoryBlobContentCache(memory.DefaultContentSize), 16))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	imageName, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	small := []byte("small blob")
+	large := []byte("a blob larger than the inline size")
+	for _, content := range [][]byte{small, large} {
+		desc, err := addBlob(ctx, bs, v1.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("unexpected error uploading blob: %v", err)
+		}
+		if desc.Size != int64(len(content)) {
+			t.Fatalf("unexpected blob size: %d", desc.Size)
+		}
+	}
+
+	serve := func(content []byte) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "", nil)
+		if err := bs.ServeBlob(ctx, w, r, digest.FromBytes(content)); err != nil {
+			t.Fatalf("unexpected error serving blob: %v", err)
+		}
+		if !bytes.Equal(w.Body.Bytes(), content) {
+			t.Fatalf("unexpected blob content: %q", w.Body.Bytes())
+		}
+	}
+
+	driver.reads = 0
+	serve(small)
+	if p, err := bs.Get(ctx, digest.FromBytes(small)); err != nil || !bytes.Equal(p, small) {
+		t.Fatalf("unexpected blob content: %q, %v", p, err)
+	}
+	if driver.reads != 0 {
+		t.Fatalf("expected small blob to be served from the cache, got %d backend reads", driver.reads)
+	}
+
+	serve(large)
+	if driver.reads == 0 {
+		t.Fatal("expected large blob to be served from the backend")
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// A link file contains the string form of the digest it links to. Link files
+// written with checksums enabled are followed by a second line holding the
+// length and the CRC-32C checksum of the digest, such as:
+//
+//	sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+//	length:71 crc32c:bdd9a1f7
+//
+// Link files without the second line, written by registries without
+// checksums enabled, remain valid.
+
+var linkCRC32C = crc32.MakeTable(crc32.Castagnoli)
+
+// maxQuotedLink is the length of the content of invalid link files quoted in
+// errors.
+const maxQuotedLink = 80
+
+// LinkCorruptError is returned when a link file cannot be read because its
+// content is truncated or corrupt.
+type LinkCorruptError struct {
+	Path   string
+	Reason string
+}
+
+func (err LinkCorruptError) Error() string {
+	return fmt.Sprintf("link file %s is corrupt: %s", err.Path, err.Reason)
+}
+
+// encodeLink returns the content of a link file linking to dgst, with a
+// checksum if checksum is true.
+func encodeLink(dgst digest.Digest, checksum bool) []byte {
+	if !checksum {
+		return []byte(dgst)
+	}
+	return []byte(fmt.Sprintf("%s\nlength:%d crc32c:%08x\n", dgst, len(dgst), crc32.Checksum([]byte(dgst), linkCRC32C)))
+}
+
+// decodeLink returns the digest a link file at path links to, verifying its
+// checksum if it has one.
+func decodeLink(path string, content []byte) (digest.Digest, error) {
+	if len(content) == 0 {
+		return "", LinkCorruptError{Path: path, Reason: "empty link"}
+	}
+
+	line, trailer, checksummed := strings.Cut(string(content), "\n")
+	if checksummed {
+		if err := verifyLink(line, trailer); err != nil {
+			return "", LinkCorruptError{Path: path, Reason: err.Error()}
+		}
+	}
+
+	dgst, err := digest.Parse(line)
+	if err != nil {
+		if len(line) > maxQuotedLink {
+			line = line[:maxQuotedLink] + "..."
+		}
+		return "", LinkCorruptError{Path: path, Reason: fmt.Sprintf("invalid digest %q: %v", line, err)}
+	}
+	return dgst, nil
+}
+
+// verifyLink checks the length and checksum of the digest line of a link file
+// against its trailer.
+func verifyLink(line, trailer string) error {
+	trailer, complete := strings.CutSuffix(trailer, "\n")
+	if !complete {
+		return fmt.Errorf("truncated checksum")
+	}
+
+	var (
+		length   = -1
+		checksum string
+	)
+	for _, field := range strings.Fields(trailer) {
+		key, value, _ := strings.Cut(field, ":")
+		switch key {
+		case "length":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid length %q", value)
+			}
+			length = n
+		case "crc32c":
+			checksum = value
+		default:
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	if length < 0 || checksum == "" {
+		return fmt.Errorf("missing length or checksum")
+	}
+
+	if len(line) != length {
+		return fmt.Errorf("digest is %d bytes, expected %d", len(line), length)
+	}
+	if actual := fmt.Sprintf("%08x", crc32.Checksum([]byte(line), linkCRC32C)); actual != checksum {
+		return fmt.Errorf("checksum is %s, expected %s", actual, checksum)
+	}
+	return nil
+}
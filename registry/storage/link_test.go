@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDecodeLink(t *testing.T) {
+	dgst := digest.FromString("link")
+	checksummed := string(encodeLink(dgst, true))
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		reason  string
+	}{
+		{name: "plain", content: dgst.String()},
+		{name: "checksummed", content: checksummed},
+		{name: "empty", content: "", reason: "empty link"},
+		{name: "truncated digest", content: dgst.String()[:20], reason: "invalid digest"},
+		{name: "truncated checksum", content: checksummed[:len(checksummed)-3], reason: "truncated checksum"},
+		{name: "missing checksum", content: dgst.String() + "\n\n", reason: "missing length or checksum"},
+		{name: "unknown field", content: dgst.String() + "\nsize:71\n", reason: "unknown field"},
+		{name: "wrong length", content: dgst.String()[:70] + checksummed[71:], reason: "digest is 70 bytes, expected 71"},
+		{name: "wrong checksum", content: strings.Replace(checksummed, dgst.Encoded()[:4], "0000", 1), reason: "checksum is"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			linked, err := decodeLink("/link", []byte(tc.content))
+			if tc.reason == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if linked != dgst {
+					t.Fatalf("expected %s, got %s", dgst, linked)
+				}
+				return
+			}
+
+			var corrupt LinkCorruptError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("expected corrupt link error, got %v", err)
+			}
+			if corrupt.Path != "/link" || !strings.Contains(corrupt.Reason, tc.reason) {
+				t.Fatalf("expected reason containing %q, got %v", tc.reason, err)
+			}
+		})
+	}
+}
+
+// TestLinkChecksums tests that links written with checksums can be read with
+// and without checksums enabled, and that corrupt links are reported.
+func TestLinkChecksums(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	repoName, _ := reference.WithName("a/b")
+	dgst := digest.FromString("manifest")
+
+	reg, err := NewRegistry(ctx, d, EnableLinkChecksums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := reg.Repository(ctx, repoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+
+	linkPath, err := pathFor(manifestTagCurrentPathSpec{name: repoName.Name(), tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := d.GetContent(ctx, linkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != string(encodeLink(dgst, true)) {
+		t.Fatalf("expected link with checksum, got %q", content)
+	}
+
+	// Registries without checksums enabled read links with checksums.
+	plain, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainRepo, err := plain.Repository(ctx, repoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := plainRepo.Tags(ctx).Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading link with checksum: %v", err)
+	}
+	if desc.Digest != dgst {
+		t.Fatalf("expected %s, got %s", dgst, desc.Digest)
+	}
+
+	// Truncating the link is reported rather than returning a digest.
+	if err := d.PutContent(ctx, linkPath, content[:len(content)-5]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Tags(ctx).Get(ctx, "latest")
+	var corrupt LinkCorruptError
+	if !errors.As(err, &corrupt) || corrupt.Path != linkPath {
+		t.Fatalf("expected corrupt link error for %s, got %v", linkPath, err)
+	}
+}
@@ -2,10 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/distribution/distribution/v3"
@@ -145,6 +147,10 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		}
 	}
 
+	if err := lbs.checkUploadLimits(ctx); err != nil {
+		return nil, err
+	}
+
 	uuid := uuid.NewString()
 	startedAt := time.Now().UTC()
 
@@ -169,9 +175,100 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		return nil, err
 	}
 
+	// Record who started the upload, so abandoned uploads can be attributed
+	session := newUploadSession(ctx, startedAt)
+	if err := writeUploadSession(ctx, lbs.blobStore.driver, lbs.repository.Named().Name(), uuid, session); err != nil {
+		return nil, err
+	}
+
 	return lbs.newBlobUpload(ctx, uuid, path, startedAt, false)
 }
 
+// checkUploadLimits returns an error if starting another upload would exceed
+// the limits on uploads in progress in the repository. The check is not
+// atomic, so concurrent uploads started at the same time may exceed the
+// limits.
+func (lbs *linkedBlobStore) checkUploadLimits(ctx context.Context) error {
+	limits := lbs.registry.uploadLimits
+	if limits.maxConcurrent == 0 && limits.maxBytes == 0 {
+		return nil
+	}
+
+	uploads, err := lbs.uploadsInProgress(ctx)
+	if err != nil {
+		return err
+	}
+	if limits.maxConcurrent > 0 && len(uploads) >= limits.maxConcurrent {
+		dcontext.GetLogger(ctx).Warnf("repository %s has %d uploads in progress", lbs.repository.Named().Name(), len(uploads))
+		return distribution.ErrBlobUploadLimitExceeded
+	}
+	if limits.maxBytes == 0 {
+		return nil
+	}
+
+	size, err := lbs.uploadBytes(ctx, uploads)
+	if err != nil {
+		return err
+	}
+	if size >= limits.maxBytes {
+		dcontext.GetLogger(ctx).Warnf("repository %s has %d bytes of uploads in progress", lbs.repository.Named().Name(), size)
+		return distribution.ErrBlobUploadQuotaExceeded
+	}
+	return nil
+}
+
+// uploadsInProgress returns the paths of the uploads in progress in the
+// repository.
+func (lbs *linkedBlobStore) uploadsInProgress(ctx context.Context) ([]string, error) {
+	uploadsPath, err := pathFor(uploadsPathSpec{name: lbs.repository.Named().Name()})
+	if err != nil {
+		return nil, err
+	}
+	uploads, err := lbs.blobStore.driver.List(ctx, uploadsPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// uploadBytes returns the total size of the data of uploads.
+func (lbs *linkedBlobStore) uploadBytes(ctx context.Context, uploads []string) (int64, error) {
+	var size int64
+	for _, upload := range uploads {
+		fi, err := lbs.blobStore.driver.Stat(ctx, path.Join(upload, "data"))
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+// uploadBytesRemaining returns the number of bytes which may still be written
+// to the uploads in progress in the repository, or -1 if their size is not
+// limited.
+func (lbs *linkedBlobStore) uploadBytesRemaining(ctx context.Context) (int64, error) {
+	maxBytes := lbs.registry.uploadLimits.maxBytes
+	if maxBytes == 0 {
+		return -1, nil
+	}
+	uploads, err := lbs.uploadsInProgress(ctx)
+	if err != nil {
+		return 0, err
+	}
+	size, err := lbs.uploadBytes(ctx, uploads)
+	if err != nil {
+		return 0, err
+	}
+	return max(maxBytes-size, 0), nil
+}
+
 func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
 	dcontext.GetLogger(ctx).Debug("(*linkedBlobStore).Resume")
 
@@ -229,10 +326,54 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 }
 
 func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.Digest) error) error {
+	return lbs.enumerate(ctx, "", ingestor)
+}
+
+// EnumerateFrom fills dgsts with the linked digests ordered after last. The
+// walk starts after the link of last where the driver supports it, so an
+// interrupted enumeration does not need to be restarted from the root.
+func (lbs *linkedBlobStore) EnumerateFrom(ctx context.Context, dgsts []digest.Digest, last digest.Digest) (int, error) {
+	if len(dgsts) == 0 {
+		return 0, errors.New("attempted to enumerate 0 blobs")
+	}
+
+	filledBuffer := false
+	found := 0
+	err := lbs.enumerate(ctx, last, func(dgst digest.Digest) error {
+		dgsts[found] = dgst
+		found++
+		if found == len(dgsts) {
+			filledBuffer = true
+			return driver.ErrFilledBuffer
+		}
+		return nil
+	})
+	if err != nil {
+		return found, err
+	}
+	if filledBuffer {
+		// There are potentially more blobs to enumerate
+		return found, nil
+	}
+	return found, io.EOF
+}
+
+// enumerate calls ingestor for each linked digest ordered after last.
+func (lbs *linkedBlobStore) enumerate(ctx context.Context, last digest.Digest, ingestor func(digest.Digest) error) error {
 	rootPath, err := pathFor(lbs.linkDirectoryPathSpec)
 	if err != nil {
 		return err
 	}
+
+	var lastKey, startAfter string
+	if last != "" {
+		if err := last.Validate(); err != nil {
+			return err
+		}
+		lastKey = path.Join(last.Algorithm().String(), last.Encoded())
+		startAfter = path.Join(rootPath, lastKey)
+	}
+
 	return lbs.driver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
 		// exit early if directory...
 		if fileInfo.IsDir() {
@@ -241,11 +382,17 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 		filePath := fileInfo.Path()
 
 		// check if it's a link
-		_, fileName := path.Split(filePath)
+		dir, fileName := path.Split(filePath)
 		if fileName != "link" {
 			return nil
 		}
 
+		// drivers are not required to honour the start after hint, so skip
+		// anything at or before the cursor
+		if lastKey != "" && !lessPath(lastKey, strings.TrimPrefix(path.Clean(dir), rootPath+"/")) {
+			return nil
+		}
+
 		// read the digest found in link
 		digest, err := lbs.blobStore.readlink(ctx, filePath)
 		if err != nil {
@@ -268,24 +415,75 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 		}
 
 		return nil
-	})
+	}, driver.WithStartAfterHint(startAfter))
+}
+
+// errMountDenied is returned when the mount policy does not allow mounting a
+// blob from the source repository.
+var errMountDenied = errors.New("blob mount denied by policy")
+
+// mountAllowed returns true if the mount policy allows mounting blobs from
+// the source repository into this one.
+func (lbs *linkedBlobStore) mountAllowed(sourceRepo reference.Named) bool {
+	switch lbs.registry.mountPolicy.scope {
+	case MountScopeNone:
+		return false
+	case MountScopeNamespace:
+		sourceNamespace, _, _ := strings.Cut(sourceRepo.Name(), "/")
+		namespace, _, _ := strings.Cut(lbs.repository.Named().Name(), "/")
+		return sourceNamespace == namespace
+	default:
+		return true
+	}
+}
+
+// statMountSource looks up the blob to mount in the source repository, if
+// the mount policy allows it, and then in the mount pools.
+func (lbs *linkedBlobStore) statMountSource(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest) (v1.Descriptor, error) {
+	err := errMountDenied
+	if lbs.mountAllowed(sourceRepo) {
+		var stat v1.Descriptor
+		stat, err = lbs.statRepositoryBlob(ctx, sourceRepo, dgst)
+		if err == nil {
+			return stat, nil
+		}
+	} else {
+		dcontext.GetLogger(ctx).Debugf("mounting blobs from %s into %s denied by policy", sourceRepo.Name(), lbs.repository.Named().Name())
+	}
+	if lbs.registry.mountPolicy.scope == MountScopeNone {
+		return v1.Descriptor{}, err
+	}
+
+	for _, pool := range lbs.registry.mountPolicy.pools {
+		stat, poolErr := lbs.statRepositoryBlob(ctx, pool, dgst)
+		if poolErr == nil {
+			dcontext.GetLogger(ctx).Infof("mounting blob %s into %s from pool %s instead of %s", dgst, lbs.repository.Named().Name(), pool.Name(), sourceRepo.Name())
+			return stat, nil
+		}
+	}
+	return v1.Descriptor{}, err
+}
+
+// statRepositoryBlob looks up the blob in the named repository.
+func (lbs *linkedBlobStore) statRepositoryBlob(ctx context.Context, name reference.Named, dgst digest.Digest) (v1.Descriptor, error) {
+	repo, err := lbs.registry.Repository(ctx, name)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return repo.Blobs(ctx).Stat(ctx, dgst)
 }
 
 func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest, sourceStat *v1.Descriptor) (v1.Descriptor, error) {
 	var stat v1.Descriptor
-	if sourceStat == nil {
-		// look up the blob info from the sourceRepo if not already provided
-		repo, err := lbs.registry.Repository(ctx, sourceRepo)
-		if err != nil {
-			return v1.Descriptor{}, err
-		}
-		stat, err = repo.Blobs(ctx).Stat(ctx, dgst)
+	if sourceStat != nil && !lbs.registry.mountPolicy.verifySource && lbs.mountAllowed(sourceRepo) {
+		// use the provided blob info
+		stat = *sourceStat
+	} else {
+		var err error
+		stat, err = lbs.statMountSource(ctx, sourceRepo, dgst)
 		if err != nil {
 			return v1.Descriptor{}, err
 		}
-	} else {
-		// use the provided blob info
-		stat = *sourceStat
 	}
 
 	desc := v1.Descriptor{
@@ -297,12 +495,19 @@ func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Name
 		MediaType: "application/octet-stream",
 		Digest:    dgst,
 	}
-	return desc, lbs.linkBlob(ctx, desc)
+	if err := lbs.linkBlob(ctx, desc); err != nil {
+		return v1.Descriptor{}, err
+	}
+	// The blob may have been tagged for expiry while unreferenced.
+	return desc, clearExpiry(ctx, lbs.driver, dgst)
 }
 
 // newBlobUpload allocates a new upload controller with the given state.
 func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string, startedAt time.Time, append bool) (distribution.BlobWriter, error) {
-	fw, err := lbs.driver.Writer(ctx, path, append)
+	// Drivers which buffer writes flush them with the context the writer
+	// was opened with, which must outlive a client disconnecting during the
+	// request for the data received to be kept.
+	fw, err := lbs.driver.Writer(context.WithoutCancel(ctx), path, append)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +64,58 @@ func TestLinkedBlobStoreEnumerator(t *testing.T) {
 	}
 }
 
+func TestLinkedBlobStoreEnumerateFrom(t *testing.T) {
+	fooRepoName, _ := reference.WithName("nm/foo")
+	fooEnv := newManifestStoreTestEnv(t, fooRepoName, "thetag")
+	ctx := context.Background()
+
+	var expected []digest.Digest
+	for i := 0; i < 5; i++ {
+		desc, err := fooEnv.repository.Blobs(ctx).Put(ctx, "application/octet-stream", []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+		expected = append(expected, desc.Digest)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+
+	enumerator, ok := fooEnv.repository.Blobs(ctx).(distribution.BlobPageEnumerator)
+	if !ok {
+		t.Fatal("Blobs is not a BlobPageEnumerator")
+	}
+
+	var (
+		actual []digest.Digest
+		last   digest.Digest
+		batch  = make([]digest.Digest, 2)
+	)
+	for {
+		n, err := enumerator.EnumerateFrom(ctx, batch, last)
+		actual = append(actual, batch[:n]...)
+		if n > 0 {
+			last = batch[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error enumerating blobs: %v", err)
+		}
+		if n != len(batch) {
+			t.Fatalf("expected a full batch of %d, got %d", len(batch), n)
+		}
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("unexpected enumeration (expected: %v actual: %v)", expected, actual)
+	}
+
+	n, err := enumerator.EnumerateFrom(ctx, batch, expected[len(expected)-1])
+	if n != 0 || err != io.EOF {
+		t.Fatalf("expected empty enumeration after last digest, got %d, %v", n, err)
+	}
+}
+
 func TestLinkedBlobStoreCreateWithMountFrom(t *testing.T) {
 	fooRepoName, _ := reference.WithName("nm/foo")
 	fooEnv := newManifestStoreTestEnv(t, fooRepoName, "thetag")
@@ -10,11 +10,20 @@ import (
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// manifestDigestMismatches counts the manifests read from storage whose
+// content does not match their digest.
+var manifestDigestMismatches = prometheus.StorageNamespace.NewCounter("manifest_digest_mismatches", "The number of manifests read from storage whose content does not match their digest")
+
+// manifestsUnverifiable counts the manifests read from storage whose digest
+// algorithm is not available to verify them.
+var manifestsUnverifiable = prometheus.StorageNamespace.NewCounter("manifests_unverifiable", "The number of manifests read from storage whose digest algorithm is not available to verify them")
+
 // A ManifestHandler gets and puts manifests of a particular type.
 type ManifestHandler interface {
 	// Unmarshal unmarshals the manifest from a byte slice.
@@ -88,6 +97,12 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 		return nil, err
 	}
 
+	if ms.repository.registry.verifyManifests {
+		if err := ms.verify(ctx, dgst, content); err != nil {
+			return nil, err
+		}
+	}
+
 	// versioned is a minimal representation of a manifest with version and mediatype.
 	var versioned struct {
 		specs.Versioned
@@ -100,6 +115,8 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 	}
 
 	switch versioned.SchemaVersion {
+	case 1:
+		return nil, distribution.ErrSchemaV1Unsupported
 	case 2:
 		// This can be an image manifest or a manifest list
 		switch versioned.MediaType {
@@ -131,27 +148,75 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 	return nil, fmt.Errorf("unrecognized manifest schema version %d", versioned.SchemaVersion)
 }
 
+// verify checks that the content of a manifest read from storage matches its
+// digest. Manifests whose digest algorithm is not available are rejected, as
+// they cannot be verified.
+func (ms *manifestStore) verify(ctx context.Context, dgst digest.Digest, content []byte) error {
+	if !dgst.Algorithm().Available() {
+		manifestsUnverifiable.Inc(1)
+		dcontext.GetLogger(ctx).Errorf("rejecting manifest %s of %s: digest algorithm %q is not available", dgst, ms.repository.Named().Name(), dgst.Algorithm())
+		return distribution.ErrManifestUnverified{}
+	}
+	actual := dgst.Algorithm().FromBytes(content)
+	if actual == dgst {
+		return nil
+	}
+
+	manifestDigestMismatches.Inc(1)
+	err := distribution.ErrManifestDigestMismatch{
+		Name:     ms.repository.Named().Name(),
+		Revision: dgst,
+		Actual:   actual,
+	}
+	dcontext.GetLogger(ctx).Errorf("rejecting manifest: %v", err)
+	return err
+}
+
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	var (
+		dgst digest.Digest
+		err  error
+	)
 	switch manifest.(type) {
 	case *schema2.DeserializedManifest:
-		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		dgst, err = ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		dgst, err = ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		dgst, err = ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
 	case *ocischema.DeserializedImageIndex:
-		return ms.ocischemaIndexHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		dgst, err = ms.ocischemaIndexHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+	default:
+		return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	}
+	if err != nil {
+		return "", err
 	}
 
-	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	// A blob found by the verification may have been tagged for expiry by
+	// a collection which found it unreferenced, and must not be removed by
+	// the storage backend now the manifest references it.
+	for _, descriptor := range manifest.References() {
+		if err := clearExpiry(ctx, ms.blobStore.driver, descriptor.Digest); err != nil {
+			return "", err
+		}
+	}
+
+	if err := ms.repository.recordChange(ctx, ms.repository.Named().Name(), ChangeManifestPut, "", dgst); err != nil {
+		return "", err
+	}
+	return dgst, nil
 }
 
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
-	return ms.blobStore.Delete(ctx, dgst)
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	return ms.repository.recordChange(ctx, ms.repository.Named().Name(), ChangeManifestDelete, "", dgst)
 }
 
 func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
@@ -164,3 +229,8 @@ func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Dig
 	})
 	return err
 }
+
+// EnumerateFrom fills dgsts with the manifest revisions ordered after last.
+func (ms *manifestStore) EnumerateFrom(ctx context.Context, dgsts []digest.Digest, last digest.Digest) (int, error) {
+	return ms.blobStore.EnumerateFrom(ctx, dgsts, last)
+}
@@ -59,6 +59,10 @@ func TestManifestStorage(t *testing.T) {
 	testManifestStorage(t, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), EnableDelete, EnableRedirect, EnableValidateImageIndexImagesExist)
 }
 
+func TestManifestStorageLinkChecksums(t *testing.T) {
+	testManifestStorage(t, EnableLinkChecksums, EnableDelete, EnableRedirect, EnableValidateImageIndexImagesExist)
+}
+
 func testManifestStorage(t *testing.T, options ...RegistryOption) {
 	repoName, _ := reference.WithName("foo/bar")
 	env := newManifestStoreTestEnv(t, repoName, "thetag", options...)
@@ -872,3 +876,73 @@ func TestManifestStorageCache(t *testing.T) {
 		t.Errorf("Unexpected error getting cached manifest: %v", err)
 	}
 }
+
+// TestManifestStorageVerify tests that manifests whose content was modified
+// in storage are rejected when manifest verification is enabled.
+func TestManifestStorageVerify(t *testing.T) {
+	repoName, _ := reference.WithName("foo/verify")
+	env := newManifestStoreTestEnv(t, repoName, "thetag", VerifyManifests)
+	ctx := context.Background()
+	ms, err := env.repository.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := createRandomImage(t, t.Name(), v1.MediaTypeImageManifest, env.repository.Blobs(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := ms.Put(ctx, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.Get(ctx, dgst); err != nil {
+		t.Fatalf("unexpected error getting unmodified manifest: %v", err)
+	}
+
+	// Modify the manifest in storage, keeping it valid.
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.driver.PutContent(ctx, dataPath, append(payload, '\n')); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ms.Get(ctx, dgst)
+	var mismatch distribution.ErrManifestDigestMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected digest mismatch getting modified manifest, got %v", err)
+	}
+	if mismatch.Revision != dgst || mismatch.Actual != digest.FromBytes(append(payload, '\n')) {
+		t.Fatalf("unexpected digests in %v", mismatch)
+	}
+
+	// Without verification, the modified manifest is served.
+	registry, err := NewRegistry(ctx, env.driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, repoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err = repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.Get(ctx, dgst); err != nil {
+		t.Fatalf("unexpected error getting modified manifest without verification: %v", err)
+	}
+
+	// Manifests whose digest algorithm is not available cannot be verified.
+	unverifiable := digest.NewDigestFromEncoded("md5", "d41d8cd98f00b204e9800998ecf8427e")
+	err = ms.(*manifestStore).verify(ctx, unverifiable, payload)
+	if !errors.As(err, new(distribution.ErrManifestUnverified)) {
+		t.Fatalf("expected unverified manifest with unavailable digest algorithm, got %v", err)
+	}
+}
@@ -49,6 +49,7 @@ const (
 //	                ├── hashstates
 //	                │   └── <algorithm>
 //	                │       └── <offset>
+//	                ├── session
 //	                └── startedat
 //
 // The storage backend layout is broken up into a content-addressable blob
@@ -97,6 +98,14 @@ const (
 //	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 //	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
 //
+//	Tag Index:
+//
+//	tagIndexPathSpec:               <root>/v2/repositories/<name>/_manifests/tagindex
+//	tagIndexSnapshotsPathSpec:      <root>/v2/repositories/<name>/_manifests/tagindex/snapshots
+//	tagIndexSnapshotPathSpec:       <root>/v2/repositories/<name>/_manifests/tagindex/snapshots/<id>
+//	tagIndexLogPathSpec:            <root>/v2/repositories/<name>/_manifests/tagindex/log
+//	tagIndexLogEntryPathSpec:       <root>/v2/repositories/<name>/_manifests/tagindex/log/<id>
+//
 //	Blobs:
 //
 //	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
@@ -104,8 +113,10 @@ const (
 //
 //	Uploads:
 //
+//	uploadsPathSpec:                <root>/v2/repositories/<name>/_uploads
 //	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
 //	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
+//	uploadSessionPathSpec:          <root>/v2/repositories/<name>/_uploads/<id>/session
 //	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
 //
 //	Blob Store:
@@ -113,6 +124,37 @@ const (
 //	blobsPathSpec:                  <root>/v2/blobs/
 //	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 //	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+//	blobChunkIndexPathSpec:         <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/chunks
+//
+//	Chunks:
+//
+//	chunksPathSpec:                 <root>/v2/chunks
+//	chunkDataPathSpec:              <root>/v2/chunks/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+//
+//	Tombstones:
+//
+//	tombstonesPathSpec:             <root>/v2/tombstones
+//	tombstonePathSpec:              <root>/v2/tombstones/<algorithm>/<first two hex bytes of digest>/<hex digest>
+//
+//	Expiring blobs:
+//
+//	expiringPathSpec:               <root>/v2/expiring
+//	expiringBlobPathSpec:           <root>/v2/expiring/<algorithm>/<first two hex bytes of digest>/<hex digest>
+//
+//	Reports:
+//
+//	usageReportPathSpec:            <root>/v2/reports/usage.<format>
+//
+//	Quarantine:
+//
+//	quarantinePathSpec:             <root>/v2/quarantine/<id>
+//
+//	Change log:
+//
+//	changesPathSpec:                <root>/v2/changes
+//	changesPrunedPathSpec:          <root>/v2/changes/_pruned
+//	changesHourPathSpec:            <root>/v2/changes/<hour>
+//	changeEntryPathSpec:            <root>/v2/changes/<hour>/<id>
 //
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
@@ -201,6 +243,16 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, path.Join(components...)), nil
+	case tagIndexPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tagindex")...), nil
+	case tagIndexSnapshotsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tagindex", "snapshots")...), nil
+	case tagIndexSnapshotPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tagindex", "snapshots", v.id)...), nil
+	case tagIndexLogPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tagindex", "log")...), nil
+	case tagIndexLogEntryPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tagindex", "log", v.id)...), nil
 	case layerLinkPathSpec:
 		components, err := digestPathComponents(v.digest, false)
 		if err != nil {
@@ -237,11 +289,65 @@ func pathFor(spec pathSpec) (string, error) {
 		components = append(components, "data")
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobChunkIndexPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
 
+		components = append(components, "chunks")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case chunksPathSpec:
+		return path.Join(append(rootPrefix, "chunks")...), nil
+	case chunkDataPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "data")
+		return path.Join(append(append(rootPrefix, "chunks"), components...)...), nil
+	case tombstonesPathSpec:
+		return path.Join(append(rootPrefix, "tombstones")...), nil
+	case tombstonePathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(rootPrefix, "tombstones"), components...)...), nil
+	case expiringPathSpec:
+		return path.Join(append(rootPrefix, "expiring")...), nil
+	case expiringBlobPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(rootPrefix, "expiring"), components...)...), nil
+
+	case usageReportPathSpec:
+		return path.Join(append(rootPrefix, "reports", "usage."+v.format)...), nil
+	case quarantinePathSpec:
+		return path.Join(append(rootPrefix, "quarantine", v.id)...), nil
+	case changesPathSpec:
+		return path.Join(append(rootPrefix, "changes")...), nil
+	case changesPrunedPathSpec:
+		return path.Join(append(rootPrefix, "changes", "_pruned")...), nil
+	case changesHourPathSpec:
+		return path.Join(append(rootPrefix, "changes", v.hour)...), nil
+	case changeEntryPathSpec:
+		return path.Join(append(rootPrefix, "changes", v.hour, v.id)...), nil
+
+	case uploadsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads")...), nil
 	case uploadDataPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
 	case uploadStartedAtPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "startedat")...), nil
+	case uploadSessionPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "session")...), nil
 	case uploadHashStatePathSpec:
 		offset := fmt.Sprintf("%d", v.offset)
 		if v.list {
@@ -306,6 +412,47 @@ type manifestTagsPathSpec struct {
 
 func (manifestTagsPathSpec) pathSpec() {}
 
+// tagIndexPathSpec describes the path of the tag index of a repository.
+type tagIndexPathSpec struct {
+	name string
+}
+
+func (tagIndexPathSpec) pathSpec() {}
+
+// tagIndexSnapshotsPathSpec describes the path of the directory holding the
+// snapshots of the tag index of a repository.
+type tagIndexSnapshotsPathSpec struct {
+	name string
+}
+
+func (tagIndexSnapshotsPathSpec) pathSpec() {}
+
+// tagIndexSnapshotPathSpec describes the path of a snapshot of the tag index
+// of a repository. A snapshot holds every tag as of the log entry id.
+type tagIndexSnapshotPathSpec struct {
+	name string
+	id   string
+}
+
+func (tagIndexSnapshotPathSpec) pathSpec() {}
+
+// tagIndexLogPathSpec describes the path of the directory holding the log
+// of tag changes of a repository.
+type tagIndexLogPathSpec struct {
+	name string
+}
+
+func (tagIndexLogPathSpec) pathSpec() {}
+
+// tagIndexLogEntryPathSpec describes the path of a tag change logged to the
+// tag index of a repository.
+type tagIndexLogEntryPathSpec struct {
+	name string
+	id   string
+}
+
+func (tagIndexLogEntryPathSpec) pathSpec() {}
+
 // manifestTagPathSpec describes the path elements required to point to the
 // manifest tag links files under a repository. These contain a blob id that
 // can be used to look up the data and signatures.
@@ -408,6 +555,108 @@ type blobDataPathSpec struct {
 
 func (blobDataPathSpec) pathSpec() {}
 
+// blobChunkIndexPathSpec contains the path of the index listing the chunks of
+// a blob stored as content-defined chunks, in place of its data.
+type blobChunkIndexPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobChunkIndexPathSpec) pathSpec() {}
+
+// chunksPathSpec contains the path for the chunks directory.
+type chunksPathSpec struct{}
+
+func (chunksPathSpec) pathSpec() {}
+
+// chunkDataPathSpec contains the path of the data of a chunk, shared by the
+// chunked blobs containing it.
+type chunkDataPathSpec struct {
+	digest digest.Digest
+}
+
+func (chunkDataPathSpec) pathSpec() {}
+
+// tombstonesPathSpec contains the path for the tombstones directory.
+type tombstonesPathSpec struct{}
+
+func (tombstonesPathSpec) pathSpec() {}
+
+// tombstonePathSpec contains the path of the tombstone recording that a blob
+// was deleted while its data was retained in WORM mode.
+type tombstonePathSpec struct {
+	digest digest.Digest
+}
+
+func (tombstonePathSpec) pathSpec() {}
+
+// expiringPathSpec contains the path for the directory of the blobs tagged
+// for expiry.
+type expiringPathSpec struct{}
+
+func (expiringPathSpec) pathSpec() {}
+
+// expiringBlobPathSpec contains the path of the marker recording that a blob
+// was tagged for expiry by garbage collection.
+type expiringBlobPathSpec struct {
+	digest digest.Digest
+}
+
+func (expiringBlobPathSpec) pathSpec() {}
+
+// usageReportPathSpec contains the path of the storage usage report in the
+// given format.
+type usageReportPathSpec struct {
+	format string
+}
+
+func (usageReportPathSpec) pathSpec() {}
+
+// quarantinePathSpec contains the path of the directory holding the files
+// moved out of the way by a run of Fsck. The files keep their path relative to
+// <root>/v2.
+type quarantinePathSpec struct {
+	id string
+}
+
+func (quarantinePathSpec) pathSpec() {}
+
+// changesPathSpec contains the path of the change log, which records the
+// changes to the repositories in directories per hour.
+type changesPathSpec struct{}
+
+func (changesPathSpec) pathSpec() {}
+
+// changesPrunedPathSpec contains the path of the file holding the first hour
+// of the change log left by pruning.
+type changesPrunedPathSpec struct{}
+
+func (changesPrunedPathSpec) pathSpec() {}
+
+// changesHourPathSpec contains the path of the directory holding the changes
+// recorded during an hour, formatted as changeLogHourFormat.
+type changesHourPathSpec struct {
+	hour string
+}
+
+func (changesHourPathSpec) pathSpec() {}
+
+// changeEntryPathSpec contains the path of a change recorded in the change
+// log, named by a ULID.
+type changeEntryPathSpec struct {
+	hour string
+	id   string
+}
+
+func (changeEntryPathSpec) pathSpec() {}
+
+// uploadsPathSpec defines the path of the directory holding the uploads in
+// progress in a repository.
+type uploadsPathSpec struct {
+	name string
+}
+
+func (uploadsPathSpec) pathSpec() {}
+
 // uploadDataPathSpec defines the path parameters of the data file for
 // uploads.
 type uploadDataPathSpec struct {
@@ -431,6 +680,16 @@ type uploadStartedAtPathSpec struct {
 
 func (uploadStartedAtPathSpec) pathSpec() {}
 
+// uploadSessionPathSpec defines the path parameters for the file that stores
+// the client which started an upload, so that abandoned uploads can be
+// attributed.
+type uploadSessionPathSpec struct {
+	name string
+	id   string
+}
+
+func (uploadSessionPathSpec) pathSpec() {}
+
 // uploadHashStatePathSpec defines the path parameters for the file that stores
 // the hash function state of an upload at a specific byte offset. If `list` is
 // set, then the path mapper will generate a list prefix for all hash state
@@ -87,6 +87,12 @@ func TestPathMapper(t *testing.T) {
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec: tombstonePathSpec{
+				digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/tombstones/sha256/ab/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
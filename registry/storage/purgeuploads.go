@@ -3,19 +3,53 @@ package storage
 import (
 	"context"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/uuid"
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	storageDriver "github.com/distribution/distribution/v3/registry/storage/driver"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// purgedUploads is the number of abandoned uploads removed by upload
+// purging, by the prefix of the policy which removed them and the subject
+// which started them. The prefix is empty for uploads removed without a
+// policy. Only the subjects configured are used as labels, see subjectLabel.
+var purgedUploads = prometheus.StorageNamespace.NewLabeledCounter("purged_uploads", "The number of abandoned uploads removed by upload purging", "policy", "subject")
+
+// otherSubjects labels the uploads purged which were started by a subject
+// not configured.
+const otherSubjects = "other"
+
+// subjectLabel returns the purgedUploads label of subject: the subject itself
+// if it is one of subjects, otherSubjects if not, or the empty string for
+// uploads started anonymously. Keeping to configured subjects bounds the
+// number of series.
+func subjectLabel(subject string, subjects []string) string {
+	if subject == "" {
+		return ""
+	}
+	for _, s := range subjects {
+		if s == subject {
+			return subject
+		}
+	}
+	return otherSubjects
+}
+
 // uploadData stored the location of temporary files created during a layer upload
-// along with the date the upload was started
+// along with the date the upload was started, and the client which started it
+// if known
 type uploadData struct {
+	id            string
 	containingDir string
+	repository    string
 	startedAt     time.Time
+	size          int64
+	session       uploadSession
 }
 
 func newUploadData() uploadData {
@@ -26,23 +60,214 @@ func newUploadData() uploadData {
 	}
 }
 
+// PurgePolicy is the age threshold and dry-run setting applied by
+// PurgeUploadsWithPolicies to uploads in repositories under Prefix.
+type PurgePolicy struct {
+	// Prefix is a repository name prefix, matched against whole path
+	// components: "ci" matches "ci" and "ci/app" but not "cicd". The empty
+	// prefix matches every repository.
+	Prefix string
+	// Age is the age after which an upload is purged.
+	Age time.Duration
+	// DryRun reports uploads which would be purged without deleting them.
+	DryRun bool
+}
+
+// matches returns true if the repository is under the policy prefix.
+func (p PurgePolicy) matches(repo string) bool {
+	prefix := strings.TrimSuffix(p.Prefix, "/")
+	return prefix == "" || repo == prefix || strings.HasPrefix(repo, prefix+"/")
+}
+
+// selectPurgePolicy returns the policy with the longest prefix matching the
+// repository, or defaultPolicy if none match.
+func selectPurgePolicy(repo string, defaultPolicy PurgePolicy, policies []PurgePolicy) PurgePolicy {
+	selected, matched := defaultPolicy, -1
+	for _, policy := range policies {
+		if policy.matches(repo) && len(policy.Prefix) > matched {
+			selected, matched = policy, len(policy.Prefix)
+		}
+	}
+	return selected
+}
+
 // PurgeUploads deletes files from the upload directory
 // created before olderThan.  The list of files deleted and errors
 // encountered are returned
 func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
 	logrus.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
-	uploadData, errors := getOutstandingUploads(ctx, driver)
-	var deleted []string
-	for _, uploadData := range uploadData {
+	purged, errors := purgeUploads(ctx, driver, nil, func(repo string) (time.Time, bool, string) {
+		return olderThan, actuallyDelete, ""
+	})
+	return purgedUploadDirs(purged), errors
+}
+
+// PurgeUploadsWithPolicies deletes files from the upload directory, applying
+// to each upload the policy with the longest prefix matching its repository,
+// or defaultPolicy if none match. The uploads deleted are counted by the
+// subject which started them if it is one of subjects. The list of files
+// deleted, including those which would have been deleted under a dry-run
+// policy, and errors encountered are returned.
+func PurgeUploadsWithPolicies(ctx context.Context, driver storageDriver.StorageDriver, defaultPolicy PurgePolicy, policies []PurgePolicy, subjects []string) ([]string, []error) {
+	logrus.Infof("PurgeUploads starting: age=%s, dryRun=%t, policies=%d", defaultPolicy.Age, defaultPolicy.DryRun, len(policies))
+	now := time.Now()
+	purged, errors := purgeUploads(ctx, driver, subjects, func(repo string) (time.Time, bool, string) {
+		policy := selectPurgePolicy(repo, defaultPolicy, policies)
+		return now.Add(-policy.Age), !policy.DryRun, policy.Prefix
+	})
+	return purgedUploadDirs(purged), errors
+}
+
+// PurgeUploadsOpts selects the uploads removed by PurgeRepositoryUploads.
+type PurgeUploadsOpts struct {
+	// Age is the age after which an upload is purged.
+	Age time.Duration
+	// DryRun reports uploads which would be purged without deleting them.
+	DryRun bool
+	// Repository limits the purge to the repository and the repositories
+	// under it, matched as a PurgePolicy prefix. All repositories are
+	// purged if it is empty.
+	Repository string
+	// Subjects are the subjects by which the uploads purged are counted.
+	Subjects []string
+}
+
+// UploadInfo describes an upload session in progress, or one removed by
+// upload purging.
+type UploadInfo struct {
+	Repository string    `json:"repository"`
+	ID         string    `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	Size       int64     `json:"size"`
+	Subject    string    `json:"subject,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+}
+
+// PurgeRepositoryUploads deletes the uploads selected by opts, returning a
+// description of each upload deleted and the errors encountered.
+func PurgeRepositoryUploads(ctx context.Context, driver storageDriver.StorageDriver, opts PurgeUploadsOpts) ([]UploadInfo, []error) {
+	logrus.Infof("PurgeUploads starting: age=%s, dryRun=%t, repository=%q", opts.Age, opts.DryRun, opts.Repository)
+	filter := PurgePolicy{Prefix: opts.Repository}
+	olderThan := time.Now().Add(-opts.Age)
+	purged, errors := purgeUploads(ctx, driver, opts.Subjects, func(repo string) (time.Time, bool, string) {
+		if !filter.matches(repo) {
+			return time.Time{}, false, ""
+		}
+		return olderThan, !opts.DryRun, ""
+	})
+	return uploadInfos(purged), errors
+}
+
+// ListUploads returns a description of the upload sessions in progress in
+// the repository and the repositories under it, matched as a PurgePolicy
+// prefix, or in all repositories if it is empty.
+func ListUploads(ctx context.Context, driver storageDriver.StorageDriver, repository string) ([]UploadInfo, []error) {
+	uploads, errors := getOutstandingUploads(ctx, driver)
+	filter := PurgePolicy{Prefix: repository}
+	var selected []uploadData
+	for _, ud := range uploads {
+		// Files left behind without a containing directory do not belong
+		// to an upload which can be aborted.
+		if ud.containingDir == "" || !filter.matches(ud.repository) {
+			continue
+		}
+		selected = append(selected, ud)
+	}
+	return uploadInfos(selected), errors
+}
+
+// AbortUpload deletes the upload session id in the repository, along with
+// the data uploaded so far. distribution.ErrBlobUploadUnknown is returned if
+// there is no such upload.
+func AbortUpload(ctx context.Context, driver storageDriver.StorageDriver, repository, id string) error {
+	if !uuid.IsValid(id) {
+		return distribution.ErrBlobUploadUnknown
+	}
+	startedAtPath, err := pathFor(uploadStartedAtPathSpec{name: repository, id: id})
+	if err != nil {
+		return err
+	}
+	uploadDir := path.Dir(startedAtPath)
+	if _, err := driver.Stat(ctx, uploadDir); err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); ok {
+			return distribution.ErrBlobUploadUnknown
+		}
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"id":         id,
+	}).Infof("Aborting upload.  Removing upload directory %s.", uploadDir)
+	if err := driver.Delete(ctx, uploadDir); err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); ok {
+			return distribution.ErrBlobUploadUnknown
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadInfos describes the uploads, sorted by repository and id.
+func uploadInfos(uploads []uploadData) []UploadInfo {
+	infos := make([]UploadInfo, 0, len(uploads))
+	for _, ud := range uploads {
+		infos = append(infos, UploadInfo{
+			Repository: ud.repository,
+			ID:         ud.id,
+			StartedAt:  ud.startedAt,
+			Size:       ud.size,
+			Subject:    ud.session.Subject,
+			RemoteAddr: ud.session.RemoteAddr,
+			UserAgent:  ud.session.UserAgent,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Repository != infos[j].Repository {
+			return infos[i].Repository < infos[j].Repository
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+func purgedUploadDirs(purged []uploadData) []string {
+	var dirs []string
+	for _, ud := range purged {
+		dirs = append(dirs, ud.containingDir)
+	}
+	return dirs
+}
+
+// purgeUploads deletes the uploads started before the time returned by
+// policy for their repository, if policy allows deletion. The prefix returned
+// by policy and the subject which started them, if one of subjects, label the
+// uploads deleted in the metrics.
+func purgeUploads(ctx context.Context, driver storageDriver.StorageDriver, subjects []string, policy func(repo string) (olderThan time.Time, actuallyDelete bool, prefix string)) ([]uploadData, []error) {
+	uploads, errors := getOutstandingUploads(ctx, driver)
+	var deleted []uploadData
+	for _, uploadData := range uploads {
+		olderThan, actuallyDelete, prefix := policy(uploadData.repository)
 		if uploadData.startedAt.Before(olderThan) {
 			var err error
-			logrus.Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
+			logrus.WithFields(logrus.Fields{
+				"repository": uploadData.repository,
+				"subject":    uploadData.session.Subject,
+				"remoteaddr": uploadData.session.RemoteAddr,
+				"useragent":  uploadData.session.UserAgent,
+				"size":       uploadData.size,
+				"dryrun":     !actuallyDelete,
+			}).Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
 				uploadData.containingDir, uploadData.startedAt, olderThan)
 			if actuallyDelete {
 				err = driver.Delete(ctx, uploadData.containingDir)
 			}
 			if err == nil {
-				deleted = append(deleted, uploadData.containingDir)
+				deleted = append(deleted, uploadData)
+				if actuallyDelete {
+					purgedUploads.WithValues(prefix, subjectLabel(uploadData.session.Subject, subjects)).Inc(1)
+				}
 			} else {
 				errors = append(errors, err)
 			}
@@ -90,14 +315,27 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 			ud = newUploadData()
 		}
 		if isContainingDir {
+			ud.id = uuid
 			ud.containingDir = filePath
+			ud.repository = repositoryFromUploadPath(root, filePath)
 		}
-		if file == "startedat" {
+		switch file {
+		case "startedat":
 			if t, err := readStartedAtFile(ctx, driver, filePath); err == nil {
 				ud.startedAt = t
 			} else {
 				errors = pushError(errors, filePath, err)
 			}
+		case "session":
+			// The session is informational, so errors reading it are not
+			// reported.
+			if session, err := readUploadSession(ctx, driver, filePath); err == nil {
+				ud.session = session
+			}
+		case "data":
+			if !fileInfo.IsDir() {
+				ud.size = fileInfo.Size()
+			}
 		}
 
 		uploads[uuid] = ud
@@ -110,19 +348,27 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 	return uploads, errors
 }
 
-// uuidFromPath extracts the upload UUID from a given path
+// uuidFromPath extracts the upload UUID or ULID from a given path
 // If the UUID is the last path component, this is the containing
 // directory for all upload files
 func uuidFromPath(path string) (string, bool) {
 	components := strings.Split(path, "/")
 	for i := len(components) - 1; i >= 0; i-- {
-		if u, err := uuid.Parse(components[i]); err == nil {
-			return u.String(), i == len(components)-1
+		if uuid.IsValid(components[i]) {
+			return components[i], i == len(components)-1
 		}
 	}
 	return "", false
 }
 
+// repositoryFromUploadPath returns the name of the repository containing the
+// upload directory uploadPath, which is under root.
+func repositoryFromUploadPath(root, uploadPath string) string {
+	rel := strings.TrimPrefix(uploadPath, strings.TrimSuffix(root, "/")+"/")
+	repo, _, _ := strings.Cut(rel, "/_uploads/")
+	return repo
+}
+
 // readStartedAtFile reads the date from an upload's startedAtFile
 func readStartedAtFile(ctx context.Context, driver storageDriver.StorageDriver, path string) (time.Time, error) {
 	startedAtBytes, err := driver.GetContent(ctx, path)
@@ -3,13 +3,17 @@ package storage
 import (
 	"context"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/internal/uuid"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
 )
 
 func testUploadFS(t *testing.T, numUploads int, repoName string, startedAt time.Time) (driver.StorageDriver, context.Context) {
@@ -64,6 +68,30 @@ func TestPurgeNone(t *testing.T) {
 	}
 }
 
+func TestPurgeULIDUploads(t *testing.T) {
+	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	fs, ctx := testUploadFS(t, 2, "test-repo", oneHourAgo)
+	ulid := uuid.NewULID()
+	addUploads(ctx, t, fs, ulid, "test-repo", oneHourAgo)
+
+	deleted, errs := PurgeUploads(ctx, fs, time.Now(), true)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != 3 {
+		t.Fatalf("Unexpectedly deleted file count %d != %d", len(deleted), 3)
+	}
+	var found bool
+	for _, dir := range deleted {
+		if path.Base(dir) == ulid {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ULID upload %s not purged: %v", ulid, deleted)
+	}
+}
+
 func TestPurgeAll(t *testing.T) {
 	uploadCount := 10
 	oneHourAgo := time.Now().Add(-1 * time.Hour)
@@ -105,6 +133,128 @@ func TestPurgeSome(t *testing.T) {
 	}
 }
 
+func TestPurgeWithPolicies(t *testing.T) {
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	fs, ctx := testUploadFS(t, 3, "ci/app", twoHoursAgo)
+	addUploads(ctx, t, fs, uuid.NewString(), "ci", twoHoursAgo)
+	addUploads(ctx, t, fs, uuid.NewString(), "cicd/app", twoHoursAgo)
+	addUploads(ctx, t, fs, uuid.NewString(), "prod/app", twoHoursAgo)
+	addUploads(ctx, t, fs, uuid.NewString(), "staging/app", twoHoursAgo)
+
+	defaultPolicy := PurgePolicy{Age: 168 * time.Hour}
+	policies := []PurgePolicy{
+		{Prefix: "ci", Age: time.Hour},
+		{Prefix: "staging/", Age: time.Hour, DryRun: true},
+		{Prefix: "prod", Age: 30 * time.Minute, DryRun: true},
+		{Prefix: "prod/app", Age: 7 * 24 * time.Hour},
+	}
+
+	deleted, errs := PurgeUploadsWithPolicies(ctx, fs, defaultPolicy, policies, nil)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+
+	repos := make(map[string]int)
+	root, _ := pathFor(repositoriesRootPathSpec{})
+	for _, dir := range deleted {
+		repos[repositoryFromUploadPath(root, dir)]++
+	}
+	expected := map[string]int{"ci/app": 3, "ci": 1, "staging/app": 1}
+	if !reflect.DeepEqual(repos, expected) {
+		t.Fatalf("unexpected purged uploads: %v != %v", repos, expected)
+	}
+
+	// The dry-run policy reports the staging upload without removing it.
+	remaining, errs := getOutstandingUploads(ctx, fs)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	for _, ud := range remaining {
+		if strings.HasPrefix(ud.repository, "ci/") || ud.repository == "ci" {
+			t.Errorf("upload in %s was not purged", ud.repository)
+		}
+	}
+	if len(remaining) != 3 {
+		t.Errorf("unexpected number of remaining uploads: %d", len(remaining))
+	}
+}
+
+func TestSubjectLabel(t *testing.T) {
+	subjects := []string{"ci-runner", "release"}
+	for subject, expected := range map[string]string{
+		"":           "",
+		"ci-runner":  "ci-runner",
+		"release":    "release",
+		"dev-laptop": otherSubjects,
+	} {
+		if label := subjectLabel(subject, subjects); label != expected {
+			t.Errorf("subjectLabel(%q) = %q, expected %q", subject, label, expected)
+		}
+	}
+}
+
+func TestPurgeRepositoryUploads(t *testing.T) {
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	fs, ctx := testUploadFS(t, 2, "ci/app", twoHoursAgo)
+	addUploads(ctx, t, fs, uuid.NewString(), "ci/app", time.Now())
+	addUploads(ctx, t, fs, uuid.NewString(), "prod/app", twoHoursAgo)
+
+	opts := PurgeUploadsOpts{Age: time.Hour, DryRun: true, Repository: "ci"}
+	purged, errs := PurgeRepositoryUploads(ctx, fs, opts)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(purged) != 2 {
+		t.Fatalf("unexpected purged uploads: %v", purged)
+	}
+	for _, upload := range purged {
+		if upload.Repository != "ci/app" || upload.ID == "" || !upload.StartedAt.Before(time.Now().Add(-time.Hour)) {
+			t.Errorf("unexpected purged upload: %+v", upload)
+		}
+	}
+	if remaining, _ := getOutstandingUploads(ctx, fs); len(remaining) != 4 {
+		t.Fatalf("dry run removed uploads: %d remaining", len(remaining))
+	}
+
+	opts.DryRun = false
+	if purged, _ = PurgeRepositoryUploads(ctx, fs, opts); len(purged) != 2 {
+		t.Fatalf("unexpected purged uploads: %v", purged)
+	}
+	if remaining, _ := getOutstandingUploads(ctx, fs); len(remaining) != 2 {
+		t.Fatalf("unexpected remaining uploads: %d", len(remaining))
+	}
+}
+
+func TestListAndAbortUploads(t *testing.T) {
+	fs, ctx := testUploadFS(t, 2, "ci/app", time.Now())
+	id := uuid.NewString()
+	addUploads(ctx, t, fs, id, "prod/app", time.Now())
+
+	uploads, errs := ListUploads(ctx, fs, "")
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(uploads) != 3 || uploads[2].Repository != "prod/app" || uploads[2].ID != id {
+		t.Fatalf("unexpected uploads: %+v", uploads)
+	}
+	if uploads, _ = ListUploads(ctx, fs, "ci"); len(uploads) != 2 {
+		t.Fatalf("unexpected uploads under ci: %+v", uploads)
+	}
+
+	if err := AbortUpload(ctx, fs, "ci/app", id); err != distribution.ErrBlobUploadUnknown {
+		t.Fatalf("expected ErrBlobUploadUnknown aborting upload in another repository, got %v", err)
+	}
+	if err := AbortUpload(ctx, fs, "prod/app", id); err != nil {
+		t.Fatalf("unexpected error aborting upload: %v", err)
+	}
+	if uploads, _ = ListUploads(ctx, fs, "prod"); len(uploads) != 0 {
+		t.Fatalf("unexpected uploads after abort: %+v", uploads)
+	}
+	if err := AbortUpload(ctx, fs, "prod/app", id); err != distribution.ErrBlobUploadUnknown {
+		t.Fatalf("expected ErrBlobUploadUnknown aborting upload twice, got %v", err)
+	}
+}
+
 func TestPurgeOnlyUploads(t *testing.T) {
 	oldUploadCount := 5
 	oneHourAgo := time.Now().Add(-1 * time.Hour)
@@ -163,3 +313,43 @@ func TestPurgeMissingStartedAt(t *testing.T) {
 		t.Errorf("Files unexpectedly deleted: %s", deleted)
 	}
 }
+
+func TestPurgeGatherSession(t *testing.T) {
+	ctx := dcontext.WithUser(context.Background(), nil, "ci-runner")
+	d := inmemory.New()
+	imageName, _ := reference.WithName("test-repo")
+	registry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	upload, err := repository.Blobs(ctx).Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := upload.Write([]byte("abandoned")); err != nil {
+		t.Fatalf("unexpected error writing upload: %v", err)
+	}
+	upload.Close()
+
+	uploadData, errs := getOutstandingUploads(ctx, d)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %q", errs)
+	}
+	ud, ok := uploadData[upload.ID()]
+	if !ok {
+		t.Fatalf("upload %s not found", upload.ID())
+	}
+	if ud.session.Subject != "ci-runner" {
+		t.Errorf("unexpected subject %q", ud.session.Subject)
+	}
+	if !ud.session.StartedAt.Truncate(time.Second).Equal(ud.startedAt) {
+		t.Errorf("unexpected session start %s, expected %s", ud.session.StartedAt, ud.startedAt)
+	}
+	if ud.size != int64(len("abandoned")) {
+		t.Errorf("unexpected upload size %d", ud.size)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"regexp"
 	"runtime"
 
@@ -24,13 +25,20 @@ type registry struct {
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
 	tagLookupConcurrencyLimit    int
+	tagIndex                     bool
+	tagIndexCompactAfter         int
+	tagIndexChanges              *tagIndexCounter
 	resumableDigestEnabled       bool
+	verifyManifests              bool
+	changeLog                    bool
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	driver                       storagedriver.StorageDriver
 
 	// Validation
 	manifestURLs         manifestURLs
 	validateImageIndexes validateImageIndexes
+	uploadLimits         uploadLimits
+	mountPolicy          mountPolicy
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -47,6 +55,20 @@ type validateImageIndexes struct {
 	imagePlatforms []platform
 }
 
+// uploadLimits are the limits on the uploads in progress in each repository.
+// A zero value means no limit.
+type uploadLimits struct {
+	maxConcurrent int
+	maxBytes      int64
+}
+
+// mountPolicy restricts the repositories blobs can be mounted from.
+type mountPolicy struct {
+	scope        MountScope
+	verifySource bool
+	pools        []reference.Named
+}
+
 // platform represents a platform to validate exists in the
 type platform struct {
 	architecture string
@@ -70,6 +92,25 @@ func TagLookupConcurrencyLimit(concurrencyLimit int) RegistryOption {
 	}
 }
 
+// EnableTagIndex returns a functional option for NewRegistry. It causes tags
+// to be listed and looked up from a per-repository tag index, compacted after
+// compactAfter tag changes, rather than from the tags directory. Zero selects
+// the default.
+func EnableTagIndex(compactAfter int) RegistryOption {
+	return func(registry *registry) error {
+		if compactAfter < 0 {
+			return fmt.Errorf("tag index compaction threshold must not be negative: %d", compactAfter)
+		}
+		if compactAfter == 0 {
+			compactAfter = defaultTagIndexCompactAfter
+		}
+		registry.tagIndex = true
+		registry.tagIndexCompactAfter = compactAfter
+		registry.tagIndexChanges = newTagIndexCounter()
+		return nil
+	}
+}
+
 // EnableDelete is a functional option for NewRegistry. It enables deletion on
 // the registry.
 func EnableDelete(registry *registry) error {
@@ -84,6 +125,23 @@ func DisableDigestResumption(registry *registry) error {
 	return nil
 }
 
+// VerifyManifests is a functional option for NewRegistry. It causes the
+// content of manifests to be hashed each time they are read from storage, and
+// manifests which do not match their digest to be rejected.
+func VerifyManifests(registry *registry) error {
+	registry.verifyManifests = true
+	return nil
+}
+
+// EnableLinkChecksums is a functional option for NewRegistry. It causes link
+// files to be written with a checksum of their content, so that truncated or
+// corrupt link files are detected when read. Link files with checksums cannot
+// be read by registries predating them.
+func EnableLinkChecksums(registry *registry) error {
+	registry.blobStore.linkChecksums = true
+	return nil
+}
+
 // ManifestURLsAllowRegexp is a functional option for NewRegistry.
 func ManifestURLsAllowRegexp(r *regexp.Regexp) RegistryOption {
 	return func(registry *registry) error {
@@ -122,6 +180,71 @@ func AddValidateImageIndexImagesExistPlatform(architecture string, os string) Re
 	}
 }
 
+// UploadLimits is a functional option for NewRegistry. It limits the number
+// of upload sessions in progress in a repository, and their total size in
+// bytes. A zero value disables the corresponding limit.
+func UploadLimits(maxConcurrent int, maxBytes int64) RegistryOption {
+	return func(registry *registry) error {
+		if maxConcurrent < 0 || maxBytes < 0 {
+			return fmt.Errorf("upload limits must not be negative")
+		}
+		registry.uploadLimits = uploadLimits{
+			maxConcurrent: maxConcurrent,
+			maxBytes:      maxBytes,
+		}
+		return nil
+	}
+}
+
+// MountScope selects the source repositories a blob can be mounted from.
+type MountScope string
+
+const (
+	// MountScopeAny allows mounting blobs from any repository.
+	MountScopeAny MountScope = "any"
+	// MountScopeNamespace only allows mounting blobs from repositories in
+	// the same namespace, the first component of the repository name.
+	MountScopeNamespace MountScope = "namespace"
+	// MountScopeNone disables cross-repository blob mounts.
+	MountScopeNone MountScope = "none"
+)
+
+// MountPolicy is a functional option for NewRegistry. It restricts the
+// repositories blobs can be mounted from to scope. If verifySource is true,
+// the blob is always looked up in the source repository, even when the
+// caller provides its descriptor.
+func MountPolicy(scope MountScope, verifySource bool) RegistryOption {
+	return func(registry *registry) error {
+		switch scope {
+		case "":
+			scope = MountScopeAny
+		case MountScopeAny, MountScopeNamespace, MountScopeNone:
+		default:
+			return fmt.Errorf("unknown mount scope: %q", scope)
+		}
+		registry.mountPolicy.scope = scope
+		registry.mountPolicy.verifySource = verifySource
+		return nil
+	}
+}
+
+// MountPools is a functional option for NewRegistry. It names pool
+// repositories, searched in order for a blob to mount when it is not found in
+// the source repository of a mount. The pools are searched regardless of the
+// mount scope, unless mounts are disabled.
+func MountPools(names ...string) RegistryOption {
+	return func(registry *registry) error {
+		for _, name := range names {
+			named, err := reference.WithName(name)
+			if err != nil {
+				return fmt.Errorf("invalid mount pool %q: %v", name, err)
+			}
+			registry.mountPolicy.pools = append(registry.mountPolicy.pools, named)
+		}
+		return nil
+	}
+}
+
 // BlobDescriptorServiceFactory returns a functional option for NewRegistry. It sets the
 // factory to create BlobDescriptorServiceFactory middleware.
 func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFactory) RegistryOption {
@@ -245,6 +368,15 @@ func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 		blobStore:        repo.registry.blobStore,
 		concurrencyLimit: limit,
 	}
+	if repo.tagIndex {
+		tags.index = &tagIndex{
+			name:             repo.name.Name(),
+			driver:           repo.registry.blobStore.driver,
+			compactAfter:     repo.tagIndexCompactAfter,
+			concurrencyLimit: limit,
+			changes:          repo.tagIndexChanges,
+		}
+	}
 
 	return tags
 }
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Schema1ConvertOpts contains options for ConvertSchema1.
+type Schema1ConvertOpts struct {
+	// DryRun reports what would be converted without writing anything.
+	DryRun bool
+	// Quiet silences the per-manifest report.
+	Quiet bool
+}
+
+// Schema1Conversion records the outcome of converting a single schema1
+// manifest.
+type Schema1Conversion struct {
+	Repository string
+	Digest     digest.Digest
+	Tags       []string
+
+	// Converted is the digest of the schema2 manifest written in place of
+	// the schema1 manifest. It is empty for a dry run or a failed conversion.
+	Converted digest.Digest
+
+	// Err is set if the manifest could not be converted.
+	Err error
+}
+
+// schema1Manifest is the subset of a schema1 manifest needed to convert it.
+type schema1Manifest struct {
+	specs.Versioned
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	FSLayers []struct {
+		BlobSum digest.Digest `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// schema1Image is the subset of a v1Compatibility entry used to rebuild the
+// image history.
+type schema1Image struct {
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	ThrowAway       bool      `json:"throwaway,omitempty"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd,omitempty"`
+	} `json:"container_config,omitempty"`
+}
+
+// ConvertSchema1 finds every schema1 manifest remaining in storage and
+// rewrites it as a schema2 manifest, moving any tags pointing at it to the
+// converted manifest. The schema1 manifests themselves are left in place so
+// that they can be removed by garbage collection once no longer tagged.
+func ConvertSchema1(ctx context.Context, registry distribution.Namespace, opts Schema1ConvertOpts) ([]Schema1Conversion, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	var results []Schema1Conversion
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+		}
+
+		return manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			content, err := repository.Blobs(ctx).Get(ctx, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %s@%s: %v", repoName, dgst, err)
+			}
+			var versioned specs.Versioned
+			if err := json.Unmarshal(content, &versioned); err != nil || versioned.SchemaVersion != 1 {
+				return nil
+			}
+
+			result := Schema1Conversion{Repository: repoName, Digest: dgst}
+			result.Tags, err = repository.Tags(ctx).Lookup(ctx, v1.Descriptor{Digest: dgst})
+			if err != nil {
+				return fmt.Errorf("failed to retrieve tags for %s@%s: %v", repoName, dgst, err)
+			}
+
+			result.Converted, result.Err = convertSchema1Manifest(ctx, repository, manifestService, content, opts.DryRun)
+			if result.Err == nil && !opts.DryRun {
+				desc := v1.Descriptor{MediaType: schema2.MediaTypeManifest, Digest: result.Converted}
+				for _, tag := range result.Tags {
+					if err := repository.Tags(ctx).Tag(ctx, tag, desc); err != nil {
+						return fmt.Errorf("failed to move tag %s:%s to %s: %v", repoName, tag, result.Converted, err)
+					}
+				}
+			}
+
+			if !opts.Quiet {
+				switch {
+				case result.Err != nil:
+					emit("%s@%s: unable to convert: %v (tags: %s)", repoName, dgst, result.Err, strings.Join(result.Tags, ", "))
+				case opts.DryRun:
+					emit("%s@%s: convertible (tags: %s)", repoName, dgst, strings.Join(result.Tags, ", "))
+				default:
+					emit("%s@%s: converted to %s (tags: %s)", repoName, dgst, result.Converted, strings.Join(result.Tags, ", "))
+				}
+			}
+			results = append(results, result)
+			return nil
+		})
+	})
+
+	var repoUnknown distribution.ErrRepositoryUnknown
+	if err != nil && !errors.As(err, &repoUnknown) {
+		return results, err
+	}
+	return results, nil
+}
+
+// convertSchema1Manifest builds a schema2 manifest and image configuration
+// from the schema1 manifest content. Layer diff IDs are computed by reading
+// each layer, so every layer must be present in the repository.
+func convertSchema1Manifest(ctx context.Context, repository distribution.Repository, manifests distribution.ManifestService, content []byte, dryRun bool) (digest.Digest, error) {
+	var m schema1Manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return "", fmt.Errorf("invalid schema1 manifest: %v", err)
+	}
+	if len(m.History) == 0 || len(m.History) != len(m.FSLayers) {
+		return "", fmt.Errorf("manifest has %d history entries for %d layers", len(m.History), len(m.FSLayers))
+	}
+
+	// The first history entry holds the complete configuration of the image.
+	config := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &config); err != nil {
+		return "", fmt.Errorf("invalid v1Compatibility: %v", err)
+	}
+	for _, key := range []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"} {
+		delete(config, key)
+	}
+
+	var (
+		blobs   = repository.Blobs(ctx)
+		diffIDs []digest.Digest
+		layers  []v1.Descriptor
+		history []v1.History
+	)
+	// Schema1 lists layers from the top down.
+	for i := len(m.History) - 1; i >= 0; i-- {
+		var img schema1Image
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &img); err != nil {
+			return "", fmt.Errorf("invalid v1Compatibility: %v", err)
+		}
+		created := img.Created
+		history = append(history, v1.History{
+			Created:    &created,
+			Author:     img.Author,
+			CreatedBy:  strings.Join(img.ContainerConfig.Cmd, " "),
+			Comment:    img.Comment,
+			EmptyLayer: img.ThrowAway,
+		})
+		if img.ThrowAway {
+			continue
+		}
+
+		desc, err := blobs.Stat(ctx, m.FSLayers[i].BlobSum)
+		if err != nil {
+			return "", fmt.Errorf("layer %s: %v", m.FSLayers[i].BlobSum, err)
+		}
+		diffID, err := schema1DiffID(ctx, blobs, desc.Digest)
+		if err != nil {
+			return "", fmt.Errorf("layer %s: %v", desc.Digest, err)
+		}
+		diffIDs = append(diffIDs, diffID)
+		layers = append(layers, v1.Descriptor{
+			MediaType: schema2.MediaTypeLayer,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		})
+	}
+
+	var err error
+	if config["rootfs"], err = json.Marshal(v1.RootFS{Type: "layers", DiffIDs: diffIDs}); err != nil {
+		return "", err
+	}
+	if config["history"], err = json.Marshal(history); err != nil {
+		return "", err
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config: v1.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(configJSON),
+			Size:      int64(len(configJSON)),
+		},
+		Layers: layers,
+	}
+	if dryRun {
+		return "", nil
+	}
+
+	if _, err := blobs.Put(ctx, schema2.MediaTypeImageConfig, configJSON); err != nil {
+		return "", fmt.Errorf("failed to store image configuration: %v", err)
+	}
+	deserialized, err := schema2.FromStruct(manifest)
+	if err != nil {
+		return "", err
+	}
+	return manifests.Put(ctx, deserialized)
+}
+
+// schema1DiffID returns the digest of the uncompressed content of a layer.
+func schema1DiffID(ctx context.Context, blobs distribution.BlobStore, dgst digest.Digest) (digest.Digest, error) {
+	rc, err := blobs.Open(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return "", fmt.Errorf("layer is not gzip compressed: %v", err)
+	}
+	defer gz.Close()
+
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(digester.Hash(), gz); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestConvertSchema1(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "legacy/app")
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte("layer contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", compressed.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putSchema1 := func(tag string, layerDigest digest.Digest) digest.Digest {
+		t.Helper()
+		manifest := map[string]interface{}{
+			"schemaVersion": 1,
+			"name":          "legacy/app",
+			"tag":           tag,
+			"architecture":  "amd64",
+			"fsLayers": []map[string]interface{}{
+				{"blobSum": digest.FromString("empty")},
+				{"blobSum": layerDigest},
+			},
+			"history": []map[string]string{
+				{"v1Compatibility": `{"id":"b","parent":"a","created":"2016-01-01T00:00:00Z","throwaway":true,"architecture":"amd64","os":"linux","config":{"Cmd":["/bin/sh"]},"container_config":{"Cmd":["/bin/sh","-c","#(nop) CMD [\"/bin/sh\"]"]}}`},
+				{"v1Compatibility": `{"id":"a","created":"2016-01-01T00:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ADD file:abc in /"]}}`},
+			},
+		}
+		p, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := repo.Blobs(ctx).Put(ctx, "application/vnd.docker.distribution.manifest.v1+json", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		linkPath, err := pathFor(manifestRevisionLinkPathSpec{name: "legacy/app", revision: desc.Digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.PutContent(ctx, linkPath, []byte(desc.Digest)); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Tags(ctx).Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+		return desc.Digest
+	}
+
+	good := putSchema1("good", layer.Digest)
+	bad := putSchema1("bad", digest.FromString("missing layer"))
+
+	manifests := makeManifestService(t, repo)
+	if _, err := manifests.Get(ctx, good); err != distribution.ErrSchemaV1Unsupported {
+		t.Fatalf("expected ErrSchemaV1Unsupported, got %v", err)
+	}
+
+	results, err := ConvertSchema1(ctx, registry, Schema1ConvertOpts{DryRun: true, Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 schema1 manifests, got %d", len(results))
+	}
+	if desc, _ := repo.Tags(ctx).Get(ctx, "good"); desc.Digest != good {
+		t.Fatal("dry run moved a tag")
+	}
+
+	results, err = ConvertSchema1(ctx, registry, Schema1ConvertOpts{Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		switch result.Digest {
+		case good:
+			if result.Err != nil {
+				t.Fatalf("unexpected conversion error: %v", result.Err)
+			}
+		case bad:
+			if result.Err == nil {
+				t.Fatal("expected conversion with missing layer to fail")
+			}
+		}
+	}
+
+	desc, err := repo.Tags(ctx).Get(ctx, "good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifests.Get(ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, ok := m.(*schema2.DeserializedManifest)
+	if !ok {
+		t.Fatalf("expected schema2 manifest, got %T", m)
+	}
+	if len(converted.Layers) != 1 || converted.Layers[0].Digest != layer.Digest {
+		t.Fatalf("unexpected layers: %v", converted.Layers)
+	}
+
+	configJSON, err := repo.Blobs(ctx).Get(ctx, converted.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config v1.Image
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.RootFS.DiffIDs) != 1 || config.RootFS.DiffIDs[0] != digest.FromString("layer contents") {
+		t.Errorf("unexpected diff IDs: %v", config.RootFS.DiffIDs)
+	}
+	if len(config.History) != 2 || !config.History[1].EmptyLayer {
+		t.Errorf("unexpected history: %+v", config.History)
+	}
+	if len(config.Config.Cmd) != 1 || config.Config.Cmd[0] != "/bin/sh" {
+		t.Errorf("unexpected config: %+v", config.Config)
+	}
+
+	if desc, _ := repo.Tags(ctx).Get(ctx, "bad"); desc.Digest != bad {
+		t.Error("tag of unconvertible manifest was moved")
+	}
+}
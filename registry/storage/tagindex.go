@@ -0,0 +1,684 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/uuid"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+)
+
+// tagIndexChunkSize is the number of tags held by each file of a tag index
+// snapshot.
+const tagIndexChunkSize = 1000
+
+// defaultTagIndexCompactAfter is the number of tag changes logged to a tag
+// index after which it is compacted, unless configured otherwise.
+const defaultTagIndexCompactAfter = 64
+
+// tagIndexInitialID is the id of the empty snapshot starting the tag index
+// of a new repository. It sorts before every ULID, so that registry
+// instances starting the index concurrently write the same snapshot and no
+// tag change logged after it is missed.
+const tagIndexInitialID = "00000000000000000000000000"
+
+var (
+	// errTagIndexMissing is returned reading the tag index of a repository
+	// which has no complete snapshot, such as a repository which has not
+	// been migrated to the tag index yet.
+	errTagIndexMissing = errors.New("tag index missing")
+
+	// errStopTagIndexWalk stops walking a tag index without error.
+	errStopTagIndexWalk = errors.New("stop tag index walk")
+)
+
+// tagIndex is an index of the tags of a repository, read instead of walking
+// the tags directory and reading the link of every tag. The index is made of
+// snapshots holding every tag, and of a log of the tag changes following
+// the latest snapshot. Once compactAfter changes follow the latest snapshot,
+// the log is compacted into a new snapshot.
+//
+// Snapshots and log entries are named by ULIDs, so that the latest snapshot
+// and the order of the changes are given by their names. As log entries are
+// named by the clock of the instance logging them, and may be written after a
+// snapshot named after a later time, a snapshot lists the log entries it
+// holds rather than holding those named before it, and every other log entry
+// is replayed on top of it. A snapshot is complete once its meta file has
+// been written. The previous snapshot and the log entries it does not hold
+// are kept by compaction, for readers which loaded the index before it was
+// compacted.
+type tagIndex struct {
+	name             string
+	driver           storagedriver.StorageDriver
+	compactAfter     int
+	concurrencyLimit int
+	// changes counts the changes logged by the registry, to check whether
+	// the index is to be compacted once every compactAfter changes.
+	changes *tagIndexCounter
+}
+
+// tagIndexCounter counts the tag changes logged by a registry to the tag
+// index of each repository.
+type tagIndexCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTagIndexCounter() *tagIndexCounter {
+	return &tagIndexCounter{counts: make(map[string]int)}
+}
+
+// add counts a change logged to the tag index of the repository name, and
+// returns true once every n changes.
+func (c *tagIndexCounter) add(name string, n int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name]++
+	if c.counts[name] < n {
+		return false
+	}
+	delete(c.counts, name)
+	return true
+}
+
+// tagIndexEntry is a tag change logged to a tag index. Digest is empty when
+// the tag was deleted.
+type tagIndexEntry struct {
+	Tag    string        `json:"tag"`
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// tagIndexMeta is the meta file of a tag index snapshot. The tags of a
+// snapshot are stored in chunk files of up to tagIndexChunkSize sorted
+// lines, and Chunks holds the first tag of each chunk. Entries holds the ids
+// of the log entries the snapshot holds which may not be pruned yet.
+type tagIndexMeta struct {
+	Chunks  []string `json:"chunks"`
+	Entries []string `json:"entries"`
+}
+
+// tagIndexState is a tag index as of its latest snapshot, and the tag
+// changes logged which it does not hold.
+type tagIndexState struct {
+	snapshot tagIndexSnapshot
+	// held holds the ids of the logged changes which the snapshot holds.
+	held []string
+	// replayed holds the ids of the logged changes which the snapshot does
+	// not hold, in order.
+	replayed []string
+	// entries is the number of changes the snapshot does not hold.
+	entries int
+	// changes holds the latest digest of each tag changed after the
+	// snapshot, empty for deleted tags.
+	changes map[string]digest.Digest
+}
+
+// tagIndexSnapshot is a complete snapshot of a tag index.
+type tagIndexSnapshot struct {
+	id   string
+	meta tagIndexMeta
+}
+
+// exists returns true if the repository has a tag index, complete or being
+// built.
+func (idx *tagIndex) exists(ctx context.Context) (bool, error) {
+	indexPath, err := pathFor(tagIndexPathSpec{name: idx.name})
+	if err != nil {
+		return false, err
+	}
+	if _, err := idx.driver.Stat(ctx, indexPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// list returns the sorted names of the entries of the directory described by
+// spec, or none if the directory does not exist.
+func (idx *tagIndex) list(ctx context.Context, spec pathSpec) ([]string, error) {
+	dir, err := pathFor(spec)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := idx.driver.List(ctx, dir)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, path.Base(entry))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// latestSnapshot returns the latest complete snapshot of the index, and the
+// ids of every snapshot.
+func (idx *tagIndex) latestSnapshot(ctx context.Context) (tagIndexSnapshot, []string, error) {
+	ids, err := idx.list(ctx, tagIndexSnapshotsPathSpec{name: idx.name})
+	if err != nil {
+		return tagIndexSnapshot{}, nil, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		snapshotPath, err := pathFor(tagIndexSnapshotPathSpec{name: idx.name, id: ids[i]})
+		if err != nil {
+			return tagIndexSnapshot{}, nil, err
+		}
+		p, err := idx.driver.GetContent(ctx, path.Join(snapshotPath, "meta"))
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				// the snapshot is being written
+				continue
+			}
+			return tagIndexSnapshot{}, nil, err
+		}
+		snapshot := tagIndexSnapshot{id: ids[i]}
+		if err := json.Unmarshal(p, &snapshot.meta); err != nil {
+			return tagIndexSnapshot{}, nil, fmt.Errorf("invalid tag index snapshot %s: %v", snapshotPath, err)
+		}
+		return snapshot, ids, nil
+	}
+	return tagIndexSnapshot{}, ids, errTagIndexMissing
+}
+
+// load reads the latest snapshot of the index and the logged changes it
+// does not hold.
+func (idx *tagIndex) load(ctx context.Context) (*tagIndexState, error) {
+	snapshot, _, err := idx.latestSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logged, err := idx.list(ctx, tagIndexLogPathSpec{name: idx.name})
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(snapshot.meta.Entries))
+	for _, id := range snapshot.meta.Entries {
+		held[id] = true
+	}
+	st := &tagIndexState{snapshot: snapshot}
+	var ids []string
+	for _, id := range logged {
+		if held[id] {
+			st.held = append(st.held, id)
+		} else {
+			ids = append(ids, id)
+		}
+	}
+
+	entries := make([]tagIndexEntry, len(ids))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(idx.concurrencyLimit)
+	for i, id := range ids {
+		g.Go(func() error {
+			entryPath, err := pathFor(tagIndexLogEntryPathSpec{name: idx.name, id: id})
+			if err != nil {
+				return err
+			}
+			p, err := idx.driver.GetContent(gctx, entryPath)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(p, &entries[i]); err != nil {
+				return fmt.Errorf("invalid tag index log entry %s: %v", entryPath, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	st.replayed = ids
+	st.entries = len(ids)
+	st.changes = make(map[string]digest.Digest, len(ids))
+	for _, entry := range entries {
+		st.changes[entry.Tag] = entry.Digest
+	}
+	return st, nil
+}
+
+// walk calls fn with each tag following last in the index, in order, and
+// its digest, until fn returns errStopTagIndexWalk.
+func (idx *tagIndex) walk(ctx context.Context, st *tagIndexState, last string, fn func(tag string, dgst digest.Digest) error) error {
+	var changed []string
+	for tag := range st.changes {
+		if tag > last {
+			changed = append(changed, tag)
+		}
+	}
+	sort.Strings(changed)
+
+	emit := func(tag string, dgst digest.Digest) error {
+		if dgst == "" {
+			// deleted after the snapshot
+			return nil
+		}
+		return fn(tag, dgst)
+	}
+	// emitChanged emits the changed tags lower than tag, and returns true
+	// if tag itself was changed.
+	emitChanged := func(tag string) (bool, error) {
+		for len(changed) > 0 && changed[0] <= tag {
+			t := changed[0]
+			changed = changed[1:]
+			if t == tag {
+				return true, emit(t, st.changes[t])
+			}
+			if err := emit(t, st.changes[t]); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	chunks := st.snapshot.meta.Chunks
+	first := sort.Search(len(chunks), func(i int) bool { return chunks[i] > last }) - 1
+	if first < 0 {
+		first = 0
+	}
+	err := func() error {
+		for i := first; i < len(chunks); i++ {
+			err := idx.readChunk(ctx, st.snapshot.id, i, func(tag string, dgst digest.Digest) error {
+				if tag <= last {
+					return nil
+				}
+				done, err := emitChanged(tag)
+				if err != nil || done {
+					return err
+				}
+				return emit(tag, dgst)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, tag := range changed {
+			if err := emit(tag, st.changes[tag]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err == errStopTagIndexWalk {
+		return nil
+	}
+	return err
+}
+
+// chunkPath returns the path of chunk i of the snapshot id.
+func (idx *tagIndex) chunkPath(id string, i int) (string, error) {
+	snapshotPath, err := pathFor(tagIndexSnapshotPathSpec{name: idx.name, id: id})
+	if err != nil {
+		return "", err
+	}
+	return path.Join(snapshotPath, fmt.Sprintf("%08d", i)), nil
+}
+
+// readChunk calls fn with each tag of chunk i of the snapshot id.
+func (idx *tagIndex) readChunk(ctx context.Context, id string, i int, fn func(tag string, dgst digest.Digest) error) error {
+	chunkPath, err := idx.chunkPath(id, i)
+	if err != nil {
+		return err
+	}
+	rc, err := idx.driver.Reader(ctx, chunkPath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		tag, dgst, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return fmt.Errorf("invalid line in tag index chunk %s: %q", chunkPath, scanner.Text())
+		}
+		if err := fn(tag, digest.Digest(dgst)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// tagIndexSnapshotWriter writes the sorted tags of a snapshot a chunk at a
+// time.
+type tagIndexSnapshotWriter struct {
+	idx   *tagIndex
+	ctx   context.Context
+	id    string
+	meta  tagIndexMeta
+	chunk bytes.Buffer
+	tags  int
+}
+
+func (idx *tagIndex) newSnapshotWriter(ctx context.Context, id string, entries []string) *tagIndexSnapshotWriter {
+	if entries == nil {
+		entries = []string{}
+	}
+	return &tagIndexSnapshotWriter{idx: idx, ctx: ctx, id: id, meta: tagIndexMeta{Chunks: []string{}, Entries: entries}}
+}
+
+func (w *tagIndexSnapshotWriter) add(tag string, dgst digest.Digest) error {
+	if w.tags == 0 {
+		w.meta.Chunks = append(w.meta.Chunks, tag)
+	}
+	fmt.Fprintf(&w.chunk, "%s %s\n", tag, dgst)
+	w.tags++
+	if w.tags == tagIndexChunkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *tagIndexSnapshotWriter) flush() error {
+	if w.tags == 0 {
+		return nil
+	}
+	chunkPath, err := w.idx.chunkPath(w.id, len(w.meta.Chunks)-1)
+	if err != nil {
+		return err
+	}
+	if err := w.idx.driver.PutContent(w.ctx, chunkPath, w.chunk.Bytes()); err != nil {
+		return err
+	}
+	w.chunk.Reset()
+	w.tags = 0
+	return nil
+}
+
+// commit writes the meta file completing the snapshot.
+func (w *tagIndexSnapshotWriter) commit() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	snapshotPath, err := pathFor(tagIndexSnapshotPathSpec{name: w.idx.name, id: w.id})
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(w.meta)
+	if err != nil {
+		return err
+	}
+	return w.idx.driver.PutContent(w.ctx, path.Join(snapshotPath, "meta"), p)
+}
+
+// start writes the empty snapshot starting the tag index of a new
+// repository.
+func (idx *tagIndex) start(ctx context.Context) error {
+	return idx.newSnapshotWriter(ctx, tagIndexInitialID, nil).commit()
+}
+
+// record logs a tag change to the index.
+func (idx *tagIndex) record(ctx context.Context, tag string, dgst digest.Digest) error {
+	entryPath, err := pathFor(tagIndexLogEntryPathSpec{name: idx.name, id: uuid.NewULID()})
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(tagIndexEntry{Tag: tag, Digest: dgst})
+	if err != nil {
+		return err
+	}
+	return idx.driver.PutContent(ctx, entryPath, p)
+}
+
+// compactIfNeeded compacts the index if compactAfter changes are not held
+// by its latest snapshot. st is the state of the index if it has been
+// loaded. Otherwise the index is only loaded once compactAfter changes have
+// been logged to it by the registry since it was last checked.
+func (idx *tagIndex) compactIfNeeded(ctx context.Context, st *tagIndexState) {
+	if st == nil {
+		if !idx.changes.add(idx.name, idx.compactAfter) {
+			return
+		}
+		var err error
+		st, err = idx.load(ctx)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error loading tag index of %s: %v", idx.name, err)
+			return
+		}
+	}
+	if st.entries < idx.compactAfter {
+		return
+	}
+	if err := idx.compact(context.WithoutCancel(ctx), st); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error compacting tag index of %s: %v", idx.name, err)
+	}
+}
+
+// compact writes a snapshot holding the changes replayed on the snapshot of
+// st, then removes the snapshots preceding that snapshot and the log entries
+// it holds.
+func (idx *tagIndex) compact(ctx context.Context, st *tagIndexState) error {
+	if st.entries == 0 {
+		return nil
+	}
+	id := uuid.NewULID()
+	if id <= st.snapshot.id {
+		return fmt.Errorf("tag index snapshot %s would not follow snapshot %s", id, st.snapshot.id)
+	}
+	entries := append(append([]string{}, st.held...), st.replayed...)
+	sort.Strings(entries)
+	w := idx.newSnapshotWriter(ctx, id, entries)
+	if err := idx.walk(ctx, st, "", w.add); err != nil {
+		return err
+	}
+	if err := w.commit(); err != nil {
+		return err
+	}
+	return idx.prune(ctx, st.snapshot.id, st.held)
+}
+
+// prune removes the snapshots preceding the snapshot id and the log entries
+// held, which it holds.
+func (idx *tagIndex) prune(ctx context.Context, id string, held []string) error {
+	snapshots, err := idx.list(ctx, tagIndexSnapshotsPathSpec{name: idx.name})
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s >= id {
+			break
+		}
+		snapshotPath, err := pathFor(tagIndexSnapshotPathSpec{name: idx.name, id: s})
+		if err != nil {
+			return err
+		}
+		if err := idx.driver.Delete(ctx, snapshotPath); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+
+	for _, e := range held {
+		entryPath, err := pathFor(tagIndexLogEntryPathSpec{name: idx.name, id: e})
+		if err != nil {
+			return err
+		}
+		if err := idx.driver.Delete(ctx, entryPath); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TagIndexer is implemented by the tag services of registries with the tag
+// index enabled, to migrate repositories to the tag index and verify it.
+type TagIndexer interface {
+	// RebuildTagIndex rebuilds the tag index of the repository from its
+	// tags directory.
+	RebuildTagIndex(ctx context.Context) error
+
+	// VerifyTagIndex returns the tags whose digest in the tag index of the
+	// repository differs from their link in the tags directory.
+	VerifyTagIndex(ctx context.Context) ([]TagIndexMismatch, error)
+}
+
+// TagIndexMismatch describes a tag whose digest in the tag index differs
+// from its link in the tags directory.
+type TagIndexMismatch struct {
+	Tag string `json:"tag"`
+	// Index is the digest of the tag in the tag index, empty if the tag is
+	// missing from the index.
+	Index digest.Digest `json:"index,omitempty"`
+	// Directory is the digest the tag links to, empty if the tag is
+	// missing from the tags directory.
+	Directory digest.Digest `json:"directory,omitempty"`
+}
+
+var _ TagIndexer = &tagStore{}
+
+// errTagIndexDisabled is returned migrating or verifying the tag index of a
+// registry without the tag index enabled.
+var errTagIndexDisabled = errors.New("tag index is not enabled")
+
+// RebuildTagIndex writes a snapshot of the tags directory to the tag index.
+// The snapshot holds the tag changes logged before it was started, as tags
+// are changed in the tags directory before being logged, and tag changes
+// logged while it is written are replayed on top of it.
+func (ts *tagStore) RebuildTagIndex(ctx context.Context) error {
+	if ts.index == nil {
+		return errTagIndexDisabled
+	}
+	_, previous, err := ts.index.latestSnapshot(ctx)
+	if err != nil && err != errTagIndexMissing {
+		return err
+	}
+	logged, err := ts.index.list(ctx, tagIndexLogPathSpec{name: ts.index.name})
+	if err != nil {
+		return err
+	}
+
+	w := ts.index.newSnapshotWriter(ctx, uuid.NewULID(), logged)
+	snapshotPath, err := pathFor(tagIndexSnapshotPathSpec{name: ts.index.name, id: w.id})
+	if err != nil {
+		return err
+	}
+	// Starting the snapshot creates the index, so that tag changes made
+	// while it is written are logged.
+	if err := ts.blobStore.driver.PutContent(ctx, path.Join(snapshotPath, "startedat"), []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return err
+	}
+	if err := ts.walkDirectory(ctx, w.add); err != nil {
+		return err
+	}
+	if err := w.commit(); err != nil {
+		return err
+	}
+
+	if len(previous) > 0 {
+		return ts.index.prune(ctx, previous[len(previous)-1], nil)
+	}
+	return nil
+}
+
+// VerifyTagIndex compares the tag index with the tags directory.
+func (ts *tagStore) VerifyTagIndex(ctx context.Context) ([]TagIndexMismatch, error) {
+	if ts.index == nil {
+		return nil, errTagIndexDisabled
+	}
+	st, err := ts.index.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	indexed := make(map[string]digest.Digest)
+	if err := ts.index.walk(ctx, st, "", func(tag string, dgst digest.Digest) error {
+		indexed[tag] = dgst
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var mismatches []TagIndexMismatch
+	if err := ts.walkDirectory(ctx, func(tag string, dgst digest.Digest) error {
+		if indexed[tag] != dgst {
+			mismatches = append(mismatches, TagIndexMismatch{Tag: tag, Index: indexed[tag], Directory: dgst})
+		}
+		delete(indexed, tag)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for tag, dgst := range indexed {
+		mismatches = append(mismatches, TagIndexMismatch{Tag: tag, Index: dgst})
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Tag < mismatches[j].Tag })
+	return mismatches, nil
+}
+
+// walkDirectory calls fn with each tag of the tags directory, in order, and
+// the digest it links to, reading the links a page of tags at a time.
+func (ts *tagStore) walkDirectory(ctx context.Context, fn func(tag string, dgst digest.Digest) error) error {
+	tags := make([]string, tagIndexChunkSize)
+	last := ""
+	for {
+		n, err := ts.listDirectory(ctx, tags, last)
+		if err != nil && err != io.EOF {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+				return nil
+			}
+			return err
+		}
+		done := err == io.EOF || n == 0
+
+		dgsts := make([]digest.Digest, n)
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(ts.concurrencyLimit)
+		for i, tag := range tags[:n] {
+			g.Go(func() error {
+				linkPath, err := pathFor(manifestTagCurrentPathSpec{name: ts.repository.Named().Name(), tag: tag})
+				if err != nil {
+					return err
+				}
+				dgst, err := ts.blobStore.readlink(gctx, linkPath)
+				if err != nil {
+					if _, ok := err.(storagedriver.PathNotFoundError); ok {
+						// untagged while walking
+						return nil
+					}
+					return err
+				}
+				dgsts[i] = dgst
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		for i, tag := range tags[:n] {
+			if dgsts[i] == "" {
+				continue
+			}
+			if err := fn(tag, dgsts[i]); err != nil {
+				return err
+			}
+		}
+
+		if done {
+			return nil
+		}
+		last = tags[n-1]
+	}
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func testTagIndexStore(t *testing.T, d driver.StorageDriver, options ...RegistryOption) *tagStore {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, d, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo.Tags(ctx).(*tagStore)
+}
+
+// listAllTags lists the tags of ts a page at a time.
+func listAllTags(t *testing.T, ts distribution.TagLister, pageSize int) []string {
+	ctx := context.Background()
+	var (
+		listed []string
+		last   string
+	)
+	for {
+		page := make([]string, pageSize)
+		n, err := ts.List(ctx, page, last)
+		if err != nil && err != io.EOF {
+			t.Fatalf("unexpected error listing tags after %q: %v", last, err)
+		}
+		listed = append(listed, page[:n]...)
+		if err == io.EOF {
+			return listed
+		}
+		last = page[n-1]
+	}
+}
+
+func TestTagIndex(t *testing.T) {
+	ctx := context.Background()
+	ts := testTagIndexStore(t, inmemory.New(), EnableTagIndex(1<<20))
+
+	d1 := digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	d2 := digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+
+	// enough tags for several snapshot chunks
+	expected := map[string]digest.Digest{}
+	for i := 0; i < 2*tagIndexChunkSize+500; i++ {
+		tag := fmt.Sprintf("tag-%05d", i)
+		dgst := d1
+		if i%3 == 0 {
+			dgst = d2
+		}
+		if err := ts.Tag(ctx, tag, v1.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+		expected[tag] = dgst
+	}
+
+	check := func(stage string, pageSizes ...int) {
+		t.Helper()
+		var tags, lookup []string
+		for tag, dgst := range expected {
+			tags = append(tags, tag)
+			if dgst == d2 {
+				lookup = append(lookup, tag)
+			}
+		}
+		sort.Strings(tags)
+		sort.Strings(lookup)
+
+		all, err := ts.All(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", stage, err)
+		}
+		if !reflect.DeepEqual(all, tags) {
+			t.Fatalf("%s: unexpected tags: %d tags, expected %d", stage, len(all), len(tags))
+		}
+		for _, pageSize := range pageSizes {
+			if listed := listAllTags(t, ts, pageSize); !reflect.DeepEqual(listed, tags) {
+				t.Fatalf("%s: unexpected tags listed with page size %d", stage, pageSize)
+			}
+		}
+		found, err := ts.Lookup(ctx, v1.Descriptor{Digest: d2})
+		if err != nil {
+			t.Fatalf("%s: %v", stage, err)
+		}
+		if !reflect.DeepEqual(found, lookup) {
+			t.Fatalf("%s: unexpected tags looked up: %d tags, expected %d", stage, len(found), len(lookup))
+		}
+		mismatches, err := ts.VerifyTagIndex(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", stage, err)
+		}
+		if len(mismatches) != 0 {
+			t.Fatalf("%s: unexpected mismatches: %v", stage, mismatches)
+		}
+	}
+	check("log", 3*tagIndexChunkSize)
+
+	st, err := ts.index.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.snapshot.id != tagIndexInitialID {
+		t.Fatalf("expected the index of a new repository to start with the initial snapshot, got %s", st.snapshot.id)
+	}
+	if err := ts.index.compact(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	check("snapshot", 7, tagIndexChunkSize)
+
+	// change tags after the snapshot, before and after the tags of the
+	// snapshot and in between
+	for _, tag := range []string{"tag-00000", "tag-01500", "tag-02499"} {
+		if err := ts.Untag(ctx, tag); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, tag)
+	}
+	for _, tag := range []string{"a", "tag-01000-x", "z", "tag-00001"} {
+		if err := ts.Tag(ctx, tag, v1.Descriptor{Digest: d2}); err != nil {
+			t.Fatal(err)
+		}
+		expected[tag] = d2
+	}
+	check("snapshot and log", 7, tagIndexChunkSize)
+
+	st, err = ts.index.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.index.compact(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	check("compacted", 7, tagIndexChunkSize)
+
+	snapshots, err := ts.index.list(ctx, tagIndexSnapshotsPathSpec{name: ts.index.name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("expected the latest snapshot and the previous one to be kept, got %v", snapshots)
+	}
+
+	// a change logged by an instance whose clock is behind, after the
+	// snapshot following it was written, is replayed
+	if err := ts.Tag(ctx, "late", v1.Descriptor{Digest: d1}); err != nil {
+		t.Fatal(err)
+	}
+	expected["late"] = d1
+	st, err = ts.index.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.entries != 1 {
+		t.Fatalf("expected a single change not held by the snapshot, got %v", st.replayed)
+	}
+	from, err := pathFor(tagIndexLogEntryPathSpec{name: ts.index.name, id: st.replayed[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := pathFor(tagIndexLogEntryPathSpec{name: ts.index.name, id: tagIndexInitialID[:25] + "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.blobStore.driver.Move(ctx, from, to); err != nil {
+		t.Fatal(err)
+	}
+	check("late change", 7)
+
+	st, err = ts.index.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.index.compact(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	check("late change compacted", 7)
+}
+
+func TestTagIndexMigration(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	unindexed := testTagIndexStore(t, d)
+	indexed := testTagIndexStore(t, d, EnableTagIndex(0))
+
+	desc := v1.Descriptor{Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"}
+	for _, tag := range []string{"a", "b", "c"} {
+		if err := unindexed.Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the tags of repositories which are not migrated are read from the
+	// tags directory, and changes to them are not logged
+	if err := indexed.Tag(ctx, "d", desc); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := indexed.index.exists(ctx); err != nil || ok {
+		t.Fatalf("unexpected tag index of an unmigrated repository: %v, %v", ok, err)
+	}
+	if tags, err := indexed.All(ctx); err != nil || !reflect.DeepEqual(tags, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("unexpected tags of an unmigrated repository: %v, %v", tags, err)
+	}
+
+	if err := indexed.RebuildTagIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexed.index.load(ctx); err != nil {
+		t.Fatalf("expected the tag index to be rebuilt: %v", err)
+	}
+	if err := indexed.Untag(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if tags := listAllTags(t, indexed, 2); !reflect.DeepEqual(tags, []string{"b", "c", "d"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	if mismatches, err := indexed.VerifyTagIndex(ctx); err != nil || len(mismatches) != 0 {
+		t.Fatalf("unexpected verification of the tag index: %v, %v", mismatches, err)
+	}
+
+	// changes made by registries without the tag index enabled are
+	// reported by verification and repaired by rebuilding the index
+	if err := unindexed.Untag(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unindexed.Tag(ctx, "e", desc); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := indexed.VerifyTagIndex(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TagIndexMismatch{{Tag: "b", Index: desc.Digest}, {Tag: "e", Directory: desc.Digest}}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Fatalf("unexpected mismatches: %v != %v", mismatches, expected)
+	}
+	if err := indexed.RebuildTagIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mismatches, err := indexed.VerifyTagIndex(ctx); err != nil || len(mismatches) != 0 {
+		t.Fatalf("unexpected verification of the rebuilt tag index: %v, %v", mismatches, err)
+	}
+}
+
+func TestTagIndexCompaction(t *testing.T) {
+	ctx := context.Background()
+	ts := testTagIndexStore(t, inmemory.New(), EnableTagIndex(2))
+
+	desc := v1.Descriptor{Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"}
+	expected := []string{"a", "b", "c", "d", "e"}
+	for _, tag := range expected {
+		if err := ts.Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// changes are compacted once every two changes
+	st, err := ts.index.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.snapshot.id == tagIndexInitialID || st.entries != 1 {
+		t.Fatalf("tag index not compacted: %d changes not held by snapshot %s", st.entries, st.snapshot.id)
+	}
+
+	if tags, err := ts.All(ctx); err != nil || !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("unexpected tags after compaction: %v, %v", tags, err)
+	}
+}
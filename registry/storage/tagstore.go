@@ -2,8 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
 	"path"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/opencontainers/go-digest"
@@ -25,10 +28,42 @@ type tagStore struct {
 	repository       *repository
 	blobStore        *blobStore
 	concurrencyLimit int
+	// index is the tag index of the repository, nil if the tag index is
+	// not enabled.
+	index *tagIndex
+}
+
+// loadIndex returns the state of the tag index, or nil if the tag index is
+// not enabled or the repository has not been migrated to it.
+func (ts *tagStore) loadIndex(ctx context.Context) (*tagIndexState, error) {
+	if ts.index == nil {
+		return nil, nil
+	}
+	st, err := ts.index.load(ctx)
+	if err == errTagIndexMissing {
+		return nil, nil
+	}
+	return st, err
 }
 
 // All returns all tags
 func (ts *tagStore) All(ctx context.Context) ([]string, error) {
+	st, err := ts.loadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil {
+		tags := []string{}
+		if err := ts.index.walk(ctx, st, "", func(tag string, dgst digest.Digest) error {
+			tags = append(tags, tag)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		ts.index.compactIfNeeded(ctx, st)
+		return tags, nil
+	}
+
 	pathSpec, err := pathFor(manifestTagsPathSpec{
 		name: ts.repository.Named().Name(),
 	})
@@ -59,6 +94,146 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 	return tags, nil
 }
 
+// List fills tags with the sorted tags following last, reading the tag index
+// or walking the tags directory from last, so that only a page of tags is
+// held in memory.
+func (ts *tagStore) List(ctx context.Context, tags []string, last string) (int, error) {
+	if len(tags) == 0 {
+		return 0, errors.New("attempted to list 0 tags")
+	}
+
+	st, err := ts.loadIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if st == nil {
+		return ts.listDirectory(ctx, tags, last)
+	}
+
+	n, more := 0, false
+	if err := ts.index.walk(ctx, st, last, func(tag string, dgst digest.Digest) error {
+		if n == len(tags) {
+			more = true
+			return errStopTagIndexWalk
+		}
+		tags[n] = tag
+		n++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	ts.index.compactIfNeeded(ctx, st)
+	if !more {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// listDirectory fills tags with the sorted tags following last, walking the
+// tags directory from last.
+func (ts *tagStore) listDirectory(ctx context.Context, tags []string, last string) (int, error) {
+	root, err := pathFor(manifestTagsPathSpec{
+		name: ts.repository.Named().Name(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The hint is only a starting point for the walk, so it is not used
+	// for markers which cannot be tags and could lead outside of root.
+	startAfter := ""
+	if last != "" && !strings.HasPrefix(last, ".") && !strings.Contains(last, "/") {
+		startAfter = path.Join(root, last)
+	}
+
+	// Drivers walk in path order, which differs from the order of tags
+	// when a tag is followed by '-' or '.' in another: object storage
+	// drivers list "a-b/" and "a.b/" before "a/". Once tags is filled, tags
+	// lower than those found still replace them, and the tags which would
+	// be listed after the tag ending the walk are looked up directly.
+	var (
+		found   []string
+		more    bool
+		stopped bool
+	)
+	insert := func(tag string) {
+		i := sort.SearchStrings(found, tag)
+		if i < len(found) && found[i] == tag {
+			return
+		}
+		copy(found[i+1:], found[i:])
+		found[i] = tag
+		more = true
+	}
+	err = ts.blobStore.driver.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		if stopped {
+			return storagedriver.ErrFilledBuffer
+		}
+		rel := strings.TrimPrefix(fileInfo.Path(), root+"/")
+		tag, _, nested := strings.Cut(rel, "/")
+		if nested || tag <= last {
+			if fileInfo.IsDir() {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+
+		if len(found) < len(tags) {
+			found = append(found, tag)
+			if len(found) == len(tags) {
+				sort.Strings(found)
+			}
+			return storagedriver.ErrSkipDir
+		}
+		if tag < found[len(found)-1] {
+			insert(tag)
+			return storagedriver.ErrSkipDir
+		}
+
+		for i := range tag {
+			if tag[i] != '-' && tag[i] != '.' {
+				continue
+			}
+			prefix := tag[:i]
+			if prefix <= last || prefix >= found[len(found)-1] {
+				continue
+			}
+			if _, err := ts.blobStore.driver.Stat(ctx, path.Join(root, prefix)); err == nil {
+				insert(prefix)
+			} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		more, stopped = true, true
+		return storagedriver.ErrFilledBuffer
+	}, storagedriver.WithStartAfterHint(startAfter))
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return 0, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+		default:
+			return 0, err
+		}
+	}
+
+	if len(found) == 0 && last != "" {
+		// Walking from a hint does not report a missing tags directory.
+		if _, err := ts.blobStore.driver.Stat(ctx, root); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				return 0, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+			}
+			return 0, err
+		}
+	}
+
+	sort.Strings(found)
+	n := copy(tags, found)
+	if !more {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
 // Tag tags the digest with the given tag, updating the store to point at
 // the current tag. The digest must point to a manifest.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
@@ -70,6 +245,11 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) err
 		return err
 	}
 
+	indexed, err := ts.prepareIndex(ctx)
+	if err != nil {
+		return err
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -78,7 +258,48 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc v1.Descriptor) err
 	}
 
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+
+	if err := ts.repository.recordChange(ctx, ts.repository.Named().Name(), ChangeTag, tag, desc.Digest); err != nil {
+		return err
+	}
+
+	if indexed {
+		if err := ts.index.record(ctx, tag, desc.Digest); err != nil {
+			return err
+		}
+		ts.index.compactIfNeeded(ctx, nil)
+	}
+	return nil
+}
+
+// prepareIndex returns true if changes to the tags of the repository are to
+// be logged to the tag index, starting the tag index of new repositories.
+// The tags of repositories with tags but without a tag index are only
+// indexed once the repository is migrated to the tag index.
+func (ts *tagStore) prepareIndex(ctx context.Context) (bool, error) {
+	if ts.index == nil {
+		return false, nil
+	}
+	if ok, err := ts.index.exists(ctx); err != nil || ok {
+		return ok, err
+	}
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: ts.repository.Named().Name()})
+	if err != nil {
+		return false, err
+	}
+	if _, err := ts.blobStore.driver.Stat(ctx, tagsPath); err == nil {
+		return false, nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return false, err
+	}
+	if err := ts.index.start(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // resolve the current revision for name and tag.
@@ -114,7 +335,28 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
-	return ts.blobStore.driver.Delete(ctx, tagPath)
+	indexed := false
+	if ts.index != nil {
+		if indexed, err = ts.index.exists(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := ts.blobStore.driver.Delete(ctx, tagPath); err != nil {
+		return err
+	}
+
+	if err := ts.repository.recordChange(ctx, ts.repository.Named().Name(), ChangeUntag, tag, ""); err != nil {
+		return err
+	}
+
+	if indexed {
+		if err := ts.index.record(ctx, tag, ""); err != nil {
+			return err
+		}
+		ts.index.compactIfNeeded(ctx, nil)
+	}
+	return nil
 }
 
 // linkedBlobStore returns the linkedBlobStore for the named tag, allowing one
@@ -139,6 +381,25 @@ func (ts *tagStore) linkedBlobStore(ctx context.Context, tag string) *linkedBlob
 // Lookup recovers a list of tags which refer to this digest.  When a manifest is deleted by
 // digest, tag entries which point to it need to be recovered to avoid dangling tags.
 func (ts *tagStore) Lookup(ctx context.Context, desc v1.Descriptor) ([]string, error) {
+	st, err := ts.loadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil {
+		// the index holds the digest of every tag, so no link is read
+		var tags []string
+		if err := ts.index.walk(ctx, st, "", func(tag string, dgst digest.Digest) error {
+			if dgst == desc.Digest {
+				tags = append(tags, tag)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		ts.index.compactIfNeeded(ctx, st)
+		return tags, nil
+	}
+
 	allTags, err := ts.All(ctx)
 	switch err.(type) {
 	case distribution.ErrRepositoryUnknown:
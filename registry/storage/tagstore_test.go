@@ -2,11 +2,17 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
+	"path"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/reference"
 	digest "github.com/opencontainers/go-digest"
@@ -166,6 +172,158 @@ func TestTagStoreAll(t *testing.T) {
 	}
 }
 
+func TestTagStoreList(t *testing.T) {
+	env := testTagStore(t)
+	tagStore := env.ts.(distribution.TagLister)
+	ctx := env.ctx
+
+	if _, err := tagStore.List(ctx, make([]string, 1), ""); !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		t.Fatalf("expected repository unknown error, got %v", err)
+	}
+
+	expected := []string{"a", "a-b", "a-b-c", "a.b", "a0", "b", "latest", "v1", "v1.0", "v1.0.1"}
+	desc := v1.Descriptor{Digest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+	for _, tag := range expected {
+		if err := env.ts.Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for pageSize := 1; pageSize <= len(expected)+1; pageSize++ {
+		var (
+			listed []string
+			last   string
+		)
+		for {
+			page := make([]string, pageSize)
+			n, err := tagStore.List(ctx, page, last)
+			if err != nil && err != io.EOF {
+				t.Fatalf("page size %d: unexpected error listing tags after %q: %v", pageSize, last, err)
+			}
+			listed = append(listed, page[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if n != pageSize {
+				t.Fatalf("page size %d: expected a full page, got %d tags", pageSize, n)
+			}
+			last = page[n-1]
+		}
+		if !reflect.DeepEqual(listed, expected) {
+			t.Errorf("page size %d: unexpected tags %v", pageSize, listed)
+		}
+	}
+
+	page := make([]string, len(expected))
+	n, err := tagStore.List(ctx, page, "does-not-exist")
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if !reflect.DeepEqual(page[:n], []string{"latest", "v1", "v1.0", "v1.0.1"}) {
+		t.Errorf("unexpected tags after missing marker: %v", page[:n])
+	}
+}
+
+// keyOrderDriver walks in the order of the files' paths, inferring the
+// directories, as object storage drivers listing keys do.
+type keyOrderDriver struct {
+	driver.StorageDriver
+}
+
+func (d keyOrderDriver) Walk(ctx context.Context, from string, f driver.WalkFn, options ...func(*driver.WalkOptions)) error {
+	var files []driver.FileInfo
+	if err := d.StorageDriver.Walk(ctx, from, func(fi driver.FileInfo) error {
+		if !fi.IsDir() {
+			files = append(files, fi)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path() < files[j].Path() })
+
+	visited := map[string]bool{}
+	var skipped []string
+	for _, fi := range files {
+		rel := strings.TrimPrefix(fi.Path(), from+"/")
+		components := strings.Split(rel, "/")
+		for i := range components {
+			p := path.Join(from, strings.Join(components[:i+1], "/"))
+			if visited[p] {
+				continue
+			}
+			visited[p] = true
+
+			isSkipped := false
+			for _, s := range skipped {
+				isSkipped = isSkipped || strings.HasPrefix(p, s+"/")
+			}
+			if isSkipped {
+				continue
+			}
+
+			info := fi
+			if i < len(components)-1 {
+				info = driver.FileInfoInternal{FileInfoFields: driver.FileInfoFields{Path: p, IsDir: true}}
+			}
+			switch err := f(info); err {
+			case nil:
+			case driver.ErrSkipDir:
+				skipped = append(skipped, p)
+			case driver.ErrFilledBuffer:
+				return nil
+			default:
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestTagStoreListKeyOrder(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, keyOrderDriver{inmemory.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a", "a-b", "a-b-c", "a.b", "a0", "b", "v1", "v1-rc", "v1.0", "v1.0-rc", "v1.0.1", "v10"}
+	desc := v1.Descriptor{Digest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+	for _, tag := range expected {
+		if err := repo.Tags(ctx).Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tagStore := repo.Tags(ctx).(distribution.TagLister)
+	for pageSize := 1; pageSize <= len(expected); pageSize++ {
+		var (
+			listed []string
+			last   string
+		)
+		for {
+			page := make([]string, pageSize)
+			n, err := tagStore.List(ctx, page, last)
+			if err != nil && err != io.EOF {
+				t.Fatalf("page size %d: unexpected error: %v", pageSize, err)
+			}
+			listed = append(listed, page[:n]...)
+			if err == io.EOF || n == 0 {
+				break
+			}
+			last = page[n-1]
+		}
+		if !reflect.DeepEqual(listed, expected) {
+			t.Errorf("page size %d: unexpected tags %v", pageSize, listed)
+		}
+	}
+}
+
 func TestTagLookup(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
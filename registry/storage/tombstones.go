@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// tombstone records that a blob was deleted by garbage collection in WORM
+// mode. The blob data is retained in the backend, but is no longer
+// referenced by any repository. A tombstone is never deleted: once the blob
+// is referenced again, it is overwritten by one recording when the blob was
+// restored.
+type tombstone struct {
+	Digest     digest.Digest `json:"digest"`
+	Size       int64         `json:"size"`
+	DeletedAt  time.Time     `json:"deletedAt"`
+	RestoredAt *time.Time    `json:"restoredAt,omitempty"`
+}
+
+// RetainedBlobs reports the blobs which have been deleted in WORM mode but
+// whose data is retained in the storage backend.
+type RetainedBlobs struct {
+	Count int64
+	Bytes int64
+}
+
+// readTombstone returns the tombstone of the blob, or a
+// driver.PathNotFoundError if the blob has none or has been restored.
+func readTombstone(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) (tombstone, error) {
+	tombstonePath, err := pathFor(tombstonePathSpec{digest: dgst})
+	if err != nil {
+		return tombstone{}, err
+	}
+	p, err := storageDriver.GetContent(ctx, tombstonePath)
+	if err != nil {
+		return tombstone{}, err
+	}
+	var t tombstone
+	if err := json.Unmarshal(p, &t); err != nil {
+		return tombstone{}, fmt.Errorf("invalid tombstone %s: %v", tombstonePath, err)
+	}
+	if t.RestoredAt != nil {
+		return tombstone{}, driver.PathNotFoundError{Path: tombstonePath}
+	}
+	return t, nil
+}
+
+// walkTombstones calls fn with every tombstone in storage, other than those
+// of restored blobs.
+func walkTombstones(ctx context.Context, storageDriver driver.StorageDriver, fn func(t tombstone) error) error {
+	root, err := pathFor(tombstonesPathSpec{})
+	if err != nil {
+		return err
+	}
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		p, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		var t tombstone
+		if err := json.Unmarshal(p, &t); err != nil {
+			return fmt.Errorf("invalid tombstone %s: %v", fileInfo.Path(), err)
+		}
+		if t.RestoredAt != nil {
+			return nil
+		}
+		return fn(t)
+	})
+	if errors.As(err, new(driver.PathNotFoundError)) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storageDriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// uploadSession records the client which started an upload. It is written
+// alongside the startedat file, which is kept for registries which do not
+// know about sessions.
+type uploadSession struct {
+	StartedAt  time.Time `json:"startedAt"`
+	Subject    string    `json:"subject,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+}
+
+// newUploadSession returns the session of an upload started with the
+// request in ctx.
+func newUploadSession(ctx context.Context, startedAt time.Time) uploadSession {
+	return uploadSession{
+		StartedAt:  startedAt,
+		Subject:    dcontext.GetUserName(ctx),
+		RemoteAddr: dcontext.GetStringValue(ctx, dcontext.RequestRemoteAddrKey),
+		UserAgent:  dcontext.GetStringValue(ctx, dcontext.RequestUserAgentKey),
+	}
+}
+
+// writeUploadSession stores the session of the upload with the given id.
+func writeUploadSession(ctx context.Context, driver storageDriver.StorageDriver, name, id string, session uploadSession) error {
+	sessionPath, err := pathFor(uploadSessionPathSpec{name: name, id: id})
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return driver.PutContent(ctx, sessionPath, p)
+}
+
+// readUploadSession reads an upload's session file.
+func readUploadSession(ctx context.Context, driver storageDriver.StorageDriver, path string) (uploadSession, error) {
+	p, err := driver.GetContent(ctx, path)
+	if err != nil {
+		return uploadSession{}, err
+	}
+	var session uploadSession
+	if err := json.Unmarshal(p, &session); err != nil {
+		return uploadSession{}, err
+	}
+	return session, nil
+}
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/docker/go-metrics"
+	"github.com/opencontainers/go-digest"
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+// namespaceBytes is the storage used by each namespace, split into bytes
+// held by blobs unique to the namespace and bytes held by blobs shared with
+// other namespaces.
+var namespaceBytes = prometheus.StorageNamespace.NewDesc("namespace_bytes", "The number of bytes of blobs referenced by a namespace", metrics.Bytes, "namespace", "type")
+
+// exportedUsage collects the namespace storage metrics of the latest usage
+// report.
+var exportedUsage = &usageCollector{}
+
+func init() {
+	prometheus.StorageNamespace.Add(exportedUsage)
+}
+
+// usageCollector reports the storage used by the namespaces of a usage
+// report. Namespaces which are not in the latest report are not reported, so
+// that those which were removed disappear from the metrics.
+type usageCollector struct {
+	mu         sync.Mutex
+	namespaces []NamespaceUsage
+}
+
+// Describe implements prometheus.Collector.
+func (c *usageCollector) Describe(ch chan<- *promclient.Desc) {
+	ch <- namespaceBytes
+}
+
+// Collect implements prometheus.Collector.
+func (c *usageCollector) Collect(ch chan<- promclient.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, usage := range c.namespaces {
+		ch <- promclient.MustNewConstMetric(namespaceBytes, promclient.GaugeValue, float64(usage.UniqueBytes), usage.Namespace, "unique")
+		ch <- promclient.MustNewConstMetric(namespaceBytes, promclient.GaugeValue, float64(usage.SharedBytes), usage.Namespace, "shared")
+	}
+}
+
+// Usage report formats accepted by WriteUsageReport.
+const (
+	UsageReportJSON = "json"
+	UsageReportCSV  = "csv"
+)
+
+// NamespaceUsage is the storage used by the repositories of a namespace,
+// which is the first component of the repository name.
+type NamespaceUsage struct {
+	Namespace    string `json:"namespace"`
+	Repositories int    `json:"repositories"`
+	Blobs        int    `json:"blobs"`
+
+	// UniqueBytes is the size of the blobs referenced only by this
+	// namespace.
+	UniqueBytes int64 `json:"uniqueBytes"`
+
+	// SharedBytes is the size of the blobs this namespace references which
+	// are also referenced by other namespaces. Shared blobs are counted in
+	// full by each namespace referencing them.
+	SharedBytes int64 `json:"sharedBytes"`
+}
+
+// UsageReport is the storage used by every namespace in the registry.
+type UsageReport struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Namespaces  []NamespaceUsage `json:"namespaces"`
+}
+
+// ComputeUsage computes the storage used by each namespace, from the blobs
+// linked into and the manifests stored in its repositories. Every referenced
+// digest is held in memory while the usage is computed.
+func ComputeUsage(ctx context.Context, registry distribution.Namespace) (UsageReport, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return UsageReport{}, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	namespaces := make(map[string]*NamespaceUsage)
+	// referencedBy holds the namespaces referencing each blob.
+	referencedBy := make(map[digest.Digest][]string)
+	addReference := func(ns string, dgst digest.Digest) {
+		for _, n := range referencedBy[dgst] {
+			if n == ns {
+				return
+			}
+		}
+		referencedBy[dgst] = append(referencedBy[dgst], ns)
+	}
+
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		ns, _, _ := strings.Cut(repoName, "/")
+		usage, ok := namespaces[ns]
+		if !ok {
+			usage = &NamespaceUsage{Namespace: ns}
+			namespaces[ns] = usage
+		}
+		usage.Repositories++
+
+		return enumerateRepositoryBlobs(ctx, registry, repoName, func(dgst digest.Digest) {
+			addReference(ns, dgst)
+		})
+	})
+	var repoUnknown distribution.ErrRepositoryUnknown
+	if err != nil && !errors.As(err, &repoUnknown) {
+		return UsageReport{}, err
+	}
+
+	statter := registry.BlobStatter()
+	for dgst, refs := range referencedBy {
+		desc, err := statter.Stat(ctx, dgst)
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				continue
+			}
+			return UsageReport{}, fmt.Errorf("failed to stat blob %s: %v", dgst, err)
+		}
+		for _, ns := range refs {
+			usage := namespaces[ns]
+			usage.Blobs++
+			if len(refs) == 1 {
+				usage.UniqueBytes += desc.Size
+			} else {
+				usage.SharedBytes += desc.Size
+			}
+		}
+	}
+
+	report := UsageReport{GeneratedAt: time.Now().UTC()}
+	for _, usage := range namespaces {
+		report.Namespaces = append(report.Namespaces, *usage)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+	return report, nil
+}
+
+// RepositoryUsage is the storage referenced by a repository.
+type RepositoryUsage struct {
+	Repository string `json:"repository"`
+	Blobs      int    `json:"blobs"`
+
+	// Bytes is the size of the blobs and manifests linked into the
+	// repository. Blobs shared with other repositories are counted in full.
+	Bytes int64 `json:"bytes"`
+}
+
+// ComputeRepositoryUsage computes the storage referenced by a repository,
+// from the blobs linked into and the manifests stored in it.
+func ComputeRepositoryUsage(ctx context.Context, registry distribution.Namespace, repoName string) (RepositoryUsage, error) {
+	var digests []digest.Digest
+	err := enumerateRepositoryBlobs(ctx, registry, repoName, func(dgst digest.Digest) {
+		digests = append(digests, dgst)
+	})
+	if err != nil {
+		return RepositoryUsage{}, err
+	}
+
+	usage := RepositoryUsage{Repository: repoName}
+	seen := make(map[digest.Digest]struct{}, len(digests))
+	statter := registry.BlobStatter()
+	for _, dgst := range digests {
+		if _, ok := seen[dgst]; ok {
+			continue
+		}
+		seen[dgst] = struct{}{}
+
+		desc, err := statter.Stat(ctx, dgst)
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				continue
+			}
+			return RepositoryUsage{}, fmt.Errorf("failed to stat blob %s: %v", dgst, err)
+		}
+		usage.Blobs++
+		usage.Bytes += desc.Size
+	}
+	return usage, nil
+}
+
+// enumerateRepositoryBlobs calls ingester with the digest of every blob and
+// manifest linked into the repository.
+func enumerateRepositoryBlobs(ctx context.Context, registry distribution.Namespace, repoName string, ingester func(dgst digest.Digest)) error {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return fmt.Errorf("failed to construct repository: %v", err)
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert %T into ManifestEnumerator", manifestService)
+	}
+	blobs := repository.Blobs(ctx)
+	blobEnumerator, ok := blobs.(distribution.BlobEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert %T into BlobEnumerator", blobs)
+	}
+
+	for _, enumerator := range []distribution.BlobEnumerator{manifestEnumerator, blobEnumerator} {
+		err := enumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			ingester(dgst)
+			return nil
+		})
+		if err != nil {
+			// Repositories without manifests or layers have no
+			// directory for them.
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExportUsage sets the namespace storage metrics from the report, replacing
+// those of the previous report.
+func ExportUsage(report UsageReport) {
+	exportedUsage.mu.Lock()
+	defer exportedUsage.mu.Unlock()
+	exportedUsage.namespaces = report.Namespaces
+}
+
+// WriteUsageReport writes the report to the storage backend in the given
+// format, replacing the previous report in that format.
+func WriteUsageReport(ctx context.Context, storageDriver driver.StorageDriver, report UsageReport, format string) error {
+	var (
+		p   []byte
+		err error
+	)
+	switch format {
+	case UsageReportJSON:
+		p, err = json.MarshalIndent(report, "", "   ")
+	case UsageReportCSV:
+		p, err = usageReportCSV(report)
+	default:
+		return fmt.Errorf("unknown usage report format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	reportPath, err := pathFor(usageReportPathSpec{format: format})
+	if err != nil {
+		return err
+	}
+	dcontext.GetLogger(ctx).Infof("Writing usage report: %s", reportPath)
+	return storageDriver.PutContent(ctx, reportPath, p)
+}
+
+func usageReportCSV(report UsageReport) ([]byte, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	records := [][]string{{"namespace", "repositories", "blobs", "unique_bytes", "shared_bytes"}}
+	for _, usage := range report.Namespaces {
+		records = append(records, []string{
+			usage.Namespace,
+			strconv.Itoa(usage.Repositories),
+			strconv.Itoa(usage.Blobs),
+			strconv.FormatInt(usage.UniqueBytes, 10),
+			strconv.FormatInt(usage.SharedBytes, 10),
+		})
+	}
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestComputeUsage(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+
+	layers, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shared, unique digest.Digest
+	for dgst := range layers {
+		if shared == "" {
+			shared = dgst
+		} else {
+			unique = dgst
+		}
+	}
+	// upload links the layer into the repository with a manifest
+	// referencing it, returning the digest of the manifest.
+	upload := func(repoName string, dgst digest.Digest) digest.Digest {
+		if _, err := layers[dgst].Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		repo := makeRepository(t, registry, repoName)
+		if err := testutil.UploadBlobs(repo, map[digest.Digest]io.ReadSeeker{dgst: layers[dgst]}); err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifestDigest, err := manifests.Put(ctx, manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return manifestDigest
+	}
+	// The manifests of a/one and b/one are identical, and all manifests
+	// share an empty image configuration.
+	sharedManifest := upload("a/one", shared)
+	uniqueManifest := upload("a/two", unique)
+	upload("b/one", shared)
+
+	size := func(dgst digest.Digest) int64 {
+		desc, err := registry.BlobStatter().Stat(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return desc.Size
+	}
+
+	report, err := ComputeUsage(ctx, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NamespaceUsage{
+		{Namespace: "a", Repositories: 2, Blobs: 5, UniqueBytes: size(unique) + size(uniqueManifest), SharedBytes: size(shared) + size(sharedManifest)},
+		{Namespace: "b", Repositories: 1, Blobs: 3, SharedBytes: size(shared) + size(sharedManifest)},
+	}
+	if len(report.Namespaces) != len(expected) {
+		t.Fatalf("unexpected namespaces: %+v", report.Namespaces)
+	}
+	for i, usage := range report.Namespaces {
+		if usage != expected[i] {
+			t.Errorf("unexpected usage %+v, expected %+v", usage, expected[i])
+		}
+	}
+
+	// b/one is the only repository of namespace b.
+	repoUsage, err := ComputeRepositoryUsage(ctx, registry, "b/one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (RepositoryUsage{Repository: "b/one", Blobs: 3, Bytes: expected[1].SharedBytes}); repoUsage != expected {
+		t.Errorf("unexpected repository usage %+v, expected %+v", repoUsage, expected)
+	}
+
+	if err := WriteUsageReport(ctx, d, report, UsageReportCSV); err != nil {
+		t.Fatal(err)
+	}
+	reportPath, err := pathFor(usageReportPathSpec{format: UsageReportCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.GetContent(ctx, reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if len(lines) != 3 || lines[0] != "namespace,repositories,blobs,unique_bytes,shared_bytes" {
+		t.Errorf("unexpected report:\n%s", p)
+	}
+}
+
+func TestExportUsage(t *testing.T) {
+	collected := func() []string {
+		ch := make(chan promclient.Metric, 10)
+		exportedUsage.Collect(ch)
+		close(ch)
+		var namespaces []string
+		for metric := range ch {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "namespace" {
+					namespaces = append(namespaces, label.GetValue())
+				}
+			}
+		}
+		return namespaces
+	}
+
+	ExportUsage(UsageReport{Namespaces: []NamespaceUsage{{Namespace: "a"}, {Namespace: "b"}}})
+	if namespaces := collected(); strings.Join(namespaces, ",") != "a,a,b,b" {
+		t.Errorf("unexpected namespaces exported: %v", namespaces)
+	}
+
+	// Namespaces removed since the previous report are no longer exported.
+	ExportUsage(UsageReport{Namespaces: []NamespaceUsage{{Namespace: "b"}}})
+	if namespaces := collected(); strings.Join(namespaces, ",") != "b,b" {
+		t.Errorf("unexpected namespaces exported: %v", namespaces)
+	}
+}
@@ -2,8 +2,12 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"path"
+	"time"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
@@ -26,6 +30,9 @@ func NewVacuum(ctx context.Context, driver driver.StorageDriver) Vacuum {
 type Vacuum struct {
 	driver driver.StorageDriver
 	ctx    context.Context
+
+	// changes records the manifests removed, if set.
+	changes changeRecorder
 }
 
 // RemoveBlob removes a blob from the filesystem
@@ -50,6 +57,214 @@ func (v Vacuum) RemoveBlob(dgst string) error {
 	return nil
 }
 
+// RemoveBlobs removes blobs from the filesystem, using a single bulk delete
+// if the driver supports it. Blobs which do not exist are ignored.
+func (v Vacuum) RemoveBlobs(dgsts []digest.Digest) error {
+	if deleter, ok := v.driver.(driver.BulkDeleter); ok {
+		paths, err := blobDataPaths(dgsts)
+		if err != nil {
+			return err
+		}
+		chunked, err := v.chunksStored()
+		if err != nil {
+			return err
+		}
+		if chunked {
+			// Blobs stored as chunks have a chunk index in place of their
+			// data. Deleting the paths which do not exist is harmless.
+			for _, dgst := range dgsts {
+				indexPath, err := pathFor(blobChunkIndexPathSpec{digest: dgst})
+				if err != nil {
+					return err
+				}
+				paths = append(paths, indexPath)
+			}
+		}
+
+		dcontext.GetLogger(v.ctx).Infof("Deleting %d blobs", len(dgsts))
+
+		err = deleter.DeleteFiles(v.ctx, paths)
+		if _, ok := err.(driver.ErrUnsupportedMethod); !ok {
+			return err
+		}
+	}
+
+	for _, dgst := range dgsts {
+		err := v.RemoveBlob(dgst.String())
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveChunk removes a chunk no longer part of any blob.
+func (v Vacuum) RemoveChunk(dgst digest.Digest) error {
+	chunkPath, err := pathFor(chunkDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	dcontext.GetLogger(v.ctx).Infof("Deleting chunk: %s", chunkPath)
+
+	return v.driver.Delete(v.ctx, path.Dir(chunkPath))
+}
+
+// chunksStored returns true if any blob has been stored as chunks.
+func (v Vacuum) chunksStored() (bool, error) {
+	chunksPath, err := pathFor(chunksPathSpec{})
+	if err != nil {
+		return false, err
+	}
+	if _, err := v.driver.Stat(v.ctx, chunksPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// TagBlobs applies tags to the data of blobs instead of removing them, so
+// that they are expired by the storage backend, and records that they were
+// tagged so that the tags are cleared if they are referenced again. The
+// driver must implement driver.Tagger.
+func (v Vacuum) TagBlobs(dgsts []digest.Digest, tags map[string]string) error {
+	tagger, ok := v.driver.(driver.Tagger)
+	if !ok {
+		return driver.ErrUnsupportedMethod{DriverName: v.driver.Name()}
+	}
+	paths, err := blobDataPaths(dgsts)
+	if err != nil {
+		return err
+	}
+	chunked, err := v.chunksStored()
+	if err != nil {
+		return err
+	}
+	if chunked {
+		// The chunk index of blobs stored as chunks is tagged in place of
+		// their data, and their chunks are removed once it expired.
+		paths = paths[:0]
+		for _, dgst := range dgsts {
+			objectPath, _, err := blobObject(v.ctx, v.driver, dgst)
+			if err != nil {
+				if _, ok := err.(driver.PathNotFoundError); ok {
+					continue
+				}
+				return err
+			}
+			paths = append(paths, objectPath)
+		}
+	}
+
+	dcontext.GetLogger(v.ctx).Infof("Tagging %d blobs for expiry", len(paths))
+
+	if err := tagger.TagFiles(v.ctx, paths, tags); err != nil {
+		return err
+	}
+	return markExpiring(v.ctx, v.driver, dgsts)
+}
+
+// TombstoneBlobs records tombstones for blobs in place of removing them, for
+// storage in WORM mode. The blob data is left untouched. It returns the
+// number and size of the blobs tombstoned.
+func (v Vacuum) TombstoneBlobs(dgsts []digest.Digest) (RetainedBlobs, error) {
+	var retained RetainedBlobs
+	for _, dgst := range dgsts {
+		_, size, err := blobObject(v.ctx, v.driver, dgst)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return retained, err
+		}
+
+		tombstonePath, err := pathFor(tombstonePathSpec{digest: dgst})
+		if err != nil {
+			return retained, err
+		}
+		p, err := json.Marshal(tombstone{
+			Digest:    dgst,
+			Size:      size,
+			DeletedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return retained, err
+		}
+
+		dcontext.GetLogger(v.ctx).Infof("Writing blob tombstone: %s", tombstonePath)
+
+		if err := v.driver.PutContent(v.ctx, tombstonePath, p); err != nil {
+			return retained, err
+		}
+		retained.Count++
+		retained.Bytes += size
+	}
+	return retained, nil
+}
+
+// RestoreTombstone overwrites the tombstone of a blob which is referenced
+// again with one recording its restoration, as nothing is deleted in WORM
+// mode.
+func (v Vacuum) RestoreTombstone(t tombstone) error {
+	tombstonePath, err := pathFor(tombstonePathSpec{digest: t.Digest})
+	if err != nil {
+		return err
+	}
+	restoredAt := time.Now().UTC()
+	t.RestoredAt = &restoredAt
+	p, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	dcontext.GetLogger(v.ctx).Infof("Restoring blob tombstone: %s", tombstonePath)
+
+	return v.driver.PutContent(v.ctx, tombstonePath, p)
+}
+
+func blobDataPaths(dgsts []digest.Digest) ([]string, error) {
+	paths := make([]string, 0, len(dgsts))
+	for _, dgst := range dgsts {
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, blobPath)
+	}
+	return paths, nil
+}
+
+// blobObject returns the path of the object holding a blob, which is its
+// chunk index if it is stored as chunks, and the size of the blob.
+func blobObject(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) (string, int64, error) {
+	dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return "", 0, err
+	}
+	fi, err := storageDriver.Stat(ctx, dataPath)
+	if err == nil {
+		return dataPath, fi.Size(), nil
+	}
+	if _, ok := err.(driver.PathNotFoundError); !ok {
+		return "", 0, err
+	}
+
+	index, err := (&chunkStore{driver: storageDriver}).index(ctx, dgst)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return "", 0, driver.PathNotFoundError{Path: dataPath}
+		}
+		return "", 0, err
+	}
+	indexPath, err := pathFor(blobChunkIndexPathSpec{digest: dgst})
+	if err != nil {
+		return "", 0, err
+	}
+	return indexPath, index.Size, nil
+}
+
 // RemoveManifest removes a manifest from the filesystem
 func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) error {
 	// remove a tag manifest reference, in case of not found continue to next one
@@ -81,7 +296,13 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 		return err
 	}
 	dcontext.GetLogger(v.ctx).Infof("deleting manifest: %s", manifestPath)
-	return v.driver.Delete(v.ctx, manifestPath)
+	if err := v.driver.Delete(v.ctx, manifestPath); err != nil {
+		return err
+	}
+	if v.changes == nil {
+		return nil
+	}
+	return v.changes.recordChange(v.ctx, name, ChangeManifestDelete, "", dgst)
 }
 
 // RemoveRepository removes a repository directory from the
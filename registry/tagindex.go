@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tagIndexVerify     bool
+	tagIndexRepository string
+)
+
+// tagIndexReport is the verification report of the tag index of a
+// repository.
+type tagIndexReport struct {
+	Repository string                     `json:"repository"`
+	Mismatches []storage.TagIndexMismatch `json:"mismatches"`
+}
+
+// TagIndexCmd is the cobra command that corresponds to the tag-index
+// subcommand
+var TagIndexCmd = &cobra.Command{
+	Use:   "tag-index <config>",
+	Short: "`tag-index` migrates repositories to the tag index and verifies it",
+	Long: "`tag-index` rebuilds the tag index of every repository from its tags directory, " +
+		"migrating repositories to the tag index. With --verify, it prints the tags whose digest " +
+		"in the tag index differs from the tags directory as JSON instead.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		if enabled, _ := config.Storage.TagIndex(); !enabled {
+			fmt.Fprintln(os.Stderr, "the tag index is not enabled: set storage.tag.index.enabled")
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, handlers.StorageOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		repositories := []string{tagIndexRepository}
+		if tagIndexRepository == "" {
+			repositories = nil
+			err = registry.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+				repositories = append(repositories, name)
+				return nil
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to enumerate repositories: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		reports := []tagIndexReport{}
+		failed := false
+		for _, name := range repositories {
+			named, err := reference.WithName(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid repository name %s: %v\n", name, err)
+				failed = true
+				continue
+			}
+			repository, err := registry.Repository(ctx, named)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to construct repository %s: %v\n", name, err)
+				failed = true
+				continue
+			}
+			indexer := repository.Tags(ctx).(storage.TagIndexer)
+
+			if !tagIndexVerify {
+				if err := indexer.RebuildTagIndex(ctx); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to rebuild the tag index of %s: %v\n", name, err)
+					failed = true
+					continue
+				}
+				dcontext.GetLogger(ctx).Infof("rebuilt the tag index of %s", name)
+				continue
+			}
+
+			mismatches, err := indexer.VerifyTagIndex(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to verify the tag index of %s: %v\n", name, err)
+				failed = true
+				continue
+			}
+			if len(mismatches) > 0 {
+				reports = append(reports, tagIndexReport{Repository: name, Mismatches: mismatches})
+				failed = true
+			}
+		}
+
+		if tagIndexVerify {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(reports); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to encode tag index reports: %v", err)
+				os.Exit(1)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
@@ -36,3 +36,13 @@ type TagManifestsProvider interface {
 	// includes currently linked digest. There is no ordering guaranteed
 	ManifestDigests(ctx context.Context, tag string) ([]digest.Digest, error)
 }
+
+// TagLister is implemented by tag services able to list the tags of a
+// repository a page at a time, without holding every tag in memory.
+type TagLister interface {
+	// List fills 'tags' with the lexicographically sorted tags following
+	// 'last', up to the size of 'tags', and returns the number of entries
+	// which were filled. 'err' will be set to io.EOF if there are no more
+	// tags to obtain.
+	List(ctx context.Context, tags []string, last string) (n int, err error)
+}